@@ -2,8 +2,30 @@ package pufferpanel
 
 import (
 	"golang.org/x/crypto/ssh"
+	"net"
 )
 
 type SFTPAuthorization interface {
 	Validate(username, password string) (perms *ssh.Permissions, err error)
+	ValidateKey(username string, key ssh.PublicKey) (perms *ssh.Permissions, err error)
+}
+
+// SFTPServerAccess describes a server reachable from an SFTP login covering all of a user's servers
+type SFTPServerAccess struct {
+	ServerId string   `json:"serverId"`
+	Name     string   `json:"name"`
+	ReadOnly bool     `json:"readOnly,omitempty"`
+	Paths    []string `json:"paths,omitempty"`
+} //@name SFTPServerAccess
+
+// SFTPKeyUsage is implemented by authorizations which track when keys are used.
+// This is called once the client has proven it holds the private key.
+type SFTPKeyUsage interface {
+	KeyUsed(fingerprint string, addr net.Addr)
+}
+
+// SFTPRemoteValidation is implemented by authorizations which limit failed password logins by where they come from.
+// This is used instead of Validate when it is implemented.
+type SFTPRemoteValidation interface {
+	ValidateFrom(username, password string, addr net.Addr) (perms *ssh.Permissions, err error)
 }
@@ -0,0 +1,14 @@
+export class AuditApi {
+  _api = null
+
+  constructor(api) {
+    this._api = api
+  }
+
+  async search(filters = {}, page = 1, limit) {
+    const query = { ...filters, page }
+    if (limit) query.limit = limit
+    const res = await this._api.get('/api/audit', query)
+    return res.data
+  }
+}
@@ -1,3 +1,5 @@
+import { getCredential } from './webauthn'
+
 function is2xx(status) {
   return status >= 200 && status < 300
 }
@@ -24,7 +26,7 @@ export class AuthApi {
 
   async login(email, password) {
     const res = await this._api.post('/auth/login', { email, password })
-    if (res.data.otpNeeded) return 'otp'
+    if (res.data.otpNeeded) return { secondFactors: res.data.secondFactors || ['otp'] }
     return this._handleLogin(res.data.scopes)
   }
 
@@ -33,11 +35,48 @@ export class AuthApi {
     return this._handleLogin(res.data.scopes)
   }
 
+  async loginWebAuthn() {
+    const options = await this._api.post('/auth/webauthn/begin')
+    const credential = await getCredential(options.data)
+    const res = await this._api.post('/auth/webauthn/finish', credential)
+    return this._handleLogin(res.data.scopes)
+  }
+
+  async loginRecovery(code) {
+    const res = await this._api.post('/auth/recovery', { code })
+    return this._handleLogin(res.data.scopes)
+  }
+
+  async getConsent(request) {
+    const res = await this._api.get('/oauth2/authorize/consent', request)
+    return res.data
+  }
+
+  async answerConsent(request, approve, scopes) {
+    const res = await this._api.post('/oauth2/authorize/consent', { ...request, approve, scopes })
+    return res.data.redirect_uri
+  }
+
+  async oidcProviders() {
+    const res = await this._api.get('/auth/oidc')
+    return res.data
+  }
+
   async register(username, email, password) {
     const res = await this._api.post('/auth/register', { username, email, password })
     return this._handleLogin(res.data.scopes)
   }
 
+  async requestPasswordReset(email) {
+    await this._api.post('/auth/reset/request', { email })
+    return true
+  }
+
+  async resetPassword(token, password) {
+    await this._api.post('/auth/reset/confirm', { token, password })
+    return true
+  }
+
   async reauth() {
     const res = await this._api.post('/auth/reauth')
     if (is2xx(res.status)) {
@@ -7,6 +7,8 @@ import { NodeApi } from './nodes'
 import { ServerApi } from './servers'
 import { TemplateApi } from './templates'
 import { SettingsApi } from './settings'
+import { RoleApi } from './roles'
+import { AuditApi } from './audit'
 
 export class ApiClient {
   _axios = null
@@ -35,6 +37,8 @@ export class ApiClient {
     this.user = new UserApi(this)
     this.template = new TemplateApi(this)
     this.settings = new SettingsApi(this)
+    this.role = new RoleApi(this)
+    this.audit = new AuditApi(this)
   }
 
   _handleError(e) {
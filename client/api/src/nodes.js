@@ -34,6 +34,36 @@ export class NodeApi {
     return res.data
   }
 
+  async status(id, refresh = false) {
+    const res = await this._api.get(`/api/nodes/${id}/status`, refresh ? { refresh: true } : undefined)
+    return res.data
+  }
+
+  async statuses() {
+    const res = await this._api.get('/api/nodes/status')
+    return res.data
+  }
+
+  async usage(id) {
+    const res = await this._api.get(`/api/nodes/${id}/usage`)
+    return res.data
+  }
+
+  async ports(id) {
+    const res = await this._api.get(`/api/nodes/${id}/ports`)
+    return res.data
+  }
+
+  async addPortRange(id, ip, portMin, portMax) {
+    const res = await this._api.post(`/api/nodes/${id}/ports`, { ip, portMin: Number(portMin), portMax: Number(portMax) })
+    return res.data
+  }
+
+  async removePortRange(id, rangeId) {
+    await this._api.delete(`/api/nodes/${id}/ports/${rangeId}`)
+    return true
+  }
+
   async create(node) {
     await this._api.post('/api/nodes/', this.fixNode(node))
     try {
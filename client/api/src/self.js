@@ -1,3 +1,5 @@
+import { createCredential } from './webauthn'
+
 export class SelfApi {
   _api = null
 
@@ -31,8 +33,8 @@ export class SelfApi {
   }
 
   async validateOtpEnroll(token) {
-    await this._api.put('/api/self/otp', { token })
-    return true
+    const res = await this._api.put('/api/self/otp', { token })
+    return res.data.recoveryCodes || []
   }
 
   async disableOtp(token) {
@@ -40,6 +42,48 @@ export class SelfApi {
     return true
   }
 
+  async getWebAuthnCredentials() {
+    const res = await this._api.get('/api/self/webauthn')
+    return res.data
+  }
+
+  async registerWebAuthn(name) {
+    const options = await this._api.post('/api/self/webauthn')
+    const credential = await createCredential(options.data)
+    const res = await this._api.put('/api/self/webauthn', { name, credential })
+    return res.data
+  }
+
+  async deleteWebAuthnCredential(id) {
+    await this._api.delete(`/api/self/webauthn/${id}`)
+    return true
+  }
+
+  async getRecoveryCodesRemaining() {
+    const res = await this._api.get('/api/self/recovery')
+    return res.data.remaining
+  }
+
+  async regenerateRecoveryCodes() {
+    const res = await this._api.post('/api/self/recovery')
+    return res.data.recoveryCodes
+  }
+
+  async getSessions() {
+    const res = await this._api.get('/api/self/sessions')
+    return res.data
+  }
+
+  async revokeSession(id) {
+    await this._api.delete(`/api/self/sessions/${id}`)
+    return true
+  }
+
+  async revokeOtherSessions() {
+    await this._api.delete('/api/self/sessions')
+    return true
+  }
+
   async getSettings() {
     const res = await this._api.get('/api/userSettings')
     const map = {}
@@ -57,13 +101,33 @@ export class SelfApi {
     return res.data
   }
 
-  async createOAuthClient(name, description) {
-    const res = await this._api.post(`/api/self/oauth2`, { name, description })
+  async createOAuthClient(name, description, redirectUris = []) {
+    const res = await this._api.post(`/api/self/oauth2`, { name, description, redirect_uris: redirectUris })
     return res.data
   }
 
+  async updateOAuthClient(clientId, name, description, redirectUris = []) {
+    await this._api.put(`/api/self/oauth2/${clientId}`, { name, description, redirect_uris: redirectUris })
+    return true
+  }
+
   async deleteOAuthClient(clientId) {
     await this._api.delete(`/api/self/oauth2/${clientId}`)
     return true
   }
+
+  async getTokens() {
+    const res = await this._api.get('/api/self/tokens')
+    return res.data
+  }
+
+  async createToken(name, scopes, expiresAt, servers = []) {
+    const res = await this._api.post('/api/self/tokens', { name, scopes, servers, expiresAt })
+    return res.data
+  }
+
+  async deleteToken(id) {
+    await this._api.delete(`/api/self/tokens/${id}`)
+    return true
+  }
 }
@@ -255,6 +255,16 @@ export class ServerApi {
     return true
   }
 
+  async migrate(id, node, backups = false) {
+    const res = await this._api.post(`/api/servers/${id}/migration`, { node, backups })
+    return res.data
+  }
+
+  async getMigration(id) {
+    const res = await this._api.get(`/api/servers/${id}/migration`)
+    return res.data
+  }
+
   getBackupUrl (id,backupId) {
     return `/api/servers/${id}/backup/download/${backupId}`
   }
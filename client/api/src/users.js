@@ -39,6 +39,11 @@ export class UserApi {
     return res.data.scopes
   }
 
+  async getEffectivePermissions(id, server) {
+    const res = await this._api.get(`/api/users/${id}/perms/effective`, server ? { server } : undefined)
+    return res.data
+  }
+
   async update(id, user) {
     await this._api.post(`/api/users/${id}`, user)
     return true
@@ -49,8 +54,28 @@ export class UserApi {
     return true
   }
 
+  async resetSecondFactors(id) {
+    await this._api.delete(`/api/users/${id}/secondfactors`)
+    return true
+  }
+
+  async logout(id) {
+    await this._api.delete(`/api/users/${id}/sessions`)
+    return true
+  }
+
   async delete(id) {
     await this._api.delete(`/api/users/${id}`)
     return true
   }
+
+  async getLockouts() {
+    const res = await this._api.get('/api/lockouts')
+    return res.data
+  }
+
+  async clearLockout(id) {
+    await this._api.delete(`/api/lockouts/${id}`)
+    return true
+  }
 }
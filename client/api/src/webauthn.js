@@ -0,0 +1,56 @@
+// the panel sends and expects binary values as base64url, the browser works with ArrayBuffers
+
+function decode(value) {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/')
+  const binary = atob(base64 + '='.repeat((4 - base64.length % 4) % 4))
+  return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer
+}
+
+function encode(buffer) {
+  const binary = String.fromCharCode(...new Uint8Array(buffer))
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+}
+
+function decodeDescriptors(list) {
+  return (list || []).map(c => ({ ...c, id: decode(c.id) }))
+}
+
+export async function createCredential(options) {
+  const publicKey = {
+    ...options.publicKey,
+    challenge: decode(options.publicKey.challenge),
+    user: { ...options.publicKey.user, id: decode(options.publicKey.user.id) },
+    excludeCredentials: decodeDescriptors(options.publicKey.excludeCredentials)
+  }
+  const credential = await navigator.credentials.create({ publicKey })
+  return {
+    id: credential.id,
+    rawId: encode(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: encode(credential.response.clientDataJSON),
+      attestationObject: encode(credential.response.attestationObject),
+      transports: credential.response.getTransports ? credential.response.getTransports() : []
+    }
+  }
+}
+
+export async function getCredential(options) {
+  const publicKey = {
+    ...options.publicKey,
+    challenge: decode(options.publicKey.challenge),
+    allowCredentials: decodeDescriptors(options.publicKey.allowCredentials)
+  }
+  const credential = await navigator.credentials.get({ publicKey })
+  return {
+    id: credential.id,
+    rawId: encode(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: encode(credential.response.clientDataJSON),
+      authenticatorData: encode(credential.response.authenticatorData),
+      signature: encode(credential.response.signature),
+      userHandle: credential.response.userHandle ? encode(credential.response.userHandle) : undefined
+    }
+  }
+}
@@ -21,6 +21,14 @@ export default (api) => [
       noAuth: true
     }
   },
+  {
+    path: '/auth/reset',
+    component: () => import('@/views/PasswordReset.vue'),
+    name: 'PasswordReset',
+    meta: {
+      noAuth: true
+    }
+  },
   {
     path: '/auth/invite',
     component: () => import('@/views/Invite.vue'),
@@ -29,6 +37,11 @@ export default (api) => [
       noAuth: true
     }
   },
+  {
+    path: '/auth/authorize',
+    component: () => import('@/views/OAuth2Authorize.vue'),
+    name: 'OAuth2Authorize'
+  },
   {
     path: '/servers',
     component: () => import('@/views/ServerList.vue'),
@@ -16,6 +16,7 @@ import (
 	"github.com/pufferpanel/pufferpanel/v3/servers"
 	"github.com/pufferpanel/pufferpanel/v3/services"
 	"github.com/pufferpanel/pufferpanel/v3/sftp"
+	"github.com/pufferpanel/pufferpanel/v3/tunnel"
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"github.com/pufferpanel/pufferpanel/v3/web"
 	"github.com/spf13/cobra"
@@ -102,6 +103,10 @@ func internalRun() (terminate chan bool, success bool) {
 			terminate <- true
 			return
 		}
+		services.StartLDAPSync(db)
+		services.StartAuditPruning(db)
+		services.StartNodeMonitor(db)
+
 		sessionStore := cookie.NewStore(result)
 		router.Use(sessions.Sessions("session", sessionStore))
 
@@ -121,6 +126,11 @@ func internalRun() (terminate chan bool, success bool) {
 
 	web.RegisterRoutes(router)
 
+	//the tunnel serves the routes, so it can only be opened once they are all there
+	if config.DaemonEnabled.Value() {
+		tunnel.Run()
+	}
+
 	l, err := net.Listen("tcp", config.WebHost.Value())
 	if err != nil {
 		logging.Error.Printf("error starting http server: %s", err.Error())
@@ -155,6 +165,9 @@ func closePanel() {
 	logging.Debug.Printf("stopping sftp server")
 	sftp.Stop()
 
+	logging.Debug.Printf("stopping tunnel")
+	tunnel.Stop()
+
 	logging.Debug.Printf("stopping servers")
 	servers.ShutdownService()
 	for _, p := range servers.GetAll() {
@@ -162,6 +175,10 @@ func closePanel() {
 		p.RunningEnvironment.WaitForMainProcessFor(time.Minute) //wait 60 seconds
 	}
 
+	services.StopLDAPSync()
+	services.StopAuditPruning()
+	services.StopNodeMonitor()
+
 	logging.Debug.Printf("stopping database connections")
 	database.Close()
 }
@@ -29,6 +29,17 @@ var ThemeSettings = asString("panel.settings.themeSettings", "{}")
 var MasterUrl = asString("panel.settings.masterUrl", "http://localhost:8080")
 var SessionKey = asString("panel.sessionKey", "")
 var RegistrationEnabled = asBool("panel.registrationEnabled", true)
+var PasswordResetExpiry = asInt("panel.passwordReset.expiry", 60)
+var PasswordResetLimit = asInt("panel.passwordReset.limit", 3)
+var AuditRetention = asInt("panel.audit.retention", 90)
+var LoginAttemptLimit = asInt("panel.login.attempts", 5)
+var LoginAttemptIpLimit = asInt("panel.login.ipAttempts", 20)
+var LoginAttemptWindow = asInt("panel.login.window", 15)
+var LoginLockoutTime = asInt("panel.login.lockout", 5)
+var LoginLockoutMaxTime = asInt("panel.login.lockoutMax", 1440)
+var NodeCheckInterval = asInt("panel.nodes.checkInterval", 60)
+var NodeCheckTimeout = asInt("panel.nodes.checkTimeout", 10)
+var NodeOfflineAfter = asInt("panel.nodes.offlineAfter", 2)
 var PrivateKey = asString("panel.token", "")
 
 var DaemonEnabled = asBool("daemon.enable", true)
@@ -36,9 +47,13 @@ var ConsoleBuffer = asInt("daemon.console.buffer", 50)
 var ConsoleForward = asBool("daemon.console.forward", false)
 var SftpHost = asString("daemon.sftp.host", "0.0.0.0:5657")
 var SftpKey = asDataFolder("daemon.sftp.key", "sftp.key")
+var SftpLogLimit = asInt("daemon.sftp.log.limit", 10000)
+var SftpBroadcast = asBool("daemon.sftp.broadcast", true)
+var SftpRsync = asBool("daemon.sftp.rsync", true)
 var AuthUrl = asString("daemon.auth.url", "http://localhost:8080")
 var ClientId = asString("daemon.auth.clientId", "")
 var ClientSecret = asString("daemon.auth.clientSecret", "")
+var TunnelEnabled = asBool("daemon.tunnel.enable", false)
 var CacheFolder = asDataFolder("daemon.data.cache", "cache")
 var ServersFolder = asDataFolder("daemon.data.servers", "servers")
 var BackupsFolder = asDataFolder("daemon.data.backups.folder", "backups")
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+var LdapEnabled = asBool("panel.ldap.enable", false)
+var LdapUrl = asString("panel.ldap.url", "")
+var LdapStartTls = asBool("panel.ldap.startTls", false)
+var LdapInsecureSkipVerify = asBool("panel.ldap.insecureSkipVerify", false)
+var LdapBindDn = asString("panel.ldap.bindDn", "")
+var LdapBindPassword = asString("panel.ldap.bindPassword", "")
+var LdapUserDn = asString("panel.ldap.userDn", "")
+var LdapBaseDn = asString("panel.ldap.baseDn", "")
+var LdapUserFilter = asString("panel.ldap.userFilter", "(&(objectClass=person)(mail={email}))")
+var LdapDisabledFilter = asString("panel.ldap.disabledFilter", "")
+var LdapEmailAttribute = asString("panel.ldap.emailAttribute", "mail")
+var LdapUsernameAttribute = asString("panel.ldap.usernameAttribute", "uid")
+var LdapGroupAttribute = asString("panel.ldap.groupAttribute", "memberOf")
+var LdapSyncInterval = asInt("panel.ldap.syncInterval", 60)
+
+// LDAPGroup maps the members of an LDAP group to the scopes they get
+type LDAPGroup struct {
+	//Group is the DN of the group, as it appears in the group attribute of its members
+	Group   string              `mapstructure:"group" json:"group"`
+	Scopes  []string            `mapstructure:"scopes" json:"scopes"`
+	Servers map[string][]string `mapstructure:"servers" json:"servers"`
+}
+
+type LDAPGroupsEntry struct {
+	key string
+}
+
+var LdapGroups = LDAPGroupsEntry{key: "panel.ldap.groups"}
+
+func (e LDAPGroupsEntry) Key() string {
+	return e.key
+}
+
+func (e LDAPGroupsEntry) Value() []LDAPGroup {
+	var groups []LDAPGroup
+	if err := viper.UnmarshalKey(e.key, &groups); err != nil {
+		return nil
+	}
+	return groups
+}
+
+func (e LDAPGroupsEntry) Set(value []LDAPGroup, save bool) error {
+	viper.Set(e.key, value)
+
+	if save {
+		return viper.WriteConfig()
+	}
+	return nil
+}
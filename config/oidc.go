@@ -0,0 +1,63 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+)
+
+// OIDCProvider is an external OpenID Connect provider users can log in with
+type OIDCProvider struct {
+	//Name is used in the login and callback urls, so should be short and url safe
+	Name         string   `mapstructure:"name" json:"name"`
+	DisplayName  string   `mapstructure:"displayName" json:"displayName"`
+	Issuer       string   `mapstructure:"issuer" json:"issuer"`
+	ClientId     string   `mapstructure:"clientId" json:"clientId"`
+	ClientSecret string   `mapstructure:"clientSecret" json:"clientSecret"`
+	Scopes       []string `mapstructure:"scopes" json:"scopes"`
+
+	//claims to read the user's details from, the standard claims are used when not set
+	SubjectClaim       string `mapstructure:"subjectClaim" json:"subjectClaim"`
+	EmailClaim         string `mapstructure:"emailClaim" json:"emailClaim"`
+	EmailVerifiedClaim string `mapstructure:"emailVerifiedClaim" json:"emailVerifiedClaim"`
+	UsernameClaim      string `mapstructure:"usernameClaim" json:"usernameClaim"`
+
+	//AllowRegistration creates an account for users who log in without having one, with the DefaultScopes
+	AllowRegistration bool     `mapstructure:"allowRegistration" json:"allowRegistration"`
+	DefaultScopes     []string `mapstructure:"defaultScopes" json:"defaultScopes"`
+}
+
+type OIDCProvidersEntry struct {
+	key string
+}
+
+var OidcProviders = OIDCProvidersEntry{key: "panel.oidc.providers"}
+
+func (e OIDCProvidersEntry) Key() string {
+	return e.key
+}
+
+func (e OIDCProvidersEntry) Value() []OIDCProvider {
+	var providers []OIDCProvider
+	if err := viper.UnmarshalKey(e.key, &providers); err != nil {
+		return nil
+	}
+	return providers
+}
+
+func (e OIDCProvidersEntry) Set(value []OIDCProvider, save bool) error {
+	viper.Set(e.key, value)
+
+	if save {
+		return viper.WriteConfig()
+	}
+	return nil
+}
+
+// Get gets the provider with the given name
+func (e OIDCProvidersEntry) Get(name string) (OIDCProvider, bool) {
+	for _, v := range e.Value() {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return OIDCProvider{}, false
+}
@@ -23,6 +23,23 @@ func Migrate(dbConn *gorm.DB) error {
 		&models.Session{},
 		&models.TemplateRepo{},
 		&models.Backup{},
+		&models.SSHKey{},
+		&models.PasswordReset{},
+		&models.OIDCIdentity{},
+		&models.LDAPIdentity{},
+		&models.WebAuthnCredential{},
+		&models.RecoveryCode{},
+		&models.AuditLog{},
+		&models.Role{},
+		&models.PermissionRole{},
+		&models.AuthorizationCode{},
+		&models.RefreshToken{},
+		&models.APIToken{},
+		&models.LoginLockout{},
+		&models.NodeStatus{},
+		&models.PortRange{},
+		&models.PortAllocation{},
+		&models.ServerMigration{},
 	}
 
 	session := dbConn.Session(&gorm.Session{})
@@ -330,6 +347,50 @@ func Migrate(dbConn *gorm.DB) error {
 					}
 				}
 
+				return nil
+			},
+		},
+		{
+			ID: "node-port-ranges",
+			Migrate: func(db *gorm.DB) error {
+				logging.Info.Printf("Migrate id:node-port-ranges")
+
+				//nodes used to have a single range of ports on every IP, which is now a range in their pool
+				if !db.Migrator().HasColumn(&models.Node{}, "port_min") {
+					return nil
+				}
+
+				type node struct {
+					ID      uint
+					PortMin uint16
+					PortMax uint16
+				}
+
+				var nodes []*node
+				err := db.Table("nodes").Where("port_min <> 0 OR port_max <> 0").Find(&nodes).Error
+				if err != nil {
+					return err
+				}
+
+				for _, v := range nodes {
+					//0 was no limit on that end
+					portRange := &models.PortRange{NodeId: v.ID, IP: models.AnyIP, PortMin: max(v.PortMin, 1), PortMax: v.PortMax}
+					if portRange.PortMax == 0 {
+						portRange.PortMax = 65535
+					}
+					err = db.Create(portRange).Error
+					if err != nil {
+						return err
+					}
+				}
+
+				for _, v := range []string{"port_min", "port_max"} {
+					err = db.Migrator().DropColumn(&models.Node{}, v)
+					if err != nil {
+						return err
+					}
+				}
+
 				return nil
 			},
 		},
@@ -56,6 +56,8 @@ type Environment interface {
 
 	AddStatsListener(ws *Socket)
 
+	AddFilesListener(ws *Socket)
+
 	GetStats() (*ServerStats, error)
 
 	DisplayToConsole(prefix bool, msg string, data ...interface{})
@@ -70,6 +72,8 @@ type Environment interface {
 
 	GetStatsTracker() *Tracker
 
+	GetFilesTracker() *Tracker
+
 	GetServer() Server
 
 	GetUid() int
@@ -91,6 +95,7 @@ type BaseEnvironment struct {
 	ConsoleTracker    *Tracker             `json:"-"`
 	StatusTracker     *Tracker             `json:"-"`
 	StatsTracker      *Tracker             `json:"-"`
+	FilesTracker      *Tracker             `json:"-"`
 	Installing        bool                 `json:"-"`
 	BackingUp         bool                 `json:"-"`
 	IsRunningFunc     func() (bool, error) `json:"-"`
@@ -186,10 +191,18 @@ func (e *BaseEnvironment) AddStatusListener(ws *Socket) {
 	e.StatusTracker.Register(ws)
 }
 
+func (e *BaseEnvironment) AddFilesListener(ws *Socket) {
+	e.FilesTracker.Register(ws)
+}
+
 func (e *BaseEnvironment) GetStatsTracker() *Tracker {
 	return e.StatsTracker
 }
 
+func (e *BaseEnvironment) GetFilesTracker() *Tracker {
+	return e.FilesTracker
+}
+
 func (e *BaseEnvironment) DisplayToConsole(daemon bool, msg string, data ...interface{}) {
 	format := msg
 	if daemon {
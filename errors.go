@@ -53,6 +53,26 @@ var ErrEnvironmentNotSupported = CreateError("environment not supported", "ErrEn
 var ErrPasswordRequirements = CreateError("password does not meet requirements", "ErrPasswordRequirements")
 var ErrBackupInProgress = CreateError("the server is currently perfoming a backup or restore, please wait for this process to be complete", "ErrBackupInProgress")
 var ErrBackupServerRunning = CreateError("the server is currently running, please stop the server before doing backup actions", "ErrBackupServerRunning")
+var ErrSSHKeyInvalid = CreateError("public key is not a valid SSH key", "ErrSSHKeyInvalid")
+var ErrSSHKeyExists = CreateError("public key is already registered", "ErrSSHKeyExists")
+var ErrPasswordResetInvalid = CreateError("password reset link is invalid or has expired", "ErrPasswordResetInvalid")
+var ErrPasswordResetLimit = CreateError("too many password resets requested", "ErrPasswordResetLimit")
+var ErrOIDCProviderNotFound = CreateError("login provider not found", "ErrOIDCProviderNotFound")
+var ErrOIDCLoginFailed = CreateError("login with provider failed", "ErrOIDCLoginFailed")
+var ErrOIDCNoAccount = CreateError("no account is linked to this login", "ErrOIDCNoAccount")
+var ErrLDAPManaged = CreateError("account is managed by LDAP", "ErrLDAPManaged")
+var ErrLDAPAccountExists = CreateError("an account with this email already has permissions in the panel, so it can't be taken over by LDAP", "ErrLDAPAccountExists")
+var ErrWebAuthnFailed = CreateError("security key could not be verified", "ErrWebAuthnFailed")
+var ErrWebAuthnExists = CreateError("security key is already registered", "ErrWebAuthnExists")
+var ErrNoSecondFactor = CreateError("no second factor is enabled", "ErrNoSecondFactor")
+var ErrRoleExists = CreateError("a role with this name already exists", "ErrRoleExists")
+var ErrInvalidAuthorizationCode = CreateError("authorization code is invalid or expired", "ErrInvalidAuthorizationCode")
+var ErrInvalidRefreshToken = CreateError("refresh token is invalid or expired", "ErrInvalidRefreshToken")
+var ErrAPITokenExpiry = CreateError("expiry must be in the future, and no more than a year away", "ErrAPITokenExpiry")
+var ErrAPITokenNoScopes = CreateError("token must have at least one scope you have", "ErrAPITokenNoScopes")
+var ErrAPITokenServers = CreateError("token can only be limited to servers the token creating it can use", "ErrAPITokenServers")
+var ErrClientsNeedLogin = CreateError("clients can only be created or given roles when logged in, not with a token or an app", "ErrClientsNeedLogin")
+var ErrLoginLocked = CreateError("too many failed logins, try again later", "ErrLoginLocked")
 
 func CreateErrMissingScope(scope scopes.Scope) *Error {
 	return CreateError(ErrMissingScope.Message, ErrMissingScope.Code).Metadata(map[string]interface{}{"scope": scope})
@@ -70,6 +90,18 @@ var ErrServiceInvalidProvider = func(service, provider string) *Error {
 	return CreateError("{service} does not support ${provider}", "ErrServiceInvalidProvider").Metadata(map[string]interface{}{"service": service, "provider": provider})
 }
 
+var ErrRoleScopeNotForServer = func(scope string) *Error {
+	return CreateError("${scope} cannot be given on a server", "ErrRoleScopeNotForServer").Metadata(map[string]interface{}{"scope": scope})
+}
+
+var ErrRoleLevelMismatch = func(role string) *Error {
+	return CreateError("role ${role} cannot be given here", "ErrRoleLevelMismatch").Metadata(map[string]interface{}{"role": role})
+}
+
+var ErrInvalidRedirectUri = func(uri string) *Error {
+	return CreateError("${uri} is not a valid redirect uri", "ErrInvalidRedirectUri").Metadata(map[string]interface{}{"uri": uri})
+}
+
 var ErrFieldRequired = func(fieldName string) *Error {
 	return CreateError("${field} is required", "ErrFieldRequired").Metadata(map[string]interface{}{"field": fieldName})
 }
@@ -123,6 +155,30 @@ var ErrFactoryError = func(operatorName string, err error) *Error {
 }
 
 var ErrNodeInvalid = CreateError("node is invalid", "ErrNodeInvalid")
+var ErrInvalidPlacement = CreateError("placement must be binpack or spread", "ErrInvalidPlacement")
+var ErrNoNodeAvailable = CreateError("no node can fit this server", "ErrNoNodeAvailable")
+
+var ErrNodeOverCommitted = func(resource string) *Error {
+	return CreateError("node does not have enough ${resource} left for this server", "ErrNodeOverCommitted").Metadata(map[string]interface{}{"resource": resource})
+}
+
+var ErrNoFreePort = CreateError("node has no free ports left", "ErrNoFreePort")
+var ErrPortRangeOverlaps = CreateError("port range overlaps another range on the node", "ErrPortRangeOverlaps")
+
+var ErrPortNotInPool = func(ip string, port uint16) *Error {
+	return CreateError("port ${port} on ${ip} is not in any of the node's port ranges", "ErrPortNotInPool").Metadata(map[string]interface{}{"ip": ip, "port": port})
+}
+
+var ErrPortInUse = func(ip string, port uint16) *Error {
+	return CreateError("port ${port} on ${ip} is already in use by another server", "ErrPortInUse").Metadata(map[string]interface{}{"ip": ip, "port": port})
+}
+
+var ErrNodeOffline = CreateError("node is offline", "ErrNodeOffline")
+var ErrMigrationSameNode = CreateError("server is already on this node", "ErrMigrationSameNode")
+var ErrServerMigrating = CreateError("server is being moved to another node", "ErrServerMigrating")
+var ErrTransferInProgress = CreateError("server files are being transferred", "ErrTransferInProgress")
+var ErrNodeNotConnected = CreateError("node is not connected through its tunnel", "ErrNodeNotConnected")
+var ErrMigrationFromTunnel = CreateError("servers can't be moved off a node which is only reached through its tunnel", "ErrMigrationFromTunnel")
 
 var ErrUnsupportedOS = func(actual, expected string) *Error {
 	return CreateError("OS (${actual}) not supported. Supported OS: ${expected}", "ErrUnsupportedOS").Metadata(map[string]interface{}{"actual": actual, "expected": expected})
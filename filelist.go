@@ -10,9 +10,17 @@ type FileList struct {
 }
 
 type FileDesc struct {
-	Name      string `json:"name"`
-	Modified  int64  `json:"modifyTime,omitempty"`
-	Size      int64  `json:"size,omitempty"`
-	File      bool   `json:"isFile"`
-	Extension string `json:"extension,omitempty"`
+	Name           string `json:"name"`
+	Modified       int64  `json:"modifyTime,omitempty"`
+	Size           int64  `json:"size,omitempty"`
+	File           bool   `json:"isFile"`
+	Extension      string `json:"extension,omitempty"`
+	Mode           string `json:"mode,omitempty"`
+	Uid            *int   `json:"uid,omitempty"`
+	Gid            *int   `json:"gid,omitempty"`
+	Symlink        bool   `json:"isSymlink,omitempty"`
+	SymlinkTarget  string `json:"symlinkTarget,omitempty"`
+	SymlinkEscapes bool   `json:"symlinkEscapes,omitempty"`
+	Mime           string `json:"mime,omitempty"`
+	Children       *int   `json:"children,omitempty"`
 } //@name FileDescription
@@ -89,7 +89,21 @@ func Compress(fs FileServer, targetFile string, files []string) error {
 		}
 	}
 
-	return archiver.Archive(files, targetFile)
+	err := archiver.Archive(files, targetFile)
+	if err != nil || fs == nil {
+		return err
+	}
+
+	//the archiver writes directly to disk, so the quota can only be checked once it is done
+	info, err := os.Stat(targetFile)
+	if err != nil {
+		return err
+	}
+	if err = fs.GetQuota().Reserve(info.Size()); err != nil {
+		_ = os.Remove(targetFile)
+		return err
+	}
+	return nil
 }
 
 func walker(fs FileServer, targetPath, filter string, skipRoot bool) archiver.WalkFunc {
@@ -127,9 +141,9 @@ func walker(fs FileServer, targetPath, filter string, skipRoot bool) archiver.Wa
 					return err
 				}
 			}
-			var outFile *os.File
+			var outFile io.WriteCloser
 			if fs != nil {
-				outFile, err = fs.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode())
+				outFile, err = fs.OpenTracked(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode())
 			} else {
 				outFile, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode())
 			}
@@ -0,0 +1,41 @@
+package files
+
+import (
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DetectContentType sniffs the mime type of a file from the first bytes of it. The file is opened without blocking, so
+// a file swapped for a FIFO doesn't hang waiting for a writer.
+func DetectContentType(fsys FileServer, path string) (string, error) {
+	file, err := fsys.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return "", err
+	}
+	defer utils.Close(file)
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// SymlinkEscapes determines if a link in the folder dir, pointing at target, would leave the server root.
+// Absolute links are always considered to escape, as they cannot be followed from within the root.
+func SymlinkEscapes(dir, target string) bool {
+	if filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return true
+	}
+
+	dir = strings.TrimPrefix(filepath.Clean("/"+dir), string(filepath.Separator))
+	resolved := filepath.Join(dir, target)
+	return resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator))
+}
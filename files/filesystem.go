@@ -3,9 +3,11 @@ package files
 import (
 	"errors"
 	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 type FileServer interface {
@@ -23,6 +25,20 @@ type FileServer interface {
 	Rename(source, target string) error
 	RemoveAll(path string) error
 	Glob(pattern string) ([]string, error)
+	Copy(source, target string, progress func(copied int64)) error
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, uid, gid int) error
+	Readlink(path string) (string, error)
+
+	OpenTracked(path string, flags int, mode os.FileMode) (*QuotaFile, error)
+	GetQuota() *Quota
+	CalculateUsage(extra ...string) (int64, error)
+
+	CreateUpload(path string, size int64) (*Upload, error)
+	GetUpload(id string) (*Upload, error)
+	WriteUpload(id string, offset int64, data io.Reader) (*Upload, error)
+	FinishUpload(id string, checksum string) error
+	CancelUpload(id string) error
 
 	Close() error
 }
@@ -33,10 +49,13 @@ type fileServer struct {
 
 	uid int
 	gid int
+
+	uploads *uploadTracker
+	quota   *Quota
 }
 
 func NewFileServer(prefix string, uid, gid int) (FileServer, error) {
-	f := &fileServer{dir: prefix, uid: uid, gid: gid}
+	f := &fileServer{dir: prefix, uid: uid, gid: gid, uploads: &uploadTracker{uploads: make(map[string]*Upload)}, quota: &Quota{}}
 	var err error
 	f.root, err = f.resolveRootFd()
 	if err != nil {
@@ -54,7 +73,8 @@ func (sfp *fileServer) Open(name string) (fs.File, error) {
 }
 
 func (sfp *fileServer) Stat(name string) (fs.FileInfo, error) {
-	f, err := sfp.Open(name)
+	//opened without blocking, as opening a FIFO otherwise waits for something to write to it
+	f, err := sfp.OpenFile(name, os.O_RDONLY|syscall.O_NONBLOCK, 0)
 	if err != nil {
 		return nil, err
 	}
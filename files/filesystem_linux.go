@@ -113,7 +113,14 @@ func (sfp *fileServer) Rename(source, target string) error {
 	}
 	defer utils.Close(targetFolder)
 
+	//whatever is being replaced no longer counts against the quota
+	var stat unix.Stat_t
+	statErr := unix.Fstatat(getFd(targetFolder), targetName, &stat, unix.AT_SYMLINK_NOFOLLOW)
+
 	err = unix.Renameat2(getFd(sourceFolder), sourceName, getFd(targetFolder), targetName, 0)
+	if err == nil && statErr == nil && stat.Mode&unix.S_IFMT == unix.S_IFREG {
+		sfp.quota.Release(stat.Size)
+	}
 	return err
 }
 
@@ -173,10 +180,22 @@ func (sfp *fileServer) Remove(path string) error {
 	if stat.IsDir() {
 		return unix.Unlinkat(getFd(folder), f, unix.AT_REMOVEDIR)
 	} else {
-		return unix.Unlinkat(getFd(folder), f, 0)
+		return sfp.unlink(folder, f)
 	}
 }
 
+// unlink removes a file from the folder, giving its space back to the quota
+func (sfp *fileServer) unlink(folder *os.File, name string) error {
+	var stat unix.Stat_t
+	statErr := unix.Fstatat(getFd(folder), name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+
+	err := unix.Unlinkat(getFd(folder), name, 0)
+	if err == nil && statErr == nil && stat.Mode&unix.S_IFMT == unix.S_IFREG {
+		sfp.quota.Release(stat.Size)
+	}
+	return err
+}
+
 func (sfp *fileServer) RemoveAll(path string) error {
 	path = prepPath(path)
 
@@ -200,7 +219,7 @@ func (sfp *fileServer) RemoveAll(path string) error {
 				return err
 			}
 		} else {
-			err = unix.Unlinkat(getFd(folder), v.Name(), 0)
+			err = sfp.unlink(folder, v.Name())
 			if err != nil {
 				return err
 			}
@@ -211,6 +230,68 @@ func (sfp *fileServer) RemoveAll(path string) error {
 	return err
 }
 
+func (sfp *fileServer) Chmod(path string, mode os.FileMode) error {
+	file, err := sfp.openForAttributes(path)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(file)
+
+	return file.Chmod(mode)
+}
+
+func (sfp *fileServer) Chown(path string, uid, gid int) error {
+	file, err := sfp.openForAttributes(path)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(file)
+
+	return file.Chown(uid, gid)
+}
+
+// openForAttributes opens a file or folder so the attributes can be changed through the fd.
+// Non-blocking so a fifo does not hang us, and only regular files and folders are permitted.
+func (sfp *fileServer) openForAttributes(path string) (*os.File, error) {
+	file, err := sfp.OpenFile(path, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		utils.Close(file)
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() && !fi.IsDir() {
+		utils.Close(file)
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrPermission}
+	}
+	return file, nil
+}
+
+func (sfp *fileServer) Readlink(path string) (string, error) {
+	path = prepPath(path)
+	parent := filepath.Dir(path)
+
+	folder, err := sfp.OpenFile(parent, os.O_RDONLY, 0755)
+	if err != nil {
+		return "", err
+	}
+	defer utils.Close(folder)
+
+	for size := 256; ; size *= 2 {
+		buf := make([]byte, size)
+		n, err := unix.Readlinkat(getFd(folder), filepath.Base(path), buf)
+		if err != nil {
+			return "", &os.PathError{Op: "readlink", Path: path, Err: err}
+		}
+		if n < size {
+			return string(buf[:n]), nil
+		}
+	}
+}
+
 func getFd(f *os.File) int {
 	return int(f.Fd())
 }
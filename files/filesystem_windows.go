@@ -81,7 +81,13 @@ func (sfp *fileServer) Rename(source, target string) error {
 	}
 	defer utils.Close(targetFolder)
 
+	//whatever is being replaced no longer counts against the quota
+	info, statErr := os.Lstat(filepath.Join(sfp.dir, targetParent, targetName))
+
 	err = os.Rename(filepath.Join(sfp.dir, sourceParent, sourceName), filepath.Join(sfp.dir, targetParent, targetName))
+	if err == nil && statErr == nil && info.Mode().IsRegular() {
+		sfp.quota.Release(info.Size())
+	}
 	return err
 }
 
@@ -107,7 +113,12 @@ func (sfp *fileServer) Remove(path string) error {
 	}
 	defer utils.Close(folder)
 
-	return os.Remove(filepath.Join(sfp.dir, path))
+	info, statErr := os.Lstat(filepath.Join(sfp.dir, path))
+	err = os.Remove(filepath.Join(sfp.dir, path))
+	if err == nil && statErr == nil && info.Mode().IsRegular() {
+		sfp.quota.Release(info.Size())
+	}
+	return err
 }
 
 func (sfp *fileServer) RemoveAll(path string) error {
@@ -120,7 +131,40 @@ func (sfp *fileServer) RemoveAll(path string) error {
 	}
 	defer utils.Close(folder)
 
-	return os.Remove(filepath.Join(sfp.dir, path))
+	info, statErr := os.Lstat(filepath.Join(sfp.dir, path))
+	err = os.Remove(filepath.Join(sfp.dir, path))
+	if err == nil && statErr == nil && info.Mode().IsRegular() {
+		sfp.quota.Release(info.Size())
+	}
+	return err
+}
+
+func (sfp *fileServer) Chmod(path string, mode os.FileMode) error {
+	path = prepPath(path)
+
+	file, err := sfp.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	utils.Close(file)
+
+	return os.Chmod(filepath.Join(sfp.dir, path), mode)
+}
+
+func (sfp *fileServer) Chown(path string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: path, Err: errors.ErrUnsupported}
+}
+
+func (sfp *fileServer) Readlink(path string) (string, error) {
+	path = prepPath(path)
+
+	folder, err := sfp.OpenFile(filepath.Dir(path), os.O_RDONLY, 0755)
+	if err != nil {
+		return "", err
+	}
+	utils.Close(folder)
+
+	return os.Readlink(filepath.Join(sfp.dir, path))
 }
 
 func prepPath(path string) string {
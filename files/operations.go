@@ -0,0 +1,136 @@
+package files
+
+import (
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrCopyIntoSelf = errors.New("cannot copy a folder into itself")
+
+// Copy copies source to target, recursing into folders.
+// Symlinks are not copied, as they may point to places the target should not.
+// If progress is provided, it is called with the number of bytes copied so far.
+func (sfp *fileServer) Copy(source, target string, progress func(copied int64)) error {
+	source = prepPath(source)
+	target = prepPath(target)
+
+	if source == target || strings.HasPrefix(target, source+string(filepath.Separator)) {
+		return ErrCopyIntoSelf
+	}
+
+	c := &copier{fs: sfp, progress: progress}
+	return c.copy(source, target)
+}
+
+// Size gets the total size of all files under path
+func Size(fsys FileServer, path string) (int64, error) {
+	var total int64
+	err := Walk(fsys, path, func(path string, d fs.DirEntry) error {
+		if d.Type().IsRegular() {
+			//entries from our folders do not know their full path, so stat through the server
+			info, err := fsys.Stat(path)
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// Walk goes through every item under path, including path itself. Symlinks are not followed.
+func Walk(fsys FileServer, path string, fn func(path string, d fs.DirEntry) error) error {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	if path == "" {
+		path = "."
+	}
+
+	return fs.WalkDir(fsys, path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return fn(path, d)
+	})
+}
+
+type copier struct {
+	fs       FileServer
+	progress func(copied int64)
+	copied   int64
+}
+
+func (c *copier) copy(source, target string) error {
+	info, err := c.fs.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return c.copyFile(source, target, info.Mode())
+	}
+
+	err = c.fs.Mkdir(target, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	entries, err := c.fs.ReadDir(source)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range entries {
+		if v.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		err = c.copy(filepath.Join(source, v.Name()), filepath.Join(target, v.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *copier) copyFile(source, target string, mode os.FileMode) error {
+	if !mode.IsRegular() {
+		return nil
+	}
+
+	in, err := c.fs.OpenFile(source, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(in)
+
+	out, err := c.fs.OpenTracked(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer utils.Close(out)
+
+	var w io.Writer = out
+	if c.progress != nil {
+		w = &progressWriter{Writer: out, c: c}
+	}
+
+	_, err = io.Copy(w, in)
+	return err
+}
+
+type progressWriter struct {
+	io.Writer
+	c *copier
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.Writer.Write(b)
+	p.c.copied += int64(n)
+	p.c.progress(p.c.copied)
+	return n, err
+}
@@ -0,0 +1,15 @@
+package files
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Owner gets the uid and gid of the file, if the platform supports it
+func Owner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
@@ -0,0 +1,290 @@
+package files
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
+// Quota tracks the disk usage of a server, and limits how much can be written through the file server.
+// Usage is kept up to date as data is written and removed, and is only fully recalculated when asked.
+type Quota struct {
+	limit   int64
+	warning int64
+	used    int64
+	warned  bool
+	lock    sync.Mutex
+
+	//OnWarning is called once usage goes over the warning threshold
+	OnWarning func(used, limit int64)
+}
+
+// SetLimits changes the limits of the quota. A limit of 0 is unlimited, and a warning of 0 disables the warning.
+func (q *Quota) SetLimits(limit, warning int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.limit = limit
+	q.warning = warning
+	q.warned = false
+}
+
+func (q *Quota) Limit() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.limit
+}
+
+func (q *Quota) Used() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.used
+}
+
+// Reserve records n more bytes as used, failing if this would put usage over the limit
+func (q *Quota) Reserve(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+
+	q.lock.Lock()
+	if q.limit > 0 && q.used+n > q.limit {
+		q.lock.Unlock()
+		return ErrQuotaExceeded
+	}
+	q.used += n
+	q.lock.Unlock()
+
+	q.checkWarning()
+	return nil
+}
+
+// Add records n more bytes as used, even if it is over the limit.
+// This is for data which has already been written by something we could not stop.
+func (q *Quota) Add(n int64) {
+	q.lock.Lock()
+	q.used += n
+	if q.used < 0 {
+		q.used = 0
+	}
+	q.lock.Unlock()
+
+	q.checkWarning()
+}
+
+// Release records n bytes as no longer used
+func (q *Quota) Release(n int64) {
+	if n <= 0 {
+		return
+	}
+	q.Add(-n)
+}
+
+// Set replaces the usage, such as after a full recalculation
+func (q *Quota) Set(used int64) {
+	q.lock.Lock()
+	q.used = used
+	q.lock.Unlock()
+
+	q.checkWarning()
+}
+
+// Available gets how many bytes can still be written, or -1 if there is no limit
+func (q *Quota) Available() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.limit <= 0 {
+		return -1
+	}
+	if q.used >= q.limit {
+		return 0
+	}
+	return q.limit - q.used
+}
+
+func (q *Quota) checkWarning() {
+	q.lock.Lock()
+	fire := false
+	if q.warning > 0 && q.used >= q.warning {
+		fire = !q.warned
+		q.warned = true
+	} else {
+		q.warned = false
+	}
+	used, limit, callback := q.used, q.limit, q.OnWarning
+	q.lock.Unlock()
+
+	if fire && callback != nil {
+		callback(used, limit)
+	}
+}
+
+// QuotaFile is a file opened for writing, where any growth is counted against the quota
+type QuotaFile struct {
+	file     *os.File
+	quota    *Quota
+	size     int64
+	position int64
+	lock     sync.Mutex
+}
+
+func (f *QuotaFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	n, err := f.writeAt(p, f.position)
+	f.position += int64(n)
+	return n, err
+}
+
+func (f *QuotaFile) WriteAt(p []byte, off int64) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.writeAt(p, off)
+}
+
+func (f *QuotaFile) writeAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if end > f.size {
+		if err := f.quota.Reserve(end - f.size); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.WriteAt(p, off)
+	if written := off + int64(n); end > f.size {
+		//give back what was reserved but did not end up being written
+		if written < end {
+			f.quota.Release(end - max(written, f.size))
+		}
+		if written > f.size {
+			f.size = written
+		}
+	}
+	return n, err
+}
+
+func (f *QuotaFile) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch whence {
+	case 0:
+		f.position = offset
+	case 1:
+		f.position += offset
+	case 2:
+		f.position = f.size + offset
+	}
+	return f.position, nil
+}
+
+func (f *QuotaFile) Truncate(size int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if size > f.size {
+		if err := f.quota.Reserve(size - f.size); err != nil {
+			return err
+		}
+	}
+	err := f.file.Truncate(size)
+	if err != nil {
+		if size > f.size {
+			f.quota.Release(size - f.size)
+		}
+		return err
+	}
+	if size < f.size {
+		f.quota.Release(f.size - size)
+	}
+	f.size = size
+	return nil
+}
+
+func (f *QuotaFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f *QuotaFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *QuotaFile) Close() error {
+	return f.file.Close()
+}
+
+// OpenTracked opens a file for writing, where any growth of the file is counted against the quota.
+// Anything removed by O_TRUNC is given back to the quota.
+func (sfp *fileServer) OpenTracked(path string, flags int, mode os.FileMode) (*QuotaFile, error) {
+	//appending is handled by us, as the file would not allow WriteAt otherwise
+	file, err := sfp.OpenFile(path, flags&^(os.O_TRUNC|os.O_APPEND), mode)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	size := info.Size()
+	if flags&os.O_TRUNC != 0 && size > 0 {
+		if err = file.Truncate(0); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		sfp.quota.Release(size)
+		size = 0
+	}
+
+	var position int64
+	if flags&os.O_APPEND != 0 {
+		position = size
+	}
+
+	return &QuotaFile{file: file, quota: sfp.quota, size: size, position: position}, nil
+}
+
+func (sfp *fileServer) GetQuota() *Quota {
+	return sfp.quota
+}
+
+// CalculateUsage walks the server to determine how much space is used, updating the quota with the result.
+// Any extra folders, such as backups, are included in the total.
+func (sfp *fileServer) CalculateUsage(extra ...string) (int64, error) {
+	total, err := Size(sfp, "/")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, v := range extra {
+		err = filepath.WalkDir(v, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.Type().IsRegular() {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	sfp.quota.Set(total)
+	return total, nil
+}
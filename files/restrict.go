@@ -0,0 +1,62 @@
+package files
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// NormalizeRestrictions cleans up a list of allowed paths, so they can be compared against requested paths.
+// Paths are always relative to the server root, so nothing can point outside of it.
+func NormalizeRestrictions(paths []string) []string {
+	result := make([]string, 0, len(paths))
+	for _, v := range paths {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		result = append(result, normalizeRestrictedPath(v))
+	}
+	return result
+}
+
+// PathAllowed checks if path is one of the allowed paths, or inside of one.
+// No allowed paths means there are no restrictions.
+func PathAllowed(path string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	path = normalizeRestrictedPath(path)
+	for _, v := range allowed {
+		if v == "." || path == v || strings.HasPrefix(path, v+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// PathVisible checks if path is allowed, or is a folder leading to an allowed path.
+// Folders leading to an allowed path can be listed, so the allowed paths can be navigated to.
+func PathVisible(path string, allowed []string) bool {
+	if PathAllowed(path, allowed) {
+		return true
+	}
+
+	path = normalizeRestrictedPath(path)
+	if path == "." {
+		return true
+	}
+	for _, v := range allowed {
+		if strings.HasPrefix(v, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeRestrictedPath(path string) string {
+	path = strings.Trim(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	if path == "" {
+		return "."
+	}
+	return path
+}
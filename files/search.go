@@ -0,0 +1,174 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const maxSnippetLength = 200
+const maxMatchesPerFile = 20
+
+type SearchOptions struct {
+	//Root is the folder to start searching from
+	Root string
+	//Name is a glob matched against the file name, or the path relative to Root if it contains a /
+	Name string
+	//Content is an optional pattern files must contain to be included
+	Content *regexp.Regexp
+	//MaxDepth is how many folders deep to go, 0 is unlimited
+	MaxDepth int
+	//MaxFileSize is the largest file to search the contents of, 0 is unlimited
+	MaxFileSize int64
+	//Skip is how many matches to skip before recording results
+	Skip int
+	//Limit is how many results to record
+	Limit int
+	//MaxScanned stops the search after this many matches, to bound the cost of counting, 0 is unlimited
+	MaxScanned int
+	//Allowed limits results to these paths, as with PathAllowed
+	Allowed []string
+}
+
+type SearchResult struct {
+	Path    string        `json:"path"`
+	Size    int64         `json:"size"`
+	File    bool          `json:"isFile"`
+	Matches []SearchMatch `json:"matches,omitempty"`
+} //@name SearchResult
+
+type SearchMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+} //@name SearchMatch
+
+var errSearchLimit = errors.New("search limit reached")
+
+// Search walks the server for files matching the options.
+// Returns the page of results requested, and how many results there were in total.
+func Search(fsys FileServer, options SearchOptions) ([]SearchResult, int, error) {
+	if options.Name == "" {
+		options.Name = "*"
+	}
+
+	root := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(options.Root)), "/")
+	if root == "" {
+		root = "."
+	}
+
+	results := make([]SearchResult, 0)
+	total := 0
+
+	err := Walk(fsys, root, func(path string, d fs.DirEntry) error {
+		if path == root {
+			return nil
+		}
+
+		//folders which cannot lead to anything allowed are not worth walking
+		if !PathVisible(path, options.Allowed) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rel := strings.TrimPrefix(path, root+"/")
+		if root == "." {
+			rel = path
+		}
+
+		//anything at the max depth is still checked, but we do not go further into it
+		var next error
+		if options.MaxDepth > 0 && strings.Count(rel, "/")+1 >= options.MaxDepth && d.IsDir() {
+			next = fs.SkipDir
+		}
+
+		var matched bool
+		if strings.Contains(options.Name, "/") {
+			matched, _ = filepath.Match(options.Name, rel)
+		} else {
+			matched, _ = filepath.Match(options.Name, d.Name())
+		}
+		if !matched || !PathAllowed(path, options.Allowed) {
+			return next
+		}
+
+		//symlinks are resolved through the server, so anything pointing out of the root will fail to stat and is dropped
+		info, err := fsys.Stat(path)
+		if err != nil {
+			return next
+		}
+
+		result := SearchResult{Path: "/" + path, Size: info.Size(), File: !info.IsDir()}
+		if options.Content != nil {
+			if info.IsDir() || !info.Mode().IsRegular() {
+				return next
+			}
+			if options.MaxFileSize > 0 && info.Size() > options.MaxFileSize {
+				return next
+			}
+			result.Matches, err = searchContents(fsys, path, options.Content)
+			if err != nil || len(result.Matches) == 0 {
+				return next
+			}
+		}
+
+		total++
+		if total > options.Skip && (options.Limit <= 0 || len(results) < options.Limit) {
+			results = append(results, result)
+		}
+		if options.MaxScanned > 0 && total >= options.MaxScanned {
+			return errSearchLimit
+		}
+		return next
+	})
+
+	if errors.Is(err, errSearchLimit) {
+		err = nil
+	}
+	return results, total, err
+}
+
+func searchContents(fsys FileServer, path string, pattern *regexp.Regexp) ([]SearchMatch, error) {
+	file, err := fsys.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Close(file)
+
+	reader := bufio.NewReader(file)
+
+	//do not try to grep through binary files
+	head, err := reader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) != -1 {
+		return nil, nil
+	}
+
+	matches := make([]SearchMatch, 0)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() && len(matches) < maxMatchesPerFile {
+		line++
+		text := scanner.Text()
+		if !pattern.MatchString(text) {
+			continue
+		}
+		if len(text) > maxSnippetLength {
+			text = text[:maxSnippetLength]
+		}
+		matches = append(matches, SearchMatch{Line: line, Text: text})
+	}
+
+	return matches, scanner.Err()
+}
@@ -0,0 +1,264 @@
+package files
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UploadExpiry is how long an upload may sit without receiving data before it is discarded
+const UploadExpiry = 24 * time.Hour
+
+var ErrUploadNotFound = errors.New("upload not found")
+var ErrUploadOffsetInvalid = errors.New("upload offset is past the end of received data")
+var ErrUploadTooLarge = errors.New("upload is larger than the declared size")
+var ErrUploadIncomplete = errors.New("upload has not received all data")
+var ErrChecksumMismatch = errors.New("checksum does not match uploaded data")
+var ErrChecksumUnsupported = errors.New("checksum algorithm is not supported")
+
+type Upload struct {
+	Id       string    `json:"id"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Offset   int64     `json:"offset"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+
+	partFile string
+	lock     sync.Mutex
+} //@name Upload
+
+type uploadTracker struct {
+	uploads map[string]*Upload
+	lock    sync.Mutex
+}
+
+// CreateUpload starts a new resumable upload targeting path.
+// Data is staged in a hidden file next to the target, so it remains inside the server root.
+// A size of 0 or less means the final size is not known ahead of time.
+func (sfp *fileServer) CreateUpload(path string, size int64) (*Upload, error) {
+	sfp.expireUploads()
+
+	path = prepPath(path)
+	if path == "" {
+		return nil, os.ErrInvalid
+	}
+
+	//refuse early if we already know it will not fit
+	if available := sfp.quota.Available(); available >= 0 && size > available {
+		return nil, ErrQuotaExceeded
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{
+		Id:       id.String(),
+		Path:     path,
+		Size:     size,
+		Created:  time.Now(),
+		Modified: time.Now(),
+		partFile: filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+id.String()+".part"),
+	}
+
+	err = sfp.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := sfp.OpenFile(upload.partFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	utils.Close(file)
+
+	sfp.uploads.lock.Lock()
+	sfp.uploads.uploads[upload.Id] = upload
+	sfp.uploads.lock.Unlock()
+
+	return upload, nil
+}
+
+func (sfp *fileServer) GetUpload(id string) (*Upload, error) {
+	sfp.uploads.lock.Lock()
+	defer sfp.uploads.lock.Unlock()
+
+	upload, exists := sfp.uploads.uploads[id]
+	if !exists {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// WriteUpload writes the data into the upload starting at offset.
+// The offset may not be past the data already received, but may rewind to resend a chunk which was not acknowledged.
+func (sfp *fileServer) WriteUpload(id string, offset int64, data io.Reader) (*Upload, error) {
+	upload, err := sfp.GetUpload(id)
+	if err != nil {
+		return nil, err
+	}
+
+	upload.lock.Lock()
+	defer upload.lock.Unlock()
+
+	if offset < 0 || offset > upload.Offset {
+		return upload, ErrUploadOffsetInvalid
+	}
+
+	file, err := sfp.OpenTracked(upload.partFile, os.O_WRONLY, 0644)
+	if err != nil {
+		return upload, err
+	}
+	defer utils.Close(file)
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return upload, err
+	}
+
+	var source = data
+	if upload.Size > 0 {
+		//allow reading 1 extra byte so we know if they sent too much
+		source = io.LimitReader(data, upload.Size-offset+1)
+	}
+
+	written, err := io.Copy(file, source)
+	if upload.Size > 0 && offset+written > upload.Size {
+		//throw away what went over, so a retry can be done from a clean spot
+		_ = file.Truncate(upload.Size)
+		written = upload.Size - offset
+		if err == nil {
+			err = ErrUploadTooLarge
+		}
+	}
+
+	if upload.Size <= 0 {
+		//without a size, the last write decides where the file ends, so nothing from an earlier attempt is left after it
+		if truncErr := file.Truncate(offset + written); truncErr != nil && err == nil {
+			err = truncErr
+		}
+		upload.Offset = offset + written
+	} else if offset+written > upload.Offset {
+		upload.Offset = offset + written
+	}
+	upload.Modified = time.Now()
+
+	return upload, err
+}
+
+// FinishUpload moves the staged data to the final path.
+// If a checksum is provided in the form of "algorithm:hex", the staged data is validated against it first.
+func (sfp *fileServer) FinishUpload(id string, checksum string) error {
+	upload, err := sfp.GetUpload(id)
+	if err != nil {
+		return err
+	}
+
+	upload.lock.Lock()
+	defer upload.lock.Unlock()
+
+	if upload.Size > 0 && upload.Offset != upload.Size {
+		return ErrUploadIncomplete
+	}
+
+	if checksum != "" {
+		err = sfp.validateChecksum(upload.partFile, checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = sfp.Rename(upload.partFile, upload.Path)
+	if err != nil {
+		return err
+	}
+
+	sfp.uploads.lock.Lock()
+	delete(sfp.uploads.uploads, id)
+	sfp.uploads.lock.Unlock()
+	return nil
+}
+
+func (sfp *fileServer) CancelUpload(id string) error {
+	upload, err := sfp.GetUpload(id)
+	if err != nil {
+		return err
+	}
+
+	upload.lock.Lock()
+	defer upload.lock.Unlock()
+
+	sfp.uploads.lock.Lock()
+	delete(sfp.uploads.uploads, id)
+	sfp.uploads.lock.Unlock()
+
+	err = sfp.Remove(upload.partFile)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+func (sfp *fileServer) expireUploads() {
+	sfp.uploads.lock.Lock()
+	var expired []string
+	for k, v := range sfp.uploads.uploads {
+		if time.Since(v.Modified) > UploadExpiry {
+			expired = append(expired, k)
+		}
+	}
+	sfp.uploads.lock.Unlock()
+
+	for _, v := range expired {
+		_ = sfp.CancelUpload(v)
+	}
+}
+
+func (sfp *fileServer) validateChecksum(path, checksum string) error {
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 {
+		return ErrChecksumUnsupported
+	}
+
+	var hasher hash.Hash
+	switch strings.ToLower(parts[0]) {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha1":
+		hasher = sha1.New()
+	case "md5":
+		hasher = md5.New()
+	default:
+		return ErrChecksumUnsupported
+	}
+
+	file, err := sfp.OpenFile(path, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(file)
+
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(actual, parts[1]) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, parts[1], actual)
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package pufferpanel
 
-import "github.com/pufferpanel/pufferpanel/v3/utils"
+import (
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"time"
+)
 
 type ServerIdResponse struct {
 	Id string `json:"id"`
@@ -10,6 +14,10 @@ type ServerStats struct {
 	Cpu    float64         `json:"cpu"`
 	Memory float64         `json:"memory"`
 	Jvm    *utils.JvmStats `json:"jvm,omitempty"`
+	//DiskUsed is how many bytes the server and its backups are using
+	DiskUsed int64 `json:"diskUsed"`
+	//DiskLimit is the most bytes the server can use, 0 is unlimited
+	DiskLimit int64 `json:"diskLimit,omitempty"`
 } //@name ServerStats
 
 type ServerLogs struct {
@@ -35,6 +43,44 @@ type DaemonRunning struct {
 	Message string `json:"message"`
 } //@name DaemonRunning
 
+// DaemonStats is how many servers the daemon has, and what the host it is on is using
+type DaemonStats struct {
+	//Servers is how many servers the daemon is running
+	Servers int            `json:"servers"`
+	Host    *HostResources `json:"host"`
+} //@name DaemonStats
+
+// HostResources is what the machine the daemon is on has, and how much of it is in use
+type HostResources struct {
+	CPUs int `json:"cpus"`
+	//CPUUsage is the percent of all CPUs in use
+	CPUUsage    float64 `json:"cpuUsage"`
+	MemoryTotal uint64  `json:"memoryTotal"`
+	MemoryUsed  uint64  `json:"memoryUsed"`
+	//DiskTotal and DiskUsed are for the disk the servers are stored on
+	DiskTotal uint64 `json:"diskTotal"`
+	DiskUsed  uint64 `json:"diskUsed"`
+} //@name HostResources
+
+// ServerTransfer tells a daemon where to get the files of a server it is being given from
+type ServerTransfer struct {
+	//Source is the address of the server on the daemon it is coming from
+	Source string `json:"source"`
+	Token  string `json:"token"`
+	//Backups are the file names of the backups to bring along
+	Backups []string `json:"backups,omitempty"`
+} //@name ServerTransfer
+
+// ServerTransferStatus is how far a daemon has got with downloading the files of a server it is being given
+type ServerTransferStatus struct {
+	Running bool `json:"running"`
+	Done    bool `json:"done"`
+	//Total is the size of the downloads started so far, so it grows as each one starts
+	Total  int64  `json:"total"`
+	Copied int64  `json:"copied"`
+	Error  string `json:"error,omitempty"`
+} //@name ServerTransferStatus
+
 type ServerTasks struct {
 	Tasks map[string]ServerTask
 } //@name ServerTasks
@@ -68,3 +114,46 @@ type ServerFlags struct {
 type ServerBackupResponse struct {
 	BackupFileName string `json:"backupFileName"`
 } //@name ServerBackup
+
+type UploadRequest struct {
+	Path string `json:"path"`
+	Size int64  `json:"size,omitempty"`
+} //@name UploadRequest
+
+type UploadFinishRequest struct {
+	Checksum string `json:"checksum,omitempty"`
+} //@name UploadFinishRequest
+
+type FileOperation struct {
+	Action    string   `json:"action" enums:"copy,move,chmod,chown,delete"`
+	Target    string   `json:"target,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Uid       *int     `json:"uid,omitempty"`
+	Gid       *int     `json:"gid,omitempty"`
+	Recursive bool     `json:"recursive,omitempty"`
+	Paths     []string `json:"paths,omitempty"`
+} //@name FileOperation
+
+type FileSearchResponse struct {
+	Results []files.SearchResult `json:"results"`
+	*Metadata
+} //@name FileSearchResponse
+
+// SFTPLogEntry records a session opening or closing, or a change made to a file over SFTP
+type SFTPLogEntry struct {
+	Time time.Time `json:"time"`
+	//Session ties together everything done in a single connection
+	Session    string `json:"session"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remoteAddr"`
+	//Action is one of connect, disconnect, write, rename, delete or mkdir
+	Action string `json:"action"`
+	Path   string `json:"path,omitempty"`
+	Target string `json:"target,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+} //@name SFTPLogEntry
+
+type SFTPLogResponse struct {
+	Entries []SFTPLogEntry `json:"entries"`
+	*Metadata
+} //@name SFTPLogResponse
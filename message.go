@@ -11,4 +11,5 @@ const (
 	MessageTypeLog    = "console"
 	MessageTypeStats  = "stat"
 	MessageTypeStatus = "status"
+	MessageTypeFiles  = "files"
 )
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+const auditTargetKey = "auditTarget"
+
+// AuditTarget describes what an audited route changes, and how to look it up as it was before and after
+type AuditTarget struct {
+	Type string
+	//Param is the path parameter holding the id of the target. When empty, the handler gives the id with SetAuditTarget
+	Param string
+	//Id is used for targets there is only one of, such as the panel settings
+	Id string
+	//Load gets the target so it can be compared, and should leave out anything secret. This can be nil to only
+	//record that the action happened.
+	Load func(c *gin.Context, db *gorm.DB, id string) (interface{}, error)
+}
+
+// SetAuditTarget Gives the id of what was changed, for handlers creating something the id is not known for until then
+func SetAuditTarget(c *gin.Context, id string) {
+	c.Set(auditTargetKey, id)
+}
+
+// Audited Records the action in the audit log if the handler succeeds. This has to be before HasTransaction, so the
+// target is looked up once the change has been committed.
+func Audited(action string, target AuditTarget) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := GetDatabase(c)
+		if db == nil {
+			NeedsDatabase(c)
+			db = GetDatabase(c)
+			if db == nil {
+				return
+			}
+		}
+
+		id := target.Id
+		if target.Param != "" {
+			id = c.Param(target.Param)
+		}
+
+		var before interface{}
+		var err error
+		if id != "" && target.Load != nil {
+			before, err = loadAuditTarget(c, db, target, id)
+			if err != nil {
+				logging.Error.Printf("Error loading %s %s for audit log: %s", target.Type, id, err.Error())
+			}
+		}
+
+		c.Next()
+
+		if c.IsAborted() || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+
+		if given := c.GetString(auditTargetKey); given != "" {
+			id = given
+		}
+
+		var after interface{}
+		if id != "" && target.Load != nil {
+			after, err = loadAuditTarget(c, db, target, id)
+			if err != nil {
+				logging.Error.Printf("Error loading %s %s for audit log: %s", target.Type, id, err.Error())
+			}
+		}
+
+		entry := &services.AuditEntry{
+			Action:     action,
+			TargetType: target.Type,
+			TargetId:   id,
+			Before:     before,
+			After:      after,
+			IP:         c.ClientIP(),
+		}
+		if user, exists := c.Get("user"); exists {
+			entry.ActorId = &user.(*models.User).ID
+		}
+		if client, exists := c.Get("client"); exists {
+			entry.ActorClient = client.(*models.Client).ClientId
+		}
+
+		as := &services.Audit{DB: db}
+		if err = as.RecordChange(entry); err != nil {
+			logging.Error.Printf("Error writing audit log: %s", err.Error())
+		}
+	}
+}
+
+// loadAuditTarget gets the target, where a target which does not exist (yet, or anymore) is nothing
+func loadAuditTarget(c *gin.Context, db *gorm.DB, target AuditTarget, id string) (interface{}, error) {
+	result, err := target.Load(c, db, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return result, err
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/response"
 	"github.com/pufferpanel/pufferpanel/v3/services"
 	"gorm.io/gorm"
@@ -77,6 +78,11 @@ func AuthMiddleware(c *gin.Context) {
 		return
 	}
 
+	if services.IsAPIToken(token) {
+		apiTokenAuth(c, db, token)
+		return
+	}
+
 	//pull user from the session
 	sess, err := ss.Validate(token)
 
@@ -88,10 +94,39 @@ func AuthMiddleware(c *gin.Context) {
 		return
 	}
 
+	if err = ss.Touch(sess, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		logging.Error.Printf("Error recording session use: %s", err.Error())
+	}
+
+	c.Set("session", sess)
 	if sess.UserId != nil {
 		c.Set("user", &sess.User)
 	}
 	if sess.ClientId != nil {
 		c.Set("client", &sess.Client)
 	}
+	if len(sess.Scopes) > 0 {
+		c.Set("grantedScopes", sess.Scopes)
+	}
+}
+
+// apiTokenAuth logs in with a personal access token, which is held to its scopes and servers
+func apiTokenAuth(c *gin.Context, db *gorm.DB, secret string) {
+	ts := &services.APIToken{DB: db}
+	token, err := ts.Validate(secret)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Header(WWWAuthenticateHeader, WWWAuthenticateHeaderContents)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	} else if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	if err = ts.MarkUsed(token, c.ClientIP()); err != nil {
+		logging.Error.Printf("Error recording token use: %s", err.Error())
+	}
+
+	c.Set("apiToken", token)
+	c.Set("user", token.User)
+	c.Set("grantedScopes", token.Scopes)
 }
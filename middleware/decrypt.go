@@ -25,9 +25,15 @@ func ValidateJWT(c *gin.Context) {
 	}
 	token := parts[1]
 
-	err = ts.ValidateRequest(token)
+	claims, err := ts.ValidateRequest(token)
 	//if decryption failed, the request wasn't valid
 	if response.HandleError(c, err, http.StatusBadRequest) {
 		return
 	}
+
+	//tokens handed to other nodes only work for what they were made for
+	if !claims.Allows(c.Request.Method, c.FullPath(), c.Param("serverId")) {
+		c.AbortWithStatusJSON(http.StatusForbidden, &oauth2.ErrorResponse{Error: "insufficient_scope"})
+		return
+	}
 }
@@ -3,6 +3,7 @@ package middleware
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/models"
 	"github.com/pufferpanel/pufferpanel/v3/response"
@@ -102,11 +103,29 @@ func requiresPermission(c *gin.Context, perm *scopes.Scope) {
 	allowed := false
 	allScopes := make([]*scopes.Scope, 0)
 	for _, p := range perms {
-		if scopes.ContainsScope(p.Scopes, perm) {
+		if scopes.ContainsScope(p.EffectiveScopes(), perm) {
 			allowed = true
 		}
 	}
 
+	//clients given roles are held to those, on top of what their user can do
+	if client, exists := c.Get("client"); allowed && exists && client.(*models.Client).UserId == user.ID {
+		allowed, err = ps.ClientAllows(client.(*models.Client).ID, serverId, perm)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+	}
+
+	//and sessions a user approved for a client can only use what was approved
+	if granted, exists := c.Get("grantedScopes"); allowed && exists {
+		allowed = scopes.ContainsScope(granted.([]*scopes.Scope), perm)
+	}
+
+	//personal access tokens may also be limited to some servers
+	if token, exists := c.Get("apiToken"); allowed && exists && serverId != "" {
+		allowed = token.(*models.APIToken).AllowsServer(serverId)
+	}
+
 	if !allowed {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
@@ -157,6 +176,12 @@ func ResolveServerPanel(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+
+	//nothing can be changed while the server is being moved, as it may not end up on the node it was changed on
+	if services.IsMigrating(serverId) && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		response.HandleError(c, pufferpanel.ErrServerMigrating, http.StatusConflict)
+		return
+	}
 	c.Set("server", server)
 }
 
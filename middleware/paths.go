@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"net/http"
+	"net/url"
+)
+
+// PathRestrictionHeader carries the paths a user is limited to from the panel to the daemon.
+// The panel always replaces it, so it cannot be provided by a user.
+const PathRestrictionHeader = "X-Puffer-Paths"
+
+// SetPathRestrictions replaces any path restrictions on the request with the ones given
+func SetPathRestrictions(header http.Header, paths []string) {
+	header.Del(PathRestrictionHeader)
+	for _, v := range paths {
+		header.Add(PathRestrictionHeader, url.PathEscape(v))
+	}
+}
+
+// GetPathRestrictions gets the paths the panel has limited this request to, nothing meaning there is no limit
+func GetPathRestrictions(c *gin.Context) []string {
+	values := c.Request.Header.Values(PathRestrictionHeader)
+	paths := make([]string, 0, len(values))
+	for _, v := range values {
+		path, err := url.PathUnescape(v)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return files.NormalizeRestrictions(paths)
+}
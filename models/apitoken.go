@@ -0,0 +1,87 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"gopkg.in/go-playground/validator.v9"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal access token. It can only do the scopes picked for it, only on the servers picked if any
+// were, and only until it expires. Its user still needs to be allowed to do whatever it is used for.
+type APIToken struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+
+	UserId uint  `gorm:"column:user_id;not null;index" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	Name string `gorm:"column:name;not null;size:100" json:"name" validate:"required,max=100,printascii"`
+
+	//sha256 of the token, the token itself is only shown once when it is created
+	Token  string `gorm:"column:token;not null;size:64;uniqueIndex;unique" json:"-"`
+	Secret string `gorm:"-" json:"token,omitempty"`
+
+	RawScopes string          `gorm:"column:scopes;not null;size:1000" json:"-"`
+	Scopes    []*scopes.Scope `gorm:"-" json:"scopes" validate:"-"`
+
+	//servers the token is limited to, empty allows every server the user can reach
+	RawServers string   `gorm:"column:servers;not null;size:4000;default:''" json:"-"`
+	Servers    []string `gorm:"-" json:"servers"`
+
+	ExpiresAt  time.Time  `gorm:"column:expires_at;not null;index" json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsed   *time.Time `gorm:"column:last_used" json:"lastUsed,omitempty"`
+	LastUsedIP string     `gorm:"column:last_used_ip;not null;size:100;default:''" json:"lastUsedIp,omitempty"`
+} //@name APIToken
+
+func (t *APIToken) IsValid() (err error) {
+	err = validator.New().Struct(t)
+	if err != nil {
+		err = pufferpanel.GenerateValidationMessage(err)
+	}
+	return
+}
+
+// AllowsServer Checks if the token can be used on a server
+func (t *APIToken) AllowsServer(serverId string) bool {
+	if len(t.Servers) == 0 {
+		return true
+	}
+	for _, v := range t.Servers {
+		if v == serverId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) BeforeSave(*gorm.DB) error {
+	err := t.IsValid()
+	if err != nil {
+		return err
+	}
+
+	tmp := make([]string, len(t.Scopes))
+	for k, v := range t.Scopes {
+		tmp[k] = v.String()
+	}
+	t.RawScopes = strings.Join(tmp, ",")
+	t.RawServers = strings.Join(t.Servers, ",")
+	return nil
+}
+
+func (t *APIToken) AfterFind(*gorm.DB) error {
+	t.Scopes = make([]*scopes.Scope, 0)
+	if t.RawScopes != "" {
+		for _, v := range strings.Split(t.RawScopes, ",") {
+			t.Scopes = append(t.Scopes, scopes.GetScope(v))
+		}
+	}
+	t.Servers = make([]string, 0)
+	if t.RawServers != "" {
+		t.Servers = strings.Split(t.RawServers, ",")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"time"
+)
+
+// AuditLog is a record of a change made in the panel. Entries are only ever added, and removed once they are older
+// than the retention period.
+type AuditLog struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+
+	//the user who performed the action, if it was done by a user
+	ActorId   *uint  `gorm:"column:actor_id;index" json:"actorId,omitempty"`
+	Actor     *User  `json:"-" validate:"-"`
+	ActorName string `gorm:"-" json:"actorName,omitempty"`
+	//the OAuth2 client the action was done through, if any
+	ActorClient string `gorm:"column:actor_client;not null;size:100;default:''" json:"actorClient,omitempty"`
+
+	Action     string `gorm:"column:action;not null;size:100;index" json:"action"`
+	TargetType string `gorm:"column:target_type;not null;size:100;default:''" json:"targetType,omitempty"`
+	TargetId   string `gorm:"column:target_id;not null;size:100;default:'';index" json:"targetId,omitempty"`
+	Details    string `gorm:"column:details;type:text" json:"details,omitempty"`
+	//JSON of the fields of the target which changed, as they were and as they became
+	Before string `gorm:"column:before_state;type:text" json:"before,omitempty"`
+	After  string `gorm:"column:after_state;type:text" json:"after,omitempty"`
+	IP     string `gorm:"column:ip;not null;size:100;default:''" json:"ip,omitempty"`
+
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+} //@name AuditLog
+
+type AuditSearch struct {
+	ActorId    uint      `form:"actor"`
+	Action     string    `form:"action"`
+	TargetType string    `form:"targetType"`
+	TargetId   string    `form:"targetId"`
+	From       time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	PageLimit  uint      `form:"limit"`
+	Page       uint      `form:"page"`
+} //@name AuditSearch
+
+type AuditSearchResponse struct {
+	Entries []*AuditLog `json:"entries"`
+	*pufferpanel.Metadata
+} //@name AuditSearchResponse
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// AuthorizationCode is handed to a client once a user approves it through /oauth2/authorize, and can be
+// exchanged once for a session
+type AuthorizationCode struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+
+	//sha256 of the code, the code itself is only given to the client
+	Code string `gorm:"column:code;not null;size:64;uniqueIndex;unique" json:"-"`
+
+	ClientId uint   `gorm:"column:client_id;not null;index" json:"-"`
+	Client   Client `gorm:"ASSOCIATION_SAVE_REFERENCE:false" json:"-" validate:"-"`
+
+	UserId uint `gorm:"column:user_id;not null;index" json:"-"`
+	User   User `gorm:"ASSOCIATION_SAVE_REFERENCE:false" json:"-" validate:"-"`
+
+	RedirectUri   string `gorm:"column:redirect_uri;not null;size:1000" json:"-"`
+	CodeChallenge string `gorm:"column:code_challenge;not null;size:128" json:"-"`
+
+	RawScopes string          `gorm:"column:scopes;not null;size:1000;default:''" json:"-"`
+	Scopes    []*scopes.Scope `gorm:"-" json:"-"`
+
+	ExpiresAt time.Time `gorm:"column:expires_at;not null;index" json:"-"`
+	CreatedAt time.Time `json:"-"`
+}
+
+func (a *AuthorizationCode) BeforeSave(*gorm.DB) error {
+	tmp := make([]string, len(a.Scopes))
+	for k, v := range a.Scopes {
+		tmp[k] = v.String()
+	}
+	a.RawScopes = strings.Join(tmp, ",")
+	return nil
+}
+
+func (a *AuthorizationCode) AfterFind(*gorm.DB) error {
+	a.Scopes = make([]*scopes.Scope, 0)
+	if a.RawScopes != "" {
+		for _, v := range strings.Split(a.RawScopes, ",") {
+			a.Scopes = append(a.Scopes, scopes.GetScope(v))
+		}
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/go-playground/validator.v9"
 	"gorm.io/gorm"
+	"net/url"
+	"strings"
 )
 
 type Client struct {
@@ -22,6 +24,14 @@ type Client struct {
 
 	Name        string `gorm:"column:name;not null;size:100;default:''" json:"name"`
 	Description string `gorm:"column:description;not null;size:4000;default:''" json:"description"`
+
+	//where users can be sent back to after approving the client through /oauth2/authorize
+	RawRedirectUris string   `gorm:"column:redirect_uris;not null;size:4000;default:''" json:"-"`
+	RedirectUris    []string `gorm:"-" json:"redirect_uris"`
+
+	//public clients, like apps on the user's own machine, can't keep a secret, so they have none and can only get
+	//tokens through /oauth2/authorize with PKCE
+	Public bool `gorm:"column:public;not null;default:false" json:"public"`
 }
 
 func (c *Client) SetClientSecret(secret string) error {
@@ -49,5 +59,49 @@ func (c *Client) IsValid() (err error) {
 }
 
 func (c *Client) BeforeSave(*gorm.DB) error {
-	return c.IsValid()
+	if err := c.IsValid(); err != nil {
+		return err
+	}
+
+	for _, v := range c.RedirectUris {
+		if err := ValidateRedirectUri(v); err != nil {
+			return err
+		}
+	}
+	c.RawRedirectUris = strings.Join(c.RedirectUris, "\n")
+	return nil
+}
+
+func (c *Client) AfterFind(*gorm.DB) error {
+	c.RedirectUris = make([]string, 0)
+	if c.RawRedirectUris != "" {
+		c.RedirectUris = strings.Split(c.RawRedirectUris, "\n")
+	}
+	return nil
+}
+
+// HasRedirectUri Checks if the uri was registered for the client, which must be an exact match
+func (c *Client) HasRedirectUri(uri string) bool {
+	for _, v := range c.RedirectUris {
+		if v == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRedirectUri Checks a redirect uri is absolute and has no fragment. Custom schemes are allowed for apps,
+// but plain http is only allowed back to the same machine
+func ValidateRedirectUri(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Fragment != "" || u.Opaque != "" {
+		return pufferpanel.ErrInvalidRedirectUri(uri)
+	}
+	if u.Scheme == "http" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" && u.Hostname() != "::1" {
+		return pufferpanel.ErrInvalidRedirectUri(uri)
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return pufferpanel.ErrInvalidRedirectUri(uri)
+	}
+	return nil
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// LDAPIdentity marks a user as coming from LDAP, their password and scopes are managed there
+type LDAPIdentity struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+
+	UserId uint  `gorm:"column:user_id;not null;uniqueIndex" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	DN       string     `gorm:"column:dn;not null;size:1000" json:"dn"`
+	Disabled bool       `gorm:"column:disabled;not null;default:0" json:"disabled"`
+	LastSync *time.Time `gorm:"column:last_sync" json:"lastSync,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+} //@name LDAPIdentity
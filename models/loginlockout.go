@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginLockout counts the failed logins from an IP or against an account, which is locked out once there are too many
+type LoginLockout struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+
+	//Kind is either ip or account, and Value the IP or the email or client id of the account
+	Kind  string `gorm:"column:kind;not null;size:20;uniqueIndex:idx_login_lockout" json:"kind"`
+	Value string `gorm:"column:value;not null;size:255;uniqueIndex:idx_login_lockout" json:"value"`
+
+	//Failures since the last successful login, or since the last lockout ended
+	Failures int `gorm:"column:failures;not null;default:0" json:"failures"`
+	//Lockouts is how many times this has been locked out in a row, each lasting twice as long as the one before
+	Lockouts    int        `gorm:"column:lockouts;not null;default:0" json:"lockouts"`
+	LastFailure time.Time  `gorm:"column:last_failure;not null;index" json:"lastFailure"`
+	LockedUntil *time.Time `gorm:"column:locked_until;index" json:"lockedUntil,omitempty"`
+} //@name LoginLockout
+
+// IsLocked Checks if logins are currently refused
+func (l *LoginLockout) IsLocked() bool {
+	return l.LockedUntil != nil && l.LockedUntil.After(time.Now())
+}
@@ -21,6 +21,14 @@ type Node struct {
 
 	Secret string `gorm:"column:secret;not null;size=36" json:"-" validate:"required"`
 
+	//the daemon connects to the panel and is reached through that, for nodes the panel can't connect to
+	Tunnel bool `gorm:"column:tunnel;not null;default:false" json:"-"`
+
+	//what the node has to give to servers, where 0 is unlimited. Memory and disk are in MB, CPU is in cores.
+	MaxMemory int64   `gorm:"column:max_memory;not null;default:0" json:"-" validate:"min=0"`
+	MaxCPU    float64 `gorm:"column:max_cpu;not null;default:0" json:"-" validate:"min=0"`
+	MaxDisk   int64   `gorm:"column:max_disk;not null;default:0" json:"-" validate:"min=0"`
+
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 
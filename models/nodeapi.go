@@ -5,3 +5,14 @@ type Deployment struct {
 	ClientSecret string `json:"clientSecret"`
 	PublicKey    string `json:"publicKey"`
 } //@name NodeDeploymentConfig
+
+// NodeUsage is how much of a node has been set aside for its servers, and how much it has to give
+type NodeUsage struct {
+	Servers   int64   `json:"servers"`
+	Memory    int64   `json:"memory"`
+	CPU       float64 `json:"cpu"`
+	Disk      int64   `json:"disk"`
+	MaxMemory int64   `json:"maxMemory"`
+	MaxCPU    float64 `json:"maxCpu"`
+	MaxDisk   int64   `json:"maxDisk"`
+} //@name NodeUsage
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// NodeStatus is what the panel last saw when it checked on a node
+type NodeStatus struct {
+	ID     uint   `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+	NodeId uint   `gorm:"column:node_id;not null;uniqueIndex" json:"nodeId"`
+	Name   string `gorm:"-" json:"name,omitempty"`
+
+	Online bool `gorm:"column:online;not null;default:false" json:"online"`
+	//Latency is how long the daemon took to respond, in milliseconds
+	Latency int64 `gorm:"column:latency;not null;default:0" json:"latency"`
+	//Error is why the last check failed, if it did
+	Error string `gorm:"column:error;not null;size:1000;default:''" json:"error,omitempty"`
+	//Failures is how many checks in a row have failed
+	Failures int `gorm:"column:failures;not null;default:0" json:"failures"`
+
+	Version string `gorm:"column:version;not null;size:100;default:''" json:"version,omitempty"`
+	//VersionSkew is if the daemon is on a different version than the panel
+	VersionSkew     bool     `gorm:"column:version_skew;not null;default:false" json:"versionSkew"`
+	OS              string   `gorm:"column:os;not null;size:50;default:''" json:"os,omitempty"`
+	Arch            string   `gorm:"column:arch;not null;size:50;default:''" json:"arch,omitempty"`
+	RawFeatures     string   `gorm:"column:features;not null;size:1000;default:''" json:"-"`
+	Features        []string `gorm:"-" json:"features"`
+	RawEnvironments string   `gorm:"column:environments;not null;size:1000;default:''" json:"-"`
+	Environments    []string `gorm:"-" json:"environments"`
+
+	ServerCount int     `gorm:"column:server_count;not null;default:0" json:"serverCount"`
+	CPUs        int     `gorm:"column:cpus;not null;default:0" json:"cpus"`
+	CPUUsage    float64 `gorm:"column:cpu_usage;not null;default:0" json:"cpuUsage"`
+	MemoryTotal uint64  `gorm:"column:memory_total;not null;default:0" json:"memoryTotal"`
+	MemoryUsed  uint64  `gorm:"column:memory_used;not null;default:0" json:"memoryUsed"`
+	DiskTotal   uint64  `gorm:"column:disk_total;not null;default:0" json:"diskTotal"`
+	DiskUsed    uint64  `gorm:"column:disk_used;not null;default:0" json:"diskUsed"`
+
+	LastChecked time.Time  `gorm:"column:last_checked" json:"lastChecked"`
+	LastOnline  *time.Time `gorm:"column:last_online" json:"lastOnline,omitempty"`
+} //@name NodeStatus
+
+func (n *NodeStatus) BeforeSave(*gorm.DB) error {
+	n.RawFeatures = strings.Join(n.Features, ",")
+	n.RawEnvironments = strings.Join(n.Environments, ",")
+	return nil
+}
+
+func (n *NodeStatus) AfterFind(*gorm.DB) error {
+	n.Features = make([]string, 0)
+	if n.RawFeatures != "" {
+		n.Features = strings.Split(n.RawFeatures, ",")
+	}
+
+	n.Environments = make([]string, 0)
+	if n.RawEnvironments != "" {
+		n.Environments = strings.Split(n.RawEnvironments, ",")
+	}
+	return nil
+}
@@ -15,6 +15,11 @@ type NodeView struct {
 	PrivatePort uint16 `json:"privatePort,omitempty"`
 	SFTPPort    uint16 `json:"sftpPort,omitempty"`
 	Local       bool   `json:"isLocal"`
+	//limits are only changed when given, 0 being unlimited
+	MaxMemory *int64   `json:"maxMemory,omitempty"`
+	MaxCPU    *float64 `json:"maxCpu,omitempty"`
+	MaxDisk   *int64   `json:"maxDisk,omitempty"`
+	Tunnel    *bool    `json:"tunnel,omitempty"`
 } //@name Node
 
 type NodesView []*NodeView //@name Nodes
@@ -29,9 +34,22 @@ func FromNode(n *Node) *NodeView {
 		PrivatePort: n.PrivatePort,
 		SFTPPort:    n.SFTPPort,
 		Local:       n.IsLocal(),
+		MaxMemory:   nonZero(n.MaxMemory),
+		MaxCPU:      nonZero(n.MaxCPU),
+		MaxDisk:     nonZero(n.MaxDisk),
+		Tunnel:      nonZero(n.Tunnel),
 	}
 }
 
+// nonZero gives nil for the zero value, so limits and options which aren't set are left out
+func nonZero[T int64 | float64 | bool](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
+
 func FromNodes(n []*Node) *NodesView {
 	result := make(NodesView, len(n))
 
@@ -66,6 +84,22 @@ func (n *NodeView) CopyToModel(newModel *Node) {
 	if n.SFTPPort > 0 {
 		newModel.SFTPPort = n.SFTPPort
 	}
+
+	if n.MaxMemory != nil {
+		newModel.MaxMemory = *n.MaxMemory
+	}
+
+	if n.MaxCPU != nil {
+		newModel.MaxCPU = *n.MaxCPU
+	}
+
+	if n.MaxDisk != nil {
+		newModel.MaxDisk = *n.MaxDisk
+	}
+
+	if n.Tunnel != nil {
+		newModel.Tunnel = *n.Tunnel
+	}
 }
 
 func (n *NodeView) Valid(allowEmpty bool) error {
@@ -134,5 +168,17 @@ func (n *NodeView) Valid(allowEmpty bool) error {
 		return pufferpanel.ErrFieldEqual("sftpPort", "privatePort")
 	}
 
+	if n.MaxMemory != nil && *n.MaxMemory < 0 {
+		return pufferpanel.ErrFieldTooSmall("maxMemory", 0)
+	}
+
+	if n.MaxCPU != nil && *n.MaxCPU < 0 {
+		return pufferpanel.ErrFieldTooSmall("maxCpu", 0)
+	}
+
+	if n.MaxDisk != nil && *n.MaxDisk < 0 {
+		return pufferpanel.ErrFieldTooSmall("maxDisk", 0)
+	}
+
 	return nil
 }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// OIDCIdentity links an account at an external OpenID Connect provider to a user
+type OIDCIdentity struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+
+	UserId uint  `gorm:"column:user_id;not null;index" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	Provider string `gorm:"column:provider;not null;size:100;uniqueIndex:idx_oidc_provider_subject" json:"provider"`
+	Subject  string `gorm:"column:subject;not null;size:255;uniqueIndex:idx_oidc_provider_subject" json:"-"`
+	Email    string `gorm:"column:email;not null;size:255;default:''" json:"email"`
+
+	CreatedAt time.Time  `json:"createdAt"`
+	LastUsed  *time.Time `gorm:"column:last_used" json:"lastUsed,omitempty"`
+} //@name OIDCIdentity
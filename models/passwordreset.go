@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+type PasswordReset struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+
+	UserId uint  `gorm:"column:user_id;not null;index" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	//only the hash of the token is kept, the token itself is only ever in the email
+	Token          string     `gorm:"column:token;not null;size:64;uniqueIndex;unique" json:"-"`
+	ExpirationTime time.Time  `gorm:"column:expiration_time;not null;index" json:"-"`
+	UsedAt         *time.Time `gorm:"column:used_at" json:"-"`
+
+	CreatedAt time.Time `json:"-"`
+}
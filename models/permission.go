@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/pufferpanel/pufferpanel/v3/files"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"gorm.io/gorm"
 	"strings"
@@ -22,6 +23,13 @@ type Permissions struct {
 
 	RawScopes string          `gorm:"column:scopes;not null;size:1000;default:''" json:"-" validate:"required"`
 	Scopes    []*scopes.Scope `gorm:"-" json:"-"`
+
+	//if this set is for a server, the only paths which can be accessed, empty being all
+	RawPaths string   `gorm:"column:paths;not null;size:4000;default:''" json:"-"`
+	Paths    []string `gorm:"-" json:"-"`
+
+	//roles given in this set, their scopes apply on top of the ones given directly
+	Roles []*Role `gorm:"-" json:"-"`
 }
 
 func (p *Permissions) BeforeSave(*gorm.DB) error {
@@ -39,6 +47,11 @@ func (p *Permissions) BeforeSave(*gorm.DB) error {
 		tmp[k] = v.String()
 	}
 	p.RawScopes = strings.Join(tmp, ",")
+
+	if p.ServerIdentifier == nil {
+		p.Paths = nil
+	}
+	p.RawPaths = strings.Join(files.NormalizeRestrictions(p.Paths), "\n")
 	return nil
 }
 
@@ -51,6 +64,11 @@ func (p *Permissions) AfterFind(*gorm.DB) error {
 		}
 	}
 
+	p.Paths = make([]string, 0)
+	if p.RawPaths != "" {
+		p.Paths = strings.Split(p.RawPaths, "\n")
+	}
+
 	return nil
 }
 
@@ -58,5 +76,19 @@ func (p *Permissions) ShouldDelete() bool {
 	if p.ServerIdentifier == nil {
 		return false
 	}
-	return len(p.Scopes) == 0
+	return len(p.Scopes) == 0 && len(p.Roles) == 0
+}
+
+// EffectiveScopes Gets every scope this set grants, both directly and through its roles
+func (p *Permissions) EffectiveScopes() []*scopes.Scope {
+	result := make([]*scopes.Scope, 0, len(p.Scopes))
+	for _, v := range p.Scopes {
+		result = scopes.AddScope(result, v)
+	}
+	for _, r := range p.Roles {
+		for _, v := range r.Scopes {
+			result = scopes.AddScope(result, v)
+		}
+	}
+	return result
 }
@@ -0,0 +1,7 @@
+package models
+
+// PermissionRole links a role to the permission set it was given in
+type PermissionRole struct {
+	PermissionsId uint `gorm:"column:permissions_id;primaryKey;autoIncrement:false"`
+	RoleId        uint `gorm:"column:role_id;primaryKey;autoIncrement:false;index"`
+}
@@ -8,11 +8,19 @@ type PermissionView struct {
 	ServerIdentifier string `json:"serverIdentifier,omitempty"`
 
 	Scopes []*scopes.Scope `json:"scopes"`
+
+	//Roles are the ids of the roles given, leaving this out when editing keeps the roles as they are
+	Roles []uint `json:"roles"`
+
+	//Paths limits which files on the server can be accessed, empty allows all
+	Paths []string `json:"paths,omitempty"`
 } //@name Permissions
 
 func FromPermission(p *Permissions) *PermissionView {
 	model := &PermissionView{
 		Scopes: p.Scopes,
+		Roles:  roleIds(p.Roles),
+		Paths:  p.Paths,
 	}
 
 	if model.Scopes == nil {
@@ -26,4 +34,83 @@ type UserPermissionsView struct {
 	Username string          `json:"username,omitempty"`
 	Email    string          `json:"email"`
 	Scopes   []*scopes.Scope `json:"scopes"`
+	Roles    []uint          `json:"roles,omitempty"`
+	Paths    []string        `json:"paths,omitempty"`
+}
+
+// EffectivePermissionsView is everything someone can do, with where each scope comes from
+type EffectivePermissionsView struct {
+	ServerIdentifier string                  `json:"serverIdentifier,omitempty"`
+	Scopes           []*scopes.Scope         `json:"scopes"`
+	Sources          []*PermissionSourceView `json:"sources"`
+} //@name EffectivePermissions
+
+type PermissionSourceView struct {
+	//which server the scopes were given on, empty if they were given globally
+	ServerIdentifier string `json:"serverIdentifier,omitempty"`
+	//which role the scopes come from, empty if they were given directly
+	Role   string          `json:"role,omitempty"`
+	Scopes []*scopes.Scope `json:"scopes"`
+} //@name PermissionSource
+
+func FromEffectivePermissions(serverId string, sets ...*Permissions) *EffectivePermissionsView {
+	model := &EffectivePermissionsView{
+		ServerIdentifier: serverId,
+		Scopes:           make([]*scopes.Scope, 0),
+		Sources:          make([]*PermissionSourceView, 0),
+	}
+
+	for _, p := range sets {
+		var server string
+		if p.ServerIdentifier != nil {
+			server = *p.ServerIdentifier
+		}
+
+		if len(p.Scopes) > 0 {
+			model.Sources = append(model.Sources, &PermissionSourceView{ServerIdentifier: server, Scopes: p.Scopes})
+		}
+		for _, r := range p.Roles {
+			model.Sources = append(model.Sources, &PermissionSourceView{ServerIdentifier: server, Role: r.Name, Scopes: r.Scopes})
+		}
+		for _, v := range p.EffectiveScopes() {
+			model.Scopes = scopes.AddScope(model.Scopes, v)
+		}
+	}
+
+	return model
+}
+
+// RoleHolderView is someone a role has been given to
+type RoleHolderView struct {
+	Username         string `json:"username,omitempty"`
+	Email            string `json:"email,omitempty"`
+	ClientId         string `json:"clientId,omitempty"`
+	ServerIdentifier string `json:"serverIdentifier,omitempty"`
+} //@name RoleHolder
+
+func roleIds(roles []*Role) []uint {
+	ids := make([]uint, len(roles))
+	for k, v := range roles {
+		ids[k] = v.ID
+	}
+	return ids
+}
+
+func FromRoleHolders(perms []*Permissions) []*RoleHolderView {
+	result := make([]*RoleHolderView, 0, len(perms))
+	for _, p := range perms {
+		model := &RoleHolderView{}
+		if p.UserId != nil {
+			model.Username = p.User.Username
+			model.Email = p.User.Email
+		}
+		if p.ClientId != nil {
+			model.ClientId = p.Client.ClientId
+		}
+		if p.ServerIdentifier != nil {
+			model.ServerIdentifier = *p.ServerIdentifier
+		}
+		result = append(result, model)
+	}
+	return result
 }
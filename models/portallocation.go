@@ -0,0 +1,57 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"gopkg.in/go-playground/validator.v9"
+	"gorm.io/gorm"
+)
+
+// AnyIP is the IP for binding to every IP on the node
+const AnyIP = "0.0.0.0"
+
+// PortRange is a range of ports on a node which can be given to servers, bound to an IP. 0.0.0.0 is every IP.
+type PortRange struct {
+	ID      uint   `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	NodeId  uint   `gorm:"column:node_id;not null;index" json:"nodeId"`
+	IP      string `gorm:"column:ip;not null;size:100" json:"ip" validate:"required,ip"`
+	PortMin uint16 `gorm:"column:port_min;not null" json:"portMin" validate:"required,min=1"`
+	PortMax uint16 `gorm:"column:port_max;not null" json:"portMax" validate:"required,gtefield=PortMin"`
+} //@name PortRange
+
+func (p *PortRange) IsValid() (err error) {
+	err = validator.New().Struct(p)
+	if err != nil {
+		err = pufferpanel.GenerateValidationMessage(err)
+	}
+	return
+}
+
+func (p *PortRange) BeforeSave(*gorm.DB) error {
+	return p.IsValid()
+}
+
+// Contains Gets if the port on the IP falls in this range
+func (p *PortRange) Contains(ip string, port uint16) bool {
+	return port >= p.PortMin && port <= p.PortMax && (p.IP == ip || p.IP == AnyIP || ip == AnyIP)
+}
+
+// PortAllocation is a port on a node which has been given to a server, for the variable in its definition
+type PortAllocation struct {
+	ID               uint   `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+	NodeId           uint   `gorm:"column:node_id;not null;uniqueIndex:idx_port_allocation" json:"nodeId"`
+	IP               string `gorm:"column:ip;not null;size:100;uniqueIndex:idx_port_allocation" json:"ip"`
+	Port             uint16 `gorm:"column:port;not null;uniqueIndex:idx_port_allocation" json:"port"`
+	ServerIdentifier string `gorm:"column:server_identifier;not null;size:20;index" json:"serverId"`
+	Variable         string `gorm:"column:variable;not null;size:100" json:"variable"`
+} //@name PortAllocation
+
+// Overlaps Gets if the port on the IP is the same as this one, where 0.0.0.0 overlaps every IP
+func (p *PortAllocation) Overlaps(ip string, port uint16) bool {
+	return p.Port == port && (p.IP == ip || p.IP == AnyIP || ip == AnyIP)
+}
+
+// NodePorts is the port pool of a node, and what has been given out of it
+type NodePorts struct {
+	Ranges      []*PortRange      `json:"ranges"`
+	Allocations []*PortAllocation `json:"allocations"`
+} //@name NodePorts
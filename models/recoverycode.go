@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+type RecoveryCode struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+
+	UserId uint  `gorm:"column:user_id;not null;index" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	//only the hash of the code is kept, the user is shown the codes once when they are generated
+	Code   string     `gorm:"column:code;not null;size:64;index" json:"-"`
+	UsedAt *time.Time `gorm:"column:used_at" json:"-"`
+
+	CreatedAt time.Time `json:"-"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// RefreshToken lets a client get a new session without asking the user again. Each one can only be used once, and
+// is swapped for a new one in the same family. A used token coming back means it was copied, so the family is revoked.
+type RefreshToken struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"-"`
+
+	//sha256 of the token, the token itself is only given to the client
+	Token  string `gorm:"column:token;not null;size:64;uniqueIndex;unique" json:"-"`
+	Family string `gorm:"column:family;not null;size:64;index" json:"-"`
+
+	ClientId uint   `gorm:"column:client_id;not null;index" json:"-"`
+	Client   Client `gorm:"ASSOCIATION_SAVE_REFERENCE:false" json:"-" validate:"-"`
+
+	UserId uint `gorm:"column:user_id;not null;index" json:"-"`
+	User   User `gorm:"ASSOCIATION_SAVE_REFERENCE:false" json:"-" validate:"-"`
+
+	RawScopes string          `gorm:"column:scopes;not null;size:1000;default:''" json:"-"`
+	Scopes    []*scopes.Scope `gorm:"-" json:"-"`
+
+	ExpiresAt time.Time  `gorm:"column:expires_at;not null;index" json:"-"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"-"`
+	CreatedAt time.Time  `json:"-"`
+}
+
+func (r *RefreshToken) BeforeSave(*gorm.DB) error {
+	tmp := make([]string, len(r.Scopes))
+	for k, v := range r.Scopes {
+		tmp[k] = v.String()
+	}
+	r.RawScopes = strings.Join(tmp, ",")
+	return nil
+}
+
+func (r *RefreshToken) AfterFind(*gorm.DB) error {
+	r.Scopes = make([]*scopes.Scope, 0)
+	if r.RawScopes != "" {
+		for _, v := range strings.Split(r.RawScopes, ",") {
+			r.Scopes = append(r.Scopes, scopes.GetScope(v))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"gopkg.in/go-playground/validator.v9"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Role is a named set of scopes which can be given to users and clients instead of picking each scope
+type Role struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+
+	Name        string `gorm:"column:name;not null;size:100;uniqueIndex;unique" json:"name" validate:"required,max=100,printascii"`
+	Description string `gorm:"column:description;not null;size:4000;default:''" json:"description" validate:"max=4000"`
+
+	//server roles are given on a server, global roles are given panel-wide
+	ForServer bool `gorm:"column:for_server;not null;default:0" json:"forServer"`
+
+	RawScopes string          `gorm:"column:scopes;not null;size:1000;default:''" json:"-"`
+	Scopes    []*scopes.Scope `gorm:"-" json:"scopes"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+} //@name Role
+
+func (r *Role) IsValid() (err error) {
+	err = validator.New().Struct(r)
+	if err != nil {
+		err = pufferpanel.GenerateValidationMessage(err)
+		return
+	}
+
+	if r.ForServer {
+		for _, v := range r.Scopes {
+			if !v.ForServer {
+				return pufferpanel.ErrRoleScopeNotForServer(v.Value)
+			}
+		}
+	}
+	return
+}
+
+func (r *Role) BeforeSave(*gorm.DB) error {
+	if r.Scopes == nil {
+		r.Scopes = make([]*scopes.Scope, 0)
+	}
+
+	if err := r.IsValid(); err != nil {
+		return err
+	}
+
+	tmp := make([]string, len(r.Scopes))
+	for k, v := range r.Scopes {
+		tmp[k] = v.String()
+	}
+	r.RawScopes = strings.Join(tmp, ",")
+	return nil
+}
+
+func (r *Role) AfterFind(*gorm.DB) error {
+	r.Scopes = make([]*scopes.Scope, 0)
+	if r.RawScopes != "" {
+		for _, v := range strings.Split(r.RawScopes, ",") {
+			r.Scopes = append(r.Scopes, scopes.GetScope(v))
+		}
+	}
+	return nil
+}
@@ -18,6 +18,11 @@ type Server struct {
 	IP   string `gorm:"" json:"-" validate:"omitempty,ip|fqdn"`
 	Port uint16 `gorm:"" json:"-" validate:"omitempty"`
 
+	//what the server has set aside on its node, memory and disk are in MB and CPU is in cores
+	Memory int64   `gorm:"column:memory;not null;default:0" json:"-" validate:"min=0"`
+	CPU    float64 `gorm:"column:cpu;not null;default:0" json:"-" validate:"min=0"`
+	Disk   int64   `gorm:"column:disk;not null;default:0" json:"-" validate:"min=0"`
+
 	Type string `gorm:"NOT NULL;default='generic'" json:"-" validate:"required,printascii"`
 	Icon string `gorm:"" json:"-"`
 
@@ -33,6 +38,14 @@ func (s *Server) IsValid() (err error) {
 	return
 }
 
+// SetResources Sets aside what the definition asks for
+func (s *Server) SetResources(definition pufferpanel.Server) {
+	s.Memory = definition.Resources.Memory
+	s.CPU = definition.Resources.CPU
+	//the quota is in bytes, round up to the next MB
+	s.Disk = (definition.Quota.Limit + 1024*1024 - 1) / (1024 * 1024)
+}
+
 func (s *Server) BeforeSave(*gorm.DB) (err error) {
 	err = s.IsValid()
 	if s.NodeID == 0 || s.Node.IsLocal() {
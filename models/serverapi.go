@@ -10,8 +10,17 @@ type ServerCreation struct {
 	NodeId uint     `json:"node"`
 	Users  []string `json:"users"`
 	Name   string   `json:"name"`
+	//Placement picks the node instead of using the one given, either binpack to fill up nodes or spread to even them out
+	Placement string `json:"placement,omitempty"`
 } //@name CreatedServer
 
+// ServerMigrationRequest asks for a server to be moved to another node
+type ServerMigrationRequest struct {
+	NodeId uint `json:"node"`
+	//Backups are moved along with the server when set
+	Backups bool `json:"backups"`
+} //@name ServerMigrationRequest
+
 type GetServerResponse struct {
 	Server *ServerView     `json:"server"`
 	Perms  *PermissionView `json:"permissions"`
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+const (
+	MigrationRunning   = "running"
+	MigrationCompleted = "completed"
+	MigrationFailed    = "failed"
+)
+
+// The steps of a migration, in the order they happen
+const (
+	MigrationStepStopping     = "stopping"
+	MigrationStepCreating     = "creating"
+	MigrationStepTransferring = "transferring"
+	MigrationStepSwitching    = "switching"
+	MigrationStepCleaning     = "cleaning"
+	MigrationStepDone         = "done"
+)
+
+// ServerMigration is a move of a server from one node to another, and how far along it is
+type ServerMigration struct {
+	ID               uint   `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	ServerIdentifier string `gorm:"column:server_identifier;not null;size:20;index" json:"serverId"`
+	SourceNodeId     uint   `gorm:"column:source_node_id;not null" json:"sourceNodeId"`
+	TargetNodeId     uint   `gorm:"column:target_node_id;not null" json:"targetNodeId"`
+	Backups          bool   `gorm:"column:backups;not null;default:false" json:"backups"`
+
+	Status string `gorm:"column:status;not null;size:20" json:"status"`
+	Step   string `gorm:"column:step;not null;size:20" json:"step"`
+	//Progress is how far along the migration is, in percent
+	Progress int `gorm:"column:progress;not null;default:0" json:"progress"`
+	//Error is why the migration failed, or what could not be cleaned up after it finished
+	Error string `gorm:"column:error;not null;size:1000;default:''" json:"error,omitempty"`
+	//RolledBack is if the server was put back on its old node after failing
+	RolledBack bool `gorm:"column:rolled_back;not null;default:false" json:"rolledBack"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `gorm:"column:finished_at" json:"finishedAt,omitempty"`
+} //@name ServerMigration
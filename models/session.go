@@ -1,6 +1,9 @@
 package models
 
 import (
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
@@ -18,4 +21,35 @@ type Session struct {
 	//if this set is for a server, what server
 	ServerIdentifier *string `gorm:"column:server_identifier" json:"-"`
 	Server           Server  `gorm:"ASSOCIATION_SAVE_REFERENCE:false" json:"-" validate:"-"`
+
+	//the scopes a user approved for a client, the session can use no others. Empty allows all
+	RawScopes string          `gorm:"column:scopes;not null;size:1000;default:''" json:"-"`
+	Scopes    []*scopes.Scope `gorm:"-" json:"-"`
+
+	//sessions from a refresh token share its family, so revoking one revokes the other
+	RefreshFamily string `gorm:"column:refresh_family;not null;size:64;default:'';index" json:"-"`
+
+	CreatedAt time.Time  `json:"-"`
+	LastSeen  *time.Time `gorm:"column:last_seen" json:"-"`
+	IPAddress string     `gorm:"column:ip_address;not null;size:100;default:''" json:"-"`
+	UserAgent string     `gorm:"column:user_agent;not null;size:500;default:''" json:"-"`
+}
+
+func (s *Session) BeforeSave(*gorm.DB) error {
+	tmp := make([]string, len(s.Scopes))
+	for k, v := range s.Scopes {
+		tmp[k] = v.String()
+	}
+	s.RawScopes = strings.Join(tmp, ",")
+	return nil
+}
+
+func (s *Session) AfterFind(*gorm.DB) error {
+	s.Scopes = make([]*scopes.Scope, 0)
+	if s.RawScopes != "" {
+		for _, v := range strings.Split(s.RawScopes, ",") {
+			s.Scopes = append(s.Scopes, scopes.GetScope(v))
+		}
+	}
+	return nil
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+type SessionView struct {
+	Id         uint       `json:"id"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastSeen   *time.Time `json:"lastSeen,omitempty"`
+	IPAddress  string     `json:"ip,omitempty"`
+	UserAgent  string     `json:"userAgent,omitempty"`
+	ClientId   string     `json:"clientId,omitempty"`
+	ClientName string     `json:"clientName,omitempty"`
+	//the session the request was made with
+	Current bool `json:"current"`
+} //@name Session
+
+func FromSession(model *Session, currentId uint) *SessionView {
+	view := &SessionView{
+		Id:        model.ID,
+		CreatedAt: model.CreatedAt,
+		ExpiresAt: model.ExpirationTime,
+		LastSeen:  model.LastSeen,
+		IPAddress: model.IPAddress,
+		UserAgent: model.UserAgent,
+		Current:   model.ID == currentId,
+	}
+	if model.ClientId != nil {
+		view.ClientId = model.Client.ClientId
+		view.ClientName = model.Client.Name
+	}
+	return view
+}
+
+func FromSessions(sessions []*Session, currentId uint) []*SessionView {
+	result := make([]*SessionView, len(sessions))
+
+	for k, v := range sessions {
+		result[k] = FromSession(v, currentId)
+	}
+
+	return result
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"gopkg.in/go-playground/validator.v9"
+	"gorm.io/gorm"
+	"time"
+)
+
+type SSHKey struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+
+	UserId uint  `gorm:"column:user_id;not null;index" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	Name        string `gorm:"column:name;not null;size:100;default:''" json:"name" validate:"max=100,printascii"`
+	PublicKey   string `gorm:"column:public_key;not null;size:4000" json:"publicKey" validate:"required"`
+	Fingerprint string `gorm:"column:fingerprint;not null;size:100;uniqueIndex;unique" json:"fingerprint"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsed   *time.Time `gorm:"column:last_used" json:"lastUsed,omitempty"`
+	LastUsedIP string     `gorm:"column:last_used_ip;not null;size:100;default:''" json:"lastUsedIp,omitempty"`
+} //@name SSHKey
+
+func (k *SSHKey) IsValid() (err error) {
+	err = validator.New().Struct(k)
+	if err != nil {
+		err = pufferpanel.GenerateValidationMessage(err)
+	}
+	return
+}
+
+func (k *SSHKey) BeforeSave(*gorm.DB) (err error) {
+	err = k.IsValid()
+	return
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"gopkg.in/go-playground/validator.v9"
+	"gorm.io/gorm"
+	"time"
+)
+
+type WebAuthnCredential struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+
+	UserId uint  `gorm:"column:user_id;not null;index" json:"-"`
+	User   *User `json:"-" validate:"-"`
+
+	Name         string `gorm:"column:name;not null;size:100;default:''" json:"name" validate:"max=100,printascii"`
+	CredentialId string `gorm:"column:credential_id;not null;size:255;uniqueIndex;unique" json:"-"`
+
+	//the credential as given back by the webauthn library, public key and sign count included
+	Credential string `gorm:"column:credential;not null;type:text" json:"-"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsed   *time.Time `gorm:"column:last_used" json:"lastUsed,omitempty"`
+	LastUsedIP string     `gorm:"column:last_used_ip;not null;size:100;default:''" json:"lastUsedIp,omitempty"`
+} //@name WebAuthnCredential
+
+func (w *WebAuthnCredential) IsValid() (err error) {
+	err = validator.New().Struct(w)
+	if err != nil {
+		err = pufferpanel.GenerateValidationMessage(err)
+	}
+	return
+}
+
+func (w *WebAuthnCredential) BeforeSave(*gorm.DB) (err error) {
+	err = w.IsValid()
+	return
+}
@@ -3,14 +3,19 @@ package oauth2
 import (
 	"bytes"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/config"
 	"net/http"
 	"net/url"
 )
 
 func createRequest(data url.Values) (request *http.Request) {
-	authUrl := config.AuthUrl.Value()
-	request, _ = http.NewRequest("POST", authUrl, bytes.NewBufferString(data.Encode()))
+	return createRequestTo(config.AuthUrl.Value(), data)
+}
+
+// createRequestTo makes a request to the panel like createRequest, but to the given url
+func createRequestTo(target string, data url.Values) (request *http.Request) {
+	request, _ = http.NewRequest("POST", target, bytes.NewBufferString(data.Encode()))
 
 	request.Header.Add("Authorization", "Bearer "+config.ClientSecret.Value())
 	request.Header.Add("Content-Type", binding.MIMEPOSTForm)
@@ -18,19 +23,32 @@ func createRequest(data url.Values) (request *http.Request) {
 }
 
 type TokenInfoResponse struct {
-	Active bool   `json:"active"`
-	Scope  string `json:"scope,omitempty"`
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
 	ErrorResponse
 } //@name OAuth2TokenInfoResponse
 
 type TokenResponse struct {
-	AccessToken string `json:"access_token,omitempty"`
-	TokenType   string `json:"token_type,omitempty"`
-	ExpiresIn   int64  `json:"expires_in,omitempty"`
-	Scope       string `json:"scope"`
+	AccessToken  string `json:"access_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	Scope        string `json:"scope"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	ErrorResponse
 } //@name OAuth2TokenResponse
 
+// SFTPTokenResponse is given to nodes validating SFTP logins, with the paths the user is limited to.
+// Logins without a server list every server the user can reach instead.
+type SFTPTokenResponse struct {
+	TokenResponse
+	Paths   []string                       `json:"paths,omitempty"`
+	Servers []pufferpanel.SFTPServerAccess `json:"servers,omitempty"`
+} //@name OAuth2SFTPTokenResponse
+
 type ErrorResponse struct {
 	Error            string `json:"error,omitempty"`
 	ErrorDescription string `json:"error_description,omitempty"`
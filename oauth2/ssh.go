@@ -4,13 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
 	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 )
 
@@ -18,16 +21,78 @@ type WebSSHAuthorization struct {
 }
 
 func (ws *WebSSHAuthorization) Validate(username string, password string) (*ssh.Permissions, error) {
-	return validateSSH(username, password, true)
+	return ws.ValidateFrom(username, password, nil)
 }
 
-func validateSSH(username string, password string, recurse bool) (*ssh.Permissions, error) {
+// ValidateFrom asks the panel to check the password, telling it where the client connects from so the panel can
+// lock out those who fail too often
+func (ws *WebSSHAuthorization) ValidateFrom(username string, password string, addr net.Addr) (*ssh.Permissions, error) {
 	data := url.Values{}
 	data.Set("grant_type", "password")
 	data.Set("username", username)
 	data.Set("password", password)
 	data.Set("scope", "sftp")
+	if addr != nil {
+		data.Set("client_ip", remoteIP(addr))
+	}
+
+	return validateSSH(data)
+}
 
+// ValidateKey asks the panel if the key is registered to the user.
+// The key is only reported as used once the client has proven it holds the private key, see KeyUsed.
+func (ws *WebSSHAuthorization) ValidateKey(username string, key ssh.PublicKey) (*ssh.Permissions, error) {
+	data := url.Values{}
+	data.Set("grant_type", "ssh_key")
+	data.Set("username", username)
+	data.Set("public_key", string(ssh.MarshalAuthorizedKey(key)))
+	data.Set("scope", "sftp")
+
+	perms, err := validateSSH(data)
+	if err != nil {
+		return nil, err
+	}
+	perms.Extensions["key_fingerprint"] = ssh.FingerprintSHA256(key)
+	return perms, nil
+}
+
+// KeyUsed tells the panel the key was used to log in, as only this node sees the handshake complete
+func (ws *WebSSHAuthorization) KeyUsed(fingerprint string, addr net.Addr) {
+	u, err := url.Parse(config.AuthUrl.Value())
+	if err != nil {
+		logging.Error.Printf("Error recording SSH key use: %s", err)
+		return
+	}
+	u.Path = path.Join(path.Dir(u.Path), "sshkey", "used")
+	u.RawQuery = ""
+
+	data := url.Values{}
+	data.Set("fingerprint", fingerprint)
+	if addr != nil {
+		data.Set("client_ip", remoteIP(addr))
+	}
+
+	response, err := pufferpanel.Http().Do(createRequestTo(u.String(), data))
+	defer utils.CloseResponse(response)
+	if err != nil {
+		logging.Error.Printf("Error recording SSH key use: %s", err)
+		return
+	}
+	if response.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(response.Body)
+		logging.Error.Printf("Error recording SSH key use: [%d] [%s]", response.StatusCode, msg)
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
+
+func validateSSH(data url.Values) (*ssh.Permissions, error) {
 	request := createRequest(data)
 
 	response, err := pufferpanel.Http().Do(request)
@@ -45,16 +110,30 @@ func validateSSH(username string, password string, recurse bool) (*ssh.Permissio
 		return nil, errors.New("invalid response from authorization server")
 	}
 
-	var respArr map[string]interface{}
-	err = json.NewDecoder(response.Body).Decode(&respArr)
+	var resp SFTPTokenResponse
+	err = json.NewDecoder(response.Body).Decode(&resp)
 	if err != nil {
 		return nil, err
 	}
-	if respArr["error"] != nil {
+	if resp.Error != "" {
 		return nil, errors.New("incorrect username or password")
 	}
 	sshPerms := &ssh.Permissions{}
-	grantedScopes := strings.Split(respArr["scope"].(string), " ")
+
+	//no server was asked for, so this login covers every server the panel gave us
+	if !strings.Contains(data.Get("username"), "#") {
+		if len(resp.Servers) == 0 {
+			return nil, errors.New("incorrect username or password")
+		}
+		serverList, err := json.Marshal(resp.Servers)
+		if err != nil {
+			return nil, err
+		}
+		sshPerms.Extensions = map[string]string{"servers": string(serverList)}
+		return sshPerms, nil
+	}
+
+	grantedScopes := strings.Split(resp.Scope, " ")
 	for _, v := range grantedScopes {
 
 		t := strings.Split(v, ":")
@@ -64,9 +143,15 @@ func validateSSH(username string, password string, recurse bool) (*ssh.Permissio
 		serverId := t[0]
 		scope := t[1]
 
-		if scopes.ScopeServerSftp.Is(scope) {
+		if scopes.ScopeServerSftp.Is(scope) || scopes.ScopeServerSftpReadOnly.Is(scope) {
 			sshPerms.Extensions = make(map[string]string)
 			sshPerms.Extensions["server_id"] = serverId
+			if scopes.ScopeServerSftpReadOnly.Is(scope) {
+				sshPerms.Extensions["read_only"] = "true"
+			}
+			if len(resp.Paths) > 0 {
+				sshPerms.Extensions["paths"] = strings.Join(resp.Paths, "\n")
+			}
 			return sshPerms, nil
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 )
@@ -91,6 +92,32 @@ func (r Requirements) Test(server Server) error {
 	return nil
 }
 
+// TestNode checks the requirements against what a node says it is and supports, so a node can be picked before the
+// server is sent to it. Binaries can only be checked on the node itself, so are left out.
+func (r Requirements) TestNode(server Server, os, arch string, features []string) error {
+	osReq := parseRequirementRow(r.OS)
+	if len(osReq) > 0 && !slices.Contains(osReq, os) {
+		return ErrUnsupportedOS(os, strings.ReplaceAll(r.OS, "||", " OR "))
+	}
+
+	archReq := parseRequirementRow(r.Arch)
+	if len(archReq) > 0 && !slices.Contains(archReq, arch) {
+		return ErrUnsupportedArch(arch, strings.ReplaceAll(r.Arch, "||", " OR "))
+	}
+
+	var envType Type
+	err := utils.UnmarshalTo(server.Environment, &envType)
+	if err != nil {
+		return err
+	}
+
+	if envType.Type == "docker" && !slices.Contains(features, "docker") {
+		return ErrDockerNotSupported
+	}
+
+	return nil
+}
+
 func parseRequirementRow(str string) []string {
 	if str == "" {
 		return []string{}
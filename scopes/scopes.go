@@ -1,6 +1,9 @@
 package scopes
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+)
 
 type Scope struct {
 	Value     string
@@ -20,6 +23,7 @@ var (
 	ScopeSelfClients = registerNonServerScope("self.clients") //can the user create and manage OAuth2 clients for their own account
 
 	ScopeServerCreate         = registerNonServerScope("server.create")
+	ScopeServerMigrate        = registerNonServerScope("server.migrate")
 	ScopeServerView           = registerServerScope("server.view")
 	ScopeServerAdmin          = registerServerScope("server.admin")
 	ScopeServerDelete         = registerServerScope("server.delete")
@@ -53,6 +57,8 @@ var (
 	ScopeServerFileView      = registerServerScope("server.files.view")
 	ScopeServerFileEdit      = registerServerScope("server.files.edit")
 	ScopeServerSftp          = registerServerScope("server.sftp")
+	ScopeServerSftpReadOnly  = registerServerScope("server.sftp.readonly")
+	ScopeServerSftpLog       = registerServerScope("server.sftp.log")
 	ScopeServerConsole       = registerServerScope("server.console")
 	ScopeServerSendCommand   = registerServerScope("server.console.send")
 	ScopeServerStats         = registerServerScope("server.stats")
@@ -64,6 +70,8 @@ var (
 
 	ScopeSettingsEdit = registerNonServerScope("settings.edit")
 
+	ScopeAuditView = registerNonServerScope("audit.view")
+
 	ScopeTemplatesView       = registerNonServerScope("templates.view")
 	ScopeTemplatesLocalEdit  = registerNonServerScope("templates.local.edit")
 	ScopeTemplatesRepoCreate = registerNonServerScope("templates.repo.create")
@@ -75,6 +83,9 @@ var (
 	ScopeUserPermsView  = registerNonServerScope("users.perms.view")
 	ScopeUserPermsEdit  = registerNonServerScope("users.perms.edit")
 
+	ScopeRolesView = registerNonServerScope("roles.view")
+	ScopeRolesEdit = registerNonServerScope("roles.edit")
+
 	ScopePanel = registerNonServerScope("panel")
 )
 
@@ -131,6 +142,25 @@ func GetScope(str string) *Scope {
 	return &Scope{Value: str}
 }
 
+// ParseScopes Parses a space separated list of scopes, as OAuth2 sends them. It fails if any scope is not known
+func ParseScopes(str string) ([]*Scope, bool) {
+	result := make([]*Scope, 0)
+	for _, v := range strings.Fields(str) {
+		found := false
+		for _, z := range allScopes {
+			if z.Is(v) {
+				result = AddScope(result, z)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return result, true
+}
+
 func ContainsScope(arr []*Scope, value *Scope) bool {
 	desired := []*Scope{value}
 	if !value.Is(ScopeAdmin.Value) {
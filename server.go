@@ -19,8 +19,25 @@ type Server struct {
 	Requirements          Requirements              `json:"requirements,omitempty"`
 	Stats                 MetadataType              `json:"stats,omitempty"`
 	Query                 MetadataType              `json:"query,omitempty"`
+	Quota                 Quota                     `json:"quota,omitempty"`
+	Resources             Resources                 `json:"resources,omitempty"`
 } //@name ServerDefinition
 
+// Resources is what the server needs set aside for it on its node. The disk it needs is its quota limit.
+type Resources struct {
+	//Memory is in MB
+	Memory int64 `json:"memory,omitempty"`
+	//CPU is how many cores, which can be part of a core such as 0.5
+	CPU float64 `json:"cpu,omitempty"`
+} //@name Resources
+
+type Quota struct {
+	//Limit is the most disk space the server can use in bytes, including backups. 0 is unlimited
+	Limit int64 `json:"limit,omitempty"`
+	//Warning is how many bytes can be used before a warning is shown on the console. 0 is no warning
+	Warning int64 `json:"warning,omitempty"`
+} //@name Quota
+
 type Execution struct {
 	Command                 interface{}               `json:"command"`
 	StopCommand             string                    `json:"stop,omitempty"`
@@ -70,6 +87,8 @@ func (s *Server) CopyFrom(replacement *Server) {
 	s.SupportedEnvironments = replacement.SupportedEnvironments
 	s.Groups = replacement.Groups
 	s.Stats = replacement.Stats
+	s.Quota = replacement.Quota
+	s.Resources = replacement.Resources
 }
 
 func (s *Server) DataToMap() map[string]interface{} {
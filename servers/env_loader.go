@@ -45,6 +45,7 @@ func CreateEnvironment(environmentType, folder string, backupFolder string, serv
 	e.ConsoleTracker = pufferpanel.CreateTracker()
 	e.StatusTracker = pufferpanel.CreateTracker()
 	e.StatsTracker = pufferpanel.CreateTracker()
+	e.FilesTracker = pufferpanel.CreateTracker()
 
 	e.ConsoleBuffer = envCache
 	e.Wait = &sync.WaitGroup{}
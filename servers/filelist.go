@@ -0,0 +1,195 @@
+package servers
+
+import (
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type ListOptions struct {
+	//Filter is a glob the names of entries must match
+	Filter string
+	//Sort is the field to sort on, being name, size, modified or type. Folders are always first.
+	Sort       string
+	Descending bool
+	//Page starts at 1, and is only used if PageSize is set
+	Page     int
+	PageSize int
+}
+
+func (p *Server) listFolder(name string, options ListOptions) (*FileData, error) {
+	fileList, err := p.GetFileServer().ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]listEntry, 0, len(fileList))
+	for _, file := range fileList {
+		if options.Filter != "" {
+			if matched, _ := filepath.Match(options.Filter, file.Name()); !matched {
+				continue
+			}
+		}
+		entries = append(entries, listEntry{DirEntry: file, folder: file.IsDir()})
+	}
+
+	if options.Sort != "" {
+		p.sortEntries(name, entries, options.Sort, options.Descending)
+	}
+
+	total := len(entries)
+	page := options.Page
+	if page <= 0 {
+		page = 1
+	}
+	if options.PageSize > 0 {
+		start := (page - 1) * options.PageSize
+		if start > len(entries) {
+			start = len(entries)
+		}
+		end := start + options.PageSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		entries = entries[start:end]
+	}
+
+	//these are more expensive, so only fill in for what we are returning
+	result := make([]pufferpanel.FileDesc, 0, len(entries)+1)
+	if name != "" && name != "." && name != "/" && page == 1 {
+		result = append(result, pufferpanel.FileDesc{Name: "..", File: false})
+	}
+	for _, v := range entries {
+		desc, info := p.describeFile(name, v.DirEntry)
+		p.describeContents(name, &desc, info)
+		result = append(result, desc)
+	}
+
+	return &FileData{FileList: result, Total: total}, nil
+}
+
+// listEntry is an entry of a folder being listed, with only what sorting needs filled in
+type listEntry struct {
+	fs.DirEntry
+	folder   bool
+	size     int64
+	modified int64
+}
+
+// sortEntries sorts the entries of the folder, only looking up what the field needs, so large folders sorted by name
+// don't have every entry looked at
+func (p *Server) sortEntries(folder string, entries []listEntry, field string, descending bool) {
+	needsInfo := field == "size" || field == "modified"
+	for i := range entries {
+		v := &entries[i]
+		//symlinks are sorted as what they point to, which means following them
+		if !needsInfo && v.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		//the entries come from a folder opened through the server, so their own Info can't find them, it's looked up
+		//through the server instead
+		info, err := p.GetFileServer().Stat(filepath.Join(folder, v.Name()))
+		if err != nil {
+			continue
+		}
+		v.folder = info.IsDir()
+		if !v.folder {
+			v.size = info.Size()
+		}
+		v.modified = info.ModTime().Unix()
+	}
+
+	var less func(a, b listEntry) bool
+	switch field {
+	case "size":
+		less = func(a, b listEntry) bool { return a.size < b.size }
+	case "modified":
+		less = func(a, b listEntry) bool { return a.modified < b.modified }
+	case "type":
+		less = func(a, b listEntry) bool {
+			return strings.ToLower(filepath.Ext(a.Name())) < strings.ToLower(filepath.Ext(b.Name()))
+		}
+	default:
+		less = func(a, b listEntry) bool { return strings.ToLower(a.Name()) < strings.ToLower(b.Name()) }
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.folder != b.folder {
+			return a.folder
+		}
+		if descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+func (p *Server) describeFile(folder string, file fs.DirEntry) (pufferpanel.FileDesc, fs.FileInfo) {
+	path := filepath.Join(folder, file.Name())
+	desc := pufferpanel.FileDesc{
+		Name: file.Name(),
+		File: !file.IsDir(),
+	}
+
+	if file.Type()&os.ModeSymlink != 0 {
+		desc.Symlink = true
+		target, err := p.GetFileServer().Readlink(path)
+		if err != nil {
+			return desc, nil
+		}
+		desc.SymlinkTarget = target
+		desc.SymlinkEscapes = files.SymlinkEscapes(folder, target)
+		if desc.SymlinkEscapes {
+			return desc, nil
+		}
+	}
+
+	//symlinks are followed through the server, which refuses with EXDEV when a chain of links leaves the root
+	info, err := p.GetFileServer().Stat(path)
+	if err != nil {
+		if desc.Symlink && errors.Is(err, syscall.EXDEV) {
+			desc.SymlinkEscapes = true
+		}
+		return desc, nil
+	}
+
+	desc.File = !info.IsDir()
+	desc.Modified = info.ModTime().Unix()
+	desc.Mode = "0" + strconv.FormatUint(uint64(info.Mode().Perm()), 8)
+	if uid, gid, ok := files.Owner(info); ok {
+		desc.Uid = &uid
+		desc.Gid = &gid
+	}
+	if desc.File {
+		desc.Size = info.Size()
+		desc.Extension = filepath.Ext(file.Name())
+	}
+	return desc, info
+}
+
+func (p *Server) describeContents(folder string, desc *pufferpanel.FileDesc, info fs.FileInfo) {
+	if desc.SymlinkEscapes || info == nil {
+		return
+	}
+
+	path := filepath.Join(folder, desc.Name)
+	//only regular files are opened, as opening the likes of a FIFO waits for something to write to it
+	if info.Mode().IsRegular() {
+		if mime, err := files.DetectContentType(p.GetFileServer(), path); err == nil {
+			desc.Mime = mime
+		}
+	} else if info.IsDir() {
+		if children, err := p.GetFileServer().ReadDir(path); err == nil {
+			count := len(children)
+			desc.Children = &count
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package servers
+
+import (
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+)
+
+// ApplyQuota updates the file server with the limits from the server definition
+func (p *Server) ApplyQuota() {
+	quota := p.GetFileServer().GetQuota()
+	quota.OnWarning = func(used, limit int64) {
+		if limit > 0 {
+			p.RunningEnvironment.DisplayToConsole(true, "Warning: server is using %s of its %s disk quota\n", formatSize(used), formatSize(limit))
+		} else {
+			p.RunningEnvironment.DisplayToConsole(true, "Warning: server is using %s of disk space\n", formatSize(used))
+		}
+	}
+	quota.SetLimits(p.Quota.Limit, p.Quota.Warning)
+}
+
+// RecalculateUsage walks the server and its backups to find how much space is used.
+// This is only needed after something outside the file server has written to the server, such as the server itself.
+func (p *Server) RecalculateUsage() {
+	_, err := p.GetFileServer().CalculateUsage(p.GetBackupDirectory())
+	if err != nil {
+		p.Log(logging.Error, "Error calculating disk usage: %s", err)
+	}
+}
+
+// GetStats gets the stats from the environment, along with the disk usage of the server
+func (p *Server) GetStats() (*pufferpanel.ServerStats, error) {
+	stats, err := p.GetEnvironment().GetStats()
+	if err != nil {
+		return nil, err
+	}
+
+	quota := p.GetFileServer().GetQuota()
+	stats.DiskUsed = quota.Used()
+	stats.DiskLimit = quota.Limit()
+	return stats, nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
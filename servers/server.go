@@ -35,6 +35,13 @@ type Server struct {
 	fileServer         files.FileServer
 	backingUp          bool
 	restoring          bool
+	transferring       bool
+	migrating          bool
+	transferStatus     pufferpanel.ServerTransferStatus
+	transferLock       sync.Mutex
+	sftpLogLock        sync.Mutex
+	sftpLogCount       int
+	sftpLogCounted     bool
 }
 
 var queue *list.List
@@ -42,6 +49,9 @@ var lock = sync.Mutex{}
 var startQueueTicker, statTicker *time.Ticker
 var running = false
 
+const copyProgressThreshold = 100 * 1024 * 1024
+const copyProgressInterval = 2 * time.Second
+
 func init() {
 	archiver.DefaultZip.OverwriteExisting = true
 	archiver.DefaultTarGz.OverwriteExisting = true
@@ -115,7 +125,7 @@ func SendStatsForServers() {
 		wg.Add(1)
 		go func(p *Server) {
 			defer wg.Done()
-			stats, err := p.GetEnvironment().GetStats()
+			stats, err := p.GetStats()
 			if err != nil {
 				return
 			}
@@ -134,6 +144,8 @@ type FileData struct {
 	ContentLength int64
 	FileList      []pufferpanel.FileDesc
 	Name          string
+	Modified      time.Time
+	Total         int
 }
 
 func (p *Server) DataToMap() map[string]interface{} {
@@ -172,6 +184,9 @@ func CreateProgram() *Server {
 // Start Starts the program.
 // This includes starting the environment if it is not running.
 func (p *Server) Start() error {
+	if p.IsMigrating() {
+		return pufferpanel.ErrServerMigrating
+	}
 	if err := p.IsIdle(); err != nil {
 		return err
 	}
@@ -411,6 +426,7 @@ func (p *Server) Install() error {
 		}
 	}
 
+	p.RecalculateUsage()
 	p.RunningEnvironment.DisplayToConsole(true, "Server installed\n")
 	return nil
 }
@@ -514,6 +530,9 @@ func (p *Server) afterExit(exitCode int) {
 		p.CrashCounter = 0
 	}
 
+	//the server may have written anything while it was running
+	p.RecalculateUsage()
+
 	mapping := p.DataToMap()
 	mapping["success"] = graceful
 	mapping["exitCode"] = exitCode
@@ -542,55 +561,20 @@ func (p *Server) afterExit(exitCode int) {
 	}
 }
 
-func (p *Server) GetItem(name string) (*FileData, error) {
+func (p *Server) GetItem(name string, options ListOptions) (*FileData, error) {
 	info, err := p.GetFileServer().Stat(name)
 	if err != nil {
 		return nil, err
 	}
 
 	if info.IsDir() {
-		fileList, _ := p.GetFileServer().ReadDir(name)
-		var fileNames []pufferpanel.FileDesc
-		offset := 0
-		if name == "" || name == "." || name == "/" {
-			fileNames = make([]pufferpanel.FileDesc, len(fileList))
-		} else {
-			fileNames = make([]pufferpanel.FileDesc, len(fileList)+1)
-			fileNames[0] = pufferpanel.FileDesc{
-				Name: "..",
-				File: false,
-			}
-			offset = 1
-		}
-
-		//validate any symlinks are valid
-
-		for i, file := range fileList {
-			newFile := pufferpanel.FileDesc{
-				Name: file.Name(),
-				File: !file.IsDir(),
-			}
-
-			if !file.IsDir() && file.Type()&os.ModeSymlink == 0 {
-				infoData, err := p.GetFileServer().Stat(filepath.Join(name, file.Name()))
-				if err != nil {
-					continue
-				}
-				newFile.Size = infoData.Size()
-				newFile.Modified = infoData.ModTime().Unix()
-				newFile.Extension = filepath.Ext(file.Name())
-			}
-
-			fileNames[i+offset] = newFile
-		}
-
-		return &FileData{FileList: fileNames}, nil
+		return p.listFolder(name, options)
 	} else {
 		file, err := p.GetFileServer().Open(name)
 		if err != nil {
 			return nil, err
 		}
-		return &FileData{Contents: file, ContentLength: info.Size(), Name: info.Name()}, nil
+		return &FileData{Contents: file, ContentLength: info.Size(), Name: info.Name(), Modified: info.ModTime()}, nil
 	}
 }
 
@@ -602,6 +586,45 @@ func (p *Server) ArchiveItems(sourceFiles []string, destination string) error {
 	return files.Compress(p.GetFileServer(), destination, sourceFiles)
 }
 
+// CopyItem copies a file or folder, reporting progress to the console for large copies
+func (p *Server) CopyItem(source, destination string) error {
+	if _, err := p.GetFileServer().Stat(destination); err == nil {
+		return pufferpanel.ErrFileExists
+	}
+
+	total, err := files.Size(p.GetFileServer(), source)
+	if err != nil {
+		return err
+	}
+
+	var progress func(int64)
+	if total >= copyProgressThreshold {
+		p.RunningEnvironment.DisplayToConsole(true, "Copying %s to %s\n", source, destination)
+		lastReport := time.Now()
+		progress = func(copied int64) {
+			if time.Since(lastReport) < copyProgressInterval {
+				return
+			}
+			lastReport = time.Now()
+			p.RunningEnvironment.DisplayToConsole(true, "Copying %s: %d%%\n", source, copied*100/total)
+		}
+	}
+
+	err = p.GetFileServer().Copy(source, destination, progress)
+	if err != nil {
+		p.Log(logging.Error, "Error copying %s to %s: %s", source, destination, err)
+		if progress != nil {
+			p.RunningEnvironment.DisplayToConsole(true, "Failed to copy %s\n", source)
+		}
+		return err
+	}
+
+	if progress != nil {
+		p.RunningEnvironment.DisplayToConsole(true, "Copied %s to %s\n", source, destination)
+	}
+	return nil
+}
+
 func (p *Server) Extract(source, destination string) error {
 	return files.Extract(p.GetFileServer(), source, destination, "*", false, nil)
 }
@@ -643,17 +666,38 @@ func (p *Server) StartBackup() (string, error) {
 	backupFileName := backupId.String() + ".tar.gz"
 	backupFile := path.Join(backupDirectory, backupFileName)
 
+	quota := p.GetFileServer().GetQuota()
+	if quota.Available() == 0 {
+		c <- false
+		return "", files.ErrQuotaExceeded
+	}
+
 	go func(file string, d chan bool) {
+		success := false
 		defer func() {
-			d <- true
+			d <- success
 		}()
 		sourceFiles := []string{filepath.Join(p.GetFileServer().Prefix())}
 
-		err = files.Compress(nil, file, sourceFiles)
+		err := files.Compress(nil, file, sourceFiles)
 		if err != nil {
 			p.Log(logging.Error, "Error creating backup file: %s", err)
 			p.RunningEnvironment.DisplayToConsole(true, "Failed to create backup file")
+			return
+		}
+
+		//backups count against the quota, so do not keep one which does not fit
+		info, err := os.Stat(file)
+		if err == nil {
+			err = quota.Reserve(info.Size())
 		}
+		if err != nil {
+			p.Log(logging.Error, "Error creating backup file: %s", err)
+			p.RunningEnvironment.DisplayToConsole(true, "Failed to create backup file: %s\n", err)
+			_ = os.Remove(file)
+			return
+		}
+		success = true
 	}(backupFile, c)
 
 	return backupFileName, nil
@@ -667,10 +711,14 @@ func (p *Server) DeleteBackup(fileName string) error {
 
 	backupFile := path.Join(backupDirectory, fileName)
 
+	info, statErr := os.Stat(backupFile)
 	err := os.Remove(backupFile)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if err == nil && statErr == nil {
+		p.GetFileServer().GetQuota().Release(info.Size())
+	}
 
 	return nil
 }
@@ -738,6 +786,7 @@ func (p *Server) StartRestore(fileName string) error {
 			p.Log(logging.Error, "Error restoring files: %s", err)
 			p.RunningEnvironment.DisplayToConsole(true, "Failed to restore files: %s", err)
 		}
+		p.RecalculateUsage()
 	}(backupFile, c)
 
 	return nil
@@ -822,11 +871,29 @@ func (p *Server) IsRestoring() bool {
 	return p.restoring
 }
 
+func (p *Server) IsTransferring() bool {
+	return p.transferring
+}
+
+// SetMigrating Marks the server as being moved to another node, which keeps it from being started or changed over SFTP
+// until it is gone or given back
+func (p *Server) SetMigrating(migrating bool) {
+	p.migrating = migrating
+}
+
+func (p *Server) IsMigrating() bool {
+	return p.migrating
+}
+
 func (p *Server) IsIdle() error {
 	if p.IsRestoring() || p.IsBackingUp() {
 		return pufferpanel.ErrBackupInProgress
 	}
 
+	if p.IsTransferring() {
+		return pufferpanel.ErrTransferInProgress
+	}
+
 	r, _ := p.GetEnvironment().IsRunning()
 	if r {
 		return pufferpanel.ErrServerRunning
@@ -40,6 +40,9 @@ func LoadFromFolder() {
 
 		logging.Info.Printf("Loaded server %s", program.Id())
 		allServers = append(allServers, program)
+
+		//walking large servers takes a while, so do not hold up the rest
+		go program.RecalculateUsage()
 	}
 }
 
@@ -108,6 +111,7 @@ func LoadFromData(id string, source []byte) (*Server, error) {
 		return nil, err
 	}
 	data.SetFileServer(fs)
+	data.ApplyQuota()
 
 	return data, nil
 }
@@ -197,6 +201,9 @@ func Delete(id string) (err error) {
 	if err != nil {
 		logging.Error.Printf("Error removing server: %s", err)
 	}
+	if logErr := os.Remove(program.sftpLogPath()); logErr != nil && !os.IsNotExist(logErr) {
+		logging.Error.Printf("Error removing server SFTP log: %s", logErr)
+	}
 	allServers = append(allServers[:index], allServers[index+1:]...)
 	return
 }
@@ -236,6 +243,7 @@ func Reload(id string) (err error) {
 
 	program.RunningEnvironment = newVersion.RunningEnvironment
 	program.Server = newVersion.Server
+	program.ApplyQuota()
 
 	program.Scheduler.Stop()
 	logging.Debug.Println("Rebuilding scheduler")
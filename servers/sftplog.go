@@ -0,0 +1,148 @@
+package servers
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RecordSFTP adds an entry to the server's SFTP log.
+// Changes to files are also sent to anyone watching the server's files, so they can refresh.
+func (p *Server) RecordSFTP(entry pufferpanel.SFTPLogEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if err := p.appendSFTPLog(entry); err != nil {
+		p.Log(logging.Error, "Error recording SFTP log: %s", err)
+	}
+
+	if entry.Path != "" && config.SftpBroadcast.Value() {
+		_ = p.GetEnvironment().GetFilesTracker().WriteMessage(pufferpanel.Transmission{
+			Message: entry,
+			Type:    pufferpanel.MessageTypeFiles,
+		})
+	}
+}
+
+// GetSFTPLog gets a page of the SFTP log, newest first, along with how many entries there are.
+// Only entries the filter accepts are included, a nil filter accepts all.
+func (p *Server) GetSFTPLog(skip, limit int, filter func(entry pufferpanel.SFTPLogEntry) bool) ([]pufferpanel.SFTPLogEntry, int, error) {
+	p.sftpLogLock.Lock()
+	entries, err := p.readSFTPLog()
+	p.sftpLogLock.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]pufferpanel.SFTPLogEntry, 0)
+	total := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filter != nil && !filter(entries[i]) {
+			continue
+		}
+		total++
+		if total > skip && (limit <= 0 || len(result) < limit) {
+			result = append(result, entries[i])
+		}
+	}
+	return result, total, nil
+}
+
+func (p *Server) appendSFTPLog(entry pufferpanel.SFTPLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	p.sftpLogLock.Lock()
+	defer p.sftpLogLock.Unlock()
+
+	if !p.sftpLogCounted {
+		existing, err := p.readSFTPLog()
+		if err != nil {
+			return err
+		}
+		p.sftpLogCount = len(existing)
+		p.sftpLogCounted = true
+	}
+
+	file, err := os.OpenFile(p.sftpLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	utils.Close(file)
+	if err != nil {
+		return err
+	}
+	p.sftpLogCount++
+
+	//trim in batches, so the log is not rewritten for every entry once it is full
+	limit := config.SftpLogLimit.Value()
+	if limit <= 0 || p.sftpLogCount <= limit+limit/10 {
+		return nil
+	}
+
+	entries, err := p.readSFTPLog()
+	if err != nil {
+		return err
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	temp := p.sftpLogPath() + ".tmp"
+	file, err = os.Create(temp)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, v := range entries {
+		if err = encoder.Encode(v); err != nil {
+			break
+		}
+	}
+	utils.Close(file)
+	if err == nil {
+		err = os.Rename(temp, p.sftpLogPath())
+	}
+	if err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	p.sftpLogCount = len(entries)
+	return nil
+}
+
+func (p *Server) readSFTPLog() ([]pufferpanel.SFTPLogEntry, error) {
+	file, err := os.Open(p.sftpLogPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer utils.Close(file)
+
+	entries := make([]pufferpanel.SFTPLogEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry pufferpanel.SFTPLogEntry
+		//a line cut short by a crash should not lose the rest of the log
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (p *Server) sftpLogPath() string {
+	return filepath.Join(config.ServersFolder.Value(), p.Id()+".sftp.log")
+}
@@ -0,0 +1,178 @@
+package servers
+
+import (
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// transferFile is an archive made to send a server to another node, which is removed once it has been sent
+type transferFile struct {
+	*os.File
+	dir string
+}
+
+func (t *transferFile) Close() error {
+	err := t.File.Close()
+	_ = os.RemoveAll(t.dir)
+	return err
+}
+
+// ArchiveForTransfer Packs the files of the server up so they can be sent to another node
+func (p *Server) ArchiveForTransfer() (*FileData, error) {
+	if err := p.IsIdle(); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "transfer")
+	if err != nil {
+		return nil, err
+	}
+
+	archive := filepath.Join(dir, p.Id()+".tar.gz")
+	err = files.Compress(nil, archive, []string{p.GetFileServer().Prefix()})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		utils.Close(file)
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &FileData{Contents: &transferFile{File: file, dir: dir}, ContentLength: info.Size(), Name: info.Name()}, nil
+}
+
+// StartTransfer Starts filling the server with the files and backups of the same server on another node, which goes
+// on in the background. The files are downloaded from that node's daemon, using the token the panel gave for it.
+func (p *Server) StartTransfer(transfer pufferpanel.ServerTransfer) error {
+	if err := p.IsIdle(); err != nil {
+		return err
+	}
+
+	p.transferring = true
+	p.transferLock.Lock()
+	p.transferStatus = pufferpanel.ServerTransferStatus{Running: true}
+	p.transferLock.Unlock()
+
+	go func() {
+		err := p.receiveTransfer(transfer)
+
+		p.transferLock.Lock()
+		p.transferStatus.Running = false
+		if err != nil {
+			p.Log(logging.Error, "Error receiving files from %s: %s", transfer.Source, err)
+			p.transferStatus.Error = err.Error()
+		} else {
+			p.transferStatus.Done = true
+		}
+		p.transferLock.Unlock()
+		p.transferring = false
+	}()
+	return nil
+}
+
+// GetTransferStatus Gets how far the last transfer to this server got
+func (p *Server) GetTransferStatus() pufferpanel.ServerTransferStatus {
+	p.transferLock.Lock()
+	defer p.transferLock.Unlock()
+	return p.transferStatus
+}
+
+func (p *Server) receiveTransfer(transfer pufferpanel.ServerTransfer) error {
+	p.Log(logging.Info, "Receiving files from %s", transfer.Source)
+
+	dir, err := os.MkdirTemp("", "transfer")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, p.Id()+".tar.gz")
+	if err = p.downloadTransfer(transfer.Source+"/transfer", transfer.Token, archive); err != nil {
+		return err
+	}
+	if err = files.Extract(nil, archive, p.GetFileServer().Prefix(), "*", true, nil); err != nil {
+		return err
+	}
+
+	if len(transfer.Backups) > 0 {
+		backupDirectory := p.GetBackupDirectory()
+		if err = os.MkdirAll(backupDirectory, 0755); err != nil {
+			return err
+		}
+		for _, v := range transfer.Backups {
+			//only the name is used, so the other node can't write outside the backup folder
+			name := filepath.Base(v)
+			err = p.downloadTransfer(transfer.Source+"/backup/download?fileName="+url.QueryEscape(name), transfer.Token, filepath.Join(backupDirectory, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	p.RecalculateUsage()
+	return nil
+}
+
+// downloadTransfer downloads one file of a transfer, counting it towards the status
+func (p *Server) downloadTransfer(source, token, target string) error {
+	request, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := pufferpanel.Http().Do(request)
+	defer utils.CloseResponse(response)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response from %s: %s", source, response.Status)
+	}
+
+	if response.ContentLength > 0 {
+		p.transferLock.Lock()
+		p.transferStatus.Total += response.ContentLength
+		p.transferLock.Unlock()
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(file)
+
+	_, err = io.Copy(&transferWriter{Writer: file, server: p}, response.Body)
+	return err
+}
+
+// transferWriter counts what is written towards the transfer the server is receiving
+type transferWriter struct {
+	io.Writer
+	server *Server
+}
+
+func (t *transferWriter) Write(b []byte) (int, error) {
+	n, err := t.Writer.Write(b)
+	t.server.transferLock.Lock()
+	t.server.transferStatus.Copied += int64(n)
+	t.server.transferLock.Unlock()
+	return n, err
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix starts every personal access token, so they can be told apart from sessions
+const APITokenPrefix = "pp_"
+
+const maxAPITokenLifetime = 365 * 24 * time.Hour
+
+type APIToken struct {
+	DB *gorm.DB
+}
+
+// IsAPIToken Checks if a bearer token is a personal access token rather than a session
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// GetForUser Gets all tokens of a user, including expired ones
+func (s *APIToken) GetForUser(userId uint) ([]*models.APIToken, error) {
+	var tokens []*models.APIToken
+	err := s.DB.Where(&models.APIToken{UserId: userId}).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// Get Gets a token, only if it belongs to the user
+func (s *APIToken) Get(userId, id uint) (*models.APIToken, error) {
+	token := &models.APIToken{}
+	err := s.DB.Where(&models.APIToken{ID: id, UserId: userId}).First(token).Error
+	return token, err
+}
+
+// Create Creates a token for the user. The token itself is put in Secret, and is not kept anywhere
+func (s *APIToken) Create(userId uint, name string, granted []*scopes.Scope, servers []string, expiresAt time.Time) (*models.APIToken, error) {
+	if !expiresAt.After(time.Now()) || expiresAt.After(time.Now().Add(maxAPITokenLifetime)) {
+		return nil, pufferpanel.ErrAPITokenExpiry
+	}
+	if len(granted) == 0 {
+		return nil, pufferpanel.ErrAPITokenNoScopes
+	}
+
+	secret, err := utils.GenerateRandomString(36)
+	if err != nil {
+		return nil, err
+	}
+	secret = APITokenPrefix + secret
+
+	hashed, err := HashToken(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &models.APIToken{
+		UserId:    userId,
+		Name:      name,
+		Token:     hashed,
+		Scopes:    granted,
+		Servers:   utils.Unique(servers),
+		ExpiresAt: expiresAt,
+	}
+	err = s.DB.Omit(clause.Associations).Create(token).Error
+	if err != nil {
+		return nil, err
+	}
+	token.Secret = secret
+	return token, nil
+}
+
+// Delete Revokes a token, only if it belongs to the user
+func (s *APIToken) Delete(userId, id uint) error {
+	res := s.DB.Where(&models.APIToken{UserId: userId}).Delete(&models.APIToken{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Validate Gets the token, and the user it belongs to, if it has not expired
+func (s *APIToken) Validate(secret string) (*models.APIToken, error) {
+	hashed, err := HashToken(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &models.APIToken{}
+	err = s.DB.Preload("User").Where(&models.APIToken{Token: hashed}).Where("expires_at > ?", time.Now()).First(token).Error
+	return token, err
+}
+
+// MarkUsed Records when and where a token was last used. Like sessions, this is only saved once a minute unless
+// where it came from changed.
+func (s *APIToken) MarkUsed(token *models.APIToken, ip string) error {
+	if token.LastUsed != nil && time.Since(*token.LastUsed) < sessionTouchInterval && token.LastUsedIP == ip {
+		return nil
+	}
+
+	now := time.Now()
+	token.LastUsed = &now
+	token.LastUsedIP = ip
+	return s.DB.Model(&models.APIToken{}).Where("id = ?", token.ID).UpdateColumns(map[string]interface{}{
+		"last_used":    now,
+		"last_used_ip": ip,
+	}).Error
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var auditPruneTicker *time.Ticker
+
+type Audit struct {
+	DB *gorm.DB
+}
+
+// AuditEntry is a change to record, with the target as it was before and after. Either can be nil when the target
+// was created or deleted.
+type AuditEntry struct {
+	ActorId     *uint
+	ActorClient string
+	Action      string
+	TargetType  string
+	TargetId    string
+	Before      interface{}
+	After       interface{}
+	IP          string
+}
+
+// Record Adds an entry to the audit log. Entries are never changed once they are written.
+func (as *Audit) Record(actorId *uint, action, targetType, targetId string, details interface{}, ip string) error {
+	entry := &models.AuditLog{
+		ActorId:    actorId,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		IP:         ip,
+	}
+
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		entry.Details = string(data)
+	}
+
+	return as.DB.Create(entry).Error
+}
+
+// RecordChange Adds a change to the audit log. Only the fields which changed are kept.
+func (as *Audit) RecordChange(change *AuditEntry) error {
+	before, after, err := diffState(change.Before, change.After)
+	if err != nil {
+		return err
+	}
+
+	entry := &models.AuditLog{
+		ActorId:     change.ActorId,
+		ActorClient: change.ActorClient,
+		Action:      change.Action,
+		TargetType:  change.TargetType,
+		TargetId:    change.TargetId,
+		Before:      before,
+		After:       after,
+		IP:          change.IP,
+	}
+	return as.DB.Create(entry).Error
+}
+
+// Search Gets entries, newest first. * is a wildcard in the action.
+func (as *Audit) Search(search *models.AuditSearch) ([]*models.AuditLog, int64, error) {
+	var entries []*models.AuditLog
+
+	query := as.DB.Model(&models.AuditLog{})
+	if search.ActorId != 0 {
+		query = query.Where("actor_id = ?", search.ActorId)
+	}
+	if action := strings.Replace(search.Action, "*", "%", -1); action != "" && action != "%" {
+		query = query.Where("action LIKE ?", action)
+	}
+	if search.TargetType != "" {
+		query = query.Where("target_type = ?", search.TargetType)
+	}
+	if search.TargetId != "" {
+		query = query.Where("target_id = ?", search.TargetId)
+	}
+	if !search.From.IsZero() {
+		query = query.Where("created_at >= ?", search.From)
+	}
+	if !search.To.IsZero() {
+		query = query.Where("created_at <= ?", search.To)
+	}
+
+	var count int64
+	err := query.Count(&count).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = query.Preload("Actor").Order("id DESC").Offset(int((search.Page - 1) * search.PageLimit)).Limit(int(search.PageLimit)).Find(&entries).Error
+	for _, v := range entries {
+		if v.Actor != nil {
+			v.ActorName = v.Actor.Username
+		}
+	}
+	return entries, count, err
+}
+
+// Prune Removes entries older than the given time
+func (as *Audit) Prune(olderThan time.Time) (int64, error) {
+	res := as.DB.Where("created_at < ?", olderThan).Delete(&models.AuditLog{})
+	return res.RowsAffected, res.Error
+}
+
+// StartAuditPruning removes entries older than the retention period once a day
+func StartAuditPruning(db *gorm.DB) {
+	days := config.AuditRetention.Value()
+	if days <= 0 {
+		return
+	}
+
+	prune := func() {
+		as := &Audit{DB: db}
+		count, err := as.Prune(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			logging.Error.Printf("Error pruning audit log: %s", err.Error())
+		} else if count > 0 {
+			logging.Info.Printf("Pruned %d audit log entries", count)
+		}
+	}
+
+	auditPruneTicker = time.NewTicker(24 * time.Hour)
+	go func(ticker *time.Ticker) {
+		prune()
+		for range ticker.C {
+			prune()
+		}
+	}(auditPruneTicker)
+}
+
+func StopAuditPruning() {
+	if auditPruneTicker != nil {
+		auditPruneTicker.Stop()
+	}
+}
+
+// diffState turns the target as it was before and after into JSON, leaving out fields which are the same in both
+func diffState(before, after interface{}) (string, string, error) {
+	beforeMap, beforeOk, err := toStateMap(before)
+	if err != nil {
+		return "", "", err
+	}
+	afterMap, afterOk, err := toStateMap(after)
+	if err != nil {
+		return "", "", err
+	}
+
+	if beforeOk && afterOk {
+		for k, v := range beforeMap {
+			if other, exists := afterMap[k]; exists && reflect.DeepEqual(v, other) {
+				delete(beforeMap, k)
+				delete(afterMap, k)
+			}
+		}
+		before = beforeMap
+		after = afterMap
+	}
+
+	beforeJson, err := marshalState(before)
+	if err != nil {
+		return "", "", err
+	}
+	afterJson, err := marshalState(after)
+	return beforeJson, afterJson, err
+}
+
+// toStateMap gets the fields of a target, if it is something with fields
+func toStateMap(state interface{}) (map[string]interface{}, bool, error) {
+	if state == nil || (reflect.ValueOf(state).Kind() == reflect.Ptr && reflect.ValueOf(state).IsNil()) {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, false, err
+	}
+	result := make(map[string]interface{})
+	if json.Unmarshal(data, &result) != nil {
+		return nil, false, nil
+	}
+	return result, true, nil
+}
+
+func marshalState(state interface{}) (string, error) {
+	if state == nil || (reflect.ValueOf(state).Kind() == reflect.Ptr && reflect.ValueOf(state).IsNil()) {
+		return "", nil
+	}
+	data, err := json.Marshal(state)
+	return string(data), err
+}
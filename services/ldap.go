@@ -0,0 +1,452 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"gorm.io/gorm"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ldapTimeout = 10 * time.Second
+
+type LDAP struct {
+	DB *gorm.DB
+}
+
+var ldapSyncTicker *time.Ticker
+
+// LDAPEnabled checks if users should be authenticated against LDAP
+func LDAPEnabled() bool {
+	return config.LdapEnabled.Value() && config.LdapUrl.Value() != ""
+}
+
+// IsLDAPUser checks if the user's password and scopes are managed by LDAP
+func IsLDAPUser(db *gorm.DB, userId uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.LDAPIdentity{}).Where("user_id = ?", userId).Count(&count).Error
+	return count > 0, err
+}
+
+// Authenticate Checks the email and password against LDAP, either by binding as the user directly when a user DN
+// is configured, or by finding the user with the bind account first. The user is created or updated to match
+// their LDAP entry.
+func (l *LDAP) Authenticate(email, password string) (*models.User, error) {
+	if email == "" || password == "" {
+		return nil, pufferpanel.ErrInvalidCredentials
+	}
+
+	conn, err := dialLDAP()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var entry *ldap.Entry
+	if template := config.LdapUserDn.Value(); template != "" {
+		dn := replaceLDAPTokens(template, email, ldap.EscapeDN)
+		if err = conn.Bind(dn, password); err != nil {
+			return nil, ldapBindError(err)
+		}
+		entry, err = getLDAPEntry(conn, dn)
+	} else {
+		if err = bindLDAPService(conn); err != nil {
+			return nil, err
+		}
+		entry, err = findLDAPUser(conn, email)
+		if err == nil {
+			if err = conn.Bind(entry.DN, password); err != nil {
+				return nil, ldapBindError(err)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	disabled, err := isLDAPEntryDisabled(conn, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		return nil, pufferpanel.ErrInvalidCredentials
+	}
+
+	return l.provision(entry, email)
+}
+
+// Sync Checks every user from LDAP is still there and enabled, and updates their scopes from their groups.
+// Users who are gone or disabled lose all of their scopes and are logged out.
+func (l *LDAP) Sync() error {
+	conn, err := dialLDAP()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = bindLDAPService(conn); err != nil {
+		return err
+	}
+
+	var identities []*models.LDAPIdentity
+	if err = l.DB.Preload("User").Find(&identities).Error; err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		if identity.User == nil {
+			continue
+		}
+
+		entry, err := getLDAPEntry(conn, identity.DN)
+		disabled := false
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || errors.Is(err, pufferpanel.ErrUserNotFound) {
+			disabled = true
+		} else if err != nil {
+			logging.Error.Printf("Error syncing LDAP user %s: %s", identity.DN, err.Error())
+			continue
+		} else if disabled, err = isLDAPEntryDisabled(conn, identity.DN); err != nil {
+			logging.Error.Printf("Error syncing LDAP user %s: %s", identity.DN, err.Error())
+			continue
+		}
+
+		if disabled {
+			if !identity.Disabled {
+				logging.Info.Printf("Deprovisioning LDAP user %s", identity.DN)
+			}
+			err = l.deprovision(identity)
+		} else {
+			err = l.DB.Transaction(func(tx *gorm.DB) error {
+				return l.update(tx, identity, entry)
+			})
+		}
+		if err != nil {
+			logging.Error.Printf("Error syncing LDAP user %s: %s", identity.DN, err.Error())
+		}
+	}
+	return nil
+}
+
+// StartLDAPSync syncs the users from LDAP on the configured interval
+func StartLDAPSync(db *gorm.DB) {
+	interval := config.LdapSyncInterval.Value()
+	if !LDAPEnabled() || interval <= 0 {
+		return
+	}
+
+	ldapSyncTicker = time.NewTicker(time.Duration(interval) * time.Minute)
+	go func(ticker *time.Ticker) {
+		for range ticker.C {
+			l := &LDAP{DB: db}
+			if err := l.Sync(); err != nil {
+				logging.Error.Printf("Error syncing LDAP users: %s", err.Error())
+			}
+		}
+	}(ldapSyncTicker)
+}
+
+func StopLDAPSync() {
+	if ldapSyncTicker != nil {
+		ldapSyncTicker.Stop()
+	}
+}
+
+// provision creates the user for an LDAP entry the first time they log in, and updates them every time after
+func (l *LDAP) provision(entry *ldap.Entry, email string) (*models.User, error) {
+	if mail := entry.GetAttributeValue(config.LdapEmailAttribute.Value()); mail != "" {
+		email = mail
+	}
+
+	var user *models.User
+	err := l.DB.Transaction(func(tx *gorm.DB) error {
+		identity := &models.LDAPIdentity{}
+		err := tx.Preload("User").Where("dn = ?", entry.DN).First(identity).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if identity.ID == 0 || identity.User == nil {
+			us := &User{DB: tx}
+			existing, err := us.GetByEmail(email)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				existing, err = createLDAPUser(tx, entry, email)
+			} else if err == nil {
+				//an account made in the panel is only taken over when the panel hasn't given it anything, as its groups
+				//would replace what it has, which could take admin from a local account or give LDAP control of it
+				var managed int64
+				err = tx.Model(&models.Permissions{}).Where("user_id = ?", existing.ID).Count(&managed).Error
+				if err == nil && managed > 0 {
+					err = pufferpanel.ErrLDAPAccountExists
+				}
+			}
+			if err != nil {
+				return err
+			}
+			identity = &models.LDAPIdentity{UserId: existing.ID, User: existing, DN: entry.DN}
+		}
+
+		if identity.User.Email != email {
+			identity.User.Email = email
+			if err = tx.Save(identity.User).Error; err != nil {
+				return err
+			}
+		}
+
+		user = identity.User
+		return l.update(tx, identity, entry)
+	})
+	return user, err
+}
+
+// update marks the user as enabled, and gives them the scopes of their groups
+func (l *LDAP) update(tx *gorm.DB, identity *models.LDAPIdentity, entry *ldap.Entry) error {
+	now := time.Now()
+	identity.Disabled = false
+	identity.LastSync = &now
+	if err := tx.Omit("User").Save(identity).Error; err != nil {
+		return err
+	}
+
+	groups := config.LdapGroups.Value()
+	ps := &Permission{DB: tx}
+
+	//without any groups mapped, scopes are managed in the panel
+	if len(groups) == 0 {
+		perms, err := ps.GetForUserAndServer(identity.UserId, "")
+		if err != nil {
+			return err
+		}
+		if perms.ID == 0 {
+			perms.Scopes = []*scopes.Scope{scopes.ScopeLogin}
+			return ps.UpdatePermissions(perms)
+		}
+		return nil
+	}
+
+	memberOf := entry.GetAttributeValues(config.LdapGroupAttribute.Value())
+	global := make([]*scopes.Scope, 0)
+	servers := make(map[string][]*scopes.Scope)
+	for _, group := range groups {
+		isMember := ldapGroupsContain(memberOf, group.Group)
+		for serverId, serverScopes := range group.Servers {
+			if _, exists := servers[serverId]; !exists {
+				servers[serverId] = make([]*scopes.Scope, 0)
+			}
+			if isMember {
+				for _, v := range serverScopes {
+					servers[serverId] = scopes.AddScope(servers[serverId], scopes.GetScope(v))
+				}
+			}
+		}
+		if isMember {
+			for _, v := range group.Scopes {
+				global = scopes.AddScope(global, scopes.GetScope(v))
+			}
+		}
+	}
+
+	//only the servers named in a mapping are managed, so users can still be added to other servers by hand
+	if err := setLDAPScopes(ps, identity.UserId, "", global); err != nil {
+		return err
+	}
+	for serverId, serverScopes := range servers {
+		var server models.Server
+		if err := tx.Where("identifier = ?", serverId).First(&server).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := setLDAPScopes(ps, identity.UserId, serverId, serverScopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deprovision takes every scope from a user who has been removed or disabled in LDAP, and logs them out
+func (l *LDAP) deprovision(identity *models.LDAPIdentity) error {
+	return l.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		identity.Disabled = true
+		identity.LastSync = &now
+		if err := tx.Omit("User").Save(identity).Error; err != nil {
+			return err
+		}
+
+		if err := deletePermissionRoles(tx, "user_id = ?", identity.UserId); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", identity.UserId).Delete(&models.Permissions{}).Error; err != nil {
+			return err
+		}
+		ss := &Session{DB: tx}
+		return ss.ExpireForUser(identity.UserId)
+	})
+}
+
+func setLDAPScopes(ps *Permission, userId uint, serverId string, desired []*scopes.Scope) error {
+	perms, err := ps.GetForUserAndServer(userId, serverId)
+	if err != nil {
+		return err
+	}
+
+	if len(desired) == 0 {
+		if perms.ID != 0 {
+			return ps.Remove(perms)
+		}
+		return nil
+	}
+
+	perms.Scopes = desired
+	return ps.UpdatePermissions(perms)
+}
+
+func createLDAPUser(tx *gorm.DB, entry *ldap.Entry, email string) (*models.User, error) {
+	username := entry.GetAttributeValue(config.LdapUsernameAttribute.Value())
+	if username == "" {
+		username = strings.SplitN(email, "@", 2)[0]
+	}
+	username = oidcUsername(username)
+
+	var count int64
+	if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		suffix, err := randomOIDCValue()
+		if err != nil {
+			return nil, err
+		}
+		username = username + "-" + suffix[:6]
+	}
+
+	//the password is never used, LDAP is always asked instead
+	password, err := randomOIDCValue()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{Username: username, Email: email}
+	if err = user.SetPassword(password); err != nil {
+		return nil, err
+	}
+	if err = tx.Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func dialLDAP() (*ldap.Conn, error) {
+	address := config.LdapUrl.Value()
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         parsed.Hostname(),
+		InsecureSkipVerify: config.LdapInsecureSkipVerify.Value(),
+	}
+
+	conn, err := ldap.DialURL(address, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if config.LdapStartTls.Value() && parsed.Scheme != "ldaps" {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func bindLDAPService(conn *ldap.Conn) error {
+	if config.LdapBindDn.Value() == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(config.LdapBindDn.Value(), config.LdapBindPassword.Value())
+}
+
+func findLDAPUser(conn *ldap.Conn, email string) (*ldap.Entry, error) {
+	filter := replaceLDAPTokens(config.LdapUserFilter.Value(), email, ldap.EscapeFilter)
+	result, err := conn.Search(ldap.NewSearchRequest(config.LdapBaseDn.Value(), ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout/time.Second), false, filter, ldapAttributes(), nil))
+	if err != nil {
+		return nil, err
+	}
+	//more than one match means the filter is not specific enough to know who this is
+	if len(result.Entries) != 1 {
+		return nil, pufferpanel.ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+func getLDAPEntry(conn *ldap.Conn, dn string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, int(ldapTimeout/time.Second), false, "(objectClass=*)", ldapAttributes(), nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) == 0 {
+		return nil, pufferpanel.ErrUserNotFound
+	}
+	return result.Entries[0], nil
+}
+
+func isLDAPEntryDisabled(conn *ldap.Conn, dn string) (bool, error) {
+	filter := config.LdapDisabledFilter.Value()
+	if filter == "" {
+		return false, nil
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, int(ldapTimeout/time.Second), false, filter, []string{"dn"}, nil))
+	if err != nil {
+		return false, err
+	}
+	return len(result.Entries) > 0, nil
+}
+
+func ldapAttributes() []string {
+	return []string{config.LdapEmailAttribute.Value(), config.LdapUsernameAttribute.Value(), config.LdapGroupAttribute.Value()}
+}
+
+// ldapBindError turns a failed bind for a user into invalid credentials, anything else is a problem with LDAP
+func ldapBindError(err error) error {
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return pufferpanel.ErrInvalidCredentials
+	}
+	return fmt.Errorf("ldap bind failed: %w", err)
+}
+
+// replaceLDAPTokens fills in {email} and {username}, where username is the part of the email before the @
+func replaceLDAPTokens(template, email string, escape func(string) string) string {
+	username := strings.SplitN(email, "@", 2)[0]
+	return strings.NewReplacer("{email}", escape(email), "{username}", escape(username)).Replace(template)
+}
+
+func ldapGroupsContain(memberOf []string, group string) bool {
+	wanted, err := ldap.ParseDN(group)
+	for _, v := range memberOf {
+		if err == nil {
+			if dn, err := ldap.ParseDN(v); err == nil && dn.EqualFold(wanted) {
+				return true
+			}
+		}
+		if strings.EqualFold(v, group) {
+			return true
+		}
+	}
+	return false
+}
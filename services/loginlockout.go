@@ -0,0 +1,188 @@
+package services
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	LockoutKindIP      = "ip"
+	LockoutKindAccount = "account"
+)
+
+// LoginLimiter counts failed logins from each IP and against each account, and locks them out once there are too
+// many. Each lockout in a row lasts twice as long as the one before, up to the configured maximum.
+type LoginLimiter struct {
+	DB *gorm.DB
+}
+
+// Check Gets when the IP or account may next try to log in, which is zero if they can now
+func (ls *LoginLimiter) Check(ip, account string) (time.Time, error) {
+	var until time.Time
+
+	records, err := ls.get(ip, account)
+	if err != nil {
+		return until, err
+	}
+
+	for _, v := range records {
+		if v.IsLocked() && v.LockedUntil.After(until) {
+			until = *v.LockedUntil
+		}
+	}
+	return until, nil
+}
+
+// Fail Records a failed login, returning when the IP or account may next try if this locked them out
+func (ls *LoginLimiter) Fail(ip, account string) (time.Time, error) {
+	var until time.Time
+	now := time.Now()
+	window := time.Duration(config.LoginAttemptWindow.Value()) * time.Minute
+	maxLockout := time.Duration(config.LoginLockoutMaxTime.Value()) * time.Minute
+
+	err := ls.DB.Transaction(func(tx *gorm.DB) error {
+		//nothing is kept about anyone who stopped trying long enough ago
+		expired := now.Add(-window)
+		if maxLockout > window {
+			expired = now.Add(-maxLockout)
+		}
+		err := tx.Where("last_failure < ? AND (locked_until IS NULL OR locked_until < ?)", expired, now).Delete(&models.LoginLockout{}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, key := range lockoutKeys(ip, account) {
+			record := &models.LoginLockout{}
+			err = tx.Where(key).Attrs(&models.LoginLockout{LastFailure: now}).FirstOrCreate(record).Error
+			if err != nil {
+				return err
+			}
+
+			if now.Sub(record.LastFailure) > window {
+				record.Failures = 0
+			}
+			if now.Sub(record.LastFailure) > maxLockout {
+				record.Lockouts = 0
+			}
+
+			record.Failures++
+			record.LastFailure = now
+
+			limit := config.LoginAttemptLimit.Value()
+			if key.Kind == LockoutKindIP {
+				limit = config.LoginAttemptIpLimit.Value()
+			}
+			if limit > 0 && record.Failures >= limit {
+				locked := now.Add(lockoutDuration(record.Lockouts))
+				record.LockedUntil = &locked
+				record.Lockouts++
+				record.Failures = 0
+				if locked.After(until) {
+					until = locked
+				}
+			}
+
+			if err = tx.Save(record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return until, err
+}
+
+// Succeed Records a successful login to the account, returning the failed logins there were since the last one, if
+// there were any. Failures from the IP are kept, so logging into an account of your own doesn't reset them.
+func (ls *LoginLimiter) Succeed(account string) (*models.LoginLockout, error) {
+	account = normalizeLockoutAccount(account)
+	if account == "" {
+		return nil, nil
+	}
+
+	var records []*models.LoginLockout
+	key := &models.LoginLockout{Kind: LockoutKindAccount, Value: account}
+	err := ls.DB.Where(key).Find(&records).Error
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+
+	err = ls.DB.Where(key).Delete(&models.LoginLockout{}).Error
+	return records[0], err
+}
+
+// GetActive Gets everything which is locked out or has failed logins which still count
+func (ls *LoginLimiter) GetActive() ([]*models.LoginLockout, error) {
+	var records []*models.LoginLockout
+	window := time.Duration(config.LoginAttemptWindow.Value()) * time.Minute
+	now := time.Now()
+	err := ls.DB.Where("locked_until > ? OR (failures > 0 AND last_failure > ?)", now, now.Add(-window)).Order("last_failure DESC").Find(&records).Error
+	return records, err
+}
+
+// Get Gets a single record by id
+func (ls *LoginLimiter) Get(id uint) (*models.LoginLockout, error) {
+	record := &models.LoginLockout{}
+	err := ls.DB.Where(&models.LoginLockout{ID: id}).First(record).Error
+	return record, err
+}
+
+// Clear Removes the lockout and failures of an IP or account
+func (ls *LoginLimiter) Clear(id uint) error {
+	res := ls.DB.Delete(&models.LoginLockout{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (ls *LoginLimiter) get(ip, account string) ([]*models.LoginLockout, error) {
+	keys := lockoutKeys(ip, account)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	query := ls.DB.Where(keys[0])
+	for _, v := range keys[1:] {
+		query = query.Or(v)
+	}
+
+	var records []*models.LoginLockout
+	err := query.Find(&records).Error
+	return records, err
+}
+
+// lockoutKeys gets what is counted for a login, leaving out what isn't known, such as the IP of a request made
+// without one
+func lockoutKeys(ip, account string) []*models.LoginLockout {
+	keys := make([]*models.LoginLockout, 0, 2)
+	if ip = strings.TrimSpace(ip); ip != "" {
+		keys = append(keys, &models.LoginLockout{Kind: LockoutKindIP, Value: ip})
+	}
+	if account = normalizeLockoutAccount(account); account != "" {
+		keys = append(keys, &models.LoginLockout{Kind: LockoutKindAccount, Value: account})
+	}
+	return keys
+}
+
+func normalizeLockoutAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+// lockoutDuration works out how long to lock out for, doubling for each lockout before this
+func lockoutDuration(previous int) time.Duration {
+	duration := time.Duration(config.LoginLockoutTime.Value()) * time.Minute
+	maxDuration := time.Duration(config.LoginLockoutMaxTime.Value()) * time.Minute
+	for i := 0; i < previous && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+	return duration
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Migration moves servers between nodes. The server is stopped, made on the new node, and the new node downloads its
+// files from the old one with a token from the panel. The server only points to the new node once everything has been
+// copied, so when a step fails it is put back on the old node as it was.
+type Migration struct {
+	DB *gorm.DB
+}
+
+// migrating holds the servers being moved right now
+var migrating sync.Map
+
+const transferPollInterval = time.Second
+
+// transferTokenExpiry is how long the new node has to start each download, which has to cover the server's files being
+// copied before the backups are started
+const transferTokenExpiry = 6 * time.Hour
+
+// transferRoutes are the only requests the new node can make to the old one
+var transferRoutes = []string{"GET /daemon/server/:serverId/transfer", "GET /daemon/server/:serverId/backup/download"}
+
+// transferProgressEnd is the progress of a migration once the files have been copied, before they are unpacked
+const transferProgressEnd = 75
+
+// IsMigrating Gets if the server is being moved to another node
+func IsMigrating(serverId string) bool {
+	_, exists := migrating.Load(serverId)
+	return exists
+}
+
+// Get Gets the last migration of the server. One still marked as running which isn't was cut off by the panel
+// stopping, and is shown as failed.
+func (ms *Migration) Get(serverId string) (*models.ServerMigration, error) {
+	migration := &models.ServerMigration{}
+	err := ms.DB.Where("server_identifier = ?", serverId).Order("id DESC").First(migration).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if migration.Status == models.MigrationRunning && !IsMigrating(serverId) {
+		migration.Status = models.MigrationFailed
+		migration.Error = "the migration was interrupted"
+	}
+	return migration, nil
+}
+
+// Start Makes sure the server can be moved to the node, then moves it in the background
+func (ms *Migration) Start(server *models.Server, target *models.Node, backups bool) (*models.ServerMigration, error) {
+	source := server.Node
+	if source.ID == target.ID {
+		return nil, pufferpanel.ErrMigrationSameNode
+	}
+	//the new node downloads the files straight from the old one, which can't be done when only the panel reaches it
+	if source.Tunnel {
+		return nil, pufferpanel.ErrMigrationFromTunnel
+	}
+
+	if _, loaded := migrating.LoadOrStore(server.Identifier, true); loaded {
+		return nil, pufferpanel.ErrServerMigrating
+	}
+	started := false
+	defer func() {
+		if !started {
+			migrating.Delete(server.Identifier)
+		}
+	}()
+
+	status, err := (&NodeStatus{DB: ms.DB}).Get(target)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Online {
+		return nil, pufferpanel.ErrNodeOffline
+	}
+
+	definition := &pufferpanel.Server{}
+	err = ms.call(&source, http.MethodGet, "/daemon/server/"+server.Identifier+"/definition", nil, http.StatusOK, definition)
+	if err != nil {
+		return nil, err
+	}
+	if err = definition.Requirements.TestNode(*definition, status.OS, status.Arch, status.Features); err != nil {
+		return nil, err
+	}
+
+	moved := *server
+	if err = (&Placement{DB: ms.DB}).Check(target, &moved); err != nil {
+		return nil, err
+	}
+	if err = ms.clearPorts(target, &moved, definition); err != nil {
+		return nil, err
+	}
+	if err = (&PortPool{DB: ms.DB}).Check(target, &moved, *definition); err != nil {
+		return nil, err
+	}
+
+	migration := &models.ServerMigration{
+		ServerIdentifier: server.Identifier,
+		SourceNodeId:     source.ID,
+		TargetNodeId:     target.ID,
+		Backups:          backups,
+		Status:           models.MigrationRunning,
+		Step:             models.MigrationStepStopping,
+	}
+	if err = ms.DB.Create(migration).Error; err != nil {
+		return nil, err
+	}
+
+	started = true
+	go ms.run(migration, &moved, &source, target, definition)
+	return migration, nil
+}
+
+func (ms *Migration) run(migration *models.ServerMigration, server *models.Server, source, target *models.Node, definition *pufferpanel.Server) {
+	defer migrating.Delete(server.Identifier)
+
+	running, err := ms.move(migration, server, source, target, definition)
+	if err != nil {
+		ms.finish(migration, err)
+		return
+	}
+
+	//the server is on the new node now, so what fails from here is only noted
+	ms.step(migration, models.MigrationStepCleaning, 90)
+	path := "/daemon/server/" + server.Identifier
+	notes := make([]string, 0)
+	if err = ms.call(source, http.MethodDelete, path, nil, http.StatusNoContent, nil); err != nil {
+		logging.Error.Printf("Error removing server %s from node %s: %s", server.Identifier, source.Name, err.Error())
+		notes = append(notes, "the server could not be removed from the old node: "+err.Error())
+	}
+	if running {
+		if err = ms.call(target, http.MethodPost, path+"/start", nil, http.StatusAccepted, nil); err != nil {
+			logging.Error.Printf("Error starting server %s on node %s: %s", server.Identifier, target.Name, err.Error())
+			notes = append(notes, "the server could not be started on the new node: "+err.Error())
+		}
+	}
+	migration.Error = strings.Join(notes, ", ")
+	ms.finish(migration, nil)
+}
+
+// move stops the server, copies it to the new node and points it there. When any of that fails, it is undone.
+func (ms *Migration) move(migration *models.ServerMigration, server *models.Server, source, target *models.Node, definition *pufferpanel.Server) (running bool, err error) {
+	path := "/daemon/server/" + server.Identifier
+
+	ms.step(migration, models.MigrationStepStopping, 5)
+	status := &pufferpanel.ServerRunning{}
+	if err = ms.call(source, http.MethodGet, path+"/status", nil, http.StatusOK, status); err != nil {
+		return
+	}
+	running = status.Running
+	if running {
+		if err = ms.call(source, http.MethodPost, path+"/stop?wait", nil, http.StatusNoContent, nil); err != nil {
+			return
+		}
+	}
+
+	var allocations []*models.PortAllocation
+	if err = ms.DB.Where("server_identifier = ?", server.Identifier).Find(&allocations).Error; err != nil {
+		return
+	}
+
+	created := false
+	defer func() {
+		if err != nil {
+			migration.RolledBack = ms.rollback(server, source, target, allocations, created, running)
+		}
+	}()
+
+	//the old node keeps the server from being started or changed, as that would be lost once it has been copied
+	if err = ms.call(source, http.MethodPut, path+"/migrating", nil, http.StatusNoContent, nil); err != nil {
+		return
+	}
+
+	ms.step(migration, models.MigrationStepCreating, 15)
+	if err = (&PortPool{DB: ms.DB}).Assign(target, server, definition); err != nil {
+		return
+	}
+	if err = ms.call(target, http.MethodPut, path, definition, http.StatusOK, nil); err != nil {
+		return
+	}
+	created = true
+
+	ms.step(migration, models.MigrationStepTransferring, 25)
+	transfer, err := ms.transfer(source, server.Identifier, migration.Backups)
+	if err != nil {
+		return
+	}
+	if err = ms.call(target, http.MethodPost, path+"/transfer", transfer, http.StatusAccepted, nil); err != nil {
+		return
+	}
+	if err = ms.waitForTransfer(migration, target, path); err != nil {
+		return
+	}
+
+	ms.step(migration, models.MigrationStepSwitching, 80)
+	var nodeId *uint
+	if !target.IsLocal() {
+		nodeId = &target.ID
+	}
+	//the node of a server can only be set when it's made, so the table is changed directly
+	err = ms.DB.Table("servers").Where("identifier = ?", server.Identifier).UpdateColumns(map[string]interface{}{
+		"node_id": nodeId,
+		"ip":      server.IP,
+		"port":    server.Port,
+	}).Error
+	return
+}
+
+// rollback puts the server back on its old node how it was, returning if all of that worked
+func (ms *Migration) rollback(server *models.Server, source, target *models.Node, allocations []*models.PortAllocation, created, running bool) bool {
+	path := "/daemon/server/" + server.Identifier
+	ok := true
+
+	if created {
+		if err := ms.call(target, http.MethodDelete, path, nil, http.StatusNoContent, nil); err != nil {
+			logging.Error.Printf("Error removing server %s from node %s: %s", server.Identifier, target.Name, err.Error())
+			ok = false
+		}
+	}
+
+	err := (&PortPool{DB: ms.DB}).Release(server.Identifier)
+	if err == nil && len(allocations) > 0 {
+		for _, v := range allocations {
+			v.ID = 0
+		}
+		err = ms.DB.Create(&allocations).Error
+	}
+	if err != nil {
+		logging.Error.Printf("Error giving server %s its ports back: %s", server.Identifier, err.Error())
+		ok = false
+	}
+
+	if err = ms.call(source, http.MethodDelete, path+"/migrating", nil, http.StatusNoContent, nil); err != nil {
+		logging.Error.Printf("Error giving server %s back on node %s: %s", server.Identifier, source.Name, err.Error())
+		ok = false
+	}
+
+	if running {
+		if err = ms.call(source, http.MethodPost, path+"/start", nil, http.StatusAccepted, nil); err != nil {
+			logging.Error.Printf("Error starting server %s on node %s: %s", server.Identifier, source.Name, err.Error())
+			ok = false
+		}
+	}
+	return ok
+}
+
+// waitForTransfer follows the new node downloading the files, moving the progress along as it goes
+func (ms *Migration) waitForTransfer(migration *models.ServerMigration, target *models.Node, path string) error {
+	start := migration.Progress
+	for {
+		time.Sleep(transferPollInterval)
+
+		status := &pufferpanel.ServerTransferStatus{}
+		if err := ms.call(target, http.MethodGet, path+"/transfer/status", nil, http.StatusOK, status); err != nil {
+			return err
+		}
+		if status.Error != "" {
+			return fmt.Errorf("node %s could not get the files: %s", target.Name, status.Error)
+		}
+		if status.Done {
+			return nil
+		}
+		//the node lost track of it, which happens when the daemon is restarted
+		if !status.Running {
+			return fmt.Errorf("node %s stopped getting the files", target.Name)
+		}
+
+		//the total grows as each download starts, so the progress is never moved back
+		if status.Total > 0 {
+			progress := start + int(min(status.Copied, status.Total)*int64(transferProgressEnd-start)/status.Total)
+			if progress > migration.Progress {
+				migration.Progress = progress
+				ms.save(migration)
+			}
+		}
+	}
+}
+
+// transfer gets what the new node needs to download the server from the old one
+func (ms *Migration) transfer(source *models.Node, serverId string, backups bool) (*pufferpanel.ServerTransfer, error) {
+	ts, err := NewTokenService()
+	if err != nil {
+		return nil, err
+	}
+	token, err := ts.GenerateServerRequest(serverId, transferRoutes, transferTokenExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := &pufferpanel.ServerTransfer{Token: token}
+
+	//the panel's own node is reached through the panel, other nodes through the address users reach them on
+	path := "/daemon/server/" + serverId
+	if source.IsLocal() {
+		transfer.Source = strings.TrimSuffix(config.MasterUrl.Value(), "/") + path
+	} else {
+		ssl, err := doesDaemonUseSSL(source)
+		if err != nil {
+			return nil, err
+		}
+		protocol := "http"
+		if ssl {
+			protocol = "https"
+		}
+		transfer.Source = fmt.Sprintf("%s://%s:%d%s", protocol, source.PublicHost, source.PublicPort, path)
+	}
+
+	if backups {
+		records, err := (&Backup{DB: ms.DB}).GetAllForServer(serverId)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range records {
+			transfer.Backups = append(transfer.Backups, v.FileName)
+		}
+	}
+	return transfer, nil
+}
+
+// clearPorts takes the ports off the server when the new node has a pool, so it is given new ones out of it
+func (ms *Migration) clearPorts(target *models.Node, server *models.Server, definition *pufferpanel.Server) error {
+	ports, err := (&PortPool{DB: ms.DB}).Get(target.ID)
+	if err != nil {
+		return err
+	}
+	if len(ports.Ranges) == 0 {
+		return nil
+	}
+
+	server.IP = models.AnyIP
+	for k, v := range definition.Variables {
+		if k == "port" || v.Type.Type == "port" {
+			v.Value = 0
+		} else if k == "ip" {
+			v.Value = models.AnyIP
+		} else {
+			continue
+		}
+		definition.Variables[k] = v
+	}
+	return nil
+}
+
+// call sends the request to the node, failing when the daemon doesn't respond with the status expected
+func (ms *Migration) call(node *models.Node, method, path string, body interface{}, expected int, result interface{}) error {
+	var reader io.ReadCloser
+	headers := http.Header{}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = io.NopCloser(bytes.NewReader(data))
+		headers.Set("Content-Type", "application/json")
+		headers.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+
+	response, err := (&Node{DB: ms.DB}).CallNode(node, method, path, reader, headers)
+	defer utils.CloseResponse(response)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != expected {
+		msg, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("unexpected response from node %s: %s %s", node.Name, response.Status, strings.TrimSpace(string(msg)))
+	}
+	if result != nil {
+		return json.NewDecoder(response.Body).Decode(result)
+	}
+	return nil
+}
+
+func (ms *Migration) step(migration *models.ServerMigration, step string, progress int) {
+	migration.Step = step
+	migration.Progress = progress
+	ms.save(migration)
+}
+
+func (ms *Migration) finish(migration *models.ServerMigration, err error) {
+	now := time.Now()
+	migration.FinishedAt = &now
+
+	action := "server.migrated"
+	if err != nil {
+		action = "server.migrate.failed"
+		migration.Status = models.MigrationFailed
+		migration.Error = err.Error()
+		logging.Error.Printf("Error moving server %s to node %d: %s", migration.ServerIdentifier, migration.TargetNodeId, migration.Error)
+	} else {
+		migration.Status = models.MigrationCompleted
+		migration.Step = models.MigrationStepDone
+		migration.Progress = 100
+		logging.Info.Printf("Moved server %s to node %d", migration.ServerIdentifier, migration.TargetNodeId)
+	}
+	if len(migration.Error) > 1000 {
+		migration.Error = migration.Error[:1000]
+	}
+	ms.save(migration)
+
+	as := &Audit{DB: ms.DB}
+	if err = as.Record(nil, action, "server", migration.ServerIdentifier, migration, ""); err != nil {
+		logging.Error.Printf("Error writing audit log: %s", err.Error())
+	}
+}
+
+func (ms *Migration) save(migration *models.ServerMigration) {
+	if err := ms.DB.Save(migration).Error; err != nil {
+		logging.Error.Printf("Error saving migration of server %s: %s", migration.ServerIdentifier, err.Error())
+	}
+}
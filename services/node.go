@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
@@ -11,6 +12,7 @@ import (
 	"github.com/pufferpanel/pufferpanel/v3/models"
 	"gorm.io/gorm"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -101,7 +103,15 @@ func (ns *Node) Update(model *models.Node) error {
 	}
 
 	res := ns.DB.Save(model)
-	return res.Error
+	if res.Error != nil {
+		return res.Error
+	}
+
+	//a node which no longer uses a tunnel is reached directly again
+	if !model.Tunnel {
+		CloseTunnel(model.ID)
+	}
+	return nil
 }
 
 func (ns *Node) Delete(id uint) error {
@@ -120,7 +130,17 @@ func (ns *Node) Delete(id uint) error {
 	}
 
 	res := ns.DB.Delete(model)
-	return res.Error
+	if res.Error != nil {
+		return res.Error
+	}
+	CloseTunnel(model.ID)
+
+	err := ns.DB.Where("node_id = ?", model.ID).Delete(&models.NodeStatus{}).Error
+	if err != nil {
+		return err
+	}
+
+	return (&PortPool{DB: ns.DB}).RemoveNode(model.ID)
 }
 
 func (ns *Node) Create(node *models.Node) error {
@@ -129,6 +149,11 @@ func (ns *Node) Create(node *models.Node) error {
 }
 
 func (ns *Node) CallNode(node *models.Node, method string, path string, body io.ReadCloser, headers http.Header) (*http.Response, error) {
+	return ns.callNode(context.Background(), node, method, path, body, headers)
+}
+
+// callNode is CallNode, where the request is given up on if the context ends first
+func (ns *Node) callNode(ctx context.Context, node *models.Node, method string, path string, body io.ReadCloser, headers http.Header) (*http.Response, error) {
 	var fullUrl string
 	var err error
 
@@ -146,14 +171,18 @@ func (ns *Node) CallNode(node *models.Node, method string, path string, body io.
 		return nil, err
 	}
 
-	request := &http.Request{
+	request := (&http.Request{
 		Method: method,
 		URL:    addr,
 		Header: headers,
-	}
+	}).WithContext(ctx)
 
 	if method != "GET" && body != nil {
 		request.Body = body
+		//keep the length if we know it, so large uploads are not re-chunked on the way to the node
+		if length, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64); err == nil {
+			request.ContentLength = length
+		}
 	}
 
 	ts, err := NewTokenService()
@@ -179,11 +208,24 @@ func (ns *Node) CallNode(node *models.Node, method string, path string, body io.
 		return w.Result(), err
 	}
 
+	t, err := getTunnel(node)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		return t.client.Do(request)
+	}
+
 	response, err := pufferpanel.Http().Do(request)
 	return response, err
 }
 
 func (ns *Node) OpenSocket(node *models.Node, path string, writer http.ResponseWriter, request *http.Request) error {
+	t, err := getTunnel(node)
+	if err != nil {
+		return err
+	}
+
 	ssl, err := doesDaemonUseSSL(node)
 	if err != nil {
 		return err
@@ -210,7 +252,17 @@ func (ns *Node) OpenSocket(node *models.Node, path string, writer http.ResponseW
 	header := http.Header{}
 	header.Set("Authorization", "Bearer "+token)
 
-	c, _, err := websocket.DefaultDialer.Dial(u, header)
+	dialer := websocket.DefaultDialer
+	if t != nil {
+		dialer = &websocket.Dialer{
+			NetDialContext: func(context.Context, string, string) (net.Conn, error) {
+				return t.session.Open()
+			},
+			HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		}
+	}
+
+	c, _, err := dialer.Dial(u, header)
 	if err != nil {
 		return err
 	}
@@ -240,7 +292,8 @@ func (ns *Node) OpenSocket(node *models.Node, path string, writer http.ResponseW
 }
 
 func doesDaemonUseSSL(node *models.Node) (bool, error) {
-	if node.IsLocal() {
+	//the tunnel is already secured by how the daemon connected to the panel
+	if node.IsLocal() || node.Tunnel {
 		return false, nil
 	}
 
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var nodeMonitorTicker *time.Ticker
+
+// NodeStatus checks whether nodes can be reached, and keeps what they last reported about themselves
+type NodeStatus struct {
+	DB *gorm.DB
+}
+
+// nodeFeatures is what the daemon gives back from /daemon/features
+type nodeFeatures struct {
+	Features     []string `json:"features"`
+	Environments []string `json:"environments"`
+	OS           string   `json:"os"`
+	Arch         string   `json:"arch"`
+	Version      string   `json:"version"`
+}
+
+// Check Polls the node and saves what it reported. A node is only marked offline once enough checks in a row have
+// failed, and admins are told when it goes offline or comes back.
+func (ns *NodeStatus) Check(node *models.Node) (*models.NodeStatus, error) {
+	status := &models.NodeStatus{}
+	err := ns.DB.Where("node_id = ?", node.ID).First(status).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	offlineAfter := config.NodeOfflineAfter.Value()
+	if offlineAfter < 1 {
+		offlineAfter = 1
+	}
+
+	now := time.Now()
+	status.NodeId = node.ID
+	status.Name = node.Name
+	status.LastChecked = now
+
+	var changed bool
+	if err = ns.probe(node, status); err != nil {
+		status.Failures++
+		status.Error = err.Error()
+		if status.Failures >= offlineAfter {
+			status.Online = false
+		}
+		changed = status.Failures == offlineAfter
+	} else {
+		changed = status.Failures >= offlineAfter
+		status.Failures = 0
+		status.Error = ""
+		status.Online = true
+		status.LastOnline = &now
+	}
+	status.VersionSkew = status.Version != "" && status.Version != pufferpanel.Version
+
+	if err = ns.DB.Save(status).Error; err != nil {
+		return nil, err
+	}
+
+	if changed {
+		ns.notify(node, status)
+	}
+	return status, nil
+}
+
+// CheckAll Checks every node, one after the other
+func (ns *NodeStatus) CheckAll() error {
+	nodes, err := (&Node{DB: ns.DB}).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range nodes {
+		if _, err = ns.Check(v); err != nil {
+			logging.Error.Printf("Error checking node %s: %s", v.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Get Gets the last status of the node, checking it now if it hasn't been yet
+func (ns *NodeStatus) Get(node *models.Node) (*models.NodeStatus, error) {
+	status := &models.NodeStatus{}
+	err := ns.DB.Where("node_id = ?", node.ID).First(status).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ns.Check(node)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status.Name = node.Name
+	return status, nil
+}
+
+// GetAll Gets the last status of every node. Nodes which haven't been checked yet have an empty status.
+func (ns *NodeStatus) GetAll() ([]*models.NodeStatus, error) {
+	nodes, err := (&Node{DB: ns.DB}).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*models.NodeStatus
+	err = ns.DB.Find(&statuses).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.NodeStatus, len(nodes))
+	for k, node := range nodes {
+		result[k] = &models.NodeStatus{NodeId: node.ID, Features: []string{}, Environments: []string{}}
+		for _, v := range statuses {
+			if v.NodeId == node.ID {
+				result[k] = v
+				break
+			}
+		}
+		result[k].Name = node.Name
+	}
+	return result, nil
+}
+
+// probe asks the daemon how it is, filling in the status with what it says
+func (ns *NodeStatus) probe(node *models.Node, status *models.NodeStatus) error {
+	ctx := context.Background()
+	if timeout := config.NodeCheckTimeout.Value(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	running := &pufferpanel.DaemonRunning{}
+	start := time.Now()
+	if err := ns.callDaemon(ctx, node, "/daemon", running); err != nil {
+		return err
+	}
+	status.Latency = time.Since(start).Milliseconds()
+
+	features := &nodeFeatures{}
+	if err := ns.callDaemon(ctx, node, "/daemon/features", features); err != nil {
+		return err
+	}
+
+	status.Version = features.Version
+	status.OS = features.OS
+	status.Arch = features.Arch
+	status.Features = features.Features
+	status.Environments = features.Environments
+
+	//daemons older than the panel don't report what they are using
+	stats := &pufferpanel.DaemonStats{}
+	if err := ns.callDaemon(ctx, node, "/daemon/stats", stats); err != nil {
+		logging.Debug.Printf("Error getting stats for node %s: %s", node.Name, err.Error())
+		return nil
+	}
+
+	status.ServerCount = stats.Servers
+	if stats.Host != nil {
+		status.CPUs = stats.Host.CPUs
+		status.CPUUsage = stats.Host.CPUUsage
+		status.MemoryTotal = stats.Host.MemoryTotal
+		status.MemoryUsed = stats.Host.MemoryUsed
+		status.DiskTotal = stats.Host.DiskTotal
+		status.DiskUsed = stats.Host.DiskUsed
+	}
+	return nil
+}
+
+func (ns *NodeStatus) callDaemon(ctx context.Context, node *models.Node, path string, target interface{}) error {
+	res, err := (&Node{DB: ns.DB}).callNode(ctx, node, "GET", path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseResponse(res)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("daemon responded with %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+// notify tells everyone who manages nodes that the node has gone offline or come back
+func (ns *NodeStatus) notify(node *models.Node, status *models.NodeStatus) {
+	action := "node.online"
+	template := "nodeOnline"
+	var details interface{}
+	if status.Online {
+		logging.Info.Printf("Node %s is back online", node.Name)
+	} else {
+		action = "node.offline"
+		template = "nodeOffline"
+		details = map[string]string{"error": status.Error}
+		logging.Error.Printf("Node %s is offline: %s", node.Name, status.Error)
+	}
+
+	as := &Audit{DB: ns.DB}
+	if err := as.Record(nil, action, "node", strconv.Itoa(int(node.ID)), details, ""); err != nil {
+		logging.Error.Printf("Error writing audit log: %s", err.Error())
+	}
+
+	users, err := (&Permission{DB: ns.DB}).GetUsersWithScope(scopes.ScopeNodesEdit)
+	if err != nil {
+		logging.Error.Printf("Error getting users to notify about node %s: %s", node.Name, err.Error())
+		return
+	}
+	for _, v := range users {
+		err = GetEmailService().SendEmail(v.Email, template, map[string]interface{}{
+			"Node":  node.Name,
+			"Error": status.Error,
+		}, true)
+		if err != nil {
+			logging.Error.Printf("Error sending email: %s\n", err)
+		}
+	}
+}
+
+// StartNodeMonitor checks every node on the configured interval
+func StartNodeMonitor(db *gorm.DB) {
+	interval := config.NodeCheckInterval.Value()
+	if interval <= 0 {
+		return
+	}
+
+	nodeMonitorTicker = time.NewTicker(time.Duration(interval) * time.Second)
+	go func(ticker *time.Ticker) {
+		for range ticker.C {
+			ns := &NodeStatus{DB: db}
+			if err := ns.CheckAll(); err != nil {
+				logging.Error.Printf("Error checking nodes: %s", err.Error())
+			}
+		}
+	}(nodeMonitorTicker)
+}
+
+func StopNodeMonitor() {
+	if nodeMonitorTicker != nil {
+		nodeMonitorTicker.Stop()
+	}
+}
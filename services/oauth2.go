@@ -1,10 +1,22 @@
 package services
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
 )
 
+// authorizationCodeExpiry is how long a client has to exchange a code after the user approves it
+const authorizationCodeExpiry = 5 * time.Minute
+
 type OAuth2 struct {
 	DB *gorm.DB
 }
@@ -40,5 +52,94 @@ func (s *OAuth2) Delete(clientId string) error {
 	client := &models.Client{
 		ClientId: clientId,
 	}
-	return s.DB.Model(client).Delete(client, client).Error
+	err := s.DB.Where(client).First(client).Error
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deletePermissionRoles(tx, "client_id = ?", client.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(models.Permissions{}, "client_id = ?", client.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(models.AuthorizationCode{}, "client_id = ?", client.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(models.RefreshToken{}, "client_id = ?", client.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(models.Session{}, "client_id = ?", client.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(client).Error
+	})
+}
+
+// CreateAuthorizationCode Creates a code the client can exchange for a session with the approved scopes.
+// The code challenge is the PKCE S256 challenge the client sent.
+func (s *OAuth2) CreateAuthorizationCode(client *models.Client, user *models.User, redirectUri, codeChallenge string, approved []*scopes.Scope) (string, error) {
+	code, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	hashed, err := HashToken(code)
+	if err != nil {
+		return "", err
+	}
+
+	model := &models.AuthorizationCode{
+		Code:          hashed,
+		ClientId:      client.ID,
+		UserId:        user.ID,
+		RedirectUri:   redirectUri,
+		CodeChallenge: codeChallenge,
+		Scopes:        approved,
+		ExpiresAt:     time.Now().Add(authorizationCodeExpiry),
+	}
+	err = s.DB.Omit(clause.Associations).Create(model).Error
+	return code, err
+}
+
+// RedeemAuthorizationCode Uses up a code, confirming it was given to this client for this redirect uri, and that
+// the verifier matches the challenge. A code can only be tried once, even if it was wrong.
+func (s *OAuth2) RedeemAuthorizationCode(client *models.Client, code, redirectUri, verifier string) (*models.AuthorizationCode, error) {
+	hashed, err := HashToken(code)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &models.AuthorizationCode{}
+	err = s.DB.Preload("User").Where(&models.AuthorizationCode{Code: hashed}).First(model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pufferpanel.ErrInvalidAuthorizationCode
+	} else if err != nil {
+		return nil, err
+	}
+
+	//whoever deletes it gets to use it, so two requests racing with the same code can't both get tokens
+	res := s.DB.Delete(model)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, pufferpanel.ErrInvalidAuthorizationCode
+	}
+
+	if model.ExpiresAt.Before(time.Now()) || model.ClientId != client.ID || model.RedirectUri != redirectUri || !VerifyCodeChallenge(verifier, model.CodeChallenge) {
+		return nil, pufferpanel.ErrInvalidAuthorizationCode
+	}
+	return model, nil
+}
+
+// VerifyCodeChallenge Checks a PKCE verifier against the S256 challenge made from it
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
 }
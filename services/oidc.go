@@ -0,0 +1,512 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"gorm.io/gorm"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a user has to log in at the provider before they have to start over
+const oidcLoginTimeout = 10 * time.Minute
+
+// how long the discovery document and keys of a provider are kept before fetching them again
+const oidcCacheTime = time.Hour
+
+type OIDC struct {
+	DB *gorm.DB
+}
+
+type oidcLogin struct {
+	provider string
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+type oidcIssuer struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+var oidcLogins = make(map[string]*oidcLogin)
+var oidcLoginsLock sync.Mutex
+
+var oidcIssuers = make(map[string]*oidcIssuer)
+var oidcIssuersLock sync.Mutex
+
+// OIDCRedirectUrl is where the provider sends the user back to after they log in
+func OIDCRedirectUrl(provider string) string {
+	return strings.TrimSuffix(config.MasterUrl.Value(), "/") + "/auth/oidc/" + url.PathEscape(provider) + "/callback"
+}
+
+// StartLogin Gets the url to send the user to in order to log in with the provider, along with the state
+// which has to come back with them. The state should be tied to the user's browser so it can't be used by anyone else.
+func (o *OIDC) StartLogin(providerName string) (authUrl string, state string, err error) {
+	provider, exists := config.OidcProviders.Get(providerName)
+	if !exists {
+		return "", "", pufferpanel.ErrOIDCProviderNotFound
+	}
+
+	issuer, err := getOIDCIssuer(provider.Issuer, false)
+	if err != nil {
+		return "", "", err
+	}
+
+	login := &oidcLogin{provider: provider.Name, expires: time.Now().Add(oidcLoginTimeout)}
+	if state, err = randomOIDCValue(); err != nil {
+		return
+	}
+	if login.verifier, err = randomOIDCValue(); err != nil {
+		return
+	}
+	if login.nonce, err = randomOIDCValue(); err != nil {
+		return
+	}
+
+	oidcLoginsLock.Lock()
+	for k, v := range oidcLogins {
+		if v.expires.Before(time.Now()) {
+			delete(oidcLogins, k)
+		}
+	}
+	oidcLogins[state] = login
+	oidcLoginsLock.Unlock()
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+
+	requestedScopes := provider.Scopes
+	if len(requestedScopes) == 0 {
+		requestedScopes = []string{"openid", "email", "profile"}
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientId)
+	query.Set("redirect_uri", OIDCRedirectUrl(provider.Name))
+	query.Set("scope", strings.Join(requestedScopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", login.nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	authUrl = issuer.AuthorizationEndpoint
+	if strings.Contains(authUrl, "?") {
+		authUrl += "&" + query.Encode()
+	} else {
+		authUrl += "?" + query.Encode()
+	}
+	return authUrl, state, nil
+}
+
+// FinishLogin Exchanges the code the provider sent the user back with, and finds the user it belongs to.
+// Users are found by the account they have linked, or by their email if the provider has verified it.
+func (o *OIDC) FinishLogin(providerName, state, code string) (*models.User, error) {
+	oidcLoginsLock.Lock()
+	login := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcLoginsLock.Unlock()
+
+	if login == nil || login.provider != providerName || login.expires.Before(time.Now()) {
+		return nil, pufferpanel.ErrInvalidSession
+	}
+
+	provider, exists := config.OidcProviders.Get(providerName)
+	if !exists {
+		return nil, pufferpanel.ErrOIDCProviderNotFound
+	}
+
+	claims, err := exchangeOIDCCode(provider, login, code)
+	if err != nil {
+		logging.Error.Printf("Error logging in with %s: %s", provider.Name, err.Error())
+		return nil, pufferpanel.ErrOIDCLoginFailed
+	}
+
+	subject := oidcClaimString(claims, provider.SubjectClaim, "sub")
+	if subject == "" {
+		logging.Error.Printf("Error logging in with %s: no subject was given", provider.Name)
+		return nil, pufferpanel.ErrOIDCLoginFailed
+	}
+	email := oidcClaimString(claims, provider.EmailClaim, "email")
+	verified := oidcClaimBool(claims, provider.EmailVerifiedClaim, "email_verified")
+	username := oidcClaimString(claims, provider.UsernameClaim, "preferred_username")
+
+	return o.linkUser(provider, subject, email, verified, username)
+}
+
+func (o *OIDC) linkUser(provider config.OIDCProvider, subject, email string, verified bool, username string) (*models.User, error) {
+	var user *models.User
+	created := false
+
+	err := o.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		identity := &models.OIDCIdentity{}
+		err := tx.Preload("User").Where(&models.OIDCIdentity{Provider: provider.Name, Subject: subject}).First(identity).Error
+		if err == nil && identity.User != nil {
+			user = identity.User
+			return tx.Model(identity).UpdateColumns(map[string]interface{}{"last_used": now, "email": email}).Error
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		//without an email the provider has checked, there is no way of knowing which user this is
+		if !verified || email == "" {
+			return pufferpanel.ErrOIDCNoAccount
+		}
+
+		us := &User{DB: tx}
+		user, err = us.GetByEmail(email)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !provider.AllowRegistration {
+				return pufferpanel.ErrOIDCNoAccount
+			}
+			user, err = o.createUser(tx, provider, email, username)
+			created = true
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.OIDCIdentity{
+			UserId:   user.ID,
+			Provider: provider.Name,
+			Subject:  subject,
+			Email:    email,
+			LastUsed: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		err = GetEmailService().SendEmail(user.Email, "accountCreation", nil, true)
+		if err != nil {
+			logging.Error.Printf("Error sending email: %s", err.Error())
+		}
+	}
+	return user, nil
+}
+
+// createUser creates an account for someone logging in for the first time, with the scopes the provider gives
+func (o *OIDC) createUser(tx *gorm.DB, provider config.OIDCProvider, email, username string) (*models.User, error) {
+	if username == "" {
+		username = strings.SplitN(email, "@", 2)[0]
+	}
+	username = oidcUsername(username)
+
+	//the password is never given out, it has to be reset before the user can log in without the provider
+	password, err := randomOIDCValue()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{Email: email}
+	if err = user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		user.Username = username
+		if i > 0 {
+			suffix, err := randomOIDCValue()
+			if err != nil {
+				return nil, err
+			}
+			user.Username = username + "-" + suffix[:6]
+		}
+
+		var count int64
+		if err = tx.Model(&models.User{}).Where("username = ?", user.Username).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			break
+		}
+		if i == 10 {
+			return nil, pufferpanel.ErrOIDCLoginFailed
+		}
+	}
+
+	if err = tx.Create(user).Error; err != nil {
+		return nil, err
+	}
+
+	ps := &Permission{DB: tx}
+	perms, err := ps.GetForUserAndServer(user.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	perms.Scopes = []*scopes.Scope{scopes.ScopeLogin}
+	if len(provider.DefaultScopes) > 0 {
+		perms.Scopes = make([]*scopes.Scope, 0, len(provider.DefaultScopes))
+		for _, v := range provider.DefaultScopes {
+			perms.Scopes = append(perms.Scopes, scopes.GetScope(v))
+		}
+	}
+	if err = ps.UpdatePermissions(perms); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func exchangeOIDCCode(provider config.OIDCProvider, login *oidcLogin, code string) (jwt.MapClaims, error) {
+	issuer, err := getOIDCIssuer(provider.Issuer, false)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", OIDCRedirectUrl(provider.Name))
+	form.Set("client_id", provider.ClientId)
+	form.Set("code_verifier", login.verifier)
+
+	request, err := http.NewRequest(http.MethodPost, issuer.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if provider.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.ClientId), url.QueryEscape(provider.ClientSecret))
+	}
+
+	response, err := pufferpanel.Http().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseResponse(response)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded with %s", response.Status)
+	}
+
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IdToken == "" {
+		return nil, errors.New("no id token was given")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IdToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return issuer.key(provider.Issuer, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(provider.ClientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if nonce, _ := claims["nonce"].(string); nonce != login.nonce {
+		return nil, errors.New("nonce does not match")
+	}
+	return claims, nil
+}
+
+// getOIDCIssuer gets the endpoints and keys of the provider, from the cache if they are recent enough
+func getOIDCIssuer(issuerUrl string, refresh bool) (*oidcIssuer, error) {
+	oidcIssuersLock.Lock()
+	issuer := oidcIssuers[issuerUrl]
+	oidcIssuersLock.Unlock()
+
+	if issuer != nil && !refresh && issuer.fetched.Add(oidcCacheTime).After(time.Now()) {
+		return issuer, nil
+	}
+
+	issuer = &oidcIssuer{}
+	err := getOIDCJson(strings.TrimSuffix(issuerUrl, "/")+"/.well-known/openid-configuration", issuer)
+	if err != nil {
+		return nil, err
+	}
+	if issuer.AuthorizationEndpoint == "" || issuer.TokenEndpoint == "" || issuer.JwksUri == "" {
+		return nil, fmt.Errorf("provider %s is missing endpoints", issuerUrl)
+	}
+
+	var keySet struct {
+		Keys []oidcKey `json:"keys"`
+	}
+	if err = getOIDCJson(issuer.JwksUri, &keySet); err != nil {
+		return nil, err
+	}
+
+	issuer.keys = make(map[string]interface{})
+	for _, v := range keySet.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+		key, err := v.publicKey()
+		if err != nil {
+			logging.Debug.Printf("Skipping key %s from %s: %s", v.Kid, issuerUrl, err.Error())
+			continue
+		}
+		issuer.keys[v.Kid] = key
+	}
+	issuer.fetched = time.Now()
+
+	oidcIssuersLock.Lock()
+	oidcIssuers[issuerUrl] = issuer
+	oidcIssuersLock.Unlock()
+	return issuer, nil
+}
+
+// key gets the key a token was signed with, fetching the keys again in case the provider has rotated them
+func (i *oidcIssuer) key(issuerUrl, kid string) (interface{}, error) {
+	if key, exists := i.keys[kid]; exists {
+		return key, nil
+	}
+
+	refreshed, err := getOIDCIssuer(issuerUrl, true)
+	if err != nil {
+		return nil, err
+	}
+	if key, exists := refreshed.keys[kid]; exists {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %s", kid)
+}
+
+func getOIDCJson(source string, target interface{}) error {
+	response, err := pufferpanel.HttpGet(source)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseResponse(response)
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", source, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+type oidcKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k oidcKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func oidcClaimString(claims jwt.MapClaims, claim, def string) string {
+	if claim == "" {
+		claim = def
+	}
+	value, _ := claims[claim].(string)
+	return value
+}
+
+func oidcClaimBool(claims jwt.MapClaims, claim, def string) bool {
+	if claim == "" {
+		claim = def
+	}
+	switch value := claims[claim].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	default:
+		return false
+	}
+}
+
+// oidcUsername makes a username from the provider fit what is allowed for usernames
+func oidcUsername(username string) string {
+	var builder strings.Builder
+	for _, c := range username {
+		if c > ' ' && c <= '~' {
+			builder.WriteRune(c)
+		}
+	}
+	result := builder.String()
+	if len(result) > 90 {
+		result = result[:90]
+	}
+	for len(result) < 5 {
+		result += "_"
+	}
+	return result
+}
+
+func randomOIDCValue() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	uuid "github.com/gofrs/uuid/v5"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type PasswordReset struct {
+	DB *gorm.DB
+}
+
+// Create Creates a reset token for the user, returning the token to send to them.
+// Only so many can be requested in an hour, so a user's inbox can't be flooded.
+func (ps *PasswordReset) Create(user *models.User) (string, error) {
+	//passwords of users from LDAP are changed there, not in the panel
+	if managed, err := IsLDAPUser(ps.DB, user.ID); err != nil {
+		return "", err
+	} else if managed {
+		return "", pufferpanel.ErrLDAPManaged
+	}
+
+	//resets used to count against the limit are kept for a day, anything older can go
+	err := ps.DB.Where("user_id = ? AND expiration_time < ?", user.ID, time.Now().Add(-24*time.Hour)).Delete(&models.PasswordReset{}).Error
+	if err != nil {
+		return "", err
+	}
+
+	var count int64
+	err = ps.DB.Model(&models.PasswordReset{}).Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-time.Hour)).Count(&count).Error
+	if err != nil {
+		return "", err
+	}
+	if count >= int64(config.PasswordResetLimit.Value()) {
+		return "", pufferpanel.ErrPasswordResetLimit
+	}
+
+	token, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	resetToken := token.String()
+	hashed, err := HashToken(resetToken)
+	if err != nil {
+		return "", err
+	}
+
+	reset := &models.PasswordReset{
+		UserId:         user.ID,
+		Token:          hashed,
+		ExpirationTime: time.Now().Add(time.Duration(config.PasswordResetExpiry.Value()) * time.Minute),
+	}
+	err = ps.DB.Create(reset).Error
+	return resetToken, err
+}
+
+// Use Sets a new password using a reset token. The token can only be used once, and every session the user
+// had is ended, so anyone who had access to the account no longer does.
+func (ps *PasswordReset) Use(token, password string) (*models.User, error) {
+	us := &User{DB: ps.DB}
+	if !us.IsSecurePassword(password) {
+		return nil, pufferpanel.ErrPasswordRequirements
+	}
+
+	hashed, err := HashToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	err = ps.DB.Transaction(func(tx *gorm.DB) error {
+		reset := &models.PasswordReset{}
+		err := tx.Preload("User").Where("token = ? AND used_at IS NULL AND expiration_time > ?", hashed, time.Now()).First(reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && reset.User == nil) {
+			return pufferpanel.ErrPasswordResetInvalid
+		} else if err != nil {
+			return err
+		}
+
+		//only one request can mark it as used, anyone else racing with the same token loses
+		res := tx.Model(&models.PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return pufferpanel.ErrPasswordResetInvalid
+		}
+
+		user = reset.User
+		if err = user.SetPassword(password); err != nil {
+			return err
+		}
+		if err = tx.Save(user).Error; err != nil {
+			return err
+		}
+
+		//other links sent before this one should not work anymore either
+		err = tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordReset{}).Error
+		if err != nil {
+			return err
+		}
+
+		ss := &Session{DB: tx}
+		return ss.ExpireForUser(user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -2,7 +2,10 @@ package services
 
 import (
 	"errors"
+	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -18,7 +21,11 @@ func (ps *Permission) GetForUser(id uint) ([]*models.Permissions, error) {
 	}
 
 	err := ps.DB.Preload(clause.Associations).Where(permissions).Find(&allPerms).Error
+	if err != nil {
+		return nil, err
+	}
 
+	err = ps.loadRoles(allPerms...)
 	return allPerms, err
 }
 
@@ -29,7 +36,11 @@ func (ps *Permission) GetForServer(serverId string) ([]*models.Permissions, erro
 	}
 
 	err := ps.DB.Preload(clause.Associations).Where(permissions).Find(&allPerms).Error
+	if err != nil {
+		return nil, err
+	}
 
+	err = ps.loadRoles(allPerms...)
 	return allPerms, err
 }
 
@@ -45,15 +56,45 @@ func (ps *Permission) GetForUserAndServer(userId uint, serverId string) (*models
 		ServerIdentifier: id,
 	}
 
-	err := ps.DB.Preload(clause.Associations).Where(permissions).First(permissions).Error
+	query := ps.DB.Preload(clause.Associations).Where(permissions)
+	if id == nil {
+		//a nil pointer is skipped when querying by struct, so the global set has to be asked for
+		query = query.Where("server_identifier IS NULL")
+	}
+	err := query.First(permissions).Error
 
 	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
 		return permissions, nil
 	}
+	if err != nil {
+		return permissions, err
+	}
 
+	err = ps.loadRoles(permissions)
 	return permissions, err
 }
 
+// GetPathRestrictions Gets the paths a user is limited to on a server, or nothing if they can access everything
+func (ps *Permission) GetPathRestrictions(userId uint, serverId string) ([]string, error) {
+	perms, err := ps.GetForUserAndServer(userId, serverId)
+	if err != nil {
+		return nil, err
+	}
+	if perms.ID == 0 || len(perms.Paths) == 0 || scopes.ContainsScope(perms.EffectiveScopes(), scopes.ScopeServerAdmin) {
+		return nil, nil
+	}
+
+	global, err := ps.GetForUserAndServer(userId, "")
+	if err != nil {
+		return nil, err
+	}
+	if scopes.ContainsScope(global.EffectiveScopes(), scopes.ScopeServerAdmin) {
+		return nil, nil
+	}
+
+	return perms.Paths, nil
+}
+
 func (ps *Permission) GetForClient(id uint) ([]*models.Permissions, error) {
 	var allPerms []*models.Permissions
 	permissions := &models.Permissions{
@@ -61,7 +102,11 @@ func (ps *Permission) GetForClient(id uint) ([]*models.Permissions, error) {
 	}
 
 	err := ps.DB.Preload(clause.Associations).Where(permissions).Find(&allPerms).Error
+	if err != nil {
+		return nil, err
+	}
 
+	err = ps.loadRoles(allPerms...)
 	return allPerms, err
 }
 
@@ -71,8 +116,16 @@ func (ps *Permission) GetForClientAndServer(id uint, serverId *string) (*models.
 		ServerIdentifier: serverId,
 	}
 
-	err := ps.DB.Preload(clause.Associations).Where(permissions).FirstOrCreate(permissions).Error
+	query := ps.DB.Preload(clause.Associations).Omit(clause.Associations).Where(permissions)
+	if serverId == nil {
+		query = query.Where("server_identifier IS NULL")
+	}
+	err := query.FirstOrCreate(permissions).Error
+	if err != nil {
+		return permissions, err
+	}
 
+	err = ps.loadRoles(permissions)
 	return permissions, err
 }
 
@@ -91,5 +144,194 @@ func (ps *Permission) UpdatePermissions(perms *models.Permissions) error {
 func (ps *Permission) Remove(perms *models.Permissions) error {
 	//update oauth2 with new information
 
-	return ps.DB.Omit(clause.Associations).Delete(perms).Error
+	return ps.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&models.PermissionRole{PermissionsId: perms.ID}).Delete(&models.PermissionRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Delete(perms).Error
+	})
+}
+
+// SetRoles Replaces the roles given in the permission set. Server roles can only be given on a server,
+// and global roles only globally.
+func (ps *Permission) SetRoles(perms *models.Permissions, roleIds []uint) error {
+	roles := make([]*models.Role, 0)
+	if len(roleIds) > 0 {
+		err := ps.DB.Where("id IN ?", roleIds).Order("name").Find(&roles).Error
+		if err != nil {
+			return err
+		}
+		if len(roles) != len(utils.Unique(roleIds)) {
+			return gorm.ErrRecordNotFound
+		}
+	}
+
+	for _, v := range roles {
+		if v.ForServer != (perms.ServerIdentifier != nil) {
+			return pufferpanel.ErrRoleLevelMismatch(v.Name)
+		}
+	}
+
+	err := ps.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&models.PermissionRole{PermissionsId: perms.ID}).Delete(&models.PermissionRole{}).Error; err != nil {
+			return err
+		}
+		for _, v := range roles {
+			if err := tx.Create(&models.PermissionRole{PermissionsId: perms.ID, RoleId: v.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	perms.Roles = roles
+	return nil
+}
+
+// ClientAllows Checks if a client may use a scope, globally or on a server. Clients which have not been given
+// any roles can do everything their user can
+func (ps *Permission) ClientAllows(clientId uint, serverId string, scope *scopes.Scope) (bool, error) {
+	perms, err := ps.GetForClient(clientId)
+	if err != nil {
+		return false, err
+	}
+
+	limited := false
+	for _, v := range perms {
+		if len(v.Roles) > 0 {
+			limited = true
+			break
+		}
+	}
+	if !limited {
+		return true, nil
+	}
+
+	for _, v := range perms {
+		if v.ServerIdentifier != nil && *v.ServerIdentifier != serverId {
+			continue
+		}
+		if scopes.ContainsScope(v.EffectiveScopes(), scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetGrantable Filters scopes down to those a user can hand on, either globally or, for server scopes, on any server
+func (ps *Permission) GetGrantable(userId uint, requested []*scopes.Scope) ([]*scopes.Scope, error) {
+	perms, err := ps.GetForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*scopes.Scope, 0)
+	for _, v := range requested {
+		for _, p := range perms {
+			if p.ServerIdentifier != nil && !v.ForServer {
+				continue
+			}
+			if scopes.ContainsScope(p.EffectiveScopes(), v) {
+				result = append(result, v)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// GetUsersWithScope Gets the users who have the scope globally, for telling them about things which affect the panel
+func (ps *Permission) GetUsersWithScope(scope *scopes.Scope) ([]*models.User, error) {
+	var allPerms []*models.Permissions
+	err := ps.DB.Preload("User").Where("user_id IS NOT NULL AND server_identifier IS NULL").Find(&allPerms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.loadRoles(allPerms...)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*models.User, 0)
+	for _, v := range allPerms {
+		if scopes.ContainsScope(v.EffectiveScopes(), scope) {
+			user := v.User
+			users = append(users, &user)
+		}
+	}
+	return users, nil
+}
+
+// GetEffectiveForUser Works out everything a user can do, globally or on a server, and where each scope comes from
+func (ps *Permission) GetEffectiveForUser(userId uint, serverId string) (*models.EffectivePermissionsView, error) {
+	sets := make([]*models.Permissions, 0)
+	if serverId != "" {
+		perms, err := ps.GetForUserAndServer(userId, serverId)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, perms)
+	}
+
+	global, err := ps.GetForUserAndServer(userId, "")
+	if err != nil {
+		return nil, err
+	}
+	sets = append(sets, global)
+
+	return models.FromEffectivePermissions(serverId, sets...), nil
+}
+
+// loadRoles Fills in the roles given in each of the permission sets
+func (ps *Permission) loadRoles(perms ...*models.Permissions) error {
+	ids := make([]uint, 0, len(perms))
+	for _, v := range perms {
+		v.Roles = make([]*models.Role, 0)
+		if v.ID != 0 {
+			ids = append(ids, v.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var links []*models.PermissionRole
+	err := ps.DB.Where("permissions_id IN ?", ids).Find(&links).Error
+	if err != nil || len(links) == 0 {
+		return err
+	}
+
+	roleIds := make([]uint, 0, len(links))
+	for _, v := range links {
+		roleIds = append(roleIds, v.RoleId)
+	}
+
+	var roles []*models.Role
+	err = ps.DB.Where("id IN ?", utils.Unique(roleIds)).Order("name").Find(&roles).Error
+	if err != nil {
+		return err
+	}
+
+	for _, p := range perms {
+		for _, r := range roles {
+			for _, l := range links {
+				if l.PermissionsId == p.ID && l.RoleId == r.ID {
+					p.Roles = append(p.Roles, r)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deletePermissionRoles Removes the roles given in the permission sets which match the query, for
+// when those sets are being removed
+func deletePermissionRoles(tx *gorm.DB, query interface{}, args ...interface{}) error {
+	ids := tx.Session(&gorm.Session{NewDB: true}).Model(&models.Permissions{}).Select("id").Where(query, args...)
+	return tx.Session(&gorm.Session{NewDB: true}).Where("permissions_id IN (?)", ids).Delete(&models.PermissionRole{}).Error
 }
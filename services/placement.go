@@ -0,0 +1,155 @@
+package services
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+)
+
+const (
+	PlacementBinPack = "binpack"
+	PlacementSpread  = "spread"
+)
+
+// Placement keeps track of what nodes have set aside for their servers, and picks where new servers go
+type Placement struct {
+	DB *gorm.DB
+}
+
+// GetUsage Gets what the servers on the node have set aside. The excluded server is left out, so a server being
+// changed isn't counted twice.
+func (ps *Placement) GetUsage(node *models.Node, exclude string) (*models.NodeUsage, error) {
+	query := serversOnNode(ps.DB, node)
+	if exclude != "" {
+		query = query.Where("identifier <> ?", exclude)
+	}
+
+	usage := &models.NodeUsage{}
+	err := query.Select("COUNT(*) AS servers, COALESCE(SUM(memory), 0) AS memory, COALESCE(SUM(cpu), 0) AS cpu, COALESCE(SUM(disk), 0) AS disk").Scan(usage).Error
+	if err != nil {
+		return nil, err
+	}
+
+	usage.MaxMemory = node.MaxMemory
+	usage.MaxCPU = node.MaxCPU
+	usage.MaxDisk = node.MaxDisk
+	return usage, nil
+}
+
+// Check Makes sure the node has room for the server
+func (ps *Placement) Check(node *models.Node, server *models.Server) error {
+	usage, err := ps.GetUsage(node, server.Identifier)
+	if err != nil {
+		return err
+	}
+	return fits(node, server, usage)
+}
+
+// Pick Chooses the node for the server out of those which are online, support what the server requires and have
+// room for it and its ports. Bin-packing picks the fullest node, so the others are kept free for large servers, while
+// spreading picks the emptiest.
+func (ps *Placement) Pick(strategy string, server *models.Server, definition pufferpanel.Server) (*models.Node, error) {
+	if strategy != PlacementBinPack && strategy != PlacementSpread {
+		return nil, pufferpanel.ErrInvalidPlacement
+	}
+
+	nodes, err := (&Node{DB: ps.DB}).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	nss := &NodeStatus{DB: ps.DB}
+	pp := &PortPool{DB: ps.DB}
+	var best *models.Node
+	var bestScore float64
+	var bestServers int64
+	for _, node := range nodes {
+		status, err := nss.Get(node)
+		if err != nil {
+			return nil, err
+		}
+		if !status.Online || definition.Requirements.TestNode(definition, status.OS, status.Arch, status.Features) != nil {
+			continue
+		}
+
+		usage, err := ps.GetUsage(node, server.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		if fits(node, server, usage) != nil {
+			continue
+		}
+		if err = pp.Check(node, server, definition); err != nil {
+			continue
+		}
+
+		score := usedAfter(node, server, usage)
+		if best == nil {
+			best, bestScore, bestServers = node, score, usage.Servers
+			continue
+		}
+
+		//when the nodes are just as full, the number of servers on them decides it
+		var better bool
+		if strategy == PlacementBinPack {
+			better = score > bestScore || (score == bestScore && usage.Servers > bestServers)
+		} else {
+			better = score < bestScore || (score == bestScore && usage.Servers < bestServers)
+		}
+		if better {
+			best, bestScore, bestServers = node, score, usage.Servers
+		}
+	}
+
+	if best == nil {
+		return nil, pufferpanel.ErrNoNodeAvailable
+	}
+	return best, nil
+}
+
+// serversOnNode gets the query for the servers on the node, where servers on the local node don't have one
+func serversOnNode(db *gorm.DB, node *models.Node) *gorm.DB {
+	query := db.Model(&models.Server{})
+	if node.IsLocal() {
+		return query.Where("node_id IS NULL")
+	}
+	return query.Where("node_id = ?", node.ID)
+}
+
+// fits checks the server against what is left on the node
+func fits(node *models.Node, server *models.Server, usage *models.NodeUsage) error {
+	if node.MaxMemory > 0 && usage.Memory+server.Memory > node.MaxMemory {
+		return pufferpanel.ErrNodeOverCommitted("memory")
+	}
+	if node.MaxCPU > 0 && usage.CPU+server.CPU > node.MaxCPU {
+		return pufferpanel.ErrNodeOverCommitted("cpu")
+	}
+	if node.MaxDisk > 0 && usage.Disk+server.Disk > node.MaxDisk {
+		return pufferpanel.ErrNodeOverCommitted("disk")
+	}
+	return nil
+}
+
+// usedAfter works out how full the node would be with the server on it, as the average of the share of each limited
+// resource in use. A node without limits is counted as empty.
+func usedAfter(node *models.Node, server *models.Server, usage *models.NodeUsage) float64 {
+	var total float64
+	var count int
+	if node.MaxMemory > 0 {
+		total += float64(usage.Memory+server.Memory) / float64(node.MaxMemory)
+		count++
+	}
+	if node.MaxCPU > 0 {
+		total += (usage.CPU + server.CPU) / node.MaxCPU
+		count++
+	}
+	if node.MaxDisk > 0 {
+		total += float64(usage.Disk+server.Disk) / float64(node.MaxDisk)
+		count++
+	}
+
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
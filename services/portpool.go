@@ -0,0 +1,365 @@
+package services
+
+import (
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
+	"sort"
+)
+
+// PortPool gives out ports to servers from the ranges their node owns, so no two servers on a node use the same port
+type PortPool struct {
+	DB *gorm.DB
+}
+
+// portRequest is a port variable of a server, where 0 is a port which needs to be given out
+type portRequest struct {
+	variable string
+	port     uint16
+}
+
+// Get Gets the ranges of the node, and the ports which have been given out of them
+func (pp *PortPool) Get(nodeId uint) (*models.NodePorts, error) {
+	result := &models.NodePorts{
+		Ranges:      make([]*models.PortRange, 0),
+		Allocations: make([]*models.PortAllocation, 0),
+	}
+
+	err := pp.DB.Where("node_id = ?", nodeId).Order("ip, port_min").Find(&result.Ranges).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = pp.DB.Where("node_id = ?", nodeId).Order("ip, port").Find(&result.Allocations).Error
+	return result, err
+}
+
+// AddRange Adds a range to the node's pool, which can't overlap one it already has
+func (pp *PortPool) AddRange(portRange *models.PortRange) error {
+	if err := portRange.IsValid(); err != nil {
+		return err
+	}
+
+	var existing []*models.PortRange
+	err := pp.DB.Where("node_id = ? AND port_min <= ? AND port_max >= ?", portRange.NodeId, portRange.PortMax, portRange.PortMin).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	for _, v := range existing {
+		if v.IP == portRange.IP || v.IP == models.AnyIP || portRange.IP == models.AnyIP {
+			return pufferpanel.ErrPortRangeOverlaps
+		}
+	}
+
+	return pp.DB.Create(portRange).Error
+}
+
+// RemoveRange Removes a range from the node's pool. Ports already given out of it are kept by their servers.
+func (pp *PortPool) RemoveRange(nodeId, id uint) error {
+	res := pp.DB.Where("node_id = ?", nodeId).Delete(&models.PortRange{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RemoveNode Removes the node's pool, for when the node is deleted
+func (pp *PortPool) RemoveNode(nodeId uint) error {
+	err := pp.DB.Where("node_id = ?", nodeId).Delete(&models.PortAllocation{}).Error
+	if err != nil {
+		return err
+	}
+	return pp.DB.Where("node_id = ?", nodeId).Delete(&models.PortRange{}).Error
+}
+
+// Check Makes sure the ports of the server can be given to it on the node, without giving them out
+func (pp *PortPool) Check(node *models.Node, server *models.Server, definition pufferpanel.Server) error {
+	requests, err := portRequests(definition)
+	if err != nil {
+		return err
+	}
+	_, _, err = pp.plan(node, server.Identifier, server.IP, requests)
+	return err
+}
+
+// Assign Gives the server its ports on the node, replacing those it had. Ports it asks for are checked to be free and
+// in the pool, and ports left at 0 are picked from the pool. The definition and server are updated with the ports and
+// IP they were given.
+func (pp *PortPool) Assign(node *models.Node, server *models.Server, definition *pufferpanel.Server) error {
+	requests, err := portRequests(*definition)
+	if err != nil {
+		return err
+	}
+
+	allocations, err := pp.assign(node, server, server.IP, requests)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range allocations {
+		variable := definition.Variables[v.Variable]
+		variable.Value = int(v.Port)
+		definition.Variables[v.Variable] = variable
+	}
+	if variable, exists := definition.Variables["ip"]; exists && server.IP != models.AnyIP {
+		variable.Value = server.IP
+		definition.Variables["ip"] = variable
+	}
+	return nil
+}
+
+// Update Changes the ports of the server to those in the variables being set, where only the main port and ports the
+// server was given before are known to be ports. The variables and server are updated with what was given.
+func (pp *PortPool) Update(node *models.Node, server *models.Server, values map[string]interface{}) error {
+	var existing []*models.PortAllocation
+	err := pp.DB.Where("server_identifier = ?", server.Identifier).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+
+	_, changed := values["ip"]
+	requests := make([]portRequest, 0, len(existing)+1)
+	for _, v := range existing {
+		requests = append(requests, portRequest{variable: v.Variable, port: v.Port})
+	}
+	if _, exists := values["port"]; exists && !slices.ContainsFunc(existing, func(a *models.PortAllocation) bool { return a.Variable == "port" }) {
+		requests = append(requests, portRequest{variable: "port", port: server.Port})
+	}
+	for k, v := range requests {
+		if value, exists := values[v.variable]; exists {
+			if requests[k].port, err = toPort(v.variable, value); err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	ip := server.IP
+	if value, exists := values["ip"]; exists {
+		ip = cast.ToString(value)
+	}
+
+	allocations, err := pp.assign(node, server, ip, requests)
+	if err != nil {
+		return err
+	}
+
+	//ports which were picked have to be sent on too, so the server knows about them
+	for _, v := range allocations {
+		values[v.Variable] = v.Port
+	}
+	if _, exists := values["ip"]; exists {
+		values["ip"] = server.IP
+	}
+	return nil
+}
+
+// assign works out the ports of the server, and replaces the ones it had with them
+func (pp *PortPool) assign(node *models.Node, server *models.Server, ip string, requests []portRequest) ([]*models.PortAllocation, error) {
+	var allocations []*models.PortAllocation
+	//the node is locked while its ports are worked out and swapped in, so two servers can't be given the same one
+	err := pp.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", node.ID).Find(&models.Node{}).Error
+		if err != nil {
+			return err
+		}
+
+		inner := &PortPool{DB: tx}
+		ip, allocations, err = inner.plan(node, server.Identifier, ip, requests)
+		if err != nil {
+			return err
+		}
+
+		if err = inner.Release(server.Identifier); err != nil {
+			return err
+		}
+		if len(allocations) > 0 {
+			return tx.Create(&allocations).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	server.IP = ip
+	for _, v := range allocations {
+		if v.Variable == "port" {
+			server.Port = v.Port
+		}
+	}
+	return allocations, nil
+}
+
+// Release Gives back the ports of the server
+func (pp *PortPool) Release(serverId string) error {
+	return pp.DB.Where("server_identifier = ?", serverId).Delete(&models.PortAllocation{}).Error
+}
+
+// plan works out which ports the server gets, and the IP it ends up bound to. Ports are only picked when the node has
+// a pool, otherwise they are left as they are.
+func (pp *PortPool) plan(node *models.Node, serverId, ip string, requests []portRequest) (string, []*models.PortAllocation, error) {
+	if ip == "" {
+		ip = models.AnyIP
+	}
+	allocations := make([]*models.PortAllocation, 0, len(requests))
+	if len(requests) == 0 {
+		return ip, allocations, nil
+	}
+
+	var ranges []*models.PortRange
+	err := pp.DB.Where("node_id = ?", node.ID).Order("ip, port_min").Find(&ranges).Error
+	if err != nil {
+		return ip, nil, err
+	}
+
+	taken, err := pp.taken(node, serverId)
+	if err != nil {
+		return ip, nil, err
+	}
+
+	for _, v := range requests {
+		if v.port == 0 {
+			continue
+		}
+		if len(ranges) > 0 && !inRanges(ranges, ip, v.port) {
+			return ip, nil, pufferpanel.ErrPortNotInPool(ip, v.port)
+		}
+		if overlapsAny(taken, ip, v.port) {
+			return ip, nil, pufferpanel.ErrPortInUse(ip, v.port)
+		}
+		allocation := &models.PortAllocation{NodeId: node.ID, IP: ip, Port: v.port, ServerIdentifier: serverId, Variable: v.variable}
+		taken = append(taken, allocation)
+		allocations = append(allocations, allocation)
+	}
+
+	for _, v := range requests {
+		if v.port != 0 || len(ranges) == 0 {
+			continue
+		}
+
+		//a server which hasn't asked for an IP takes the one of the range its first port comes from
+		allocation := nextFree(ranges, taken, ip, len(allocations) == 0)
+		if allocation == nil {
+			return ip, nil, pufferpanel.ErrNoFreePort
+		}
+		ip = allocation.IP
+		allocation.NodeId = node.ID
+		allocation.ServerIdentifier = serverId
+		allocation.Variable = v.variable
+		taken = append(taken, allocation)
+		allocations = append(allocations, allocation)
+	}
+
+	return ip, allocations, nil
+}
+
+// taken gets the ports in use on the node by other servers. Servers from before the node had a pool don't have
+// allocations, so the port they were made with is counted instead.
+func (pp *PortPool) taken(node *models.Node, serverId string) ([]*models.PortAllocation, error) {
+	var taken []*models.PortAllocation
+	err := pp.DB.Where("node_id = ? AND server_identifier <> ?", node.ID, serverId).Find(&taken).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []*models.Server
+	allocated := pp.DB.Model(&models.PortAllocation{}).Select("server_identifier")
+	err = serversOnNode(pp.DB, node).Where("identifier <> ? AND port <> 0 AND identifier NOT IN (?)", serverId, allocated).Find(&servers).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range servers {
+		ip := v.IP
+		if ip == "" {
+			ip = models.AnyIP
+		}
+		taken = append(taken, &models.PortAllocation{IP: ip, Port: v.Port, ServerIdentifier: v.Identifier})
+	}
+	return taken, nil
+}
+
+// portRequests gets the port variables of the definition, being the port variable and any of the port type, with the
+// main port first so it gets the lowest free port
+func portRequests(definition pufferpanel.Server) ([]portRequest, error) {
+	requests := make([]portRequest, 0)
+	for k, v := range definition.Variables {
+		if k != "port" && v.Type.Type != "port" {
+			continue
+		}
+
+		port, err := toPort(k, v.Value)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, portRequest{variable: k, port: port})
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].variable == "port" || requests[j].variable == "port" {
+			return requests[i].variable == "port"
+		}
+		return requests[i].variable < requests[j].variable
+	})
+	return requests, nil
+}
+
+// toPort reads the value of a port variable, where nothing is 0
+func toPort(variable string, value interface{}) (uint16, error) {
+	if str, ok := value.(string); ok && str == "" {
+		return 0, nil
+	}
+	port, err := cast.ToUint16E(value)
+	if err != nil {
+		return 0, pufferpanel.ErrFieldNotBetween(variable, 0, 65535)
+	}
+	return port, nil
+}
+
+func inRanges(ranges []*models.PortRange, ip string, port uint16) bool {
+	for _, v := range ranges {
+		if v.Contains(ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func overlapsAny(taken []*models.PortAllocation, ip string, port uint16) bool {
+	for _, v := range taken {
+		if v.Overlaps(ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextFree finds the lowest free port in the ranges usable from the IP. When the IP can be picked, it is the IP of the
+// range the port comes from.
+func nextFree(ranges []*models.PortRange, taken []*models.PortAllocation, ip string, pickIP bool) *models.PortAllocation {
+	for _, r := range ranges {
+		if r.IP != ip && r.IP != models.AnyIP && ip != models.AnyIP {
+			continue
+		}
+
+		bind := ip
+		if pickIP && ip == models.AnyIP {
+			bind = r.IP
+		}
+		for port := int(r.PortMin); port <= int(r.PortMax); port++ {
+			if !overlapsAny(taken, bind, uint16(port)) {
+				return &models.PortAllocation{IP: bind, Port: uint16(port)}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+type RecoveryCode struct {
+	DB *gorm.DB
+}
+
+// Generate Replaces any recovery codes the user has with a new set, returning them so they can be shown once
+func (rs *RecoveryCode) Generate(userId uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]*models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+
+		hashed, err := HashToken(codes[i])
+		if err != nil {
+			return nil, err
+		}
+		records[i] = &models.RecoveryCode{UserId: userId, Code: hashed}
+	}
+
+	err := rs.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&models.RecoveryCode{UserId: userId}).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// GenerateIfMissing Creates recovery codes for a user who is enrolling a second factor, unless they already have
+// some left. Codes are only returned when new ones were made.
+func (rs *RecoveryCode) GenerateIfMissing(userId uint) ([]string, error) {
+	remaining, err := rs.Remaining(userId)
+	if err != nil || remaining > 0 {
+		return nil, err
+	}
+	return rs.Generate(userId)
+}
+
+func (rs *RecoveryCode) Remaining(userId uint) (int64, error) {
+	var count int64
+	err := rs.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userId).Count(&count).Error
+	return count, err
+}
+
+// Use Marks the code as used, so it can't be used again
+func (rs *RecoveryCode) Use(userId uint, code string) error {
+	hashed, err := HashToken(strings.ToLower(strings.TrimSpace(code)))
+	if err != nil {
+		return err
+	}
+
+	res := rs.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code = ? AND used_at IS NULL", userId, hashed).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return pufferpanel.ErrInvalidCredentials
+	}
+	return nil
+}
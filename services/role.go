@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Role struct {
+	DB *gorm.DB
+}
+
+func (rs *Role) GetAll() ([]*models.Role, error) {
+	roles := make([]*models.Role, 0)
+	err := rs.DB.Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (rs *Role) Get(id uint) (*models.Role, error) {
+	role := &models.Role{}
+	err := rs.DB.Where(&models.Role{ID: id}).First(role).Error
+	return role, err
+}
+
+func (rs *Role) Create(role *models.Role) error {
+	if err := rs.checkName(role); err != nil {
+		return err
+	}
+	return rs.DB.Create(role).Error
+}
+
+// Update Saves changes to the role, which everyone holding it gets straight away
+func (rs *Role) Update(role *models.Role) error {
+	if err := rs.checkName(role); err != nil {
+		return err
+	}
+	return rs.DB.Save(role).Error
+}
+
+// Delete Removes the role, and takes it away from everyone holding it
+func (rs *Role) Delete(id uint) error {
+	return rs.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&models.PermissionRole{RoleId: id}).Delete(&models.PermissionRole{}).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.Role{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// GetHolders Gets the permission sets the role has been given in
+func (rs *Role) GetHolders(id uint) ([]*models.Permissions, error) {
+	var perms []*models.Permissions
+	err := rs.DB.Preload(clause.Associations).
+		Where("id IN (?)", rs.DB.Model(&models.PermissionRole{}).Select("permissions_id").Where(&models.PermissionRole{RoleId: id})).
+		Order("id").
+		Find(&perms).Error
+	return perms, err
+}
+
+func (rs *Role) checkName(role *models.Role) error {
+	existing := &models.Role{}
+	err := rs.DB.Where(&models.Role{Name: role.Name}).First(existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != role.ID {
+		return pufferpanel.ErrRoleExists
+	}
+	return nil
+}
@@ -86,7 +86,12 @@ func (ss *Server) Delete(id string) error {
 		Identifier: id,
 	}
 
-	err := ss.DB.Delete(models.Permissions{}, "server_identifier = ?", id).Error
+	err := deletePermissionRoles(ss.DB, "server_identifier = ?", id)
+	if err != nil {
+		return err
+	}
+
+	err = ss.DB.Delete(models.Permissions{}, "server_identifier = ?", id).Error
 	if err != nil {
 		return err
 	}
@@ -101,6 +106,16 @@ func (ss *Server) Delete(id string) error {
 		return err
 	}
 
+	err = ss.DB.Delete(models.PortAllocation{}, "server_identifier = ?", id).Error
+	if err != nil {
+		return err
+	}
+
+	err = ss.DB.Delete(models.ServerMigration{}, "server_identifier = ?", id).Error
+	if err != nil {
+		return err
+	}
+
 	err = ss.DB.Delete(model).Error
 	if err != nil {
 		return err
@@ -5,12 +5,20 @@ import (
 	"encoding/hex"
 	"errors"
 	uuid "github.com/gofrs/uuid/v5"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"strings"
 	"time"
 )
 
+const refreshTokenExpiry = 30 * 24 * time.Hour
+const sessionTouchInterval = time.Minute
+
 type Session struct {
 	DB *gorm.DB
 }
@@ -62,6 +70,72 @@ func (ss *Session) CreateForClient(client *models.Client) (string, error) {
 	return sessionToken, err
 }
 
+// CreateForAuthorization Creates a session for a client a user approved, which can only use the approved scopes.
+// A refresh token for the same scopes is returned along with it.
+func (ss *Session) CreateForAuthorization(code *models.AuthorizationCode) (string, string, error) {
+	family, err := uuid.NewV4()
+	if err != nil {
+		return "", "", err
+	}
+
+	var sessionToken, refreshToken string
+	err = ss.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		sessionToken, refreshToken, err = createRefreshedSession(tx, code.ClientId, code.UserId, code.Scopes, family.String())
+		return err
+	})
+	return sessionToken, refreshToken, err
+}
+
+// Refresh Swaps a refresh token for a new session and refresh token. A token which was already used means someone
+// else has a copy of it, so every token and session it led to is revoked.
+func (ss *Session) Refresh(client *models.Client, token string) (string, string, []*scopes.Scope, error) {
+	hashed, err := HashToken(token)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var sessionToken, refreshToken string
+	var granted []*scopes.Scope
+	reused := false
+	err = ss.DB.Transaction(func(tx *gorm.DB) error {
+		model := &models.RefreshToken{}
+		err := tx.Where(&models.RefreshToken{Token: hashed, ClientId: client.ID}).First(model).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pufferpanel.ErrInvalidRefreshToken
+		} else if err != nil {
+			return err
+		}
+
+		if model.UsedAt != nil {
+			reused = true
+			return revokeRefreshFamily(tx, model.Family)
+		}
+		if model.ExpiresAt.Before(time.Now()) {
+			return pufferpanel.ErrInvalidRefreshToken
+		}
+
+		//only one request can use it, anyone racing it is treated as reuse
+		res := tx.Model(model).Where("used_at IS NULL").UpdateColumn("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			reused = true
+			return revokeRefreshFamily(tx, model.Family)
+		}
+
+		granted = model.Scopes
+		sessionToken, refreshToken, err = createRefreshedSession(tx, model.ClientId, model.UserId, model.Scopes, model.Family)
+		return err
+	})
+	if err == nil && reused {
+		logging.Info.Printf("Refresh token for client %s was used twice, revoking its sessions", client.ClientId)
+		err = pufferpanel.ErrInvalidRefreshToken
+	}
+	return sessionToken, refreshToken, granted, err
+}
+
 func (ss *Session) Validate(token string) (*models.Session, error) {
 	hashed, err := HashToken(token)
 	if err != nil {
@@ -79,6 +153,34 @@ func (ss *Session) Validate(token string) (*models.Session, error) {
 	return session, err
 }
 
+// Touch Records that a session was used, and from where. To avoid a write on every request, this is only saved
+// once a minute unless where it came from changed.
+func (ss *Session) Touch(session *models.Session, ip, userAgent string) error {
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	if session.LastSeen != nil && time.Since(*session.LastSeen) < sessionTouchInterval && session.IPAddress == ip && session.UserAgent == userAgent {
+		return nil
+	}
+
+	now := time.Now()
+	session.LastSeen = &now
+	session.IPAddress = ip
+	session.UserAgent = userAgent
+	return ss.DB.Model(session).UpdateColumns(map[string]interface{}{
+		"last_seen":  now,
+		"ip_address": ip,
+		"user_agent": userAgent,
+	}).Error
+}
+
+// GetForUser Gets the active sessions of a user, including those of clients acting for them
+func (ss *Session) GetForUser(userId uint) ([]*models.Session, error) {
+	var sessions []*models.Session
+	err := ss.DB.Preload("Client").Where("user_id = ? AND expiration_time > ?", userId, time.Now()).Order("id").Find(&sessions).Error
+	return sessions, err
+}
+
 func (ss *Session) ValidateNode(token string) (*models.Node, error) {
 	if models.LocalNode != nil && models.LocalNode.Secret == token {
 		return models.LocalNode, nil
@@ -103,6 +205,136 @@ func (ss *Session) Expire(token string) error {
 	return err
 }
 
+// ExpireForClient removes a session, but only if it was given to the client
+func (ss *Session) ExpireForClient(token string, clientId uint) error {
+	hashed, err := HashToken(token)
+	if err != nil {
+		return err
+	}
+
+	//either kind of token ends everything that came from the same approval
+	return ss.DB.Transaction(func(tx *gorm.DB) error {
+		session := &models.Session{}
+		err := tx.Where(&models.Session{Token: hashed, ClientId: &clientId}).First(session).Error
+		if err == nil {
+			if session.RefreshFamily != "" {
+				return revokeRefreshFamily(tx, session.RefreshFamily)
+			}
+			return tx.Delete(session).Error
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		refresh := &models.RefreshToken{}
+		err = tx.Where(&models.RefreshToken{Token: hashed, ClientId: clientId}).First(refresh).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return revokeRefreshFamily(tx, refresh.Family)
+	})
+}
+
+// ExpireById removes one of a user's sessions, along with any refresh tokens it came from
+func (ss *Session) ExpireById(userId, id uint) error {
+	session := &models.Session{}
+	err := ss.DB.Where("id = ? AND user_id = ?", id, userId).First(session).Error
+	if err != nil {
+		return err
+	}
+	if session.RefreshFamily != "" {
+		return ss.DB.Transaction(func(tx *gorm.DB) error {
+			return revokeRefreshFamily(tx, session.RefreshFamily)
+		})
+	}
+	return ss.DB.Delete(session).Error
+}
+
+// ExpireOthers removes every session belonging to the user except the one given, along with refresh tokens
+func (ss *Session) ExpireOthers(userId, keepId uint) error {
+	return ss.DB.Transaction(func(tx *gorm.DB) error {
+		keep := &models.Session{}
+		err := tx.Where("id = ? AND user_id = ?", keepId, userId).First(keep).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		query := tx.Where("user_id = ?", userId)
+		if keep.RefreshFamily != "" {
+			query = query.Where("family <> ?", keep.RefreshFamily)
+		}
+		if err = query.Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id <> ?", userId, keepId).Delete(&models.Session{}).Error
+	})
+}
+
+// ExpireForUser removes every session belonging to the user, including those of their OAuth2 clients
+func (ss *Session) ExpireForUser(userId uint) error {
+	return ss.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&models.Session{}).Error
+	})
+}
+
+// createRefreshedSession creates a session for a client acting for a user, and the refresh token to replace it
+func createRefreshedSession(tx *gorm.DB, clientId, userId uint, granted []*scopes.Scope, family string) (string, string, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return "", "", err
+	}
+	sessionToken := token.String()
+	hashed, err := HashToken(sessionToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	session := &models.Session{
+		Token:          hashed,
+		ExpirationTime: time.Now().Add(time.Hour),
+		ClientId:       &clientId,
+		UserId:         &userId,
+		Scopes:         granted,
+		RefreshFamily:  family,
+	}
+	err = tx.Omit(clause.Associations).Create(session).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := utils.GenerateRandomString(48)
+	if err != nil {
+		return "", "", err
+	}
+	hashed, err = HashToken(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	refresh := &models.RefreshToken{
+		Token:     hashed,
+		Family:    family,
+		ClientId:  clientId,
+		UserId:    userId,
+		Scopes:    granted,
+		ExpiresAt: time.Now().Add(refreshTokenExpiry),
+	}
+	err = tx.Omit(clause.Associations).Create(refresh).Error
+	return sessionToken, refreshToken, err
+}
+
+// revokeRefreshFamily removes every refresh token and session which came from the same approval
+func revokeRefreshFamily(tx *gorm.DB, family string) error {
+	if err := tx.Where("family = ?", family).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("refresh_family = ?", family).Delete(&models.Session{}).Error
+}
+
 func HashToken(source string) (result string, err error) {
 	h := sha256.New()
 	_, err = h.Write([]byte(source))
@@ -1,11 +1,17 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net"
 	"strings"
 )
 
@@ -13,37 +19,246 @@ type DatabaseSFTPAuthorization struct {
 }
 
 func (s *DatabaseSFTPAuthorization) Validate(username, password string) (perms *ssh.Permissions, err error) {
+	return s.ValidateFrom(username, password, nil)
+}
+
+// ValidateFrom checks the password like Validate, counting failures against where the client connects from and the
+// account, and refusing them once either has failed too many times
+func (s *DatabaseSFTPAuthorization) ValidateFrom(username, password string, addr net.Addr) (perms *ssh.Permissions, err error) {
+	//a username without a server gets every server the user has access to
 	parts := strings.Split(username, "#")
-	if len(parts) != 2 {
+	if len(parts) > 2 {
 		return nil, errors.New("incorrect username or password")
 	}
 
-	email := parts[0]
-	serverId := parts[1]
-
 	db, err := database.GetConnection()
 	if err != nil {
 		return nil, pufferpanel.ErrDatabaseNotAvailable
 	}
 
+	ip := addrIP(addr)
+	ls := &LoginLimiter{DB: db}
+	until, err := ls.Check(ip, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if !until.IsZero() {
+		return nil, pufferpanel.ErrLoginLocked
+	}
+
 	us := &User{DB: db}
-	user, err := us.GetByEmail(email)
-	if user == nil || err != nil || !us.IsValidCredentials(user, password) {
+	user, _, err := us.ValidateLogin(parts[0], password)
+	if errors.Is(err, pufferpanel.ErrInvalidCredentials) {
+		if _, err = ls.Fail(ip, parts[0]); err != nil {
+			logging.Error.Printf("Error recording failed login: %s", err.Error())
+		}
+		return nil, errors.New("incorrect username or password")
+	}
+	if user == nil || err != nil {
 		return nil, errors.New("incorrect username or password")
 	}
+	if _, err = ls.Succeed(parts[0]); err != nil {
+		logging.Error.Printf("Error clearing failed logins: %s", err.Error())
+	}
+
+	if len(parts) == 1 {
+		return authorizeSFTPServers(db, user)
+	}
+	return authorizeSFTP(db, user, parts[1])
+}
+
+// ValidateKey checks the public key is registered to the user, and they have SFTP access to the server.
+// The key is only recorded as used once the client has proven it holds the private key, see KeyUsed.
+func (s *DatabaseSFTPAuthorization) ValidateKey(username string, key ssh.PublicKey) (perms *ssh.Permissions, err error) {
+	parts := strings.Split(username, "#")
+	if len(parts) > 2 {
+		return nil, errors.New("incorrect username or key")
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		return nil, pufferpanel.ErrDatabaseNotAvailable
+	}
+
+	user, err := ValidateSSHKey(db, parts[0], key)
+	if err != nil {
+		return nil, errors.New("incorrect username or key")
+	}
+
+	if len(parts) == 1 {
+		perms, err = authorizeSFTPServers(db, user)
+	} else {
+		perms, err = authorizeSFTP(db, user, parts[1])
+	}
+	if err != nil {
+		return nil, errors.New("incorrect username or key")
+	}
+	perms.Extensions["key_fingerprint"] = ssh.FingerprintSHA256(key)
+	return perms, nil
+}
+
+func (s *DatabaseSFTPAuthorization) KeyUsed(fingerprint string, addr net.Addr) {
+	db, err := database.GetConnection()
+	if err != nil {
+		return
+	}
+
+	ks := &SSHKey{DB: db}
+	key, err := ks.GetByFingerprint(fingerprint)
+	if err != nil {
+		return
+	}
+
+	if err = ks.MarkUsed(key, addrIP(addr)); err != nil {
+		logging.Error.Printf("Error recording SSH key use: %s", err)
+	}
+}
+
+// ValidateSSHKey gets the user with the given email, if the key is registered to them
+func ValidateSSHKey(db *gorm.DB, email string, key ssh.PublicKey) (*models.User, error) {
+	ks := &SSHKey{DB: db}
+	registered, err := ks.GetByFingerprint(ssh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if registered.User == nil || !strings.EqualFold(registered.User.Email, email) {
+		return nil, pufferpanel.ErrInvalidCredentials
+	}
+	return registered.User, nil
+}
+
+// SFTPAccess is what a user is allowed to do over SFTP to a server
+type SFTPAccess struct {
+	ReadOnly bool
+	Paths    []string
+}
+
+// GetSFTPAccess works out if the user can use SFTP on the server, and what they are limited to.
+// Access granted globally applies to every server.
+func GetSFTPAccess(db *gorm.DB, userId uint, serverId string) (*SFTPAccess, error) {
+	ps := &Permission{DB: db}
+	serverPerms, err := ps.GetForUserAndServer(userId, serverId)
+	if err != nil {
+		return nil, err
+	}
+	globalPerms, err := ps.GetForUserAndServer(userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	allScopes := append(serverPerms.EffectiveScopes(), globalPerms.EffectiveScopes()...)
+
+	access := &SFTPAccess{}
+	if !scopes.ContainsScope(allScopes, scopes.ScopeServerSftp) {
+		if !scopes.ContainsScope(allScopes, scopes.ScopeServerSftpReadOnly) {
+			return nil, pufferpanel.ErrNoPermission
+		}
+		access.ReadOnly = true
+	}
+
+	access.Paths, err = ps.GetPathRestrictions(userId, serverId)
+	if err != nil {
+		return nil, err
+	}
+	return access, nil
+}
 
-	ss := &Permission{DB: db}
-	serverPerms, err := ss.GetForUserAndServer(user.ID, serverId)
+// SFTPServer is a server a user can reach over SFTP, and what they can do with it
+type SFTPServer struct {
+	Server *models.Server
+	Access *SFTPAccess
+}
+
+// GetSFTPServers gets every server the user can use SFTP on.
+// Users with SFTP access granted globally can reach every server.
+func GetSFTPServers(db *gorm.DB, userId uint) ([]*SFTPServer, error) {
+	ps := &Permission{DB: db}
+	globalPerms, err := ps.GetForUserAndServer(userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*models.Server
+	query := db.Preload(clause.Associations).Order("servers.name")
+	if !scopes.ContainsScope(globalPerms.EffectiveScopes(), scopes.ScopeServerSftp) && !scopes.ContainsScope(globalPerms.EffectiveScopes(), scopes.ScopeServerSftpReadOnly) {
+		query = query.Joins("JOIN permissions p ON servers.identifier = p.server_identifier").Where("p.user_id = ?", userId)
+	}
+	err = query.Find(&candidates).Error
 	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*SFTPServer, 0)
+	for _, v := range candidates {
+		access, err := GetSFTPAccess(db, userId, v.Identifier)
+		if errors.Is(err, pufferpanel.ErrNoPermission) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, &SFTPServer{Server: v, Access: access})
+	}
+	return result, nil
+}
+
+// ToSFTPServerAccess converts the servers so they can be given to the SFTP server
+func ToSFTPServerAccess(sftpServers []*SFTPServer) []pufferpanel.SFTPServerAccess {
+	result := make([]pufferpanel.SFTPServerAccess, 0, len(sftpServers))
+	for _, v := range sftpServers {
+		result = append(result, pufferpanel.SFTPServerAccess{
+			ServerId: v.Server.Identifier,
+			Name:     v.Server.Name,
+			ReadOnly: v.Access.ReadOnly,
+			Paths:    v.Access.Paths,
+		})
+	}
+	return result
+}
+
+func authorizeSFTPServers(db *gorm.DB, user *models.User) (*ssh.Permissions, error) {
+	sftpServers, err := GetSFTPServers(db, user.ID)
+	if err != nil || len(sftpServers) == 0 {
 		return nil, errors.New("incorrect username or password")
 	}
 
-	if !scopes.ContainsScope(serverPerms.Scopes, scopes.ScopeServerSftp) {
+	data, err := json.Marshal(ToSFTPServerAccess(sftpServers))
+	if err != nil {
+		return nil, err
+	}
+
+	perms := &ssh.Permissions{}
+	perms.Extensions = make(map[string]string)
+	perms.Extensions["servers"] = string(data)
+	return perms, nil
+}
+
+func authorizeSFTP(db *gorm.DB, user *models.User, serverId string) (*ssh.Permissions, error) {
+	access, err := GetSFTPAccess(db, user.ID, serverId)
+	if err != nil {
 		return nil, errors.New("incorrect username or password")
 	}
 
-	perms = &ssh.Permissions{}
+	perms := &ssh.Permissions{}
 	perms.Extensions = make(map[string]string)
 	perms.Extensions["server_id"] = serverId
+	if access.ReadOnly {
+		perms.Extensions["read_only"] = "true"
+	}
+	if len(access.Paths) > 0 {
+		perms.Extensions["paths"] = strings.Join(access.Paths, "\n")
+	}
 	return perms, nil
 }
+
+// addrIP gets the IP of a remote address, without the port
+func addrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type SSHKey struct {
+	DB *gorm.DB
+}
+
+// GetForUser Gets all keys registered to a user
+func (s *SSHKey) GetForUser(userId uint) ([]*models.SSHKey, error) {
+	var keys []*models.SSHKey
+	err := s.DB.Where(&models.SSHKey{UserId: userId}).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// Get Gets a key, only if it belongs to the user
+func (s *SSHKey) Get(userId, id uint) (*models.SSHKey, error) {
+	key := &models.SSHKey{}
+	err := s.DB.Where(&models.SSHKey{ID: id, UserId: userId}).First(key).Error
+	return key, err
+}
+
+// GetByFingerprint Gets the key with the given SHA256 fingerprint, including the user it belongs to
+func (s *SSHKey) GetByFingerprint(fingerprint string) (*models.SSHKey, error) {
+	key := &models.SSHKey{}
+	err := s.DB.Preload("User").Where(&models.SSHKey{Fingerprint: fingerprint}).First(key).Error
+	return key, err
+}
+
+// Create Registers a public key, in authorized_keys format, to the user
+func (s *SSHKey) Create(userId uint, name, publicKey string) (*models.SSHKey, error) {
+	parsed, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, pufferpanel.ErrSSHKeyInvalid
+	}
+
+	if name == "" {
+		name = comment
+	}
+
+	key := &models.SSHKey{
+		UserId:      userId,
+		Name:        name,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(parsed))),
+		Fingerprint: ssh.FingerprintSHA256(parsed),
+	}
+
+	_, err = s.GetByFingerprint(key.Fingerprint)
+	if err == nil {
+		return nil, pufferpanel.ErrSSHKeyExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	err = s.DB.Create(key).Error
+	return key, err
+}
+
+// Delete Revokes a key, only if it belongs to the user
+func (s *SSHKey) Delete(userId, id uint) error {
+	res := s.DB.Where(&models.SSHKey{UserId: userId}).Delete(&models.SSHKey{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// MarkUsed Records when and where a key was last used to log in
+func (s *SSHKey) MarkUsed(key *models.SSHKey, ip string) error {
+	now := time.Now()
+	key.LastUsed = &now
+	key.LastUsedIP = ip
+	return s.DB.Model(&models.SSHKey{}).Where("id = ?", key.ID).UpdateColumns(map[string]interface{}{
+		"last_used":    now,
+		"last_used_ip": ip,
+	}).Error
+}
@@ -10,14 +10,35 @@ import (
 	"github.com/MicahParks/keyfunc/v3"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/pufferpanel/pufferpanel/v3/config"
+	"slices"
 	"sync"
+	"time"
 )
 
 type TokenService interface {
 	GetKeyFunc() jwt.Keyfunc
 	GetTokenStore() jwkset.Storage
 	GenerateRequest() (string, error)
-	ValidateRequest(string) error
+	GenerateServerRequest(serverId string, routes []string, expiry time.Duration) (string, error)
+	ValidateRequest(string) (*RequestClaims, error)
+}
+
+// RequestClaims are what a token to a daemon can be used for. Tokens the panel uses itself have none, so can be used
+// for anything.
+type RequestClaims struct {
+	jwt.RegisteredClaims
+	//Server is the only server the token can be used for
+	Server string `json:"server,omitempty"`
+	//Routes are the only requests the token can be used for, as the method and the route the daemon registered
+	Routes []string `json:"routes,omitempty"`
+}
+
+// Allows is whether the token can be used for the request
+func (rc *RequestClaims) Allows(method, route, serverId string) bool {
+	if rc.Server == "" && len(rc.Routes) == 0 {
+		return true
+	}
+	return rc.Server == serverId && slices.Contains(rc.Routes, method+" "+route)
 }
 
 type tokenService struct{}
@@ -104,9 +125,21 @@ func NewTokenService() (TokenService, error) {
 }
 
 func (ts *tokenService) GenerateRequest() (string, error) {
-	token := jwt.New(jwt.SigningMethodEdDSA)
+	return ts.sign(&RequestClaims{})
+}
+
+// GenerateServerRequest Generates a token which can only be used for the given routes of the server, until it expires
+func (ts *tokenService) GenerateServerRequest(serverId string, routes []string, expiry time.Duration) (string, error) {
+	return ts.sign(&RequestClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry))},
+		Server:           serverId,
+		Routes:           routes,
+	})
+}
+
+func (ts *tokenService) sign(claims *RequestClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
 	token.Header[jwkset.HeaderKID] = keyId
-	token.Claims = jwt.MapClaims{}
 
 	signed, err := token.SignedString(privateKey)
 	if err != nil {
@@ -115,15 +148,16 @@ func (ts *tokenService) GenerateRequest() (string, error) {
 	return signed, nil
 }
 
-func (ts *tokenService) ValidateRequest(token string) error {
-	parsed, err := jwt.Parse(token, ts.GetKeyFunc())
+func (ts *tokenService) ValidateRequest(token string) (*RequestClaims, error) {
+	claims := &RequestClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, ts.GetKeyFunc())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !parsed.Valid {
-		return jwt.ErrTokenSignatureInvalid
+		return nil, jwt.ErrTokenSignatureInvalid
 	}
-	return nil
+	return claims, nil
 }
 
 func (ts *tokenService) GetKeyFunc() jwt.Keyfunc {
@@ -32,7 +32,7 @@ func Test_tokenService_GenerateRequest(t *testing.T) {
 			}
 
 			//now check if we can decrypt it
-			err = ts.ValidateRequest(got)
+			_, err = ts.ValidateRequest(got)
 			if !assert.NoError(t, err) {
 				return
 			}
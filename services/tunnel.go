@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/tunnel"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// nodeTunnel is the connection a node keeps open to the panel, with the client used to send requests over it
+type nodeTunnel struct {
+	session *tunnel.Session
+	client  *http.Client
+}
+
+// tunnels holds the nodes connected through a tunnel, by their id
+var tunnels sync.Map
+
+// AddTunnel Sends requests for the node over the session from now on, closing any session the node had before
+func AddTunnel(node *models.Node, session *tunnel.Session) {
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		return session.Open()
+	}
+	t := &nodeTunnel{
+		session: session,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:         dial,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+
+	if old, loaded := tunnels.Swap(node.ID, t); loaded {
+		_ = old.(*nodeTunnel).session.Close()
+	}
+	logging.Info.Printf("Node %s connected through tunnel from %s", node.Name, session.Addr())
+
+	go func() {
+		<-session.Done()
+		if tunnels.CompareAndDelete(node.ID, t) {
+			logging.Info.Printf("Tunnel of node %s closed", node.Name)
+		}
+	}()
+}
+
+// CloseTunnel Disconnects the node if it's connected through a tunnel
+func CloseTunnel(nodeId uint) {
+	if t, loaded := tunnels.LoadAndDelete(nodeId); loaded {
+		_ = t.(*nodeTunnel).session.Close()
+	}
+}
+
+// IsTunnelConnected Gets if the node is connected through its tunnel right now
+func IsTunnelConnected(nodeId uint) bool {
+	_, exists := tunnels.Load(nodeId)
+	return exists
+}
+
+// getTunnel gets the tunnel requests to the node go through, which is nil for nodes reached directly
+func getTunnel(node *models.Node) (*nodeTunnel, error) {
+	if !node.Tunnel || node.IsLocal() {
+		return nil, nil
+	}
+	t, exists := tunnels.Load(node.ID)
+	if !exists {
+		return nil, pufferpanel.ErrNodeNotConnected
+	}
+	return t.(*nodeTunnel), nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/pquerna/otp/totp"
 	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/models"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -58,6 +59,34 @@ func (us *User) ValidateLogin(email string, password string) (user *models.User,
 		return
 	}
 
+	if LDAPEnabled() {
+		var managed bool
+		if user.ID != 0 {
+			if managed, err = IsLDAPUser(us.DB, user.ID); err != nil {
+				return
+			}
+		}
+
+		ldapService := &LDAP{DB: us.DB}
+		var ldapUser *models.User
+		ldapUser, err = ldapService.Authenticate(email, password)
+		if err == nil {
+			user = ldapUser
+			otpNeeded, err = us.SecondFactorNeeded(user)
+			return
+		}
+		if !errors.Is(err, pufferpanel.ErrInvalidCredentials) {
+			logging.Error.Printf("Error authenticating with LDAP: %s", err.Error())
+		}
+
+		//users from LDAP never fall back to the password stored in the panel, only local users do
+		if managed || user.ID == 0 {
+			err = pufferpanel.ErrInvalidCredentials
+			return
+		}
+		err = nil
+	}
+
 	if user.ID == 0 || errors.Is(err, gorm.ErrRecordNotFound) {
 		err = pufferpanel.ErrInvalidCredentials
 		return
@@ -68,11 +97,70 @@ func (us *User) ValidateLogin(email string, password string) (user *models.User,
 		return
 	}
 
+	otpNeeded, err = us.SecondFactorNeeded(user)
+	return
+}
+
+// SecondFactorNeeded Checks if the user has any second factor which has to be given after their password
+func (us *User) SecondFactorNeeded(user *models.User) (bool, error) {
 	if user.OtpActive {
-		otpNeeded = true
-		return
+		return true, nil
 	}
-	return
+	ws := &WebAuthn{DB: us.DB}
+	return ws.HasCredentials(user.ID)
+}
+
+// SecondFactors Gets which second factors the user is able to log in with
+func (us *User) SecondFactors(user *models.User) ([]string, error) {
+	factors := make([]string, 0)
+	if user.OtpActive {
+		factors = append(factors, "otp")
+	}
+
+	ws := &WebAuthn{DB: us.DB}
+	if has, err := ws.HasCredentials(user.ID); err != nil {
+		return nil, err
+	} else if has {
+		factors = append(factors, "webauthn")
+	}
+
+	if len(factors) > 0 {
+		rs := &RecoveryCode{DB: us.DB}
+		if remaining, err := rs.Remaining(user.ID); err != nil {
+			return nil, err
+		} else if remaining > 0 {
+			factors = append(factors, "recovery")
+		}
+	}
+	return factors, nil
+}
+
+// ResetSecondFactors Removes every second factor of the user, for when they have lost access to all of them
+func (us *User) ResetSecondFactors(userId uint) error {
+	return us.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", userId).UpdateColumns(map[string]interface{}{"otp_secret": "", "otp_active": false}).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where("user_id = ?", userId).Delete(&models.WebAuthnCredential{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RemoveRecoveryCodesIfUnneeded Recovery codes only exist to get past a second factor, so once the last one is
+// removed the codes go with it
+func (us *User) RemoveRecoveryCodesIfUnneeded(userId uint) error {
+	user, err := us.GetById(userId)
+	if err != nil {
+		return err
+	}
+	needed, err := us.SecondFactorNeeded(user)
+	if err != nil || needed {
+		return err
+	}
+	return us.DB.Where("user_id = ?", userId).Delete(&models.RecoveryCode{}).Error
 }
 
 func (us *User) ValidOtp(email string, token string) (user *models.User, err error) {
@@ -91,7 +179,7 @@ func (us *User) ValidOtp(email string, token string) (user *models.User, err err
 		return
 	}
 
-	if !totp.Validate(token, user.OtpSecret) {
+	if !user.OtpActive || !totp.Validate(token, user.OtpSecret) {
 		err = pufferpanel.ErrInvalidCredentials
 		return
 	}
@@ -121,9 +209,22 @@ func (us *User) Update(model *models.User) error {
 
 func (us *User) Delete(model *models.User) (err error) {
 	return us.DB.Transaction(func(tx *gorm.DB) error {
+		_ = deletePermissionRoles(tx, "user_id = ?", model.ID)
 		tx.Delete(models.Permissions{}, "user_id = ?", model.ID)
+		//their clients may have been given access by other users too
+		clients := tx.Session(&gorm.Session{NewDB: true}).Model(&models.Client{}).Select("id").Where("user_id = ?", model.ID)
+		tx.Delete(models.AuthorizationCode{}, "user_id = ? OR client_id IN (?)", model.ID, clients)
+		tx.Delete(models.RefreshToken{}, "user_id = ? OR client_id IN (?)", model.ID, clients)
+		tx.Delete(models.Session{}, "client_id IN (?)", clients)
 		tx.Delete(models.Client{}, "user_id = ?", model.ID)
 		tx.Delete(models.Session{}, "user_id = ?", model.ID)
+		tx.Delete(models.SSHKey{}, "user_id = ?", model.ID)
+		tx.Delete(models.APIToken{}, "user_id = ?", model.ID)
+		tx.Delete(models.PasswordReset{}, "user_id = ?", model.ID)
+		tx.Delete(models.OIDCIdentity{}, "user_id = ?", model.ID)
+		tx.Delete(models.LDAPIdentity{}, "user_id = ?", model.ID)
+		tx.Delete(models.WebAuthnCredential{}, "user_id = ?", model.ID)
+		tx.Delete(models.RecoveryCode{}, "user_id = ?", model.ID)
 		tx.Delete(models.User{}, "id = ?", model.ID)
 		return nil
 	})
@@ -218,7 +319,10 @@ func (us *User) DisableOtp(userId uint, token string) error {
 
 	user.OtpSecret = ""
 	user.OtpActive = false
-	return us.Update(user)
+	if err = us.Update(user); err != nil {
+		return err
+	}
+	return us.RemoveRecoveryCodesIfUnneeded(userId)
 }
 
 func (us *User) Search(usernameFilter, emailFilter string, pageSize, page uint) ([]*models.User, int64, error) {
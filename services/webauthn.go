@@ -0,0 +1,292 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"gorm.io/gorm"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type WebAuthn struct {
+	DB *gorm.DB
+}
+
+// ceremonies in progress, by user, as a user only ever does one registration or login at a time
+var webauthnRegistrations = make(map[uint]*webauthn.SessionData)
+var webauthnLogins = make(map[uint]*webauthn.SessionData)
+var webauthnLock sync.Mutex
+
+type webauthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (w *webauthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(w.user.ID), 10))
+}
+
+func (w *webauthnUser) WebAuthnName() string {
+	return w.user.Email
+}
+
+func (w *webauthnUser) WebAuthnDisplayName() string {
+	return w.user.Username
+}
+
+func (w *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return w.credentials
+}
+
+// newWebAuthn The relying party is the panel itself, so it is whatever the master url is set to
+func newWebAuthn() (*webauthn.WebAuthn, error) {
+	u, err := url.Parse(config.MasterUrl.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    5 * time.Minute,
+		TimeoutUVD: 5 * time.Minute,
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: config.CompanyName.Value(),
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+}
+
+func (ws *WebAuthn) GetForUser(userId uint) ([]*models.WebAuthnCredential, error) {
+	var credentials []*models.WebAuthnCredential
+	err := ws.DB.Where(&models.WebAuthnCredential{UserId: userId}).Order("id").Find(&credentials).Error
+	return credentials, err
+}
+
+func (ws *WebAuthn) HasCredentials(userId uint) (bool, error) {
+	var count int64
+	err := ws.DB.Model(&models.WebAuthnCredential{}).Where(&models.WebAuthnCredential{UserId: userId}).Count(&count).Error
+	return count > 0, err
+}
+
+// BeginRegistration Creates the options the browser needs to register a new security key for the user
+func (ws *WebAuthn) BeginRegistration(user *models.User) (*protocol.CredentialCreation, error) {
+	w, err := newWebAuthn()
+	if err != nil {
+		return nil, err
+	}
+
+	wu, _, err := ws.getUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	//keys which are already registered shouldn't be registered again
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(wu.credentials))
+	for _, v := range wu.credentials {
+		exclusions = append(exclusions, v.Descriptor())
+	}
+
+	creation, session, err := w.BeginRegistration(wu, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, err
+	}
+
+	webauthnLock.Lock()
+	webauthnRegistrations[user.ID] = session
+	webauthnLock.Unlock()
+
+	return creation, nil
+}
+
+// FinishRegistration Validates the response of the browser to the registration and saves the key
+func (ws *WebAuthn) FinishRegistration(user *models.User, name string, response []byte) (*models.WebAuthnCredential, error) {
+	webauthnLock.Lock()
+	session := webauthnRegistrations[user.ID]
+	delete(webauthnRegistrations, user.ID)
+	webauthnLock.Unlock()
+
+	if session == nil {
+		return nil, pufferpanel.ErrInvalidSession
+	}
+
+	w, err := newWebAuthn()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		logging.Debug.Printf("Error parsing webauthn registration: %s", err.Error())
+		return nil, pufferpanel.ErrWebAuthnFailed
+	}
+
+	wu, _, err := ws.getUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := w.CreateCredential(wu, *session, parsed)
+	if err != nil {
+		logging.Debug.Printf("Error validating webauthn registration: %s", err.Error())
+		return nil, pufferpanel.ErrWebAuthnFailed
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &models.WebAuthnCredential{
+		UserId:       user.ID,
+		Name:         name,
+		CredentialId: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Credential:   string(data),
+	}
+
+	var count int64
+	err = ws.DB.Model(&models.WebAuthnCredential{}).Where(&models.WebAuthnCredential{CredentialId: model.CredentialId}).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, pufferpanel.ErrWebAuthnExists
+	}
+
+	err = ws.DB.Create(model).Error
+	return model, err
+}
+
+// BeginLogin Creates the challenge the browser needs to sign with one of the user's security keys
+func (ws *WebAuthn) BeginLogin(user *models.User) (*protocol.CredentialAssertion, error) {
+	w, err := newWebAuthn()
+	if err != nil {
+		return nil, err
+	}
+
+	wu, _, err := ws.getUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wu.credentials) == 0 {
+		return nil, pufferpanel.ErrWebAuthnFailed
+	}
+
+	assertion, session, err := w.BeginLogin(wu)
+	if err != nil {
+		return nil, err
+	}
+
+	webauthnLock.Lock()
+	webauthnLogins[user.ID] = session
+	webauthnLock.Unlock()
+
+	return assertion, nil
+}
+
+// FinishLogin Validates the signed challenge from the browser, keeping track of the key's counter so cloned
+// keys can be caught.
+func (ws *WebAuthn) FinishLogin(user *models.User, response []byte, ip string) error {
+	webauthnLock.Lock()
+	session := webauthnLogins[user.ID]
+	delete(webauthnLogins, user.ID)
+	webauthnLock.Unlock()
+
+	if session == nil {
+		return pufferpanel.ErrInvalidSession
+	}
+
+	w, err := newWebAuthn()
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		logging.Debug.Printf("Error parsing webauthn login: %s", err.Error())
+		return pufferpanel.ErrWebAuthnFailed
+	}
+
+	wu, records, err := ws.getUser(user)
+	if err != nil {
+		return err
+	}
+
+	credential, err := w.ValidateLogin(wu, *session, parsed)
+	if err != nil {
+		logging.Debug.Printf("Error validating webauthn login: %s", err.Error())
+		return pufferpanel.ErrWebAuthnFailed
+	}
+
+	if credential.Authenticator.CloneWarning {
+		logging.Error.Printf("Security key for user %d may have been cloned, refusing login", user.ID)
+		return pufferpanel.ErrWebAuthnFailed
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	for _, record := range records {
+		if record.CredentialId != id {
+			continue
+		}
+
+		data, err := json.Marshal(credential)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		record.Credential = string(data)
+		record.LastUsed = &now
+		record.LastUsedIP = ip
+		return ws.DB.Save(record).Error
+	}
+
+	return pufferpanel.ErrWebAuthnFailed
+}
+
+func (ws *WebAuthn) Get(userId, id uint) (*models.WebAuthnCredential, error) {
+	credential := &models.WebAuthnCredential{}
+	err := ws.DB.Where(&models.WebAuthnCredential{ID: id, UserId: userId}).First(credential).Error
+	return credential, err
+}
+
+func (ws *WebAuthn) Delete(userId, id uint) error {
+	res := ws.DB.Where(&models.WebAuthnCredential{ID: id, UserId: userId}).Delete(&models.WebAuthnCredential{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (ws *WebAuthn) getUser(user *models.User) (*webauthnUser, []*models.WebAuthnCredential, error) {
+	records, err := ws.GetForUser(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wu := &webauthnUser{user: user, credentials: make([]webauthn.Credential, 0, len(records))}
+	for _, v := range records {
+		var credential webauthn.Credential
+		if err = json.Unmarshal([]byte(v.Credential), &credential); err != nil {
+			return nil, nil, err
+		}
+		wu.credentials = append(wu.credentials, credential)
+	}
+	return wu, records, nil
+}
@@ -3,55 +3,109 @@ package sftp
 import (
 	"fmt"
 	"github.com/pkg/sftp"
+	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/files"
+	"github.com/pufferpanel/pufferpanel/v3/servers"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 type requestPrefix struct {
-	fs files.FileServer
+	server   *servers.Server
+	fs       files.FileServer
+	readOnly bool
+	allowed  []string
+	record   func(entry pufferpanel.SFTPLogEntry)
 }
 
-func CreateRequestPrefix(fs files.FileServer) sftp.Handlers {
-	h := requestPrefix{fs: fs}
+// CreateRequestPrefix serves the files of the server, optionally refusing any changes and limiting access to the allowed
+// paths. Changes made are given to record, if set.
+func CreateRequestPrefix(server *servers.Server, readOnly bool, allowed []string, record func(entry pufferpanel.SFTPLogEntry)) sftp.Handlers {
+	h := requestPrefix{server: server, fs: server.GetFileServer(), readOnly: readOnly, allowed: files.NormalizeRestrictions(allowed), record: record}
 
 	return sftp.Handlers{FileCmd: h, FileGet: h, FileList: h, FilePut: h}
 }
 
 func (rp requestPrefix) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	rp.log(request)
-
-	file, err := rp.getFile(request.Filepath, os.O_RDONLY, 0644)
-	return file, err
+	return rp.read(request.Filepath)
 }
 
 func (rp requestPrefix) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	rp.log(request)
-
-	file, err := rp.getFile(request.Filepath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
-	return file, err
+	return rp.write(request.Filepath)
 }
 
 func (rp requestPrefix) Filecmd(request *sftp.Request) error {
 	rp.log(request)
+	return rp.cmd(request.Method, request.Filepath, request.Target)
+}
+
+func (rp requestPrefix) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	rp.log(request)
+	return rp.list(request.Method, request.Filepath)
+}
+
+func (rp requestPrefix) read(path string) (io.ReaderAt, error) {
+	if !files.PathAllowed(path, rp.allowed) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	file, err := rp.getFile(path, os.O_RDONLY, 0644)
+	return file, err
+}
+
+func (rp requestPrefix) write(path string) (io.WriterAt, error) {
+	if rp.readOnly || !files.PathAllowed(path, rp.allowed) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	//changes made while the server is copied to another node would be lost
+	if rp.server.IsMigrating() {
+		return nil, pufferpanel.ErrServerMigrating
+	}
 
-	switch request.Method {
+	//writes are tracked so they count against the server's quota
+	file, err := rp.getTrackedFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedWriter{file: file, path: path, rp: rp}, nil
+}
+
+func (rp requestPrefix) cmd(method, path, target string) error {
+	if rp.readOnly || !files.PathAllowed(path, rp.allowed) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if method == "Rename" && !files.PathAllowed(target, rp.allowed) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if rp.server.IsMigrating() {
+		return pufferpanel.ErrServerMigrating
+	}
+
+	var err error
+	var action string
+	switch method {
 	case "SetStat", "Setstat":
 		{
 			return nil
 		}
 	case "Rename":
 		{
-			return rp.fs.Rename(request.Filepath, request.Target)
+			action = "rename"
+			err = rp.fs.Rename(path, target)
 		}
 	case "Rmdir":
 		{
-			return rp.fs.RemoveAll(request.Filepath)
+			action = "delete"
+			err = rp.fs.RemoveAll(path)
 		}
 	case "Mkdir":
 		{
-			return rp.fs.Mkdir(request.Filepath, 0755)
+			action = "mkdir"
+			err = rp.fs.Mkdir(path, 0755)
 		}
 	case "Symlink":
 		{
@@ -59,29 +113,46 @@ func (rp requestPrefix) Filecmd(request *sftp.Request) error {
 		}
 	case "Remove":
 		{
-			return rp.fs.Remove(request.Filepath)
+			action = "delete"
+			err = rp.fs.Remove(path)
 		}
 	default:
-		return fmt.Errorf("unknown request method: %v", request.Method)
+		return fmt.Errorf("unknown request method: %v", method)
+	}
+
+	if err == nil {
+		entry := pufferpanel.SFTPLogEntry{Action: action, Path: path}
+		if method == "Rename" {
+			entry.Target = target
+		}
+		rp.recordEntry(entry)
 	}
+	return err
 }
 
-func (rp requestPrefix) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
-	rp.log(request)
+func (rp requestPrefix) list(method, path string) (sftp.ListerAt, error) {
+	//folders leading to allowed paths can be looked at, but only show the way there
+	if !files.PathVisible(path, rp.allowed) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
 
-	switch request.Method {
+	switch method {
 	case "List":
 		{
-			files, err := rp.fs.ReadDir(request.Filepath)
+			entries, err := rp.fs.ReadDir(path)
 			if err != nil {
 				return nil, err
 			}
 
-			return toListerAt(rp.fs, request.Filepath, files), nil
+			result := toListerAt(rp.fs, path, entries)
+			if !files.PathAllowed(path, rp.allowed) {
+				result = result.visible(path, rp.allowed)
+			}
+			return result, nil
 		}
 	case "Stat":
 		{
-			file, err := rp.getFile(request.Filepath, os.O_RDONLY, 0644)
+			file, err := rp.getFile(path, os.O_RDONLY, 0644)
 			if err != nil {
 				return nil, err
 			}
@@ -97,7 +168,7 @@ func (rp requestPrefix) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 		}
 	case "Readlink":
 		{
-			file, err := rp.fs.Open(request.Filepath)
+			file, err := rp.fs.Open(path)
 			if err != nil {
 				return nil, err
 			}
@@ -112,7 +183,13 @@ func (rp requestPrefix) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 			return listerat([]os.FileInfo{fi}), nil
 		}
 	default:
-		return nil, fmt.Errorf("unknown request method: %s", request.Method)
+		return nil, fmt.Errorf("unknown request method: %s", method)
+	}
+}
+
+func (rp requestPrefix) recordEntry(entry pufferpanel.SFTPLogEntry) {
+	if rp.record != nil {
+		rp.record(entry)
 	}
 }
 
@@ -121,16 +198,9 @@ func (rp requestPrefix) log(request *sftp.Request) {
 }
 
 func (rp requestPrefix) getFile(path string, flags int, mode os.FileMode) (*os.File, error) {
-	//if this is a file create, then ensure the folder path exists
-	if flags&os.O_CREATE != 0 {
-		_, err := rp.fs.Stat(path)
-		if os.IsNotExist(err) {
-			err = nil
-			err = rp.fs.MkdirAll(filepath.Dir(path), 0755)
-			if err != nil {
-				return nil, err
-			}
-		}
+	err := rp.createParent(path, flags)
+	if err != nil {
+		return nil, err
 	}
 
 	file, err := rp.fs.OpenFile(path, flags, mode)
@@ -142,6 +212,51 @@ func (rp requestPrefix) getFile(path string, flags int, mode os.FileMode) (*os.F
 	return file, err
 }
 
+func (rp requestPrefix) getTrackedFile(path string, flags int, mode os.FileMode) (*files.QuotaFile, error) {
+	err := rp.createParent(path, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return rp.fs.OpenTracked(path, flags, mode)
+}
+
+// createParent ensures the folder path exists if this is a file create
+func (rp requestPrefix) createParent(path string, flags int) error {
+	if flags&os.O_CREATE == 0 {
+		return nil
+	}
+
+	_, err := rp.fs.Stat(path)
+	if os.IsNotExist(err) {
+		return rp.fs.MkdirAll(filepath.Dir(path), 0755)
+	}
+	return nil
+}
+
+// recordedWriter counts what is written to a file, so the write can be logged once the client is done with it
+type recordedWriter struct {
+	file    *files.QuotaFile
+	path    string
+	rp      requestPrefix
+	written int64
+	lock    sync.Mutex
+}
+
+func (w *recordedWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.file.WriteAt(p, off)
+	w.lock.Lock()
+	w.written += int64(n)
+	w.lock.Unlock()
+	return n, err
+}
+
+func (w *recordedWriter) Close() error {
+	err := w.file.Close()
+	w.rp.recordEntry(pufferpanel.SFTPLogEntry{Action: "write", Path: w.path, Bytes: w.written})
+	return err
+}
+
 type listerat []os.FileInfo
 
 func toListerAt(fs files.FileServer, root string, entries []os.DirEntry) listerat {
@@ -157,6 +272,17 @@ func toListerAt(fs files.FileServer, root string, entries []os.DirEntry) listera
 	return result
 }
 
+// visible filters out anything in the folder which does not lead to an allowed path
+func (f listerat) visible(root string, allowed []string) listerat {
+	result := listerat{}
+	for _, v := range f {
+		if files.PathVisible(filepath.Join(root, v.Name()), allowed) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
 // ListAt Modeled after strings.Reader's ReadAt() implementation
 func (f listerat) ListAt(ls []os.FileInfo, offset int64) (int, error) {
 	var n int
@@ -0,0 +1,264 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rsyncShortOptions are the single letter options the server side of rsync may be given.
+// Anything which follows links out of the server, or hides the arguments from us, is left out.
+const rsyncShortOptions = "vqcrlptgoDzSWInuHdREmOJiCyxXAUNb"
+
+// rsyncQuotaInterval is how often the usage of a server with a quota is looked at while rsync writes to it
+const rsyncQuotaInterval = 2 * time.Second
+
+// rsyncLongOptions are the long options the server side of rsync may be given, those ending in = take a value
+var rsyncLongOptions = []string{
+	"--sender", "--delete", "--del", "--delete-before", "--delete-during", "--delete-delay", "--delete-after",
+	"--delete-excluded", "--partial", "--inplace", "--append", "--append-verify", "--numeric-ids",
+	"--size-only", "--ignore-existing", "--existing", "--remove-source-files", "--safe-links",
+	"--delay-updates", "--force", "--ignore-errors", "--no-implied-dirs", "--mkpath",
+	"--timeout=", "--bwlimit=", "--log-format=", "--out-format=", "--modify-window=",
+	"--compress-level=", "--max-size=", "--min-size=", "--max-delete=", "--checksum-choice=", "--compress-choice=",
+}
+
+// rsync runs the server side of rsync in the server's folder, after checking it was not asked to do anything
+// it should not. The login has to be for a single server, as rsync works on the real files.
+func (s *session) rsync(channel ssh.Channel, args []string) error {
+	if !config.SftpRsync.Value() {
+		return errors.New("rsync is disabled on this node")
+	}
+	if s.server == nil {
+		return errors.New("rsync can only be used when logged in to a single server")
+	}
+
+	sender, paths, err := parseRsyncArgs(args)
+	if err != nil {
+		return err
+	}
+	if s.readOnly && (!sender || rsyncChangesSender(args)) {
+		return errors.New("rsync: permission denied, access is read only")
+	}
+	if s.server.IsMigrating() && (!sender || rsyncChangesSender(args)) {
+		return pufferpanel.ErrServerMigrating
+	}
+
+	root := s.server.RunningEnvironment.GetRootDirectory()
+	for i, v := range paths {
+		cleaned := path.Clean("/" + v)
+		if !files.PathAllowed(cleaned, s.allowed) {
+			return fmt.Errorf("rsync: %s: permission denied", v)
+		}
+		if err = checkRsyncPath(root, cleaned); err != nil {
+			return err
+		}
+		//rsync treats a trailing slash as "the contents of", which has to be kept
+		relative := "." + cleaned
+		if strings.HasSuffix(v, "/") && !strings.HasSuffix(relative, "/") {
+			relative += "/"
+		}
+		paths[i] = relative
+	}
+
+	available := s.server.GetFileServer().GetQuota().Available()
+	if !sender && available == 0 {
+		return errors.New("rsync: server has no disk space left")
+	}
+
+	binary, err := exec.LookPath("rsync")
+	if err != nil {
+		return errors.New("rsync is not installed on this node")
+	}
+
+	cmdArgs := append([]string{}, args[:len(args)-len(paths)]...)
+	if !sender {
+		//the receiver must never create links which point out of the server
+		extra := []string{"--safe-links"}
+		if available > 0 {
+			//rsync writes around the quota, so no single file may be larger than what is left
+			extra = append(extra, "--max-size="+strconv.FormatInt(available, 10))
+		}
+		//these go last, before the dot which starts the paths, so they win over the same options given by the client
+		dot := len(cmdArgs) - 1
+		cmdArgs = append(append(cmdArgs[:dot:dot], extra...), ".")
+	}
+	cmdArgs = append(cmdArgs, paths...)
+
+	cmd := exec.Command(binary, cmdArgs...)
+	cmd.Dir = root
+	cmd.Env = []string{"HOME=" + root}
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+	if err = setRsyncUser(cmd, s.server.RunningEnvironment.GetUid(), s.server.RunningEnvironment.GetGid()); err != nil {
+		return err
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		_, _ = io.Copy(stdin, channel)
+		_ = stdin.Close()
+	}()
+
+	done := make(chan struct{})
+	if !sender && available > 0 {
+		go s.watchRsyncQuota(cmd, channel.Stderr(), done)
+	}
+	err = cmd.Wait()
+	close(done)
+
+	if !sender {
+		//rsync writes around the file server, so usage has to be worked out again
+		s.server.RecalculateUsage()
+		for _, v := range paths {
+			s.record(pufferpanel.SFTPLogEntry{Action: "rsync", Path: strings.TrimPrefix(v, ".")})
+		}
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			logging.Error.Printf("Error running rsync for %s: %s", s.server.Id(), err)
+		}
+	}
+	return err
+}
+
+// watchRsyncQuota stops rsync once the server goes over its quota, as many files which each fit can still add up to
+// more than is left
+func (s *session) watchRsyncQuota(cmd *exec.Cmd, stderr io.Writer, done chan struct{}) {
+	ticker := time.NewTicker(rsyncQuotaInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.server.RecalculateUsage()
+			if s.server.GetFileServer().GetQuota().Available() > 0 {
+				continue
+			}
+			_, _ = fmt.Fprintln(stderr, "rsync: server has no disk space left")
+			//rsync cleans up its partial files when asked to stop, which can't be done everywhere
+			if cmd.Process.Signal(syscall.SIGTERM) != nil {
+				_ = cmd.Process.Kill()
+			}
+			return
+		}
+	}
+}
+
+// rsyncChangesSender checks if the options change the files on the side sending them
+func rsyncChangesSender(args []string) bool {
+	for _, v := range args {
+		if v == "." {
+			break
+		}
+		if v == "--remove-source-files" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRsyncArgs checks the arguments rsync gave for its server side, and returns the paths it asked for
+func parseRsyncArgs(args []string) (sender bool, paths []string, err error) {
+	if len(args) == 0 || args[0] != "--server" {
+		return false, nil, errors.New("rsync: only the server side of rsync can be run")
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == ".":
+			//everything after the dot are the paths
+			paths = args[i+1:]
+			if len(paths) == 0 {
+				return false, nil, errors.New("rsync: no path given")
+			}
+			return sender, paths, nil
+		case arg == "--sender":
+			sender = true
+		case strings.HasPrefix(arg, "--"):
+			if !rsyncLongOptionAllowed(arg) {
+				return false, nil, fmt.Errorf("rsync: option %s is not allowed", arg)
+			}
+		case strings.HasPrefix(arg, "-"):
+			for _, c := range arg[1:] {
+				//e starts the protocol details, which are not options
+				if c == 'e' {
+					break
+				}
+				if !strings.ContainsRune(rsyncShortOptions, c) {
+					return false, nil, fmt.Errorf("rsync: option -%c is not allowed", c)
+				}
+			}
+		default:
+			return false, nil, fmt.Errorf("rsync: unexpected argument %s", arg)
+		}
+	}
+	return false, nil, errors.New("rsync: no path given")
+}
+
+func rsyncLongOptionAllowed(arg string) bool {
+	name, _, hasValue := strings.Cut(arg, "=")
+	for _, v := range rsyncLongOptions {
+		if hasValue && v == name+"=" {
+			return true
+		}
+		if !hasValue && v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRsyncPath makes sure the part of the path which exists does not lead out of the server through a link
+func checkRsyncPath(root, item string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	existing := filepath.Join(root, filepath.FromSlash(item))
+	for {
+		if _, err = os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if resolved != realRoot && !strings.HasPrefix(resolved, realRoot+string(filepath.Separator)) {
+		return fmt.Errorf("rsync: %s: permission denied", item)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package sftp
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setRsyncUser runs rsync as the user the server runs as, so it cannot touch anything the server cannot
+func setRsyncUser(cmd *exec.Cmd, uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	if uid < 0 || gid < 0 {
+		return errors.New("rsync: server has no user to run as")
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}
+	return nil
+}
@@ -0,0 +1,8 @@
+package sftp
+
+import "os/exec"
+
+// setRsyncUser does nothing on Windows, rsync runs as the daemon does
+func setRsyncUser(cmd *exec.Cmd, uid, gid int) error {
+	return nil
+}
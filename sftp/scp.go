@@ -0,0 +1,456 @@
+package sftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// scp speaks the classic SCP protocol, as run by "scp -t" to receive files and "scp -f" to send them.
+// Everything goes through the SFTP handlers, so the same access checks and logging apply.
+type scp struct {
+	handlers  sftp.Handlers
+	in        *bufio.Reader
+	out       io.Writer
+	recursive bool
+	preserve  bool
+	targetDir bool
+}
+
+func runSCP(handlers sftp.Handlers, args []string, in io.Reader, out io.Writer) error {
+	s := &scp{handlers: handlers, in: bufio.NewReader(in), out: out}
+
+	var sink, source bool
+	var paths []string
+	for i, v := range args {
+		if v == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(v, "-") || v == "-" {
+			paths = append(paths, v)
+			continue
+		}
+		for _, flag := range v[1:] {
+			switch flag {
+			case 't':
+				sink = true
+			case 'f':
+				source = true
+			case 'r':
+				s.recursive = true
+			case 'p':
+				s.preserve = true
+			case 'd':
+				s.targetDir = true
+			case 'v', 'q':
+			default:
+				return fmt.Errorf("scp: unsupported option -%c", flag)
+			}
+		}
+	}
+
+	if sink == source {
+		return errors.New("scp: exactly one of -t or -f must be given")
+	}
+	if len(paths) == 0 {
+		return errors.New("scp: no path given")
+	}
+
+	if sink {
+		if len(paths) != 1 {
+			return errors.New("scp: only one target can be given")
+		}
+		return s.receive(scpPath(paths[0]))
+	}
+	return s.send(paths)
+}
+
+// receive is the sink side, the client sends us files to place at target
+func (s *scp) receive(target string) error {
+	info, err := s.stat(target)
+	targetIsDir := err == nil && info.IsDir()
+	if s.targetDir && !targetIsDir {
+		_ = s.fatal(fmt.Errorf("%s: not a directory", target))
+		return errors.New("scp: target is not a directory")
+	}
+
+	if err = s.ack(); err != nil {
+		return err
+	}
+
+	//folders we are in, the target is only used as is for the first item when it does not exist yet
+	var stack []string
+	current := target
+
+	destination := func(name string) string {
+		if len(stack) == 0 && !targetIsDir {
+			return target
+		}
+		return path.Join(current, name)
+	}
+
+	for {
+		line, err := s.in.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("scp: protocol error, empty line")
+		}
+
+		switch line[0] {
+		case 'T':
+			//times are not kept, the file server sets them as the file is written
+			err = s.ack()
+		case 'C':
+			var size int64
+			var name string
+			_, size, name, err = parseSCPHeader(line)
+			if err != nil {
+				_ = s.fatal(err)
+				return err
+			}
+			err = s.receiveFile(destination(name), size)
+		case 'D':
+			var name string
+			_, _, name, err = parseSCPHeader(line)
+			if err != nil {
+				_ = s.fatal(err)
+				return err
+			}
+			if !s.recursive {
+				err = errors.New("scp: received a directory without -r")
+				_ = s.fatal(err)
+				return err
+			}
+
+			dir := destination(name)
+			if info, statErr := s.stat(dir); statErr != nil || !info.IsDir() {
+				if err = s.handlers.FileCmd.Filecmd(sftp.NewRequest("Mkdir", dir)); err != nil {
+					_ = s.fatal(fmt.Errorf("%s: %w", dir, err))
+					return err
+				}
+			}
+			stack = append(stack, current)
+			current = dir
+			err = s.ack()
+		case 'E':
+			if len(stack) == 0 {
+				err = errors.New("scp: protocol error, unexpected end of directory")
+				_ = s.fatal(err)
+				return err
+			}
+			current = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			err = s.ack()
+		case 1:
+			//the client had trouble with one of its files, it will carry on with the rest
+			continue
+		case 2:
+			return fmt.Errorf("scp: %s", line[1:])
+		default:
+			err = fmt.Errorf("scp: protocol error, unexpected %q", line[0])
+			_ = s.fatal(err)
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *scp) receiveFile(target string, size int64) error {
+	writer, err := s.handlers.FilePut.Filewrite(sftp.NewRequest("Put", target))
+	if err != nil {
+		//the client skips sending this file when told about a problem
+		return s.warn(fmt.Errorf("%s: %w", target, err))
+	}
+
+	if err = s.ack(); err != nil {
+		closeSCP(writer)
+		return err
+	}
+
+	_, err = io.CopyN(io.NewOffsetWriter(writer, 0), s.in, size)
+	if err != nil {
+		closeSCP(writer)
+		//the rest of the file still has to be read, so the next header lines up
+		_, _ = io.CopyN(io.Discard, s.in, size)
+	}
+
+	if _, readErr := s.in.ReadByte(); readErr != nil {
+		closeSCP(writer)
+		return readErr
+	}
+
+	if c, ok := writer.(io.Closer); ok && err == nil {
+		err = c.Close()
+	}
+	if err != nil {
+		return s.warn(fmt.Errorf("%s: %w", target, err))
+	}
+	return s.ack()
+}
+
+// send is the source side, we send the paths asked for to the client
+func (s *scp) send(paths []string) error {
+	if err := s.readAck(); err != nil {
+		return err
+	}
+
+	for _, v := range paths {
+		matches, err := s.expand(scpPath(v))
+		if err != nil {
+			if err = s.warn(fmt.Errorf("%s: %w", v, err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, match := range matches {
+			if err = s.sendItem(match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *scp) sendItem(item string) error {
+	info, err := s.stat(item)
+	if err != nil {
+		return s.warn(fmt.Errorf("%s: %w", item, err))
+	}
+
+	if info.IsDir() {
+		if !s.recursive {
+			return s.warn(fmt.Errorf("%s: not a regular file", item))
+		}
+		return s.sendDir(item, info)
+	}
+	if !info.Mode().IsRegular() {
+		return s.warn(fmt.Errorf("%s: not a regular file", item))
+	}
+	return s.sendFile(item, info)
+}
+
+func (s *scp) sendFile(item string, info os.FileInfo) error {
+	reader, err := s.handlers.FileGet.Fileread(sftp.NewRequest("Get", item))
+	if err != nil {
+		return s.warn(fmt.Errorf("%s: %w", item, err))
+	}
+	defer closeSCP(reader)
+
+	if err = s.sendTimes(info); err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(s.out, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), info.Name()); err != nil {
+		return err
+	}
+	if err = s.readAck(); err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(s.out, io.NewSectionReader(reader, 0, info.Size())); err != nil {
+		return err
+	}
+	if _, err = s.out.Write([]byte{0}); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+func (s *scp) sendDir(item string, info os.FileInfo) error {
+	entries, err := s.list(item)
+	if err != nil {
+		return s.warn(fmt.Errorf("%s: %w", item, err))
+	}
+
+	if err = s.sendTimes(info); err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(s.out, "D%04o 0 %s\n", info.Mode().Perm(), scpName(item, info)); err != nil {
+		return err
+	}
+	if err = s.readAck(); err != nil {
+		return err
+	}
+
+	for _, v := range entries {
+		if err = s.sendItem(path.Join(item, v.Name())); err != nil {
+			return err
+		}
+	}
+
+	if _, err = fmt.Fprint(s.out, "E\n"); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+func (s *scp) sendTimes(info os.FileInfo) error {
+	if !s.preserve {
+		return nil
+	}
+	modified := info.ModTime().Unix()
+	if _, err := fmt.Fprintf(s.out, "T%d 0 %d 0\n", modified, modified); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+// expand resolves wildcards in the last part of a path, as the shell would for a real scp
+func (s *scp) expand(item string) ([]string, error) {
+	dir, pattern := path.Split(item)
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{item}, nil
+	}
+
+	entries, err := s.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, v := range entries {
+		if matched, _ := filepath.Match(pattern, v.Name()); matched {
+			matches = append(matches, path.Join(dir, v.Name()))
+		}
+	}
+	if len(matches) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return matches, nil
+}
+
+func (s *scp) stat(item string) (os.FileInfo, error) {
+	lister, err := s.handlers.FileList.Filelist(sftp.NewRequest("Stat", item))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 1)
+	n, err := lister.ListAt(infos, 0)
+	if n == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = os.ErrNotExist
+		}
+		return nil, err
+	}
+	return infos[0], nil
+}
+
+func (s *scp) list(item string) ([]os.FileInfo, error) {
+	lister, err := s.handlers.FileList.Filelist(sftp.NewRequest("List", item))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []os.FileInfo
+	page := make([]os.FileInfo, 100)
+	for {
+		n, err := lister.ListAt(page, int64(len(result)))
+		result = append(result, page[:n]...)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		} else if n == 0 {
+			return result, nil
+		}
+	}
+}
+
+func (s *scp) ack() error {
+	_, err := s.out.Write([]byte{0})
+	return err
+}
+
+// warn tells the client about a problem with a single file, it will carry on with the rest
+func (s *scp) warn(err error) error {
+	_, writeErr := fmt.Fprintf(s.out, "\x01scp: %s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+	return writeErr
+}
+
+func (s *scp) fatal(err error) error {
+	_, writeErr := fmt.Fprintf(s.out, "\x02%s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+	return writeErr
+}
+
+// readAck waits for the client to confirm what we sent
+func (s *scp) readAck() error {
+	code, err := s.in.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+
+	msg, err := s.in.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if code == 1 {
+		//only a warning, there is nothing to retry so continue as if it worked
+		return nil
+	}
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// parseSCPHeader parses the "C0644 12 name" and "D0755 0 name" lines
+func parseSCPHeader(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("scp: protocol error, bad header %q", line)
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("scp: protocol error, bad mode %q", parts[0])
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("scp: protocol error, bad size %q", parts[1])
+	}
+
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return 0, 0, "", fmt.Errorf("scp: invalid name %q", name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+// scpPath turns a path given to scp into one for the handlers, paths start at the server's root
+func scpPath(item string) string {
+	item = strings.TrimPrefix(item, "~")
+	return path.Clean("/" + item)
+}
+
+// scpName gets the name to send for an item, the root of a server has no name of its own
+func scpName(item string, info os.FileInfo) string {
+	if name := path.Base(item); name != "/" {
+		return name
+	}
+	return info.Name()
+}
+
+func closeSCP(item any) {
+	if c, ok := item.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
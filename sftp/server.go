@@ -2,8 +2,8 @@ package sftp
 
 import (
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
-	"github.com/pkg/sftp"
 	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/config"
 	"github.com/pufferpanel/pufferpanel/v3/logging"
@@ -14,6 +14,7 @@ import (
 	"golang.org/x/crypto/ssh"
 	"net"
 	"os"
+	"strings"
 )
 
 var sftpServer net.Listener
@@ -44,8 +45,14 @@ func runServer() error {
 
 	serverConfig := &ssh.ServerConfig{
 		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if remote, ok := auth.(pufferpanel.SFTPRemoteValidation); ok {
+				return remote.ValidateFrom(c.User(), string(pass), c.RemoteAddr())
+			}
 			return auth.Validate(c.User(), string(pass))
 		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return auth.ValidateKey(c.User(), key)
+		},
 	}
 
 	serverKeyFile := config.SftpKey.Value()
@@ -130,6 +137,13 @@ func handleConn(conn net.Conn, serverConfig *ssh.ServerConfig) error {
 		return e
 	}
 
+	//the handshake is done, so if a key was used the client has proven they own it
+	if fingerprint := sc.Permissions.Extensions["key_fingerprint"]; fingerprint != "" {
+		if usage, ok := auth.(pufferpanel.SFTPKeyUsage); ok {
+			go usage.KeyUsed(fingerprint, sc.RemoteAddr())
+		}
+	}
+
 	// The incoming Request channel must be serviced.
 	go PrintDiscardRequests(reqs)
 
@@ -150,39 +164,36 @@ func handleConn(conn net.Conn, serverConfig *ssh.ServerConfig) error {
 			return err
 		}
 
-		// Sessions have out-of-band requests such as "shell",
-		// "pty-req" and "env".  Here we handle only the
-		// "subsystem" request.
-		go func(in <-chan *ssh.Request) {
-			for req := range in {
-				ok := false
-				switch req.Type {
-				case "subsystem":
-					if string(req.Payload[4:]) == "sftp" {
-						ok = true
-					}
-				}
-				_ = req.Reply(ok, nil)
-			}
-		}(requests)
-
-		serverId := sc.Permissions.Extensions["server_id"]
-		server := servers.GetFromCache(serverId)
-		if server == nil {
+		sess, err := createSession(sc)
+		if err != nil {
+			return err
+		}
+		if sess == nil {
 			//this daemon can't handle this request...
 			return nil
 		}
 
-		fs := CreateRequestPrefix(server.GetFileServer())
-		s := sftp.NewRequestServer(channel, fs)
-
-		if err = s.Serve(); err != nil {
+		if err = sess.serve(channel, requests); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// sessionRecorder fills in who is connected for entries being added to the server's SFTP log
+func sessionRecorder(server *servers.Server, sc *ssh.ServerConn) func(entry pufferpanel.SFTPLogEntry) {
+	session := hex.EncodeToString(sc.SessionID())
+	user := strings.SplitN(sc.User(), "#", 2)[0]
+	addr := sc.RemoteAddr().String()
+
+	return func(entry pufferpanel.SFTPLogEntry) {
+		entry.Session = session
+		entry.User = user
+		entry.RemoteAddr = addr
+		server.RecordSFTP(entry)
+	}
+}
+
 func PrintDiscardRequests(in <-chan *ssh.Request) {
 	for req := range in {
 		if req.WantReply {
@@ -0,0 +1,219 @@
+package sftp
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"github.com/pufferpanel/pufferpanel/v3/servers"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// serverRoot shows every server a user can access as a folder, so a single login can reach all of them.
+// Each folder is handled by that server's own file server, with the user's access to that server.
+type serverRoot struct {
+	folders map[string]requestPrefix
+	names   []string
+	created time.Time
+}
+
+// CreateServerRoot serves a folder for each server the user has access to.
+// Changes made in a server are given to the recorder for that server, if set.
+func CreateServerRoot(access []pufferpanel.SFTPServerAccess, recorderFor func(server *servers.Server) func(entry pufferpanel.SFTPLogEntry)) sftp.Handlers {
+	return createServerRoot(access, recorderFor).handlers()
+}
+
+// createServerRoot builds the root from the servers the user has access to.
+// Servers which are not on this node cannot be reached, so they are left out.
+func createServerRoot(access []pufferpanel.SFTPServerAccess, recorderFor func(server *servers.Server) func(entry pufferpanel.SFTPLogEntry)) serverRoot {
+	root := serverRoot{folders: make(map[string]requestPrefix), names: make([]string, 0), created: time.Now()}
+
+	for _, v := range access {
+		server := servers.GetFromCache(v.ServerId)
+		if server == nil {
+			continue
+		}
+
+		rp := requestPrefix{
+			server:   server,
+			fs:       server.GetFileServer(),
+			readOnly: v.ReadOnly,
+			allowed:  files.NormalizeRestrictions(v.Paths),
+		}
+		if recorderFor != nil {
+			rp.record = recorderFor(server)
+		}
+
+		name := serverFolderName(v)
+		root.folders[name] = rp
+		root.names = append(root.names, name)
+	}
+	sort.Strings(root.names)
+
+	return root
+}
+
+// serverFolderName names the folder for a server, the ID is included as names do not need to be unique
+func serverFolderName(access pufferpanel.SFTPServerAccess) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, access.Name)
+	return fmt.Sprintf("%s (%s)", name, access.ServerId)
+}
+
+func (sr serverRoot) handlers() sftp.Handlers {
+	return sftp.Handlers{FileCmd: sr, FileGet: sr, FileList: sr, FilePut: sr}
+}
+
+// record adds the entry to the log of every server in the root
+func (sr serverRoot) record(entry pufferpanel.SFTPLogEntry) {
+	for _, v := range sr.folders {
+		v.recordEntry(entry)
+	}
+}
+
+func (sr serverRoot) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	rp, path, err := sr.resolve(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return rp.read(path)
+}
+
+func (sr serverRoot) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	rp, path, err := sr.resolve(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return rp.write(path)
+}
+
+func (sr serverRoot) Filecmd(request *sftp.Request) error {
+	rp, path, err := sr.resolve(request.Filepath)
+	if err != nil {
+		return err
+	}
+
+	//the server folders themselves cannot be changed
+	if path == "/" {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	var target string
+	if request.Method == "Rename" {
+		var targetRp requestPrefix
+		targetRp, target, err = sr.resolve(request.Target)
+		if err != nil {
+			return err
+		}
+		//moving between servers would need a copy, which clients can do themselves
+		if targetRp.fs != rp.fs || target == "/" {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+	}
+
+	return rp.cmd(request.Method, path, target)
+}
+
+func (sr serverRoot) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	folder, _ := splitServerPath(request.Filepath)
+	if folder == "" {
+		switch request.Method {
+		case "List":
+			result := listerat{}
+			for _, v := range sr.names {
+				result = append(result, &virtualFolder{name: v, modTime: sr.created})
+			}
+			return result, nil
+		case "Stat":
+			return listerat{&virtualFolder{name: "/", modTime: sr.created}}, nil
+		default:
+			return nil, sftp.ErrSSHFxOpUnsupported
+		}
+	}
+
+	rp, path, err := sr.resolve(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := rp.list(request.Method, path)
+	if err != nil {
+		return nil, err
+	}
+
+	//the server's root should look like the folder it is shown as
+	if path == "/" && request.Method != "List" {
+		if stat, ok := result.(listerat); ok && len(stat) == 1 {
+			return listerat{&renamedFileInfo{FileInfo: stat[0], name: folder}}, nil
+		}
+	}
+	return result, nil
+}
+
+// resolve finds the server a path is in, and the path within that server
+func (sr serverRoot) resolve(path string) (requestPrefix, string, error) {
+	folder, rest := splitServerPath(path)
+	if folder == "" {
+		return requestPrefix{}, "", sftp.ErrSSHFxPermissionDenied
+	}
+
+	rp, exists := sr.folders[folder]
+	if !exists {
+		return requestPrefix{}, "", sftp.ErrSSHFxNoSuchFile
+	}
+	return rp, rest, nil
+}
+
+// splitServerPath splits a path into the server folder, and the path within that server
+func splitServerPath(path string) (string, string) {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	folder, rest, _ := strings.Cut(path, "/")
+	return folder, "/" + rest
+}
+
+type virtualFolder struct {
+	name    string
+	modTime time.Time
+}
+
+func (v *virtualFolder) Name() string {
+	return v.name
+}
+
+func (v *virtualFolder) Size() int64 {
+	return 0
+}
+
+func (v *virtualFolder) Mode() os.FileMode {
+	return os.ModeDir | 0555
+}
+
+func (v *virtualFolder) ModTime() time.Time {
+	return v.modTime
+}
+
+func (v *virtualFolder) IsDir() bool {
+	return true
+}
+
+func (v *virtualFolder) Sys() any {
+	return nil
+}
+
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (r *renamedFileInfo) Name() string {
+	return r.name
+}
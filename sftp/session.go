@@ -0,0 +1,127 @@
+package sftp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/sftp"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/servers"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"golang.org/x/crypto/ssh"
+	"os/exec"
+	"strings"
+)
+
+// session is what a single login can reach, and how to record what is done with it
+type session struct {
+	handlers sftp.Handlers
+	record   func(entry pufferpanel.SFTPLogEntry)
+
+	//server is only set when the login is for a single server
+	server   *servers.Server
+	readOnly bool
+	allowed  []string
+}
+
+// createSession works out what the login can reach, returning nothing if none of it is on this node
+func createSession(sc *ssh.ServerConn) (*session, error) {
+	if serverList := sc.Permissions.Extensions["servers"]; serverList != "" {
+		//logged in without a server, so they get every server they can access on this node
+		var access []pufferpanel.SFTPServerAccess
+		if err := json.Unmarshal([]byte(serverList), &access); err != nil {
+			return nil, err
+		}
+		root := createServerRoot(access, func(server *servers.Server) func(entry pufferpanel.SFTPLogEntry) {
+			return sessionRecorder(server, sc)
+		})
+		return &session{handlers: root.handlers(), record: root.record}, nil
+	}
+
+	server := servers.GetFromCache(sc.Permissions.Extensions["server_id"])
+	if server == nil {
+		return nil, nil
+	}
+
+	sess := &session{
+		server:   server,
+		readOnly: sc.Permissions.Extensions["read_only"] == "true",
+		record:   sessionRecorder(server, sc),
+	}
+	if paths := sc.Permissions.Extensions["paths"]; paths != "" {
+		sess.allowed = strings.Split(paths, "\n")
+	}
+	sess.handlers = CreateRequestPrefix(server, sess.readOnly, sess.allowed, sess.record)
+	return sess, nil
+}
+
+// serve waits for the client to ask for SFTP or to run a command, then handles it until it is done
+func (s *session) serve(channel ssh.Channel, requests <-chan *ssh.Request) error {
+	defer utils.Close(channel)
+
+	// Sessions have out-of-band requests such as "shell",
+	// "pty-req" and "env".  Here we handle only the
+	// "subsystem" and "exec" requests.
+	for req := range requests {
+		switch req.Type {
+		case "subsystem":
+			if len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp" {
+				_ = req.Reply(true, nil)
+				go PrintDiscardRequests(requests)
+
+				s.record(pufferpanel.SFTPLogEntry{Action: "connect"})
+				err := sftp.NewRequestServer(channel, s.handlers).Serve()
+				s.record(pufferpanel.SFTPLogEntry{Action: "disconnect"})
+				return err
+			}
+		case "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				_ = req.Reply(true, nil)
+				go PrintDiscardRequests(requests)
+
+				s.record(pufferpanel.SFTPLogEntry{Action: "connect"})
+				status := s.exec(channel, payload.Command)
+				s.record(pufferpanel.SFTPLogEntry{Action: "disconnect"})
+
+				_, err := channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{Status: status}))
+				return err
+			}
+		}
+		if req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+	}
+	return nil
+}
+
+// exec runs the few commands we support, scp and rsync, and gives back the exit status for the client
+func (s *session) exec(channel ssh.Channel, command string) uint32 {
+	args, err := shellquote.Split(command)
+	if err == nil && len(args) == 0 {
+		err = errors.New("no command given")
+	}
+
+	if err == nil {
+		switch args[0] {
+		case "scp":
+			err = runSCP(s.handlers, args[1:], channel, channel)
+		case "rsync":
+			err = s.rsync(channel, args[1:])
+		default:
+			err = fmt.Errorf("%s is not supported, only sftp, scp and rsync can be used", args[0])
+		}
+	}
+
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return uint32(exitErr.ExitCode())
+	}
+	_, _ = fmt.Fprintf(channel.Stderr(), "%s\n", err)
+	return 1
+}
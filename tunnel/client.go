@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+const maxBackoff = time.Minute
+
+var client struct {
+	lock    sync.Mutex
+	session *Session
+	stop    chan struct{}
+}
+
+// Run Connects the daemon to the panel, if it's set to use a tunnel. Requests the panel sends over the tunnel are
+// handled as if they came in directly, and the tunnel is opened again whenever it drops.
+func Run() {
+	if !config.TunnelEnabled.Value() {
+		return
+	}
+
+	u, err := Url()
+	if err != nil {
+		logging.Error.Printf("Error starting tunnel: %s", err.Error())
+		return
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.stop != nil {
+		return
+	}
+	client.stop = make(chan struct{})
+	go connect(u, client.stop)
+}
+
+// Stop Closes the tunnel and stops opening it again
+func Stop() {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.stop != nil {
+		close(client.stop)
+		client.stop = nil
+	}
+	if client.session != nil {
+		_ = client.session.Close()
+		client.session = nil
+	}
+}
+
+// Url Gets where the tunnel is opened, which is next to where the daemon gets its tokens from
+func Url() (string, error) {
+	u, err := url.Parse(config.AuthUrl.Value())
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return "", errors.New("auth url must be http or https")
+	}
+	u.Path = path.Join(path.Dir(u.Path), "tunnel")
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+func connect(u string, stop chan struct{}) {
+	backoff := time.Second
+
+	for {
+		connected, err := serve(u, stop)
+		if err != nil {
+			logging.Error.Printf("Error connecting tunnel to %s: %s", u, err.Error())
+		}
+		if connected {
+			backoff = time.Second
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// serve opens the tunnel and handles requests on it until it closes, returning if it was opened at all
+func serve(u string, stop chan struct{}) (bool, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+config.ClientSecret.Value())
+
+	conn, response, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		if response != nil {
+			return false, errors.New(response.Status)
+		}
+		return false, err
+	}
+
+	session := NewSession(conn, true)
+	client.lock.Lock()
+	select {
+	case <-stop:
+		client.lock.Unlock()
+		_ = session.Close()
+		return false, nil
+	default:
+	}
+	client.session = session
+	client.lock.Unlock()
+
+	logging.Info.Printf("Tunnel connected to %s", u)
+	//the server ends once the session does, as it can't accept any more streams
+	_ = (&http.Server{Handler: pufferpanel.Engine}).Serve(session)
+	_ = session.Close()
+	logging.Info.Printf("Tunnel to %s closed", u)
+
+	client.lock.Lock()
+	if client.session == session {
+		client.session = nil
+	}
+	client.lock.Unlock()
+	return true, nil
+}
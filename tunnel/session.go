@@ -0,0 +1,255 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"sync"
+	"time"
+)
+
+// Frames are sent as binary websocket messages, made of the type, the id of the stream and what the type carries
+const (
+	frameOpen byte = iota
+	frameData
+	frameClose
+	frameWindow
+)
+
+const headerSize = 5
+
+// window is how much a stream may be sent before the other side has read it, so one slow stream can't hold up the rest
+const window = 256 * 1024
+
+// maxFrame is the most data sent in one frame
+const maxFrame = 32 * 1024
+
+const pingInterval = 30 * time.Second
+const pongWait = 3 * pingInterval
+
+var ErrSessionClosed = errors.New("tunnel closed")
+
+// Session carries many streams over one websocket. The side which was dialed opens streams, and the side which dialed
+// is given them by Accept, so it can be served like any listener.
+type Session struct {
+	conn      *websocket.Conn
+	client    bool
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	streams map[uint32]*Stream
+	nextId  uint32
+
+	accept    chan *Stream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSession Starts carrying streams over the websocket. The side which dialed is the client, which is the side given
+// streams to accept.
+func NewSession(conn *websocket.Conn, client bool) *Session {
+	s := &Session{
+		conn:    conn,
+		client:  client,
+		streams: make(map[uint32]*Stream),
+		nextId:  2,
+		accept:  make(chan *Stream, 16),
+		done:    make(chan struct{}),
+	}
+	if client {
+		s.nextId = 1
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second*10))
+	})
+
+	go s.read()
+	go s.ping()
+	return s
+}
+
+// Open Opens a new stream to the other side
+func (s *Session) Open() (*Stream, error) {
+	s.lock.Lock()
+	if s.IsClosed() {
+		s.lock.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextId
+	s.nextId += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.lock.Unlock()
+
+	if err := s.write(frameOpen, id, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept Waits for the other side to open a stream. Only the side which dialed is given any.
+func (s *Session) Accept() (net.Conn, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.done:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Close Closes the websocket, which ends every stream on it
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		//control messages can be written alongside frames, so a stuck write doesn't hold up closing
+		_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = s.conn.Close()
+
+		s.lock.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.lock.Unlock()
+		for _, v := range streams {
+			v.remoteClose()
+		}
+	})
+	return err
+}
+
+// Addr Gets the address of the other side
+func (s *Session) Addr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Done Gets a channel which is closed once the session is
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) read() {
+	defer s.Close()
+
+	for {
+		messageType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType != websocket.BinaryMessage || len(data) < headerSize {
+			continue
+		}
+
+		id := binary.BigEndian.Uint32(data[1:headerSize])
+		payload := data[headerSize:]
+
+		switch data[0] {
+		case frameOpen:
+			//nothing takes streams on the side which was dialed, so they are turned away rather than left waiting
+			if !s.client {
+				_ = s.write(frameClose, id, nil)
+				continue
+			}
+
+			s.lock.Lock()
+			_, exists := s.streams[id]
+			//ids the other side opens have the other parity to ours
+			valid := !exists && id%2 != s.nextId%2
+			var stream *Stream
+			if valid {
+				stream = newStream(s, id)
+				s.streams[id] = stream
+			}
+			s.lock.Unlock()
+			if !valid {
+				continue
+			}
+
+			select {
+			case s.accept <- stream:
+			case <-s.done:
+				return
+			}
+		case frameData:
+			if stream := s.get(id); stream != nil {
+				stream.receive(payload)
+			}
+		case frameWindow:
+			if stream := s.get(id); stream != nil && len(payload) == 4 {
+				stream.grant(binary.BigEndian.Uint32(payload))
+			}
+		case frameClose:
+			if stream := s.get(id); stream != nil {
+				s.remove(id)
+				stream.remoteClose()
+			}
+		}
+	}
+}
+
+func (s *Session) ping() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second*10))
+			if err != nil {
+				_ = s.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Session) write(frameType byte, id uint32, payload []byte) error {
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	frame := make([]byte, headerSize+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:headerSize], id)
+	copy(frame[headerSize:], payload)
+
+	s.writeLock.Lock()
+	err := s.conn.WriteMessage(websocket.BinaryMessage, frame)
+	s.writeLock.Unlock()
+	if err != nil {
+		_ = s.Close()
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+func (s *Session) get(id uint32) *Stream {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) remove(id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.streams, id)
+}
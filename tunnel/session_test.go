@@ -0,0 +1,175 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openSessions gets both ends of a session, where the client side echoes back everything sent on the streams opened
+// to it
+func openSessions(t *testing.T) (*Session, *Session) {
+	sessions := make(chan *Session, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		sessions <- NewSession(conn, false)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client := NewSession(conn, true)
+	remote := <-sessions
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = remote.Close()
+	})
+
+	go func() {
+		for {
+			stream, err := client.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(stream, stream)
+				_ = stream.Close()
+			}()
+		}
+	}()
+	return client, remote
+}
+
+func TestSession(t *testing.T) {
+	t.Run("Echo", func(t *testing.T) {
+		_, remote := openSessions(t)
+
+		stream, err := remote.Open()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer stream.Close()
+
+		_, err = stream.Write([]byte("hello"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		result := make([]byte, 5)
+		_, err = io.ReadFull(stream, result)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "hello", string(result))
+		}
+	})
+
+	t.Run("LargerThanWindow", func(t *testing.T) {
+		_, remote := openSessions(t)
+
+		data := make([]byte, window*4+123)
+		_, _ = rand.Read(data)
+
+		//a few streams at once, which each have to wait on the other side reading
+		results := make(chan []byte, 3)
+		for i := 0; i < 3; i++ {
+			stream, err := remote.Open()
+			if !assert.NoError(t, err) {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				go func() {
+					_, _ = stream.Write(data)
+				}()
+				result := make([]byte, len(data))
+				_, _ = io.ReadFull(stream, result)
+				results <- result
+			}()
+		}
+
+		for i := 0; i < 3; i++ {
+			select {
+			case result := <-results:
+				assert.True(t, bytes.Equal(data, result))
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the data")
+			}
+		}
+	})
+
+	t.Run("ReadDeadline", func(t *testing.T) {
+		_, remote := openSessions(t)
+
+		stream, err := remote.Open()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer stream.Close()
+
+		_ = stream.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = stream.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("ClosedByClient", func(t *testing.T) {
+		client, remote := openSessions(t)
+
+		stream, err := remote.Open()
+		if !assert.NoError(t, err) {
+			return
+		}
+		_ = client.Close()
+
+		_, err = stream.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		_, err = remote.Open()
+		//the remote notices the websocket closing on its own, which may take a moment
+		for i := 0; i < 50 && err == nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+			_, err = remote.Open()
+		}
+		assert.ErrorIs(t, err, ErrSessionClosed)
+	})
+
+	t.Run("TurnsAwayStreams", func(t *testing.T) {
+		client, remote := openSessions(t)
+
+		//more than could be waiting to be accepted, which would stop the remote reading anything else
+		for i := 0; i < 20; i++ {
+			stream, err := client.Open()
+			if !assert.NoError(t, err) {
+				return
+			}
+			_ = stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err = stream.Read(make([]byte, 1))
+			assert.Equal(t, io.EOF, err)
+		}
+
+		stream, err := remote.Open()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer stream.Close()
+		_, err = stream.Write([]byte("hello"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		result := make([]byte, 5)
+		_ = stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.ReadFull(stream, result)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "hello", string(result))
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream is one connection carried by a session
+type Stream struct {
+	session *Session
+	id      uint32
+
+	lock     sync.Mutex
+	buffer   bytes.Buffer
+	unacked  int
+	credit   int
+	closed   bool
+	eof      bool
+	readBy   time.Time
+	writeBy  time.Time
+	readable chan struct{}
+	writable chan struct{}
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		session:  session,
+		id:       id,
+		credit:   window,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.lock.Lock()
+		if s.buffer.Len() > 0 {
+			n, _ := s.buffer.Read(b)
+			s.unacked += n
+			var ack int
+			//the other side is told what was read in batches, or once everything sent has been read
+			if s.unacked >= window/4 || s.buffer.Len() == 0 {
+				ack, s.unacked = s.unacked, 0
+			}
+			closed := s.closed || s.eof
+			s.lock.Unlock()
+
+			if ack > 0 && !closed {
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, uint32(ack))
+				_ = s.session.write(frameWindow, s.id, payload)
+			}
+			return n, nil
+		}
+		if s.closed {
+			s.lock.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if s.eof {
+			s.lock.Unlock()
+			return 0, io.EOF
+		}
+		deadline := s.readBy
+		s.lock.Unlock()
+
+		if err := wait(s.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		s.lock.Lock()
+		if s.closed || s.eof {
+			s.lock.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if s.credit == 0 {
+			deadline := s.writeBy
+			s.lock.Unlock()
+			if err := wait(s.writable, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		size := min(len(b)-written, s.credit, maxFrame)
+		s.credit -= size
+		s.lock.Unlock()
+
+		if err := s.session.write(frameData, s.id, b[written:written+size]); err != nil {
+			return written, err
+		}
+		written += size
+	}
+	return written, nil
+}
+
+// Close Closes the stream, telling the other side it has ended
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	eof := s.eof
+	s.lock.Unlock()
+	notify(s.readable)
+	notify(s.writable)
+
+	s.session.remove(s.id)
+	if !eof {
+		return s.session.write(frameClose, s.id, nil)
+	}
+	return nil
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.conn.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.conn.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.readBy = t
+	s.writeBy = t
+	s.lock.Unlock()
+	notify(s.readable)
+	notify(s.writable)
+	return nil
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.readBy = t
+	s.lock.Unlock()
+	notify(s.readable)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.writeBy = t
+	s.lock.Unlock()
+	notify(s.writable)
+	return nil
+}
+
+// receive adds data the other side sent to what can be read
+func (s *Stream) receive(data []byte) {
+	s.lock.Lock()
+	if !s.closed {
+		s.buffer.Write(data)
+	}
+	s.lock.Unlock()
+	notify(s.readable)
+}
+
+// grant gives back room the other side has read
+func (s *Stream) grant(size uint32) {
+	s.lock.Lock()
+	s.credit += int(size)
+	s.lock.Unlock()
+	notify(s.writable)
+}
+
+// remoteClose marks the stream as ended by the other side, what was already sent can still be read
+func (s *Stream) remoteClose() {
+	s.lock.Lock()
+	s.eof = true
+	s.lock.Unlock()
+	notify(s.readable)
+	notify(s.writable)
+}
+
+// wait waits for the stream to change, or for the deadline to pass
+func wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -34,3 +34,17 @@ func Union[T comparable](a, b []T) []T {
 
 	return result
 }
+
+// Unique Gets the values of a, without any repeats, keeping the order they first appear in
+func Unique[T comparable](a []T) []T {
+	result := make([]T, 0, len(a))
+	seen := make(map[T]bool, len(a))
+	for _, v := range a {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	return result
+}
@@ -79,3 +79,20 @@ func TestUnion(t *testing.T) {
 		})
 	}
 }
+
+func TestUnique(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []uint
+		want []uint
+	}{
+		{name: "nil slice", a: nil, want: []uint{}},
+		{name: "no repeats", a: []uint{3, 1, 2}, want: []uint{3, 1, 2}},
+		{name: "repeats", a: []uint{3, 1, 3, 2, 1}, want: []uint{3, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Unique(tt.a))
+		})
+	}
+}
@@ -38,7 +38,8 @@ func (v *Variable) UnmarshalJSON(data []byte) (err error) {
 
 	//convert variable to correct typing
 	switch aux.Type.Type {
-	case "integer":
+	//ports are numbers too, they are just given out from the node's port pool
+	case "integer", "port":
 		{
 			aux.Value, err = cast.ToIntE(aux.Value)
 			if err != nil {
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func registerAudit(g *gin.RouterGroup) {
+	g.Handle("GET", "", middleware.RequiresPermission(scopes.ScopeAuditView), searchAudit)
+	g.Handle("OPTIONS", "", response.CreateOptions("GET"))
+}
+
+// @Summary Search audit log
+// @Description Gets changes made in the panel, newest first. * is a wildcard that can be used for the action
+// @Success 200 {object} models.AuditSearchResponse
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param actor query uint false "ID of the user who made the change"
+// @Param action query string false "Action to filter on, such as server.create or server.*"
+// @Param targetType query string false "Type of what was changed, such as server or user"
+// @Param targetId query string false "ID of what was changed"
+// @Param from query string false "Only changes made at or after this time, in RFC3339"
+// @Param to query string false "Only changes made at or before this time, in RFC3339"
+// @Param limit query uint false "Max number of results to return"
+// @Param page query uint false "What page to get back for many results"
+// @Router /api/audit [get]
+// @Security OAuth2Application[audit.view]
+func searchAudit(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	as := &services.Audit{DB: db}
+
+	search := &models.AuditSearch{
+		PageLimit: DefaultPageSize,
+		Page:      1,
+	}
+	err = c.ShouldBindQuery(search)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if search.PageLimit == 0 {
+		response.HandleError(c, pufferpanel.ErrFieldTooSmall("limit", 0), http.StatusBadRequest)
+		return
+	}
+	if search.Page == 0 {
+		response.HandleError(c, pufferpanel.ErrFieldTooSmall("page", 0), http.StatusBadRequest)
+		return
+	}
+	if search.PageLimit > MaxPageSize {
+		search.PageLimit = MaxPageSize
+	}
+
+	var results []*models.AuditLog
+	var total int64
+	if results, total, err = as.Search(search); response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &models.AuditSearchResponse{
+		Entries: results,
+		Metadata: &pufferpanel.Metadata{Paging: &pufferpanel.Paging{
+			Page:    search.Page,
+			Size:    search.PageLimit,
+			MaxSize: MaxPageSize,
+			Total:   total,
+		}},
+	})
+}
+
+// The targets below are what the audited routes change. Each is looked up as what the API would show, so nothing
+// secret ends up in the audit log.
+
+var auditServer = middleware.AuditTarget{
+	Type:  "server",
+	Param: "serverId",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		server, err := (&services.Server{DB: db}).Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return models.FromServer(server), nil
+	},
+}
+
+var auditServerUser = middleware.AuditTarget{
+	Type:  "server",
+	Param: "serverId",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		user, err := (&services.User{DB: db}).GetByEmail(c.Param("email"))
+		if err != nil {
+			return nil, err
+		}
+		perms, err := (&services.Permission{DB: db}).GetForUserAndServer(user.ID, id)
+		if err != nil || perms.ID == 0 {
+			return nil, err
+		}
+		view := models.FromPermission(perms)
+		return &models.UserPermissionsView{
+			Username: user.Username,
+			Email:    user.Email,
+			Scopes:   view.Scopes,
+			Roles:    view.Roles,
+			Paths:    view.Paths,
+		}, nil
+	},
+}
+
+var auditBackup = middleware.AuditTarget{
+	Type:  "backup",
+	Param: "backupId",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		backupId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		return (&services.Backup{DB: db}).Get(c.Param("serverId"), backupId)
+	},
+}
+
+var auditUser = middleware.AuditTarget{
+	Type:  "user",
+	Param: "id",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		userId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		user, err := (&services.User{DB: db}).GetById(userId)
+		if err != nil {
+			return nil, err
+		}
+		return models.FromUser(user), nil
+	},
+}
+
+var auditUserPerms = middleware.AuditTarget{
+	Type:  "user",
+	Param: "id",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		userId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		perms, err := (&services.Permission{DB: db}).GetForUserAndServer(userId, "")
+		if err != nil {
+			return nil, err
+		}
+		return models.FromPermission(perms), nil
+	},
+}
+
+var auditNode = middleware.AuditTarget{
+	Type:  "node",
+	Param: "id",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		nodeId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		node, err := (&services.Node{DB: db}).Get(nodeId)
+		if err != nil {
+			return nil, err
+		}
+		return models.FromNode(node), nil
+	},
+}
+
+var auditNodePorts = middleware.AuditTarget{
+	Type:  "node",
+	Param: "id",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		nodeId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		ports, err := (&services.PortPool{DB: db}).Get(nodeId)
+		if err != nil {
+			return nil, err
+		}
+		return ports.Ranges, nil
+	},
+}
+
+var auditRole = middleware.AuditTarget{
+	Type:  "role",
+	Param: "id",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		roleId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		return (&services.Role{DB: db}).Get(roleId)
+	},
+}
+
+var auditTemplate = middleware.AuditTarget{
+	Type:  "template",
+	Param: "name",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		ts := &services.Template{DB: db}
+		return ts.Get(ts.GetLocalRepoId(), id)
+	},
+}
+
+var auditTemplateRepo = middleware.AuditTarget{
+	Type:  "templateRepo",
+	Param: "repo",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		repo := &models.TemplateRepo{}
+		err := db.Where("id = ?", id).First(repo).Error
+		return repo, err
+	},
+}
+
+var auditSettings = middleware.AuditTarget{
+	Type: "settings",
+	Id:   "panel",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		result := make(map[string]interface{})
+		for _, v := range editableStringEntries {
+			result[v.Key()] = v.Value()
+		}
+		for _, v := range editableBoolEntries {
+			result[v.Key()] = v.Value()
+		}
+		for _, v := range editableIntEntries {
+			result[v.Key()] = v.Value()
+		}
+		for _, v := range []config.StringEntry{config.EmailKey, config.EmailPassword} {
+			if result[v.Key()] != "" {
+				result[v.Key()] = "********"
+			}
+		}
+		return result, nil
+	},
+}
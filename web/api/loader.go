@@ -22,9 +22,12 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	registerNodes(rg.Group("/nodes"))
 	registerServers(rg.Group("/servers"))
 	registerUsers(rg.Group("/users"))
+	registerRoles(rg.Group("/roles"))
 	registerTemplates(rg.Group("/templates"))
 	registerSelf(rg.Group("/self"))
 	registerSettings(rg.Group("/settings"))
+	registerAudit(rg.Group("/audit"))
+	registerLockouts(rg.Group("/lockouts"))
 	registerUserSettings(rg.Group("/userSettings"))
 
 	rg.GET("/config", panelConfig)
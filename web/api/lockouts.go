@@ -0,0 +1,78 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func registerLockouts(g *gin.RouterGroup) {
+	g.Handle("GET", "", middleware.RequiresPermission(scopes.ScopeUserInfoSearch), getLockouts)
+	g.Handle("OPTIONS", "", response.CreateOptions("GET"))
+
+	g.Handle("DELETE", "/:id", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), middleware.Audited("login.lockout.clear", auditLockout), clearLockout)
+	g.Handle("OPTIONS", "/:id", response.CreateOptions("DELETE"))
+}
+
+// @Summary Get login lockouts
+// @Description Gets the IPs and accounts which are locked out, or have failed logins which still count towards being locked out
+// @Success 200 {object} []models.LoginLockout
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/lockouts [get]
+// @Security OAuth2Application[users.info.search]
+func getLockouts(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	ls := &services.LoginLimiter{DB: db}
+
+	records, err := ls.GetActive()
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+// @Summary Clear login lockout
+// @Description Lets the IP or account log in again, and forgets its failed logins. This is recorded in the audit log.
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Lockout ID"
+// @Router /api/lockouts/{id} [delete]
+// @Security OAuth2Application[users.info.edit]
+func clearLockout(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	ls := &services.LoginLimiter{DB: db}
+
+	id, err := cast.ToUintE(c.Param("id"))
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	err = ls.Clear(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+var auditLockout = middleware.AuditTarget{
+	Type:  "lockout",
+	Param: "id",
+	Load: func(c *gin.Context, db *gorm.DB, id string) (interface{}, error) {
+		lockoutId, err := cast.ToUintE(id)
+		if err != nil {
+			return nil, err
+		}
+		return (&services.LoginLimiter{DB: db}).Get(lockoutId)
+	},
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pufferpanel/pufferpanel/v3/services"
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"github.com/pufferpanel/pufferpanel/v3/web/daemon"
+	"github.com/spf13/cast"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,17 +21,33 @@ import (
 
 func registerNodes(g *gin.RouterGroup) {
 	g.Handle("GET", "", middleware.RequiresPermission(scopes.ScopeNodesView), getAllNodes)
-	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeNodesCreate), createNode)
+	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeNodesCreate), middleware.Audited("node.create", auditNode), createNode)
 	g.Handle("OPTIONS", "", response.CreateOptions("GET", "POST"))
 
+	g.Handle("GET", "/status", middleware.RequiresPermission(scopes.ScopeNodesView), getNodeStatuses)
+	g.Handle("OPTIONS", "/status", response.CreateOptions("GET"))
+
 	g.Handle("GET", "/:id", middleware.RequiresPermission(scopes.ScopeNodesView), getNode)
-	g.Handle("PUT", "/:id", middleware.RequiresPermission(scopes.ScopeNodesEdit), updateNode)
-	g.Handle("DELETE", "/:id", middleware.RequiresPermission(scopes.ScopeNodesDelete), deleteNode)
+	g.Handle("PUT", "/:id", middleware.RequiresPermission(scopes.ScopeNodesEdit), middleware.Audited("node.edit", auditNode), updateNode)
+	g.Handle("DELETE", "/:id", middleware.RequiresPermission(scopes.ScopeNodesDelete), middleware.Audited("node.delete", auditNode), deleteNode)
 	g.Handle("OPTIONS", "/:id", response.CreateOptions("PUT", "GET", "DELETE"))
 
 	g.Handle("GET", "/:id/features", middleware.RequiresPermission(scopes.ScopeNodesView), getFeatures)
 	g.Handle("OPTIONS", "/:id/features", response.CreateOptions("GET"))
 
+	g.Handle("GET", "/:id/status", middleware.RequiresPermission(scopes.ScopeNodesView), getNodeStatus)
+	g.Handle("OPTIONS", "/:id/status", response.CreateOptions("GET"))
+
+	g.Handle("GET", "/:id/usage", middleware.RequiresPermission(scopes.ScopeNodesView), getNodeUsage)
+	g.Handle("OPTIONS", "/:id/usage", response.CreateOptions("GET"))
+
+	g.Handle("GET", "/:id/ports", middleware.RequiresPermission(scopes.ScopeNodesView), getNodePorts)
+	g.Handle("POST", "/:id/ports", middleware.RequiresPermission(scopes.ScopeNodesEdit), middleware.Audited("node.ports.add", auditNodePorts), addNodePortRange)
+	g.Handle("OPTIONS", "/:id/ports", response.CreateOptions("GET", "POST"))
+
+	g.Handle("DELETE", "/:id/ports/:rangeId", middleware.RequiresPermission(scopes.ScopeNodesEdit), middleware.Audited("node.ports.remove", auditNodePorts), removeNodePortRange)
+	g.Handle("OPTIONS", "/:id/ports/:rangeId", response.CreateOptions("DELETE"))
+
 	g.Handle("GET", "/:id/deployment", middleware.RequiresPermission(scopes.ScopeNodesDeploy), deployNode)
 	g.Handle("OPTIONS", "/:id/deployment", response.CreateOptions("GET"))
 }
@@ -123,6 +140,8 @@ func createNode(c *gin.Context) {
 		return
 	}
 
+	middleware.SetAuditTarget(c, strconv.Itoa(int(create.ID)))
+
 	c.JSON(http.StatusOK, create)
 }
 
@@ -275,6 +294,211 @@ func getFeatures(c *gin.Context) {
 	c.JSON(http.StatusOK, features)
 }
 
+// @Summary Get status of all nodes
+// @Description Gets what the panel last saw when checking each node, such as if it is online and what it is using
+// @Success 200 {object} []models.NodeStatus
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/nodes/status [get]
+// @Security OAuth2Application[nodes.view]
+func getNodeStatuses(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	ns := &services.NodeStatus{DB: db}
+
+	var statuses []*models.NodeStatus
+	if statuses, err = ns.GetAll(); response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// @Summary Get status of a node
+// @Description Gets what the panel last saw when checking the node, such as if it is online and what it is using
+// @Success 200 {object} models.NodeStatus
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Node Id"
+// @Param refresh query bool false "Check the node now, instead of using the last check"
+// @Router /api/nodes/{id}/status [get]
+// @Security OAuth2Application[nodes.view]
+func getNodeStatus(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	ns := &services.Node{DB: db}
+	nss := &services.NodeStatus{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	node, err := ns.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	var status *models.NodeStatus
+	if c.Query("refresh") == "true" {
+		status, err = nss.Check(node)
+	} else {
+		status, err = nss.Get(node)
+	}
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// @Summary Get what a node has set aside
+// @Description Gets how much memory, CPU and disk the servers on the node have set aside, and how much the node has
+// @Success 200 {object} models.NodeUsage
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Node Id"
+// @Router /api/nodes/{id}/usage [get]
+// @Security OAuth2Application[nodes.view]
+func getNodeUsage(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	ns := &services.Node{DB: db}
+	ps := &services.Placement{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	node, err := ns.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	usage, err := ps.GetUsage(node, "")
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// @Summary Get port pool of a node
+// @Description Gets the port ranges of the node, and which servers have been given ports from it
+// @Success 200 {object} models.NodePorts
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Node Id"
+// @Router /api/nodes/{id}/ports [get]
+// @Security OAuth2Application[nodes.view]
+func getNodePorts(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	ns := &services.Node{DB: db}
+	pp := &services.PortPool{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	node, err := ns.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	ports, err := pp.Get(node.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, ports)
+}
+
+// @Summary Add port range to a node
+// @Description Adds a range of ports bound to an IP to the node's pool, which servers are given ports from. Use 0.0.0.0 to bind to every IP.
+// @Success 200 {object} models.PortRange
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Node Id"
+// @Param body body models.PortRange true "Port range"
+// @Router /api/nodes/{id}/ports [post]
+// @Security OAuth2Application[nodes.edit]
+func addNodePortRange(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	ns := &services.Node{DB: db}
+	pp := &services.PortPool{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	node, err := ns.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	portRange := &models.PortRange{}
+	if err = c.BindJSON(portRange); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+	portRange.ID = 0
+	portRange.NodeId = node.ID
+	if portRange.IP == "" {
+		portRange.IP = models.AnyIP
+	}
+
+	if err = pp.AddRange(portRange); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	c.JSON(http.StatusOK, portRange)
+}
+
+// @Summary Remove port range from a node
+// @Description Removes a range from the node's pool. Servers keep the ports they were given from it.
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Node Id"
+// @Param rangeId path uint true "Port range Id"
+// @Router /api/nodes/{id}/ports/{rangeId} [delete]
+// @Security OAuth2Application[nodes.edit]
+func removeNodePortRange(c *gin.Context) {
+	var err error
+	db := middleware.GetDatabase(c)
+	pp := &services.PortPool{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	rangeId, err := cast.ToUintE(c.Param("rangeId"))
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if err = pp.RemoveRange(id, rangeId); response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 func validateId(c *gin.Context) (uint, bool) {
 	param := c.Param("id")
 
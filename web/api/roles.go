@@ -0,0 +1,304 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/utils"
+	"github.com/spf13/cast"
+	"net/http"
+)
+
+func registerRoles(g *gin.RouterGroup) {
+	g.Handle("GET", "", middleware.RequiresPermission(scopes.ScopeRolesView), getRoles)
+	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeRolesEdit), middleware.Audited("role.create", auditRole), createRole)
+	g.Handle("OPTIONS", "", response.CreateOptions("GET", "POST"))
+
+	g.Handle("GET", "/:id", middleware.RequiresPermission(scopes.ScopeRolesView), getRole)
+	g.Handle("PUT", "/:id", middleware.RequiresPermission(scopes.ScopeRolesEdit), middleware.Audited("role.edit", auditRole), updateRole)
+	g.Handle("DELETE", "/:id", middleware.RequiresPermission(scopes.ScopeRolesEdit), middleware.Audited("role.delete", auditRole), deleteRole)
+	g.Handle("OPTIONS", "/:id", response.CreateOptions("GET", "PUT", "DELETE"))
+
+	g.Handle("GET", "/:id/holders", middleware.RequiresPermission(scopes.ScopeRolesView), getRoleHolders)
+	g.Handle("OPTIONS", "/:id/holders", response.CreateOptions("GET"))
+}
+
+// @Summary Get roles
+// @Description Gets all roles which can be given to users and clients
+// @Success 200 {object} []models.Role
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/roles [get]
+// @Security OAuth2Application[roles.view]
+func getRoles(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	rs := &services.Role{DB: db}
+
+	roles, err := rs.GetAll()
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// @Summary Get role
+// @Success 200 {object} models.Role
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Role ID"
+// @Router /api/roles/{id} [get]
+// @Security OAuth2Application[roles.view]
+func getRole(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	rs := &services.Role{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	role, err := rs.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// @Summary Create role
+// @Description Creates a role. Unless you are an admin, the role can only have scopes you have yourself
+// @Success 200 {object} models.Role
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param role body models.Role true "Role to create"
+// @Router /api/roles [post]
+// @Security OAuth2Application[roles.edit]
+func createRole(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	rs := &services.Role{DB: db}
+
+	request := &models.Role{}
+	err := c.BindJSON(request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	editorScopes, ok := getEditorScopes(c)
+	if !ok {
+		return
+	}
+
+	role := &models.Role{
+		Name:        request.Name,
+		Description: request.Description,
+		ForServer:   request.ForServer,
+		Scopes:      scopes.UpdateScopesWhereGranted(nil, request.Scopes, editorScopes),
+	}
+
+	if response.HandleError(c, role.IsValid(), http.StatusBadRequest) {
+		return
+	}
+
+	err = rs.Create(role)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	middleware.SetAuditTarget(c, cast.ToString(role.ID))
+
+	c.JSON(http.StatusOK, role)
+}
+
+// @Summary Update role
+// @Description Updates a role, which changes what everyone holding it can do. Unless you are an admin, only scopes you have yourself are changed. A role cannot be moved between global and server level
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Role ID"
+// @Param role body models.Role true "New role information"
+// @Router /api/roles/{id} [put]
+// @Security OAuth2Application[roles.edit]
+func updateRole(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	rs := &services.Role{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	request := &models.Role{}
+	err := c.BindJSON(request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	role, err := rs.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	editorScopes, ok := getEditorScopes(c)
+	if !ok {
+		return
+	}
+
+	role.Name = request.Name
+	role.Description = request.Description
+	role.Scopes = scopes.UpdateScopesWhereGranted(role.Scopes, request.Scopes, editorScopes)
+
+	if response.HandleError(c, role.IsValid(), http.StatusBadRequest) {
+		return
+	}
+
+	err = rs.Update(role)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Delete role
+// @Description Deletes a role, taking it away from everyone who holds it
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Role ID"
+// @Router /api/roles/{id} [delete]
+// @Security OAuth2Application[roles.edit]
+func deleteRole(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	rs := &services.Role{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	role, err := rs.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	//taking a role away from others is the same as changing it, so the same limits apply
+	editorScopes, ok := getEditorScopes(c)
+	if !ok {
+		return
+	}
+	if !canGrantRole(role, editorScopes) {
+		response.HandleError(c, pufferpanel.ErrNoPermission, http.StatusForbidden)
+		return
+	}
+
+	err = rs.Delete(role.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get role holders
+// @Description Gets the users and clients who hold a role, and on which server
+// @Success 200 {object} []models.RoleHolderView
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Role ID"
+// @Router /api/roles/{id}/holders [get]
+// @Security OAuth2Application[roles.view]
+func getRoleHolders(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	rs := &services.Role{DB: db}
+
+	id, ok := validateId(c)
+	if !ok {
+		return
+	}
+
+	role, err := rs.Get(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	perms, err := rs.GetHolders(role.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FromRoleHolders(perms))
+}
+
+// getEditorScopes Gets the global scopes of the user making the request
+func getEditorScopes(c *gin.Context) ([]*scopes.Scope, bool) {
+	db := middleware.GetDatabase(c)
+	ps := &services.Permission{DB: db}
+
+	user := c.MustGet("user").(*models.User)
+	perms, err := ps.GetForUserAndServer(user.ID, "")
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return nil, false
+	}
+	return perms.EffectiveScopes(), true
+}
+
+// canGrantRole Checks if someone with the given scopes may hand out or take away a role
+func canGrantRole(role *models.Role, editorScopes []*scopes.Scope) bool {
+	for _, v := range role.Scopes {
+		if !scopes.ContainsScope(editorScopes, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveRoleChange Works out what roles a permission set should have after an edit. Roles the editor could not
+// have granted are left as they were, unless the editor is unrestricted
+func resolveRoleChange(rs *services.Role, perms *models.Permissions, requested []uint, editorScopes []*scopes.Scope, unrestricted bool) ([]uint, error) {
+	result := make([]uint, 0)
+	for _, id := range utils.Unique(requested) {
+		role, err := rs.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if role.ForServer != (perms.ServerIdentifier != nil) {
+			return nil, pufferpanel.ErrRoleLevelMismatch(role.Name)
+		}
+		if unrestricted || canGrantRole(role, editorScopes) {
+			result = append(result, role.ID)
+			continue
+		}
+		for _, v := range perms.Roles {
+			if v.ID == role.ID {
+				result = append(result, role.ID)
+				break
+			}
+		}
+	}
+
+	if unrestricted {
+		return result, nil
+	}
+
+	for _, v := range perms.Roles {
+		if !canGrantRole(v, editorScopes) {
+			result = append(result, v.ID)
+		}
+	}
+	return utils.Unique(result), nil
+}
@@ -1,9 +1,13 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	uuid "github.com/gofrs/uuid/v5"
@@ -20,6 +24,9 @@ func registerSelf(g *gin.RouterGroup) {
 	g.Handle("PUT", "", middleware.RequiresPermission(scopes.ScopeSelfEdit), updateSelf)
 	g.Handle("OPTIONS", "", response.CreateOptions("GET", "PUT"))
 
+	g.Handle("GET", "/perms/effective", middleware.RequiresPermission(scopes.ScopeLogin), getSelfEffectivePerms)
+	g.Handle("OPTIONS", "/perms/effective", response.CreateOptions("GET"))
+
 	g.Handle("GET", "/otp", middleware.RequiresPermission(scopes.ScopeSelfEdit), getOtpStatus)
 	g.Handle("POST", "/otp", middleware.RequiresPermission(scopes.ScopeSelfEdit), startOtpEnroll)
 	g.Handle("PUT", "/otp", middleware.RequiresPermission(scopes.ScopeSelfEdit), validateOtpEnroll)
@@ -32,8 +39,46 @@ func registerSelf(g *gin.RouterGroup) {
 	g.Handle("POST", "/oauth2", middleware.RequiresPermission(scopes.ScopeSelfClients), createPersonalOAuth2Client)
 	g.Handle("OPTIONS", "/oauth2", response.CreateOptions("GET", "POST"))
 
+	g.Handle("PUT", "/oauth2/:clientId", middleware.RequiresPermission(scopes.ScopeSelfClients), updatePersonalOAuth2Client)
 	g.Handle("DELETE", "/oauth2/:clientId", middleware.RequiresPermission(scopes.ScopeSelfClients), deletePersonalOAuth2Client)
-	g.Handle("OPTIONS", "/oauth2/:clientId", response.CreateOptions("DELETE"))
+	g.Handle("OPTIONS", "/oauth2/:clientId", response.CreateOptions("PUT", "DELETE"))
+
+	g.Handle("GET", "/oauth2/:clientId/perms", middleware.RequiresPermission(scopes.ScopeSelfClients), getPersonalOAuth2ClientPerms)
+	g.Handle("PUT", "/oauth2/:clientId/perms", middleware.RequiresPermission(scopes.ScopeSelfClients), setPersonalOAuth2ClientPerms)
+	g.Handle("OPTIONS", "/oauth2/:clientId/perms", response.CreateOptions("GET", "PUT"))
+
+	g.Handle("GET", "/tokens", middleware.RequiresPermission(scopes.ScopeSelfClients), getAPITokens)
+	g.Handle("POST", "/tokens", middleware.RequiresPermission(scopes.ScopeSelfClients), createAPIToken)
+	g.Handle("OPTIONS", "/tokens", response.CreateOptions("GET", "POST"))
+
+	g.Handle("DELETE", "/tokens/:id", middleware.RequiresPermission(scopes.ScopeSelfClients), deleteAPIToken)
+	g.Handle("OPTIONS", "/tokens/:id", response.CreateOptions("DELETE"))
+
+	g.Handle("GET", "/sshkeys", middleware.RequiresPermission(scopes.ScopeSelfEdit), getSSHKeys)
+	g.Handle("POST", "/sshkeys", middleware.RequiresPermission(scopes.ScopeSelfEdit), createSSHKey)
+	g.Handle("OPTIONS", "/sshkeys", response.CreateOptions("GET", "POST"))
+
+	g.Handle("DELETE", "/sshkeys/:id", middleware.RequiresPermission(scopes.ScopeSelfEdit), deleteSSHKey)
+	g.Handle("OPTIONS", "/sshkeys/:id", response.CreateOptions("DELETE"))
+
+	g.Handle("GET", "/webauthn", middleware.RequiresPermission(scopes.ScopeSelfEdit), getWebAuthnCredentials)
+	g.Handle("POST", "/webauthn", middleware.RequiresPermission(scopes.ScopeSelfEdit), startWebAuthnRegistration)
+	g.Handle("PUT", "/webauthn", middleware.RequiresPermission(scopes.ScopeSelfEdit), finishWebAuthnRegistration)
+	g.Handle("OPTIONS", "/webauthn", response.CreateOptions("GET", "POST", "PUT"))
+
+	g.Handle("DELETE", "/webauthn/:id", middleware.RequiresPermission(scopes.ScopeSelfEdit), deleteWebAuthnCredential)
+	g.Handle("OPTIONS", "/webauthn/:id", response.CreateOptions("DELETE"))
+
+	g.Handle("GET", "/recovery", middleware.RequiresPermission(scopes.ScopeSelfEdit), getRecoveryCodeStatus)
+	g.Handle("POST", "/recovery", middleware.RequiresPermission(scopes.ScopeSelfEdit), regenerateRecoveryCodes)
+	g.Handle("OPTIONS", "/recovery", response.CreateOptions("GET", "POST"))
+
+	g.Handle("GET", "/sessions", middleware.RequiresPermission(scopes.ScopeSelfEdit), getSelfSessions)
+	g.Handle("DELETE", "/sessions", middleware.RequiresPermission(scopes.ScopeSelfEdit), deleteOtherSelfSessions)
+	g.Handle("OPTIONS", "/sessions", response.CreateOptions("GET", "DELETE"))
+
+	g.Handle("DELETE", "/sessions/:id", middleware.RequiresPermission(scopes.ScopeSelfEdit), deleteSelfSession)
+	g.Handle("OPTIONS", "/sessions/:id", response.CreateOptions("DELETE"))
 }
 
 // @Summary Get your user info
@@ -209,11 +254,17 @@ func validateOtpEnroll(c *gin.Context) {
 		return
 	}
 
+	rs := &services.RecoveryCode{DB: db}
+	codes, err := rs.GenerateIfMissing(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
 	err = services.GetEmailService().SendEmail(user.Email, "otpEnabled", nil, true)
 	if err != nil {
 		logging.Error.Printf("Error sending email: %s\n", err)
 	}
-	c.Status(http.StatusNoContent)
+	c.JSON(http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
 }
 
 func disableOtp(c *gin.Context) {
@@ -279,6 +330,11 @@ func getPersonalOAuth2Clients(c *gin.Context) {
 func createPersonalOAuth2Client(c *gin.Context) {
 	user := c.MustGet("user").(*models.User)
 
+	if isDelegated(c) {
+		response.HandleError(c, pufferpanel.ErrClientsNeedLogin, http.StatusForbidden)
+		return
+	}
+
 	db := middleware.GetDatabase(c)
 	os := &services.OAuth2{DB: db}
 
@@ -293,22 +349,33 @@ func createPersonalOAuth2Client(c *gin.Context) {
 		return
 	}
 	client := &models.Client{
-		ClientId:    id.String(),
-		UserId:      user.ID,
-		Name:        request.Name,
-		Description: request.Description,
+		ClientId:     id.String(),
+		UserId:       user.ID,
+		Name:         request.Name,
+		Description:  request.Description,
+		RedirectUris: request.RedirectUris,
+		Public:       request.Public,
 	}
 
-	secret, err := utils.GenerateRandomString(36)
-	if response.HandleError(c, err, http.StatusInternalServerError) {
-		return
+	for _, v := range client.RedirectUris {
+		if response.HandleError(c, models.ValidateRedirectUri(v), http.StatusBadRequest) {
+			return
+		}
 	}
 
-	client.ClientSecret = secret
+	//public clients can't keep a secret, so aren't given one
+	if !client.Public {
+		secret, err := utils.GenerateRandomString(36)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+
+		client.ClientSecret = secret
 
-	err = client.SetClientSecret(secret)
-	if response.HandleError(c, err, http.StatusInternalServerError) {
-		return
+		err = client.SetClientSecret(secret)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
 	}
 
 	err = os.Create(client)
@@ -324,6 +391,50 @@ func createPersonalOAuth2Client(c *gin.Context) {
 	c.JSON(http.StatusOK, client)
 }
 
+// @Summary Updates an account-level OAuth2 client
+// @Description Updates the name, description and redirect uris of a client. The secret cannot be changed
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Client ID"
+// @Param client body models.Client true "New information for the client"
+// @Router /api/self/oauth2/{id} [PUT]
+// @Security OAuth2Application[self.clients]
+func updatePersonalOAuth2Client(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	os := &services.OAuth2{DB: db}
+
+	client, ok := getPersonalOAuth2Client(c)
+	if !ok {
+		return
+	}
+
+	var request models.Client
+	err := c.BindJSON(&request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	for _, v := range request.RedirectUris {
+		if response.HandleError(c, models.ValidateRedirectUri(v), http.StatusBadRequest) {
+			return
+		}
+	}
+
+	client.Name = request.Name
+	client.Description = request.Description
+	client.RedirectUris = request.RedirectUris
+
+	err = os.Update(client)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // @Summary Deletes an account-level OAuth2 client
 // @Success 204 {object} nil
 // @Failure 400 {object} pufferpanel.ErrorResponse
@@ -363,6 +474,757 @@ func deletePersonalOAuth2Client(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-type ValidateOtpRequest struct {
-	Token string `json:"token"`
+// @Summary Get your effective permissions
+// @Description Gets everything you can do, either globally or on a server, including what your roles give you
+// @Success 200 {object} models.EffectivePermissionsView
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param server query string false "Server ID"
+// @Router /api/self/perms/effective [GET]
+// @Security OAuth2Application[login]
+func getSelfEffectivePerms(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ps := &services.Permission{DB: db}
+
+	perms, err := ps.GetEffectiveForUser(user.ID, c.Query("server"))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, perms)
+}
+
+// @Summary Get the roles of an account-level OAuth2 client
+// @Description Gets the roles given to a client, globally or on a server. A client without any roles can do everything you can
+// @Success 200 {object} models.PermissionView
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Client ID"
+// @Param server query string false "Server ID"
+// @Router /api/self/oauth2/{id}/perms [GET]
+// @Security OAuth2Application[self.clients]
+func getPersonalOAuth2ClientPerms(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	ps := &services.Permission{DB: db}
+
+	client, ok := getPersonalOAuth2Client(c)
+	if !ok {
+		return
+	}
+
+	serverId := c.Query("server")
+	all, err := ps.GetForClient(client.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	perms := &models.Permissions{}
+	for _, v := range all {
+		if (v.ServerIdentifier == nil && serverId == "") || (v.ServerIdentifier != nil && *v.ServerIdentifier == serverId) {
+			perms = v
+			break
+		}
+	}
+
+	view := models.FromPermission(perms)
+	view.ServerIdentifier = serverId
+	c.JSON(http.StatusOK, view)
+}
+
+// @Summary Set the roles of an account-level OAuth2 client
+// @Description Sets the roles given to a client, globally or on a server. Once a client has a role, it can only do what its roles allow. Only the roles in the body are used, and you can only give roles with scopes you have yourself
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path string true "Client ID"
+// @Param server query string false "Server ID"
+// @Param body body models.PermissionView true "Roles to give"
+// @Router /api/self/oauth2/{id}/perms [PUT]
+// @Security OAuth2Application[self.clients]
+func setPersonalOAuth2ClientPerms(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	if isDelegated(c) {
+		response.HandleError(c, pufferpanel.ErrClientsNeedLogin, http.StatusForbidden)
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ps := &services.Permission{DB: db}
+
+	client, ok := getPersonalOAuth2Client(c)
+	if !ok {
+		return
+	}
+
+	request := &models.PermissionView{}
+	err := c.BindJSON(request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	var serverId *string
+	if server := c.Query("server"); server != "" {
+		ss := &services.Server{DB: db}
+		_, err = ss.Get(server)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+		serverId = &server
+	}
+
+	//a client can only be given what its user can do
+	globalPerms, err := ps.GetForUserAndServer(user.ID, "")
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	userScopes := globalPerms.EffectiveScopes()
+	unrestricted := scopes.ContainsScope(userScopes, scopes.ScopeAdmin)
+	if serverId != nil {
+		serverPerms, err := ps.GetForUserAndServer(user.ID, *serverId)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+		userScopes = append(userScopes, serverPerms.EffectiveScopes()...)
+	}
+
+	perms, err := ps.GetForClientAndServer(client.ID, serverId)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	roleIds, err := resolveRoleChange(&services.Role{DB: db}, perms, request.Roles, userScopes, unrestricted)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if serverId != nil && len(roleIds) == 0 {
+		err = ps.Remove(perms)
+	} else {
+		err = ps.SetRoles(perms, roleIds)
+	}
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getPersonalOAuth2Client Gets the client from the path, if it belongs to the current user
+func getPersonalOAuth2Client(c *gin.Context) (*models.Client, bool) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	os := &services.OAuth2{DB: db}
+
+	client, err := os.Get(c.Param("clientId"))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return nil, false
+	}
+
+	if client.UserId != user.ID {
+		c.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+	return client, true
+}
+
+// isDelegated is whether the request is made with a personal access token or by an app the user approved, rather than
+// by the user being logged in
+func isDelegated(c *gin.Context) bool {
+	_, exists := c.Get("grantedScopes")
+	return exists
+}
+
+// @Summary Get your personal access tokens
+// @Description Gets your tokens, including expired ones, and when they were last used
+// @Success 200 {object} []models.APIToken
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/tokens [GET]
+// @Security OAuth2Application[self.clients]
+func getAPITokens(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ts := &services.APIToken{DB: db}
+
+	tokens, err := ts.GetForUser(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &tokens)
+}
+
+// @Summary Create a personal access token
+// @Description Creates a token which can be used as a bearer token. It can only use the scopes given, only on the servers given if any are, and expires within a year. Scopes you do not have are left out, as are ones the token or app making the request was not given. The token is only returned this once
+// @Success 200 {object} models.APIToken
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param token body models.APIToken true "Token to create"
+// @Router /api/self/tokens [POST]
+// @Security OAuth2Application[self.clients]
+func createAPIToken(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ts := &services.APIToken{DB: db}
+	ps := &services.Permission{DB: db}
+	ss := &services.Server{DB: db}
+
+	var request models.APIToken
+	err := c.BindJSON(&request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if response.HandleError(c, (&models.APIToken{Name: request.Name}).IsValid(), http.StatusBadRequest) {
+		return
+	}
+
+	//unknown scopes are dropped along with ones the user does not have
+	requested := make([]*scopes.Scope, 0)
+	for _, v := range request.Scopes {
+		if parsed, ok := scopes.ParseScopes(v.Value); ok && len(parsed) == 1 {
+			requested = append(requested, parsed[0])
+		}
+	}
+	//a token, or an app the user approved, can't make a token which can do more than it can
+	if limit, exists := c.Get("grantedScopes"); exists {
+		allowed := make([]*scopes.Scope, 0)
+		for _, v := range requested {
+			if scopes.ContainsScope(limit.([]*scopes.Scope), v) {
+				allowed = append(allowed, v)
+			}
+		}
+		requested = allowed
+	}
+	granted, err := ps.GetGrantable(user.ID, requested)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	if caller, exists := c.Get("apiToken"); exists && len(caller.(*models.APIToken).Servers) > 0 {
+		if len(request.Servers) == 0 {
+			response.HandleError(c, pufferpanel.ErrAPITokenServers, http.StatusBadRequest)
+			return
+		}
+		for _, v := range request.Servers {
+			if !caller.(*models.APIToken).AllowsServer(v) {
+				response.HandleError(c, pufferpanel.ErrAPITokenServers, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	global, err := ps.GetForUserAndServer(user.ID, "")
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	isAdmin := scopes.ContainsScope(global.EffectiveScopes(), scopes.ScopeAdmin)
+	for _, v := range request.Servers {
+		server, err := ss.Get(v)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+		if isAdmin {
+			continue
+		}
+		perms, err := ps.GetForUserAndServer(user.ID, server.Identifier)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+		if perms.ID == 0 {
+			response.HandleError(c, pufferpanel.ErrServerNotFound, http.StatusNotFound)
+			return
+		}
+	}
+
+	token, err := ts.Create(user.ID, request.Name, granted, request.Servers, request.ExpiresAt)
+	if errors.Is(err, pufferpanel.ErrAPITokenExpiry) || errors.Is(err, pufferpanel.ErrAPITokenNoScopes) {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "apiTokenCreated", map[string]interface{}{
+		"NAME":    token.Name,
+		"EXPIRES": token.ExpiresAt.Format(time.RFC1123),
+	}, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+
+	c.JSON(http.StatusOK, token)
 }
+
+// @Summary Revoke a personal access token
+// @Description Removes a token, so it can no longer be used
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Token ID"
+// @Router /api/self/tokens/{id} [DELETE]
+// @Security OAuth2Application[self.clients]
+func deleteAPIToken(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ts := &services.APIToken{DB: db}
+
+	token, err := ts.Get(user.ID, uint(id))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = ts.Delete(user.ID, token.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "apiTokenRevoked", map[string]interface{}{
+		"NAME": token.Name,
+	}, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get your SSH keys
+// @Description Gets the SSH public keys which can be used to log into SFTP
+// @Success 200 {object} []models.SSHKey
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/sshkeys [GET]
+// @Security OAuth2Application[self.edit]
+func getSSHKeys(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ks := &services.SSHKey{DB: db}
+
+	keys, err := ks.GetForUser(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &keys)
+}
+
+// @Summary Add an SSH key
+// @Description Registers an SSH public key, in authorized_keys format, which can be used to log into SFTP
+// @Success 200 {object} models.SSHKey
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 409 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param key body models.SSHKey true "Key to add"
+// @Router /api/self/sshkeys [POST]
+// @Security OAuth2Application[self.edit]
+func createSSHKey(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ks := &services.SSHKey{DB: db}
+
+	var request models.SSHKey
+	err := c.BindJSON(&request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if request.PublicKey == "" {
+		response.HandleError(c, pufferpanel.ErrFieldRequired("publicKey"), http.StatusBadRequest)
+		return
+	}
+
+	key, err := ks.Create(user.ID, request.Name, request.PublicKey)
+	if errors.Is(err, pufferpanel.ErrSSHKeyExists) {
+		response.HandleError(c, err, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, pufferpanel.ErrSSHKeyInvalid) {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "sshKeyAdded", map[string]interface{}{
+		"FINGERPRINT": key.Fingerprint,
+	}, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// @Summary Revoke an SSH key
+// @Description Removes an SSH key, so it can no longer be used to log into SFTP
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Key ID"
+// @Router /api/self/sshkeys/{id} [DELETE]
+// @Security OAuth2Application[self.edit]
+func deleteSSHKey(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ks := &services.SSHKey{DB: db}
+
+	key, err := ks.Get(user.ID, uint(id))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = ks.Delete(user.ID, key.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "sshKeyRemoved", map[string]interface{}{
+		"FINGERPRINT": key.Fingerprint,
+	}, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get your security keys
+// @Description Gets the WebAuthn security keys which can be used as a second factor when logging in
+// @Success 200 {object} []models.WebAuthnCredential
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/webauthn [GET]
+// @Security OAuth2Application[self.edit]
+func getWebAuthnCredentials(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ws := &services.WebAuthn{DB: db}
+
+	credentials, err := ws.GetForUser(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &credentials)
+}
+
+// @Summary Start registering a security key
+// @Description Gets the options to pass to navigator.credentials.create in the browser
+// @Success 200 {object} protocol.CredentialCreation
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/webauthn [POST]
+// @Security OAuth2Application[self.edit]
+func startWebAuthnRegistration(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ws := &services.WebAuthn{DB: db}
+
+	creation, err := ws.BeginRegistration(user)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, creation)
+}
+
+// @Summary Finish registering a security key
+// @Description Saves the security key the browser created. If this is the first second factor of the user, recovery codes are generated and returned.
+// @Success 200 {object} WebAuthnRegistrationResponse
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 409 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param key body WebAuthnRegistrationRequest true "Response from the browser"
+// @Router /api/self/webauthn [PUT]
+// @Security OAuth2Application[self.edit]
+func finishWebAuthnRegistration(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ws := &services.WebAuthn{DB: db}
+	rs := &services.RecoveryCode{DB: db}
+
+	var request WebAuthnRegistrationRequest
+	err := c.BindJSON(&request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if len(request.Credential) == 0 {
+		response.HandleError(c, pufferpanel.ErrFieldRequired("credential"), http.StatusBadRequest)
+		return
+	}
+
+	credential, err := ws.FinishRegistration(user, request.Name, request.Credential)
+	if errors.Is(err, pufferpanel.ErrWebAuthnExists) {
+		response.HandleError(c, err, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, pufferpanel.ErrWebAuthnFailed) || errors.Is(err, pufferpanel.ErrInvalidSession) {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	codes, err := rs.GenerateIfMissing(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "securityKeyAdded", map[string]interface{}{
+		"NAME": credential.Name,
+	}, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+
+	c.JSON(http.StatusOK, &WebAuthnRegistrationResponse{
+		Credential:    credential,
+		RecoveryCodes: codes,
+	})
+}
+
+// @Summary Remove a security key
+// @Description Removes a security key, so it can no longer be used to log in. Removing the last second factor also removes the recovery codes.
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Key ID"
+// @Router /api/self/webauthn/{id} [DELETE]
+// @Security OAuth2Application[self.edit]
+func deleteWebAuthnCredential(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ws := &services.WebAuthn{DB: db}
+	us := &services.User{DB: db}
+
+	credential, err := ws.Get(user.ID, uint(id))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = ws.Delete(user.ID, credential.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = us.RemoveRecoveryCodesIfUnneeded(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "securityKeyRemoved", map[string]interface{}{
+		"NAME": credential.Name,
+	}, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get recovery code status
+// @Description Gets how many unused recovery codes the user has left
+// @Success 200 {object} RecoveryCodeStatus
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/recovery [GET]
+// @Security OAuth2Application[self.edit]
+func getRecoveryCodeStatus(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	rs := &services.RecoveryCode{DB: db}
+
+	remaining, err := rs.Remaining(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &RecoveryCodeStatus{Remaining: remaining})
+}
+
+// @Summary Regenerate recovery codes
+// @Description Replaces the recovery codes of the user with new ones. Only possible when a second factor is enabled.
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/recovery [POST]
+// @Security OAuth2Application[self.edit]
+func regenerateRecoveryCodes(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	rs := &services.RecoveryCode{DB: db}
+
+	needed, err := us.SecondFactorNeeded(user)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	if !needed {
+		response.HandleError(c, pufferpanel.ErrNoSecondFactor, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := rs.Generate(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// @Summary Get your sessions
+// @Description Gets where you are logged in, including clients acting for you, and which session made this request
+// @Success 200 {object} []models.SessionView
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/sessions [GET]
+// @Security OAuth2Application[self.edit]
+func getSelfSessions(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ss := &services.Session{DB: db}
+
+	sessions, err := ss.GetForUser(user.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FromSessions(sessions, currentSessionId(c)))
+}
+
+// @Summary Log out everywhere else
+// @Description Revokes every session except the one making this request, along with any refresh tokens clients hold
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Router /api/self/sessions [DELETE]
+// @Security OAuth2Application[self.edit]
+func deleteOtherSelfSessions(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	db := middleware.GetDatabase(c)
+	ss := &services.Session{DB: db}
+
+	err := ss.ExpireOthers(user.ID, currentSessionId(c))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Revoke a session
+// @Description Logs out a session. If a client got it with a refresh token, the refresh token is revoked too
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "Session ID"
+// @Router /api/self/sessions/{id} [DELETE]
+// @Security OAuth2Application[self.edit]
+func deleteSelfSession(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ss := &services.Session{DB: db}
+
+	err = ss.ExpireById(user.ID, uint(id))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// currentSessionId Gets the session the request was made with, or 0 if a personal access token was used
+func currentSessionId(c *gin.Context) uint {
+	if session, exists := c.Get("session"); exists {
+		return session.(*models.Session).ID
+	}
+	return 0
+}
+
+type ValidateOtpRequest struct {
+	Token string `json:"token"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+} //@name RecoveryCodes
+
+type RecoveryCodeStatus struct {
+	Remaining int64 `json:"remaining"`
+} //@name RecoveryCodeStatus
+
+type WebAuthnRegistrationRequest struct {
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential" swaggertype:"object"`
+} //@name WebAuthnRegistrationRequest
+
+type WebAuthnRegistrationResponse struct {
+	Credential    *models.WebAuthnCredential `json:"credential"`
+	RecoveryCodes []string                   `json:"recoveryCodes,omitempty"`
+} //@name WebAuthnRegistrationResponse
@@ -30,24 +30,24 @@ func registerServers(g *gin.RouterGroup) {
 	g.Handle("OPTIONS", "", response.CreateOptions("GET"))
 
 	g.Handle("GET", "/:serverId", middleware.RequiresPermission(scopes.ScopeServerView), middleware.ResolveServerPanel, getServer)
-	g.Handle("PUT", "/:serverId", middleware.RequiresPermission(scopes.ScopeServerCreate), middleware.HasTransaction, createServer)
-	g.Handle("DELETE", "/:serverId", middleware.RequiresPermission(scopes.ScopeServerDelete), middleware.ResolveServerPanel, middleware.HasTransaction, deleteServer)
+	g.Handle("PUT", "/:serverId", middleware.RequiresPermission(scopes.ScopeServerCreate), middleware.Audited("server.create", auditServer), middleware.HasTransaction, createServer)
+	g.Handle("DELETE", "/:serverId", middleware.RequiresPermission(scopes.ScopeServerDelete), middleware.ResolveServerPanel, middleware.Audited("server.delete", auditServer), middleware.HasTransaction, deleteServer)
 	g.Handle("OPTIONS", "/:serverId", response.CreateOptions("PUT", "GET", "POST", "DELETE"))
 
-	g.Handle("PUT", "/:serverId/name/:name", middleware.RequiresPermission(scopes.ScopeServerEditName), middleware.ResolveServerPanel, middleware.HasTransaction, renameServer)
+	g.Handle("PUT", "/:serverId/name/:name", middleware.RequiresPermission(scopes.ScopeServerEditName), middleware.ResolveServerPanel, middleware.Audited("server.rename", auditServer), middleware.HasTransaction, renameServer)
 	g.Handle("OPTIONS", "/:serverId/name", response.CreateOptions("PUT"))
 	g.Handle("OPTIONS", "/:serverId/name/:name", response.CreateOptions("PUT"))
 
 	g.Handle("GET", "/:serverId/definition", middleware.RequiresPermission(scopes.ScopeServerViewDefinition), middleware.ResolveServerPanel, proxyServerRequest)
-	g.Handle("PUT", "/:serverId/definition", middleware.RequiresPermission(scopes.ScopeServerEditDefinition), middleware.ResolveServerPanel, middleware.HasTransaction, editServer)
+	g.Handle("PUT", "/:serverId/definition", middleware.RequiresPermission(scopes.ScopeServerEditDefinition), middleware.ResolveServerPanel, middleware.Audited("server.definition.edit", auditServer), middleware.HasTransaction, editServer)
 	g.Handle("OPTIONS", "/:serverId/definition", response.CreateOptions("PUT", "GET"))
 
 	g.Handle("GET", "/:serverId/user", middleware.RequiresPermission(scopes.ScopeServerUserView), middleware.ResolveServerPanel, getServerUsers)
 	g.Handle("OPTIONS", "/:serverId/user", response.CreateOptions("GET"))
 
 	g.Handle("GET", "/:serverId/user/:email", middleware.RequiresPermission(scopes.ScopeServerUserView), middleware.ResolveServerPanel, getServerUsers)
-	g.Handle("PUT", "/:serverId/user/:email", middleware.RequiresPermission(scopes.ScopeServerUserEdit), middleware.ResolveServerPanel, middleware.HasTransaction, editServerUser)
-	g.Handle("DELETE", "/:serverId/user/:email", middleware.RequiresPermission(scopes.ScopeServerUserDelete), middleware.ResolveServerPanel, middleware.HasTransaction, removeServerUser)
+	g.Handle("PUT", "/:serverId/user/:email", middleware.RequiresPermission(scopes.ScopeServerUserEdit), middleware.ResolveServerPanel, middleware.Audited("server.users.edit", auditServerUser), middleware.HasTransaction, editServerUser)
+	g.Handle("DELETE", "/:serverId/user/:email", middleware.RequiresPermission(scopes.ScopeServerUserDelete), middleware.ResolveServerPanel, middleware.Audited("server.users.delete", auditServerUser), middleware.HasTransaction, removeServerUser)
 	g.Handle("OPTIONS", "/:serverId/user/:email", response.CreateOptions("GET", "PUT", "DELETE"))
 
 	g.GET("/:serverId/data", middleware.RequiresPermission(scopes.ScopeServerViewData), middleware.ResolveServerPanel, proxyServerRequest)
@@ -89,6 +89,17 @@ func registerServers(g *gin.RouterGroup) {
 	g.POST("/:serverId/file/*filename", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
 	g.OPTIONS("/:serverId/file/*filename", response.CreateOptions("GET", "PUT", "DELETE", "POST"))
 
+	g.GET("/:serverId/search", middleware.RequiresPermission(scopes.ScopeServerFileView), middleware.ResolveServerPanel, proxyServerRequest)
+	g.OPTIONS("/:serverId/search", response.CreateOptions("GET"))
+
+	g.POST("/:serverId/upload", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
+	g.OPTIONS("/:serverId/upload", response.CreateOptions("POST"))
+	g.GET("/:serverId/upload/:uploadId", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
+	g.PUT("/:serverId/upload/:uploadId", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
+	g.POST("/:serverId/upload/:uploadId", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
+	g.DELETE("/:serverId/upload/:uploadId", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
+	g.OPTIONS("/:serverId/upload/:uploadId", response.CreateOptions("GET", "PUT", "POST", "DELETE"))
+
 	g.GET("/:serverId/console", middleware.RequiresPermission(scopes.ScopeServerConsole), middleware.ResolveServerPanel, proxyServerRequest)
 	g.POST("/:serverId/console", middleware.RequiresPermission(scopes.ScopeServerSendCommand), middleware.ResolveServerPanel, proxyServerRequest)
 	g.OPTIONS("/:serverId/console", response.CreateOptions("GET", "POST"))
@@ -103,6 +114,9 @@ func registerServers(g *gin.RouterGroup) {
 	g.GET("/:serverId/status", middleware.RequiresPermission(scopes.ScopeServerStatus), middleware.ResolveServerPanel, proxyServerRequest)
 	g.OPTIONS("/:serverId/status", response.CreateOptions("GET"))
 
+	g.GET("/:serverId/sftp/log", middleware.RequiresPermission(scopes.ScopeServerSftpLog), middleware.ResolveServerPanel, proxyServerRequest)
+	g.OPTIONS("/:serverId/sftp/log", response.CreateOptions("GET"))
+
 	g.HEAD("/:serverId/archive/*filename", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
 	g.POST("/:serverId/archive/*filename", middleware.RequiresPermission(scopes.ScopeServerFileEdit), middleware.ResolveServerPanel, proxyServerRequest)
 	g.OPTIONS("/:serverId/archive/*filename", response.CreateOptions("HEAD", "POST"))
@@ -113,15 +127,19 @@ func registerServers(g *gin.RouterGroup) {
 	g.GET("/:serverId/backup", middleware.RequiresPermission(scopes.ScopeServerBackupView), middleware.ResolveServerPanel, getBackups)
 	g.OPTIONS("/:serverId/backup", response.CreateOptions("GET"))
 	g.GET("/:serverId/backup/:backupId", middleware.RequiresPermission(scopes.ScopeServerBackupView), middleware.ResolveServerPanel, getBackup)
-	g.DELETE("/:serverId/backup/:backupId", middleware.RequiresPermission(scopes.ScopeServerBackupDelete), middleware.ResolveServerPanel, deleteBackup)
+	g.DELETE("/:serverId/backup/:backupId", middleware.RequiresPermission(scopes.ScopeServerBackupDelete), middleware.ResolveServerPanel, middleware.Audited("server.backup.delete", auditBackup), deleteBackup)
 	g.OPTIONS("/:serverId/backup/:backupId", response.CreateOptions("GET", "DELETE"))
-	g.POST("/:serverId/backup/create", middleware.RequiresPermission(scopes.ScopeServerBackupCreate), middleware.ResolveServerPanel, createBackup)
+	g.POST("/:serverId/backup/create", middleware.RequiresPermission(scopes.ScopeServerBackupCreate), middleware.ResolveServerPanel, middleware.Audited("server.backup.create", auditBackup), createBackup)
 	g.OPTIONS("/:serverId/backup/create", response.CreateOptions("POST"))
-	g.POST("/:serverId/backup/restore/:backupId", middleware.RequiresPermission(scopes.ScopeServerBackupRestore), middleware.ResolveServerPanel, restoreBackup)
+	g.POST("/:serverId/backup/restore/:backupId", middleware.RequiresPermission(scopes.ScopeServerBackupRestore), middleware.ResolveServerPanel, middleware.Audited("server.backup.restore", auditBackup), restoreBackup)
 	g.OPTIONS("/:serverId/backup/restore/:backupId", response.CreateOptions("POST"))
 	g.GET("/:serverId/backup/download/:backupId", middleware.RequiresPermission(scopes.ScopeServerBackupView), middleware.ResolveServerPanel, downloadBackup)
 	g.OPTIONS("/:serverId/backup/download/:backupId", response.CreateOptions("GET"))
 
+	g.GET("/:serverId/migration", middleware.RequiresPermission(scopes.ScopeServerMigrate), middleware.ResolveServerPanel, getServerMigration)
+	g.POST("/:serverId/migration", middleware.RequiresPermission(scopes.ScopeServerMigrate), middleware.ResolveServerPanel, middleware.Audited("server.migrate", auditServer), migrateServer)
+	g.OPTIONS("/:serverId/migration", response.CreateOptions("GET", "POST"))
+
 	p := g.Group("/:serverId/socket")
 	{
 		p.GET("", middleware.RequiresPermission(scopes.ScopeServerView), cors.New(cors.Config{
@@ -189,7 +207,7 @@ func searchServers(c *gin.Context) {
 
 	isAdmin := false
 	for _, p := range perms {
-		if scopes.ContainsScope(p.Scopes, scopes.ScopeAdmin) {
+		if scopes.ContainsScope(p.EffectiveScopes(), scopes.ScopeAdmin) {
 			isAdmin = true
 		}
 	}
@@ -235,7 +253,7 @@ func searchServers(c *gin.Context) {
 			if p == nil {
 				continue
 			}
-			if scopes.ContainsScope(p.Scopes, scopes.ScopeServerStatus) {
+			if scopes.ContainsScope(p.EffectiveScopes(), scopes.ScopeServerStatus) {
 				v.CanGetStatus = true
 				break
 			}
@@ -290,7 +308,7 @@ func getServer(c *gin.Context) {
 }
 
 // @Summary Create server
-// @Description Creates a server
+// @Description Creates a server on the given node, or on one picked for it when a placement is given. The node must have room for the resources the server asks for. Ports left at 0 are given out of the node's port pool.
 // @Success 200 {object} models.CreateServerResponse
 // @Param id path string true "Server ID"
 // @Param server body models.ServerCreation true "Creation information"
@@ -303,6 +321,8 @@ func createServer(c *gin.Context) {
 	ns := &services.Node{DB: db}
 	us := &services.User{DB: db}
 	ps := &services.Permission{DB: db}
+	pls := &services.Placement{DB: db}
+	pps := &services.PortPool{DB: db}
 
 	serverId := c.Param("serverId")
 
@@ -321,15 +341,6 @@ func createServer(c *gin.Context) {
 		return
 	}
 
-	node, err := ns.Get(postBody.NodeId)
-
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		response.HandleError(c, pufferpanel.ErrNodeInvalid, http.StatusBadRequest)
-		return
-	} else if response.HandleError(c, err, http.StatusInternalServerError) {
-		return
-	}
-
 	port, err := getFromDataOrDefault(postBody.Variables, "port", uint16(0))
 	if response.HandleError(c, err, http.StatusBadRequest) {
 		return
@@ -347,12 +358,37 @@ func createServer(c *gin.Context) {
 	server := &models.Server{
 		Name:       postBody.Name,
 		Identifier: postBody.Identifier,
-		NodeID:     node.ID,
 		IP:         cast.ToString(ip),
 		Port:       cast.ToUint16(port),
 		Type:       postBody.Type.Type,
 		Icon:       postBody.Icon,
 	}
+	server.SetResources(postBody.Server)
+
+	var node *models.Node
+	if postBody.Placement != "" {
+		node, err = pls.Pick(postBody.Placement, server, postBody.Server)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	} else {
+		node, err = ns.Get(postBody.NodeId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.HandleError(c, pufferpanel.ErrNodeInvalid, http.StatusBadRequest)
+			return
+		} else if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+	}
+	server.NodeID = node.ID
+
+	if err = pls.Check(node, server); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if err = pps.Assign(node, server, &postBody.Server); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
 
 	users := make([]*models.User, len(postBody.Users))
 
@@ -504,6 +540,15 @@ func editServer(c *gin.Context) {
 		server.Icon = postBody.Icon
 	}
 
+	server.SetResources(postBody.Server)
+	if err = (&services.Placement{DB: db}).Check(&server.Node, server); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if err = (&services.PortPool{DB: db}).Assign(&server.Node, server, &postBody.Server); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
 	err = ss.Update(server)
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
@@ -656,8 +701,21 @@ func getServerUsers(c *gin.Context) {
 	}
 
 	users := map[*models.User][]*scopes.Scope{}
+	paths := map[uint][]string{}
+	roles := map[uint][]uint{}
 
 	for _, v := range perms {
+		if v.UserId == nil {
+			//client access is not shown here
+			continue
+		}
+		if len(v.Paths) > 0 {
+			paths[v.User.ID] = v.Paths
+		}
+		for _, r := range v.Roles {
+			roles[v.User.ID] = append(roles[v.User.ID], r.ID)
+		}
+
 		p := make([]*scopes.Scope, 0)
 		for z, r := range users {
 			if v.User.ID == z.ID {
@@ -688,6 +746,8 @@ func getServerUsers(c *gin.Context) {
 			Username: k.Username,
 			Email:    k.Email,
 			Scopes:   v,
+			Paths:    paths[k.ID],
+			Roles:    roles[k.ID],
 		})
 	}
 
@@ -784,21 +844,48 @@ func editServerUser(c *gin.Context) {
 	}
 
 	//update perms to match this "setup", but not stomp over what the user can't change
-	if scopes.ContainsScope(currentPerms.Scopes, scopes.ScopeServerAdmin) || scopes.ContainsScope(currentGlobalPerms.Scopes, scopes.ScopeServerAdmin) || scopes.ContainsScope(currentGlobalPerms.Scopes, scopes.ScopeAdmin) {
+	editorScopes := currentPerms.EffectiveScopes()
+	globalScopes := currentGlobalPerms.EffectiveScopes()
+	unrestricted := scopes.ContainsScope(editorScopes, scopes.ScopeServerAdmin) || scopes.ContainsScope(globalScopes, scopes.ScopeServerAdmin) || scopes.ContainsScope(globalScopes, scopes.ScopeAdmin)
+	if unrestricted {
 		existing.Scopes = perms.Scopes
 	} else {
-		allowedScopes := utils.Union(existing.Scopes, currentPerms.Scopes)
+		allowedScopes := utils.Union(existing.Scopes, editorScopes)
 		//update perms to match this "setup", but not stomp over what the user can't change
-		replacement := scopes.UpdateScopesWhereGranted(existing.Scopes, allowedScopes, currentPerms.Scopes)
+		replacement := scopes.UpdateScopesWhereGranted(existing.Scopes, allowedScopes, editorScopes)
 		existing.Scopes = replacement
 	}
 
+	var roleIds []uint
+	if perms.Roles != nil {
+		roleIds, err = resolveRoleChange(&services.Role{DB: db}, existing, perms.Roles, editorScopes, unrestricted)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
+	//someone limited to certain paths cannot decide where others may go
+	restricted, err := ps.GetPathRestrictions(currentUser.ID, server.Identifier)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	if len(restricted) == 0 {
+		existing.Paths = perms.Paths
+	}
+
 	err = ps.UpdatePermissions(existing)
 
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
 	}
 
+	if perms.Roles != nil {
+		err = ps.SetRoles(existing, roleIds)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
 	if response.HandleError(c, db.Commit().Error, http.StatusInternalServerError) {
 		return
 	}
@@ -937,24 +1024,12 @@ func editServerData(c *gin.Context) {
 func editServerDataAdmin(c *gin.Context) {
 	server := getServerFromGin(c)
 
-	//clone request body, so we can re-set it for the proxy call
-	useHere := &bytes.Buffer{}
-	useThere := &bytes.Buffer{}
-
-	multi := io.MultiWriter(useHere, useThere)
-	_, err := io.Copy(multi, c.Request.Body)
-	if err != nil && response.HandleError(c, err, http.StatusBadRequest) {
-		return
-	}
-
-	_ = c.Request.Body.Close()
-	c.Request.Body = io.NopCloser(useThere)
-
 	var postBody map[string]interface{}
-	err = json.NewDecoder(useHere).Decode(&postBody)
+	err := json.NewDecoder(c.Request.Body).Decode(&postBody)
 	if response.HandleError(c, err, http.StatusBadRequest) {
 		return
 	}
+	_ = c.Request.Body.Close()
 
 	dirty := false
 	port, exist := postBody["port"]
@@ -969,15 +1044,19 @@ func editServerDataAdmin(c *gin.Context) {
 
 	ip, exist := postBody["ip"]
 	if exist {
-		if response.HandleError(c, err, http.StatusBadRequest) {
-			return
-		}
 		server.IP = cast.ToString(ip)
 		dirty = true
 	}
 
+	db := middleware.GetDatabase(c)
+
+	//the ports could be ones the node gave to another server, and any which were picked have to be sent on
+	err = (&services.PortPool{DB: db}).Update(&server.Node, server, postBody)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
 	if dirty {
-		db := middleware.GetDatabase(c)
 		ss := &services.Server{DB: db}
 		err = ss.Update(server)
 		if response.HandleError(c, err, http.StatusInternalServerError) {
@@ -985,6 +1064,15 @@ func editServerDataAdmin(c *gin.Context) {
 		}
 	}
 
+	//re-set the body for the proxy call, with the ports which were given
+	data, err := json.Marshal(postBody)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	c.Request.ContentLength = int64(len(data))
+	c.Request.Header.Set("Content-Length", strconv.Itoa(len(data)))
+
 	proxyServerRequest(c)
 }
 
@@ -1082,6 +1170,8 @@ func createBackup(c *gin.Context) {
 		return
 	}
 
+	middleware.SetAuditTarget(c, cast.ToString(backup.ID))
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -1225,6 +1315,54 @@ func downloadBackup(c *gin.Context) {
 	c.DataFromReader(callResponse.StatusCode, callResponse.ContentLength, callResponse.Header.Get("Content-Type"), callResponse.Body, newHeaders)
 }
 
+// @Summary Move server to another node
+// @Description Moves the server to another node, along with its backups when asked. The server is stopped while it is moved and started again on the new node if it was running. This happens in the background, so the migration returned should be checked for how far along it is. If moving fails, the server is left on its old node.
+// @Success 202 {object} models.ServerMigration
+// @Param id path string true "Server ID"
+// @Param migration body models.ServerMigrationRequest true "Node to move to"
+// @Router /api/servers/{id}/migration [post]
+// @Security OAuth2Application[server.migrate]
+func migrateServer(c *gin.Context) {
+	server := getServerFromGin(c)
+	db := middleware.GetDatabase(c)
+
+	request := &models.ServerMigrationRequest{}
+	if err := c.BindJSON(request); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	node, err := (&services.Node{DB: db}).Get(request.NodeId)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	ms := &services.Migration{DB: db}
+	migration, err := ms.Start(server, node, request.Backups)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, migration)
+}
+
+// @Summary Get server migration
+// @Description Gets the last time the server was moved to another node, and how far along that is
+// @Success 200 {object} models.ServerMigration
+// @Param id path string true "Server ID"
+// @Router /api/servers/{id}/migration [get]
+// @Security OAuth2Application[server.migrate]
+func getServerMigration(c *gin.Context) {
+	server := getServerFromGin(c)
+	db := middleware.GetDatabase(c)
+
+	migration, err := (&services.Migration{DB: db}).Get(server.Identifier)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, migration)
+}
+
 func getFromData(variables map[string]pufferpanel.Variable, key string) (result interface{}, exists bool) {
 	for k, v := range variables {
 		if k == key {
@@ -1270,6 +1408,14 @@ func proxyServerRequest(c *gin.Context) {
 	//switch to our token for auth
 	c.Request.Header.Set("Authorization", "Bearer "+token)
 
+	//tell the daemon which files this user is limited to, replacing anything they may have sent themselves
+	permService := &services.Permission{DB: db}
+	paths, err := permService.GetPathRestrictions(user.ID, server.Identifier)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	middleware.SetPathRestrictions(c.Request.Header, paths)
+
 	if c.IsWebsocket() {
 		//for websocket, nuke the query params to avoid trying to escalate
 		resolvedPath = strings.SplitN(resolvedPath, "?", 2)[0]
@@ -1277,20 +1423,19 @@ func proxyServerRequest(c *gin.Context) {
 			resolvedPath = "/" + resolvedPath
 		}
 
-		permService := &services.Permission{DB: db}
 		perms, err := permService.GetForUserAndServer(user.ID, server.Identifier)
 		if response.HandleError(c, err, http.StatusInternalServerError) {
 			return
 		}
 
-		allScopes := perms.Scopes
+		allScopes := perms.EffectiveScopes()
 
 		perms, err = permService.GetForUserAndServer(user.ID, "")
 		if response.HandleError(c, err, http.StatusInternalServerError) {
 			return
 		}
 
-		allScopes = append(allScopes, perms.Scopes...)
+		allScopes = append(allScopes, perms.EffectiveScopes()...)
 
 		//add the params we can grant for this request
 		var params []string
@@ -1303,6 +1448,10 @@ func proxyServerRequest(c *gin.Context) {
 		if scopes.ContainsScope(allScopes, scopes.ScopeServerStats) {
 			params = append(params, "stats")
 		}
+		//file changes would show paths the user may be restricted from seeing
+		if scopes.ContainsScope(allScopes, scopes.ScopeServerFileView) && len(paths) == 0 {
+			params = append(params, "files")
+		}
 		resolvedPath = resolvedPath + "?" + strings.Join(params, "&")
 
 		proxySocketRequest(c, resolvedPath, ns, node)
@@ -13,11 +13,11 @@ import (
 )
 
 func registerSettings(g *gin.RouterGroup) {
-	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeSettingsEdit), setSettings)
+	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeSettingsEdit), middleware.Audited("settings.edit", auditSettings), setSettings)
 	g.Handle("OPTIONS", "", response.CreateOptions("POST"))
 
 	g.Handle("GET", "/:key", middleware.RequiresPermission(scopes.ScopeSettingsEdit), getSetting)
-	g.Handle("PUT", "/:key", middleware.RequiresPermission(scopes.ScopeSettingsEdit), setSetting)
+	g.Handle("PUT", "/:key", middleware.RequiresPermission(scopes.ScopeSettingsEdit), middleware.Audited("settings.edit", auditSettings), setSetting)
 	g.Handle("OPTIONS", "/:key", response.CreateOptions("GET", "PUT"))
 }
 
@@ -17,16 +17,16 @@ import (
 
 func registerTemplates(g *gin.RouterGroup) {
 	g.Handle("GET", "", middleware.RequiresPermission(scopes.ScopeTemplatesView), getRepos)
-	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeTemplatesRepoCreate), addRepo)
+	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeTemplatesRepoCreate), middleware.Audited("template.repo.create", auditTemplateRepo), addRepo)
 	g.Handle("OPTIONS", "", response.CreateOptions("GET", "POST"))
 
 	g.Handle("GET", "/:repo", middleware.RequiresPermission(scopes.ScopeTemplatesView), getsTemplatesForRepo)
-	g.Handle("DELETE", "/:repo", middleware.RequiresPermission(scopes.ScopeTemplatesRepoDelete), deleteRepo)
+	g.Handle("DELETE", "/:repo", middleware.RequiresPermission(scopes.ScopeTemplatesRepoDelete), middleware.Audited("template.repo.delete", auditTemplateRepo), deleteRepo)
 	g.Handle("OPTIONS", "/:repo", response.CreateOptions("GET", "PUT", "DELETE"))
 
 	g.Handle("GET", "/:repo/:name", middleware.RequiresPermission(scopes.ScopeTemplatesView), getTemplateFromRepo)
-	g.Handle("DELETE", "/0/:name", middleware.RequiresPermission(scopes.ScopeTemplatesLocalEdit), deleteTemplate)
-	g.Handle("PUT", "/0/:name", middleware.RequiresPermission(scopes.ScopeTemplatesLocalEdit), putTemplate)
+	g.Handle("DELETE", "/0/:name", middleware.RequiresPermission(scopes.ScopeTemplatesLocalEdit), middleware.Audited("template.delete", auditTemplate), deleteTemplate)
+	g.Handle("PUT", "/0/:name", middleware.RequiresPermission(scopes.ScopeTemplatesLocalEdit), middleware.Audited("template.edit", auditTemplate), putTemplate)
 	g.Handle("OPTIONS", "/:repo/:name", response.CreateOptions("GET"))
 	g.Handle("OPTIONS", "/0/:name", response.CreateOptions("GET", "DELETE", "PUT"))
 }
@@ -104,6 +104,8 @@ func addRepo(c *gin.Context) {
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
 	}
+
+	middleware.SetAuditTarget(c, cast.ToString(repo.ID))
 	c.JSON(http.StatusOK, repo)
 }
 
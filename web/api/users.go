@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/middleware"
 	"github.com/pufferpanel/pufferpanel/v3/models"
 	"github.com/pufferpanel/pufferpanel/v3/response"
@@ -15,17 +16,26 @@ import (
 
 func registerUsers(g *gin.RouterGroup) {
 	g.Handle("GET", "", middleware.RequiresPermission(scopes.ScopeUserInfoSearch), searchUsers)
-	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), createUser)
+	g.Handle("POST", "", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), middleware.Audited("user.create", auditUser), createUser)
 	g.Handle("OPTIONS", "", response.CreateOptions("GET", "POST"))
 
 	g.Handle("GET", "/:id", middleware.RequiresPermission(scopes.ScopeUserInfoView), getUser)
-	g.Handle("POST", "/:id", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), updateUser)
-	g.Handle("DELETE", "/:id", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), deleteUser)
+	g.Handle("POST", "/:id", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), middleware.Audited("user.edit", auditUser), updateUser)
+	g.Handle("DELETE", "/:id", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), middleware.Audited("user.delete", auditUser), deleteUser)
 	g.Handle("OPTIONS", "/:id", response.CreateOptions("GET", "POST", "DELETE"))
 
 	g.Handle("GET", "/:id/perms", middleware.RequiresPermission(scopes.ScopeUserPermsView), getUserPerms)
-	g.Handle("PUT", "/:id/perms", middleware.RequiresPermission(scopes.ScopeUserPermsEdit), setUserPerms)
+	g.Handle("PUT", "/:id/perms", middleware.RequiresPermission(scopes.ScopeUserPermsEdit), middleware.Audited("user.perms.edit", auditUserPerms), setUserPerms)
 	g.Handle("OPTIONS", "/:id/perms", response.CreateOptions("PUT", "GET"))
+
+	g.Handle("GET", "/:id/perms/effective", middleware.RequiresPermission(scopes.ScopeUserPermsView), getUserEffectivePerms)
+	g.Handle("OPTIONS", "/:id/perms/effective", response.CreateOptions("GET"))
+
+	g.Handle("DELETE", "/:id/secondfactors", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), resetUserSecondFactors)
+	g.Handle("OPTIONS", "/:id/secondfactors", response.CreateOptions("DELETE"))
+
+	g.Handle("DELETE", "/:id/sessions", middleware.RequiresPermission(scopes.ScopeUserInfoEdit), deleteUserSessions)
+	g.Handle("OPTIONS", "/:id/sessions", response.CreateOptions("DELETE"))
 }
 
 // @Summary Get users
@@ -105,6 +115,8 @@ func createUser(c *gin.Context) {
 		return
 	}
 
+	middleware.SetAuditTarget(c, cast.ToString(user.ID))
+
 	resultModel := models.FromUser(user)
 
 	c.JSON(http.StatusOK, resultModel)
@@ -214,6 +226,62 @@ func deleteUser(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// @Summary Reset second factors of user
+// @Description Removes the OTP, security keys and recovery codes of a user who has lost access to them. This is recorded in the audit log.
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "User ID"
+// @Router /api/users/{id}/secondfactors [delete]
+// @Security OAuth2Application[users.info.edit]
+func resetUserSecondFactors(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	as := &services.Audit{DB: db}
+
+	var err error
+	var id uint
+	if id, err = cast.ToUintE(c.Param("id")); err != nil {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := us.GetById(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	factors, err := us.SecondFactors(user)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	if err = us.ResetSecondFactors(user.ID); response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	//clients acting without a user are still recorded, just without who did it
+	var actorId *uint
+	if actor, ok := c.Get("user"); ok {
+		actorId = &actor.(*models.User).ID
+	}
+	err = as.Record(actorId, "user.secondfactors.reset", "user", cast.ToString(user.ID), map[string]interface{}{
+		"removed": factors,
+	}, c.ClientIP())
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "secondFactorsReset", nil, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s\n", err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // @Summary Gets user permissions
 // @Success 200 {object} models.PermissionView
 // @Failure 400 {object} pufferpanel.ErrorResponse
@@ -248,6 +316,42 @@ func getUserPerms(c *gin.Context) {
 	c.JSON(http.StatusOK, models.FromPermission(perms))
 }
 
+// @Summary Gets user effective permissions
+// @Description Gets everything a user can do, either globally or on a server, including what their roles give them
+// @Success 200 {object} models.EffectivePermissionsView
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "User ID"
+// @Param server query string false "Server ID"
+// @Router /api/users/{id}/perms/effective [get]
+// @Security OAuth2Application[users.perms.view]
+func getUserEffectivePerms(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	ps := &services.Permission{DB: db}
+
+	var err error
+	var id uint
+	if id, err = cast.ToUintE(c.Param("id")); err != nil {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := us.GetById(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	perms, err := ps.GetEffectiveForUser(user.ID, c.Query("server"))
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, perms)
+}
+
 // @Summary Sets user permissions
 // @Success 204 {object} nil
 // @Failure 400 {object} pufferpanel.ErrorResponse
@@ -294,20 +398,81 @@ func setUserPerms(c *gin.Context) {
 	}
 
 	//admins can override, so skip our comparers
-	if scopes.ContainsScope(editorPerms.Scopes, scopes.ScopeAdmin) {
+	editorScopes := editorPerms.EffectiveScopes()
+	isAdmin := scopes.ContainsScope(editorScopes, scopes.ScopeAdmin)
+	if isAdmin {
 		perms.Scopes = viewModel.Scopes
 	} else {
-		allowedScopes := utils.Union(viewModel.Scopes, editorPerms.Scopes)
+		allowedScopes := utils.Union(viewModel.Scopes, editorScopes)
 		//update perms to match this "setup", but not stomp over what the user can't change
-		replacement := scopes.UpdateScopesWhereGranted(perms.Scopes, allowedScopes, editorPerms.Scopes)
+		replacement := scopes.UpdateScopesWhereGranted(perms.Scopes, allowedScopes, editorScopes)
 		perms.Scopes = replacement
 	}
 
+	var roleIds []uint
+	if viewModel.Roles != nil {
+		roleIds, err = resolveRoleChange(&services.Role{DB: db}, perms, viewModel.Roles, editorScopes, isAdmin)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
 	err = ps.UpdatePermissions(perms)
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
 	}
 
+	if viewModel.Roles != nil {
+		err = ps.SetRoles(perms, roleIds)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Log out a user
+// @Description Revokes every session of a user, including those of clients acting for them and their refresh tokens. This is recorded in the audit log.
+// @Success 204 {object} nil
+// @Failure 400 {object} pufferpanel.ErrorResponse
+// @Failure 403 {object} pufferpanel.ErrorResponse
+// @Failure 404 {object} pufferpanel.ErrorResponse
+// @Failure 500 {object} pufferpanel.ErrorResponse
+// @Param id path uint true "User ID"
+// @Router /api/users/{id}/sessions [delete]
+// @Security OAuth2Application[users.info.edit]
+func deleteUserSessions(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	ss := &services.Session{DB: db}
+	as := &services.Audit{DB: db}
+
+	var err error
+	var id uint
+	if id, err = cast.ToUintE(c.Param("id")); err != nil {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := us.GetById(id)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	if err = ss.ExpireForUser(user.ID); response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	var actorId *uint
+	if actor, ok := c.Get("user"); ok {
+		actorId = &actor.(*models.User).ID
+	}
+	err = as.Record(actorId, "user.sessions.revoked", "user", cast.ToString(user.ID), nil, c.ClientIP())
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -12,7 +12,15 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	rg.POST("login", middleware.NeedsDatabase, LoginPost)
 	rg.POST("logout", middleware.NeedsDatabase, LogoutPost)
 	rg.POST("otp", middleware.NeedsDatabase, OtpPost)
+	rg.POST("webauthn/begin", middleware.NeedsDatabase, WebAuthnBeginPost)
+	rg.POST("webauthn/finish", middleware.NeedsDatabase, WebAuthnFinishPost)
+	rg.POST("recovery", middleware.NeedsDatabase, RecoveryPost)
 	rg.POST("register", middleware.NeedsDatabase, RegisterPost)
+	rg.POST("reset/request", middleware.NeedsDatabase, ResetRequestPost)
+	rg.POST("reset/confirm", middleware.NeedsDatabase, ResetConfirmPost)
+	rg.GET("oidc", OidcProvidersGet)
+	rg.GET("oidc/:provider/login", middleware.NeedsDatabase, OidcLoginGet)
+	rg.GET("oidc/:provider/callback", middleware.NeedsDatabase, OidcCallbackGet)
 	rg.POST("reauth", middleware.AuthMiddleware, middleware.NeedsDatabase, Reauth)
 
 	rg.GET("publickey", TokenServiceGetPublicKey)
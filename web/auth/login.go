@@ -1,15 +1,18 @@
 package auth
 
 import (
+	"errors"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/middleware"
 	"github.com/pufferpanel/pufferpanel/v3/models"
 	"github.com/pufferpanel/pufferpanel/v3/response"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"github.com/pufferpanel/pufferpanel/v3/services"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -24,18 +27,31 @@ func LoginPost(c *gin.Context) {
 		return
 	}
 
+	if !checkLockout(c, request.Email) {
+		return
+	}
+
 	user, otpNeeded, err := us.ValidateLogin(request.Email, request.Password)
+	if errors.Is(err, pufferpanel.ErrInvalidCredentials) {
+		failLogin(c, request.Email)
+	}
 	if response.HandleError(c, err, http.StatusBadRequest) {
 		return
 	}
 
 	if otpNeeded {
+		factors, err := us.SecondFactors(user)
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+
 		userSession := sessions.Default(c)
 		userSession.Set("user", user.Email)
 		userSession.Set("time", time.Now().Unix())
 		_ = userSession.Save()
 		c.JSON(http.StatusOK, &LoginResponse{
-			OtpNeeded: true,
+			OtpNeeded:     true,
+			SecondFactors: factors,
 		})
 		return
 	}
@@ -54,52 +70,123 @@ func OtpPost(c *gin.Context) {
 		return
 	}
 
+	email, ok := pendingLogin(c)
+	if !ok || !checkLockout(c, email) {
+		return
+	}
+
+	user, err := us.ValidOtp(email, request.Token)
+	if errors.Is(err, pufferpanel.ErrInvalidCredentials) {
+		failLogin(c, email)
+	}
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	finishPendingLogin(c, user)
+}
+
+// pendingLogin Gets the email of the user who has given their password and still has to give their second factor
+func pendingLogin(c *gin.Context) (string, bool) {
 	userSession := sessions.Default(c)
-	email := userSession.Get("user").(string)
-	timestamp := userSession.Get("time").(int64)
+	email, _ := userSession.Get("user").(string)
+	timestamp, _ := userSession.Get("time").(int64)
 
 	if email == "" {
 		response.HandleError(c, pufferpanel.ErrInvalidSession, http.StatusBadRequest)
-		return
+		return "", false
 	}
 
 	if timestamp < time.Now().Unix()-300 {
 		userSession.Clear()
 		_ = userSession.Save()
 		response.HandleError(c, pufferpanel.ErrSessionExpired, http.StatusBadRequest)
-		return
+		return "", false
 	}
 
-	user, err := us.ValidOtp(email, request.Token)
-	if response.HandleError(c, err, http.StatusBadRequest) {
-		return
+	return email, true
+}
+
+// checkLockout refuses the login if the IP or account has failed too many times, saying when they can try again
+func checkLockout(c *gin.Context, account string) bool {
+	ls := &services.LoginLimiter{DB: middleware.GetDatabase(c)}
+
+	until, err := ls.Check(c.ClientIP(), account)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return false
+	}
+	if !until.IsZero() {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(until)/time.Second)+1))
+		response.HandleError(c, pufferpanel.ErrLoginLocked, http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// failLogin counts a failed login against the IP and account. This can't fail the request, which has already failed.
+func failLogin(c *gin.Context, account string) {
+	ls := &services.LoginLimiter{DB: middleware.GetDatabase(c)}
+
+	until, err := ls.Fail(c.ClientIP(), account)
+	if err != nil {
+		logging.Error.Printf("Error recording failed login: %s", err.Error())
+	} else if !until.IsZero() {
+		logging.Info.Printf("Logins to %s or from %s locked until %s", account, c.ClientIP(), until.Format(time.RFC3339))
 	}
+}
+
+// finishPendingLogin Logs the user in once their second factor is validated, so the pending login can't be used again
+func finishPendingLogin(c *gin.Context, user *models.User) {
+	userSession := sessions.Default(c)
+	userSession.Clear()
+	_ = userSession.Save()
 
 	createSession(c, user)
 }
 
 func createSession(c *gin.Context, user *models.User) {
+	data, status, err := startSession(c, user)
+	if response.HandleError(c, err, status) {
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// startSession logs the user in by setting the session cookies, if they are allowed to log in
+func startSession(c *gin.Context, user *models.User) (*LoginResponse, int, error) {
 	db := middleware.GetDatabase(c)
 	ps := &services.Permission{DB: db}
 	ss := &services.Session{DB: db}
 
 	perms, err := ps.GetForUserAndServer(user.ID, "")
-	if response.HandleError(c, err, http.StatusInternalServerError) {
-		return
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
 	}
 
-	if !scopes.ContainsScope(perms.Scopes, scopes.ScopeLogin) {
-		response.HandleError(c, pufferpanel.ErrLoginNotPermitted, http.StatusForbidden)
-		return
+	if !scopes.ContainsScope(perms.EffectiveScopes(), scopes.ScopeLogin) {
+		return nil, http.StatusForbidden, pufferpanel.ErrLoginNotPermitted
 	}
 
 	session, err := ss.CreateForUser(user)
-	if response.HandleError(c, err, http.StatusInternalServerError) {
-		return
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	//the user is told if someone was trying to get into their account, in case it wasn't them who got in
+	ls := &services.LoginLimiter{DB: db}
+	failed, err := ls.Succeed(user.Email)
+	if err != nil {
+		logging.Error.Printf("Error clearing failed logins: %s", err.Error())
+	} else if failed != nil {
+		err = services.GetEmailService().SendEmail(user.Email, "login", map[string]interface{}{"IP": c.ClientIP()}, true)
+		if err != nil {
+			logging.Error.Printf("Error sending email: %s\n", err)
+		}
 	}
 
 	data := &LoginResponse{}
-	data.Scopes = perms.Scopes
+	data.Scopes = perms.EffectiveScopes()
 
 	secure := false
 	if c.Request.TLS != nil {
@@ -111,7 +198,7 @@ func createSession(c *gin.Context, user *models.User) {
 	c.SetCookie("puffer_auth", session, maxAge, "/", "", secure, true)
 	c.SetCookie("puffer_auth_expires", "", maxAge, "/", "", secure, false)
 
-	c.JSON(http.StatusOK, data)
+	return data, http.StatusOK, nil
 }
 
 type LoginRequestData struct {
@@ -120,8 +207,9 @@ type LoginRequestData struct {
 }
 
 type LoginResponse struct {
-	Scopes    []*scopes.Scope `json:"scopes,omitempty"`
-	OtpNeeded bool            `json:"otpNeeded,omitempty"`
+	Scopes        []*scopes.Scope `json:"scopes,omitempty"`
+	OtpNeeded     bool            `json:"otpNeeded,omitempty"`
+	SecondFactors []string        `json:"secondFactors,omitempty"`
 }
 
 type OtpRequestData struct {
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// oidcStateCookie ties a login at a provider to the browser which started it
+const oidcStateCookie = "puffer_oidc"
+
+// OidcProvidersGet lists the providers which can be used to log in
+func OidcProvidersGet(c *gin.Context) {
+	providers := config.OidcProviders.Value()
+	result := make([]OIDCProviderInfo, 0, len(providers))
+	for _, v := range providers {
+		name := v.DisplayName
+		if name == "" {
+			name = v.Name
+		}
+		result = append(result, OIDCProviderInfo{Name: v.Name, DisplayName: name})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// OidcLoginGet sends the user to the provider to log in
+func OidcLoginGet(c *gin.Context) {
+	oidc := &services.OIDC{DB: middleware.GetDatabase(c)}
+
+	authUrl, state, err := oidc.StartLogin(c.Param("provider"))
+	if err != nil {
+		oidcRedirectError(c, err)
+		return
+	}
+
+	c.SetCookie(oidcStateCookie, state, int(10*time.Minute/time.Second), "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authUrl)
+}
+
+// OidcCallbackGet is where the provider sends the user back to, which logs them in
+func OidcCallbackGet(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	oidc := &services.OIDC{DB: db}
+
+	state, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		logging.Info.Printf("Login with %s was refused: %s %s", c.Param("provider"), providerErr, c.Query("error_description"))
+		oidcRedirectError(c, pufferpanel.ErrOIDCLoginFailed)
+		return
+	}
+
+	if state == "" || state != c.Query("state") {
+		oidcRedirectError(c, pufferpanel.ErrInvalidSession)
+		return
+	}
+
+	user, err := oidc.FinishLogin(c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		oidcRedirectError(c, err)
+		return
+	}
+
+	//the provider only replaces the password, a second factor is still needed
+	us := &services.User{DB: db}
+	factors, err := us.SecondFactors(user)
+	if err != nil {
+		oidcRedirectError(c, err)
+		return
+	}
+	if len(factors) > 0 {
+		userSession := sessions.Default(c)
+		userSession.Set("user", user.Email)
+		userSession.Set("time", time.Now().Unix())
+		_ = userSession.Save()
+		c.Redirect(http.StatusFound, "/auth/login?otp=true&factors="+url.QueryEscape(strings.Join(factors, ",")))
+		return
+	}
+
+	_, _, err = startSession(c, user)
+	if err != nil {
+		oidcRedirectError(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, "/auth/login?oidc=success")
+}
+
+// oidcRedirectError sends the user back to the login page, as the login happens outside the frontend
+func oidcRedirectError(c *gin.Context, err error) {
+	code := pufferpanel.ErrOIDCLoginFailed.Code
+	var genericErr *pufferpanel.Error
+	if errors.As(err, &genericErr) {
+		code = genericErr.Code
+	} else {
+		logging.Error.Printf("Error logging in with %s: %s", c.Param("provider"), err.Error())
+	}
+	c.Redirect(http.StatusFound, "/auth/login?error="+url.QueryEscape(code))
+}
+
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+} //@name OIDCProviderInfo
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ResetRequestPost emails a reset link to the user with the given email.
+// The response is the same whether the email is known or not, so it can't be used to find out who has an account.
+func ResetRequestPost(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	ps := &services.PasswordReset{DB: db}
+
+	request := &ResetRequestData{}
+	err := c.BindJSON(request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if request.Email == "" {
+		response.HandleError(c, pufferpanel.ErrFieldRequired("email"), http.StatusBadRequest)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+	user, err := us.GetByEmail(request.Email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	} else if err != nil {
+		logging.Error.Printf("Error looking up user for password reset: %s", err.Error())
+		return
+	}
+
+	token, err := ps.Create(user)
+	if errors.Is(err, pufferpanel.ErrLDAPManaged) {
+		logging.Info.Printf("Password reset for user %d skipped, their password is managed by LDAP", user.ID)
+		return
+	} else if errors.Is(err, pufferpanel.ErrPasswordResetLimit) {
+		logging.Info.Printf("Password reset for user %d skipped, too many have been requested", user.ID)
+		return
+	} else if err != nil {
+		logging.Error.Printf("Error creating password reset: %s", err.Error())
+		return
+	}
+
+	//sending can take long enough to tell known emails apart by how slow the response is, so it finishes afterwards
+	link := strings.TrimSuffix(config.MasterUrl.Value(), "/") + "/auth/reset?token=" + url.QueryEscape(token)
+	go func(email string) {
+		err := services.GetEmailService().SendEmail(email, "passwordReset", map[string]interface{}{"RESET_LINK": link}, true)
+		if err != nil {
+			logging.Error.Printf("Error sending email: %s", err.Error())
+		}
+	}(user.Email)
+}
+
+// ResetConfirmPost sets a new password using the token from a reset link, and logs the user out everywhere
+func ResetConfirmPost(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	ps := &services.PasswordReset{DB: db}
+
+	request := &ResetConfirmData{}
+	err := c.BindJSON(request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if request.Token == "" {
+		response.HandleError(c, pufferpanel.ErrPasswordResetInvalid, http.StatusBadRequest)
+		return
+	}
+
+	user, err := ps.Use(request.Token, request.Password)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	err = services.GetEmailService().SendEmail(user.Email, "passwordChanged", nil, true)
+	if err != nil {
+		logging.Error.Printf("Error sending email: %s", err.Error())
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type ResetRequestData struct {
+	Email string `json:"email"`
+}
+
+type ResetConfirmData struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"io"
+	"net/http"
+)
+
+// WebAuthnBeginPost Gets the challenge for the security key of the user who is logging in
+func WebAuthnBeginPost(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	ws := &services.WebAuthn{DB: db}
+
+	email, ok := pendingLogin(c)
+	if !ok {
+		return
+	}
+
+	user, err := us.GetByEmail(email)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	assertion, err := ws.BeginLogin(user)
+	if errors.Is(err, pufferpanel.ErrWebAuthnFailed) {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, assertion)
+}
+
+// WebAuthnFinishPost Validates the challenge signed by the security key, and logs the user in
+func WebAuthnFinishPost(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	ws := &services.WebAuthn{DB: db}
+
+	email, ok := pendingLogin(c)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	user, err := us.GetByEmail(email)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	err = ws.FinishLogin(user, body, c.ClientIP())
+	if errors.Is(err, pufferpanel.ErrWebAuthnFailed) || errors.Is(err, pufferpanel.ErrInvalidSession) {
+		response.HandleError(c, err, http.StatusBadRequest)
+		return
+	}
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	finishPendingLogin(c, user)
+}
+
+// RecoveryPost Logs the user in with one of their recovery codes, instead of their OTP or security key
+func RecoveryPost(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	us := &services.User{DB: db}
+	rs := &services.RecoveryCode{DB: db}
+
+	request := &RecoveryRequestData{}
+	err := c.BindJSON(request)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	email, ok := pendingLogin(c)
+	if !ok || !checkLockout(c, email) {
+		return
+	}
+
+	user, err := us.GetByEmail(email)
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	err = rs.Use(user.ID, request.Code)
+	if errors.Is(err, pufferpanel.ErrInvalidCredentials) {
+		failLogin(c, email)
+	}
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	finishPendingLogin(c, user)
+}
+
+type RecoveryRequestData struct {
+	Code string `json:"code"`
+}
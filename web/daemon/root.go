@@ -5,8 +5,14 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/gin-gonic/gin"
 	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
 	"github.com/pufferpanel/pufferpanel/v3/response"
 	"github.com/pufferpanel/pufferpanel/v3/servers"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/mem"
 	"net/http"
 	"runtime"
 	"time"
@@ -20,6 +26,9 @@ func RegisterDaemonRoutes(e *gin.RouterGroup) {
 	e.GET("features", getFeatures)
 	e.Handle("OPTIONS", "features", response.CreateOptions("GET"))
 
+	e.GET("stats", middleware.ValidateJWT, getHostStats)
+	e.Handle("OPTIONS", "stats", response.CreateOptions("GET"))
+
 	RegisterServerRoutes(e)
 }
 
@@ -58,6 +67,46 @@ func getFeatures(c *gin.Context) {
 	c.JSON(http.StatusOK, Features{Features: features, Environments: envs, OS: runtime.GOOS, Arch: runtime.GOARCH, Version: pufferpanel.Version})
 }
 
+// @Summary Get node stats
+// @Description Gets how many servers the daemon has, and what the host is using
+// @Success 200 {object} pufferpanel.DaemonStats
+// @Router /daemon/stats [get]
+// @Security OAuth2Application[none]
+func getHostStats(c *gin.Context) {
+	c.JSON(http.StatusOK, &pufferpanel.DaemonStats{
+		Servers: len(servers.GetAll()),
+		Host:    getHostResources(),
+	})
+}
+
+// getHostResources gets what it can of the host's usage, anything which can't be read is left as 0
+func getHostResources() *pufferpanel.HostResources {
+	host := &pufferpanel.HostResources{CPUs: runtime.NumCPU()}
+
+	//with no interval, this is the usage since the last call
+	if usage, err := cpu.Percent(0, false); err != nil {
+		logging.Debug.Printf("Error getting cpu usage: %s", err.Error())
+	} else if len(usage) > 0 {
+		host.CPUUsage = usage[0]
+	}
+
+	if memory, err := mem.VirtualMemory(); err != nil {
+		logging.Debug.Printf("Error getting memory usage: %s", err.Error())
+	} else {
+		host.MemoryTotal = memory.Total
+		host.MemoryUsed = memory.Used
+	}
+
+	if usage, err := disk.Usage(config.ServersFolder.Value()); err != nil {
+		logging.Debug.Printf("Error getting disk usage: %s", err.Error())
+	} else {
+		host.DiskTotal = usage.Total
+		host.DiskUsed = usage.Used
+	}
+
+	return host
+}
+
 func testDocker() bool {
 	d, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
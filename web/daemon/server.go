@@ -10,6 +10,7 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/gorilla/websocket"
 	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/files"
 	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/middleware"
 	"github.com/pufferpanel/pufferpanel/v3/query"
@@ -18,12 +19,22 @@ import (
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"github.com/spf13/cast"
 	"io"
+	iofs "io/fs"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 )
 
+const defaultSearchPageSize = 50
+const maxSearchPageSize = 500
+const maxSearchResults = 10000
+const defaultSearchFileSize = 10 * 1024 * 1024
+const defaultSftpLogPageSize = 50
+const maxSftpLogPageSize = 500
+
 var wsupgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -77,9 +88,21 @@ func RegisterServerRoutes(e *gin.RouterGroup) {
 		l.GET("/:serverId/file/*filename", middleware.ResolveServerNode, getFile)
 		l.PUT("/:serverId/file/*filename", middleware.ResolveServerNode, putFile)
 		l.DELETE("/:serverId/file/*filename", middleware.ResolveServerNode, deleteFile)
-		l.POST("/:serverId/file/*filename", middleware.ResolveServerNode, response.NotImplemented)
+		l.POST("/:serverId/file/*filename", middleware.ResolveServerNode, postFile)
 		l.OPTIONS("/:serverId/file/*filename", response.CreateOptions("GET", "PUT", "DELETE", "POST"))
 
+		l.GET("/:serverId/search", middleware.ResolveServerNode, searchFiles)
+		l.OPTIONS("/:serverId/search", response.CreateOptions("GET"))
+
+		l.POST("/:serverId/upload", middleware.ResolveServerNode, createUpload)
+		l.OPTIONS("/:serverId/upload", response.CreateOptions("POST"))
+
+		l.GET("/:serverId/upload/:uploadId", middleware.ResolveServerNode, getUpload)
+		l.PUT("/:serverId/upload/:uploadId", middleware.ResolveServerNode, writeUpload)
+		l.POST("/:serverId/upload/:uploadId", middleware.ResolveServerNode, finishUpload)
+		l.DELETE("/:serverId/upload/:uploadId", middleware.ResolveServerNode, cancelUpload)
+		l.OPTIONS("/:serverId/upload/:uploadId", response.CreateOptions("GET", "PUT", "POST", "DELETE"))
+
 		l.GET("/:serverId/console", middleware.ResolveServerNode, getLogs)
 		l.POST("/:serverId/console", middleware.ResolveServerNode, postConsole)
 		l.OPTIONS("/:serverId/console", response.CreateOptions("GET", "POST"))
@@ -94,6 +117,9 @@ func RegisterServerRoutes(e *gin.RouterGroup) {
 		l.GET("/:serverId/status", middleware.ResolveServerNode, getStatus)
 		l.OPTIONS("/:serverId/status", response.CreateOptions("GET"))
 
+		l.GET("/:serverId/sftp/log", middleware.ResolveServerNode, getSftpLog)
+		l.OPTIONS("/:serverId/sftp/log", response.CreateOptions("GET"))
+
 		l.POST("/:serverId/archive/*filename", middleware.ResolveServerNode, archive)
 		l.POST("/:serverId/extract/*filename", middleware.ResolveServerNode, extract)
 
@@ -102,6 +128,15 @@ func RegisterServerRoutes(e *gin.RouterGroup) {
 		l.POST("/:serverId/backup/restore", middleware.ResolveServerNode, restoreBackup)
 		l.GET("/:serverId/backup/download", middleware.ResolveServerNode, downloadBackup)
 
+		l.GET("/:serverId/transfer", middleware.ResolveServerNode, sendTransfer)
+		l.POST("/:serverId/transfer", middleware.ResolveServerNode, receiveTransfer)
+		l.OPTIONS("/:serverId/transfer", response.CreateOptions("GET", "POST"))
+		l.GET("/:serverId/transfer/status", middleware.ResolveServerNode, getTransferStatus)
+		l.OPTIONS("/:serverId/transfer/status", response.CreateOptions("GET"))
+		l.PUT("/:serverId/migrating", middleware.ResolveServerNode, lockForMigration)
+		l.DELETE("/:serverId/migrating", middleware.ResolveServerNode, unlockForMigration)
+		l.OPTIONS("/:serverId/migrating", response.CreateOptions("PUT", "DELETE"))
+
 		l.HEAD("/:serverId/query", middleware.ResolveServerNode, canQueryServer)
 		l.GET("/:serverId/query", middleware.ResolveServerNode, queryServer)
 
@@ -537,6 +572,7 @@ func editServerAdmin(c *gin.Context) {
 		server.CopyFrom(backup)
 		return
 	}
+	prg.ApplyQuota()
 
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
@@ -549,9 +585,16 @@ func editServerAdmin(c *gin.Context) {
 // @Description Gets a specific file or a list of files in a folder. This will either return
 // @Description a) A raw file if the path points to a valid file
 // @Description or b) An array of files for the folder contents
+// @Description Folder listings can be filtered, sorted and paged, with the total number of entries in the X-Total-Count header.
+// @Description Files support Range and If-Range for partial downloads.
 // @Success 200 {object} nil
 // @Param id path string true "Server ID"
 // @Param filepath path string true "File path"
+// @Param filter query string false "Glob to filter entry names with"
+// @Param sort query string false "Field to sort on" Enums(name, size, modified, type)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param limit query uint false "Max number of entries to return"
+// @Param page query uint false "What page to get back for many entries"
 // @Router /api/servers/{id}/file/{filepath} [get]
 // @Security OAuth2Application[server.files.view]
 func getFile(c *gin.Context) {
@@ -559,7 +602,30 @@ func getFile(c *gin.Context) {
 
 	targetPath := c.Param("filename")
 
-	data, err := server.GetItem(targetPath)
+	options := servers.ListOptions{
+		Filter:     c.Query("filter"),
+		Sort:       c.Query("sort"),
+		Descending: c.Query("order") == "desc",
+	}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		options.PageSize, err = strconv.Atoi(limit)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+		options.Page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
+	restrictions := middleware.GetPathRestrictions(c)
+	if !files.PathVisible(targetPath, restrictions) {
+		response.HandleError(c, pufferpanel.ErrNoPermission, http.StatusForbidden)
+		return
+	}
+
+	data, err := server.GetItem(targetPath, options)
 	defer func() {
 		if data != nil {
 			utils.Close(data.Contents)
@@ -577,11 +643,36 @@ func getFile(c *gin.Context) {
 		return
 	}
 
+	if !files.PathAllowed(targetPath, restrictions) {
+		//this is only a folder leading to what the user can see, so only show them the way there
+		if data.FileList == nil {
+			response.HandleError(c, pufferpanel.ErrNoPermission, http.StatusForbidden)
+			return
+		}
+		visible := make([]pufferpanel.FileDesc, 0)
+		for _, v := range data.FileList {
+			if v.Name == ".." || files.PathVisible(filepath.Join(targetPath, v.Name), restrictions) {
+				visible = append(visible, v)
+			}
+		}
+		data.FileList = visible
+		data.Total = len(visible)
+	}
+
 	if data.FileList != nil {
+		c.Header("X-Total-Count", strconv.Itoa(data.Total))
 		c.JSON(http.StatusOK, data.FileList)
 	} else if data.Contents != nil {
 		fileName := filepath.Base(data.Name)
 
+		//if we can seek, let the std lib deal with Range and If-Range for partial downloads
+		if seeker, ok := data.Contents.(io.ReadSeeker); ok {
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+			c.Header("Content-Type", "application/octet-stream")
+			http.ServeContent(c.Writer, c.Request, fileName, data.Modified, seeker)
+			return
+		}
+
 		extraHeaders := map[string]string{
 			"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, fileName),
 		}
@@ -613,6 +704,10 @@ func putFile(c *gin.Context) {
 		return
 	}
 
+	if !checkPathsAllowed(c, targetPath) {
+		return
+	}
+
 	var err error
 
 	_, mkFolder := c.GetQuery("folder")
@@ -634,14 +729,18 @@ func putFile(c *gin.Context) {
 		sourceFile = c.Request.Body
 	}
 
-	file, err := server.GetFileServer().OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
-	defer utils.Close(file)
+	file, err := server.GetFileServer().OpenTracked(targetPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
 	}
 
 	_, err = io.Copy(file, sourceFile)
-	if response.HandleError(c, err, http.StatusInternalServerError) {
+	utils.Close(file)
+	if errors.Is(err, files.ErrQuotaExceeded) {
+		//do not leave half a file behind taking up what space is left
+		_ = server.GetFileServer().Remove(targetPath)
+	}
+	if handleFileError(c, err) {
 		return
 	}
 
@@ -661,6 +760,10 @@ func deleteFile(c *gin.Context) {
 
 	targetPath := c.Param("filename")
 
+	if !checkPathsAllowed(c, targetPath) {
+		return
+	}
+
 	fi, err := server.GetFileServer().Stat(targetPath)
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 		return
@@ -677,6 +780,397 @@ func deleteFile(c *gin.Context) {
 	}
 }
 
+// @Summary File operation
+// @Description Performs an operation on a file or folder.
+// @Description copy and move use target as the new location, which must not exist.
+// @Description chmod takes an octal mode, chown can only assign the server's own user and group.
+// @Description delete removes every entry in paths, relative to the folder given, recursively.
+// @Success 204 {object} nil
+// @Param id path string true "Server ID"
+// @Param filepath path string true "File path"
+// @Param operation body pufferpanel.FileOperation true "Operation to perform"
+// @Router /api/servers/{id}/file/{filepath} [post]
+// @Security OAuth2Application[server.files.edit]
+func postFile(c *gin.Context) {
+	server := getServerFromGin(c)
+	fs := server.GetFileServer()
+
+	targetPath := c.Param("filename")
+
+	var req pufferpanel.FileOperation
+	if err := c.BindJSON(&req); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	affected := []string{targetPath}
+	if req.Target != "" {
+		affected = append(affected, req.Target)
+	}
+	for _, v := range req.Paths {
+		affected = append(affected, filepath.Join(targetPath, v))
+	}
+	if !checkPathsAllowed(c, affected...) {
+		return
+	}
+
+	var err error
+	switch req.Action {
+	case "copy":
+		if req.Target == "" {
+			response.HandleError(c, pufferpanel.ErrFieldRequired("target"), http.StatusBadRequest)
+			return
+		}
+		err = server.CopyItem(targetPath, req.Target)
+	case "move":
+		if req.Target == "" {
+			response.HandleError(c, pufferpanel.ErrFieldRequired("target"), http.StatusBadRequest)
+			return
+		}
+		if _, err = fs.Stat(req.Target); err == nil {
+			err = pufferpanel.ErrFileExists
+		} else if os.IsNotExist(err) {
+			err = fs.Rename(targetPath, req.Target)
+		}
+	case "chmod":
+		var mode uint64
+		mode, err = strconv.ParseUint(req.Mode, 8, 32)
+		if err != nil {
+			response.HandleError(c, pufferpanel.ErrFieldRequired("mode"), http.StatusBadRequest)
+			return
+		}
+		//only the permission bits, we do not allow setuid and friends
+		perm := os.FileMode(mode).Perm()
+		err = applyToItem(fs, targetPath, req.Recursive, func(path string) error {
+			return fs.Chmod(path, perm)
+		})
+	case "chown":
+		uid, gid := server.GetEnvironment().GetUid(), server.GetEnvironment().GetGid()
+		if (req.Uid != nil && *req.Uid != uid) || (req.Gid != nil && *req.Gid != gid) {
+			response.HandleError(c, pufferpanel.ErrNoPermission, http.StatusForbidden)
+			return
+		}
+		if uid == -1 {
+			response.HandleError(c, pufferpanel.ErrNotImplemented, http.StatusBadRequest)
+			return
+		}
+		err = applyToItem(fs, targetPath, req.Recursive, func(path string) error {
+			return fs.Chown(path, uid, gid)
+		})
+	case "delete":
+		if len(req.Paths) == 0 {
+			response.HandleError(c, pufferpanel.ErrFieldRequired("paths"), http.StatusBadRequest)
+			return
+		}
+		for _, v := range req.Paths {
+			var fi os.FileInfo
+			path := filepath.Join(targetPath, v)
+			fi, err = fs.Stat(path)
+			if err != nil {
+				break
+			}
+			if fi.IsDir() {
+				err = fs.RemoveAll(path)
+			} else {
+				err = fs.Remove(path)
+			}
+			if err != nil {
+				break
+			}
+		}
+	default:
+		response.HandleError(c, pufferpanel.ErrFieldRequired("action"), http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, pufferpanel.ErrFileExists) || errors.Is(err, files.ErrCopyIntoSelf) {
+		response.HandleError(c, err, http.StatusConflict)
+	} else if os.IsNotExist(err) {
+		c.AbortWithStatus(http.StatusNotFound)
+	} else if handleFileError(c, err) {
+	} else {
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func applyToItem(fs files.FileServer, path string, recursive bool, fn func(path string) error) error {
+	if !recursive {
+		return fn(path)
+	}
+	return files.Walk(fs, path, func(path string, d iofs.DirEntry) error {
+		if d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return fn(path)
+	})
+}
+
+// @Summary Search files
+// @Description Searches the server's files by name, and optionally by contents.
+// @Description Results with content matches include the line numbers and text of the matching lines.
+// @Success 200 {object} pufferpanel.FileSearchResponse
+// @Param id path string true "Server ID"
+// @Param name query string false "Glob to match file names against, or paths if it contains a /"
+// @Param content query string false "Regular expression the file contents must match"
+// @Param path query string false "Folder to search in"
+// @Param depth query int false "How many folders deep to search, 0 is unlimited"
+// @Param maxSize query int64 false "Largest file size to search contents of"
+// @Param limit query uint false "Max number of results to return"
+// @Param page query uint false "What page to get back for many results"
+// @Router /api/servers/{id}/search [get]
+// @Security OAuth2Application[server.files.view]
+func searchFiles(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	options := files.SearchOptions{
+		Root:       c.DefaultQuery("path", "/"),
+		Name:       c.DefaultQuery("name", "*"),
+		MaxScanned: maxSearchResults,
+		Allowed:    middleware.GetPathRestrictions(c),
+	}
+
+	if !files.PathVisible(options.Root, options.Allowed) {
+		response.HandleError(c, pufferpanel.ErrNoPermission, http.StatusForbidden)
+		return
+	}
+
+	var err error
+	if content := c.Query("content"); content != "" {
+		options.Content, err = regexp.Compile(content)
+		if response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
+	options.MaxDepth, err = cast.ToIntE(c.DefaultQuery("depth", "0"))
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	options.MaxFileSize, err = cast.ToInt64E(c.DefaultQuery("maxSize", strconv.Itoa(defaultSearchFileSize)))
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchPageSize)))
+	if response.HandleError(c, err, http.StatusBadRequest) || pageSize <= 0 {
+		response.HandleError(c, pufferpanel.ErrFieldTooSmall("pageSize", 0), http.StatusBadRequest)
+		return
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if response.HandleError(c, err, http.StatusBadRequest) || page <= 0 {
+		response.HandleError(c, pufferpanel.ErrFieldTooSmall("page", 0), http.StatusBadRequest)
+		return
+	}
+
+	options.Limit = pageSize
+	options.Skip = (page - 1) * pageSize
+
+	results, total, err := files.Search(server.GetFileServer(), options)
+	if os.IsNotExist(err) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &pufferpanel.FileSearchResponse{
+		Results: results,
+		Metadata: &pufferpanel.Metadata{Paging: &pufferpanel.Paging{
+			Page:    uint(page),
+			Size:    uint(pageSize),
+			MaxSize: maxSearchPageSize,
+			Total:   int64(total),
+		}},
+	})
+}
+
+// @Summary Start upload
+// @Description Starts a resumable upload for a file. Chunks are sent to the returned upload, and the file is
+// @Description only placed at the path once the upload is finished.
+// @Success 201 {object} files.Upload
+// @Param id path string true "Server ID"
+// @Param upload body pufferpanel.UploadRequest true "Upload information"
+// @Router /api/servers/{id}/upload [post]
+// @Security OAuth2Application[server.files.edit]
+func createUpload(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	var req pufferpanel.UploadRequest
+	if err := c.BindJSON(&req); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+	if req.Path == "" {
+		response.HandleError(c, pufferpanel.ErrFieldRequired("path"), http.StatusBadRequest)
+		return
+	}
+	if !checkPathsAllowed(c, req.Path) {
+		return
+	}
+
+	upload, err := server.GetFileServer().CreateUpload(req.Path, req.Size)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, upload)
+}
+
+// @Summary Get upload
+// @Description Gets the state of an upload, including how much data has been received
+// @Success 200 {object} files.Upload
+// @Param id path string true "Server ID"
+// @Param uploadId path string true "Upload ID"
+// @Router /api/servers/{id}/upload/{uploadId} [get]
+// @Security OAuth2Application[server.files.edit]
+func getUpload(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	upload, ok := getAllowedUpload(c, server)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+// @Summary Upload chunk
+// @Description Writes the body into the upload at the given offset.
+// @Description The offset cannot be past the amount of data already received.
+// @Success 200 {object} files.Upload
+// @Param id path string true "Server ID"
+// @Param uploadId path string true "Upload ID"
+// @Param offset query int64 true "Offset to write the chunk at"
+// @Param chunk body string true "Chunk contents"
+// @Accept application/octet-stream
+// @Router /api/servers/{id}/upload/{uploadId} [put]
+// @Security OAuth2Application[server.files.edit]
+func writeUpload(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	offset, err := cast.ToInt64E(c.Query("offset"))
+	if response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+
+	if _, ok := getAllowedUpload(c, server); !ok {
+		return
+	}
+
+	upload, err := server.GetFileServer().WriteUpload(c.Param("uploadId"), offset, c.Request.Body)
+	if handleUploadError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+// @Summary Finish upload
+// @Description Completes an upload, moving it to the requested path.
+// @Description If a checksum is provided (sha256, sha1 or md5, as "algorithm:hex"), the data is validated first.
+// @Success 204 {object} nil
+// @Param id path string true "Server ID"
+// @Param uploadId path string true "Upload ID"
+// @Param finish body pufferpanel.UploadFinishRequest false "Checksum to validate"
+// @Router /api/servers/{id}/upload/{uploadId} [post]
+// @Security OAuth2Application[server.files.edit]
+func finishUpload(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	if _, ok := getAllowedUpload(c, server); !ok {
+		return
+	}
+
+	var req pufferpanel.UploadFinishRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&req); response.HandleError(c, err, http.StatusBadRequest) {
+			return
+		}
+	}
+
+	err := server.GetFileServer().FinishUpload(c.Param("uploadId"), req.Checksum)
+	if handleUploadError(c, err) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Cancel upload
+// @Description Cancels an upload, removing any data received
+// @Success 204 {object} nil
+// @Param id path string true "Server ID"
+// @Param uploadId path string true "Upload ID"
+// @Router /api/servers/{id}/upload/{uploadId} [delete]
+// @Security OAuth2Application[server.files.edit]
+func cancelUpload(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	if _, ok := getAllowedUpload(c, server); !ok {
+		return
+	}
+
+	err := server.GetFileServer().CancelUpload(c.Param("uploadId"))
+	if handleUploadError(c, err) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getAllowedUpload gets the upload, rejecting the request if the user may not touch the path it goes to
+func getAllowedUpload(c *gin.Context, server *servers.Server) (*files.Upload, bool) {
+	upload, err := server.GetFileServer().GetUpload(c.Param("uploadId"))
+	if handleUploadError(c, err) {
+		return nil, false
+	}
+	if !checkPathsAllowed(c, upload.Path) {
+		return nil, false
+	}
+	return upload, true
+}
+
+func handleUploadError(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, files.ErrUploadNotFound):
+		c.AbortWithStatus(http.StatusNotFound)
+		return true
+	case errors.Is(err, files.ErrUploadOffsetInvalid), errors.Is(err, files.ErrUploadTooLarge):
+		return response.HandleError(c, err, http.StatusConflict)
+	case errors.Is(err, files.ErrUploadIncomplete), errors.Is(err, files.ErrChecksumMismatch), errors.Is(err, files.ErrChecksumUnsupported):
+		return response.HandleError(c, err, http.StatusBadRequest)
+	default:
+		return handleFileError(c, err)
+	}
+}
+
+// checkPathsAllowed verifies the user is allowed to touch every path given, rejecting the request if not
+func checkPathsAllowed(c *gin.Context, paths ...string) bool {
+	restrictions := middleware.GetPathRestrictions(c)
+	for _, v := range paths {
+		if !files.PathAllowed(v, restrictions) {
+			response.HandleError(c, pufferpanel.ErrNoPermission, http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// handleFileError responds with the error, using 507 if the server has run out of disk quota
+func handleFileError(c *gin.Context, err error) bool {
+	if errors.Is(err, files.ErrQuotaExceeded) {
+		return response.HandleError(c, err, http.StatusInsufficientStorage)
+	}
+	return response.HandleError(c, err, http.StatusInternalServerError)
+}
+
 // @Summary Send command
 // @Description Sends a command to the server
 // @Success 204 {object} nil
@@ -714,7 +1208,7 @@ func postConsole(c *gin.Context) {
 func getStats(c *gin.Context) {
 	server := getServerFromGin(c)
 
-	results, err := server.GetEnvironment().GetStats()
+	results, err := server.GetStats()
 	if response.HandleError(c, err, http.StatusInternalServerError) {
 	} else {
 		c.JSON(http.StatusOK, results)
@@ -747,6 +1241,52 @@ func getLogs(c *gin.Context) {
 	})
 }
 
+// @Summary Get SFTP log
+// @Description Gets who has connected to the server over SFTP, and the changes they made to files, newest first
+// @Success 200 {object} pufferpanel.SFTPLogResponse
+// @Param id path string true "Server ID"
+// @Param limit query uint false "Max number of entries to return"
+// @Param page query uint false "What page to get back for many entries"
+// @Router /api/servers/{id}/sftp/log [get]
+// @Security OAuth2Application[server.sftp.log]
+func getSftpLog(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSftpLogPageSize)))
+	if response.HandleError(c, err, http.StatusBadRequest) || pageSize <= 0 {
+		response.HandleError(c, pufferpanel.ErrFieldTooSmall("pageSize", 0), http.StatusBadRequest)
+		return
+	}
+	if pageSize > maxSftpLogPageSize {
+		pageSize = maxSftpLogPageSize
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if response.HandleError(c, err, http.StatusBadRequest) || page <= 0 {
+		response.HandleError(c, pufferpanel.ErrFieldTooSmall("page", 0), http.StatusBadRequest)
+		return
+	}
+
+	//only show changes to files the user can see
+	restrictions := middleware.GetPathRestrictions(c)
+	entries, total, err := server.GetSFTPLog((page-1)*pageSize, pageSize, func(entry pufferpanel.SFTPLogEntry) bool {
+		return entry.Path == "" || files.PathAllowed(entry.Path, restrictions)
+	})
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &pufferpanel.SFTPLogResponse{
+		Entries: entries,
+		Metadata: &pufferpanel.Metadata{Paging: &pufferpanel.Paging{
+			Page:    uint(page),
+			Size:    uint(pageSize),
+			MaxSize: maxSftpLogPageSize,
+			Total:   int64(total),
+		}},
+	})
+}
+
 // @Summary Get status
 // @Description Get the server's status (is it running)
 // @Success 200 {object} pufferpanel.ServerRunning
@@ -792,8 +1332,12 @@ func archive(c *gin.Context) {
 	}
 	destination := c.Param("filename")
 
+	if !checkPathsAllowed(c, append([]string{destination}, files...)...) {
+		return
+	}
+
 	err := server.ArchiveItems(files, destination)
-	if response.HandleError(c, err, http.StatusInternalServerError) {
+	if handleFileError(c, err) {
 	} else {
 		c.Status(http.StatusNoContent)
 	}
@@ -813,8 +1357,12 @@ func extract(c *gin.Context) {
 	targetPath := c.Param("filename")
 	destination := c.Query("destination")
 
+	if !checkPathsAllowed(c, targetPath, destination) {
+		return
+	}
+
 	err := server.Extract(targetPath, destination)
-	if response.HandleError(c, err, http.StatusInternalServerError) {
+	if handleFileError(c, err) {
 	} else {
 		c.Status(http.StatusNoContent)
 	}
@@ -839,7 +1387,7 @@ func createBackup(c *gin.Context) {
 
 	id, err := server.StartBackup()
 
-	if response.HandleError(c, err, http.StatusInternalServerError) {
+	if handleFileError(c, err) {
 		return
 	}
 	c.JSON(http.StatusOK, &pufferpanel.ServerBackupResponse{BackupFileName: id})
@@ -919,6 +1467,61 @@ func downloadBackup(c *gin.Context) {
 	}
 }
 
+// Used by the daemon a server is being moved to, to download its files
+func sendTransfer(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	data, err := server.ArchiveForTransfer()
+	defer func() {
+		if data != nil {
+			utils.Close(data.Contents)
+		}
+	}()
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, data.ContentLength, "application/gzip", data.Contents, nil)
+}
+
+// Used by the panel to keep the server as it is while it is moved to another node
+func lockForMigration(c *gin.Context) {
+	getServerFromGin(c).SetMigrating(true)
+	c.Status(http.StatusNoContent)
+}
+
+// Used by the panel to give the server back when moving it failed
+func unlockForMigration(c *gin.Context) {
+	getServerFromGin(c).SetMigrating(false)
+	c.Status(http.StatusNoContent)
+}
+
+// Used by the panel to start filling a server which is being moved here with its files
+func receiveTransfer(c *gin.Context) {
+	server := getServerFromGin(c)
+
+	var transfer pufferpanel.ServerTransfer
+	if err := c.BindJSON(&transfer); response.HandleError(c, err, http.StatusBadRequest) {
+		return
+	}
+	if transfer.Source == "" {
+		response.HandleError(c, pufferpanel.ErrFieldRequired("source"), http.StatusBadRequest)
+		return
+	}
+
+	err := server.StartTransfer(transfer)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// Used by the panel to follow the files of a server being moved here
+func getTransferStatus(c *gin.Context) {
+	server := getServerFromGin(c)
+	c.JSON(http.StatusOK, server.GetTransferStatus())
+}
+
 // @Summary Get flags
 // @Description Get the management flags for a server
 // @Success 200 {object} pufferpanel.ServerFlags
@@ -1050,4 +1653,8 @@ func openSocket(c *gin.Context) {
 	if _, exists := c.GetQuery("status"); exists {
 		server.GetEnvironment().AddStatusListener(socket)
 	}
+
+	if _, exists := c.GetQuery("files"); exists {
+		server.GetEnvironment().AddFilesListener(socket)
+	}
 }
@@ -54,6 +54,7 @@ var clientFiles fs.ReadFileFS
 // @scope.server.admin Admin access to a server (full permissions)
 // @scope.server.view Allows viewing a server
 // @scope.server.create Allows creating servers
+// @scope.server.migrate Allows moving servers to another node
 // @scope.server.delete Allows deleting servers
 // @scope.server.definition.edit Allows editing a server's definition
 // @scope.server.data.edit Allows editing the values of variables
@@ -83,11 +84,14 @@ var clientFiles fs.ReadFileFS
 // @scope.server.files.view Allows viewing and downloading files for a server through the File Manager
 // @scope.server.files.edit Allows editing files for a server through the File Manager
 // @scope.server.sftp Allows connection to a server over SFTP
+// @scope.server.sftp.readonly Allows connection to a server over SFTP, without being able to change files
+// @scope.server.sftp.log Allows viewing who connected to a server over SFTP, and what they changed
 // @scope.server.console Allows viewing the console of a server
 // @scope.server.console.send Allows sending commands to a server's console
 // @scope.server.stats Allows getting stats of a server like CPU and memory usage
 // @scope.server.status Allows getting the status of a server
 // @scope.settings.edit Allows for editing of panel settings
+// @scope.audit.view Allows for searching the audit log of changes made in the panel
 // @scope.templates.view Allows viewing templates
 // @scope.templates.local.edit Allows editing of templates in the local repo
 // @scope.templates.repo.create Allows adding a new template repo
@@ -97,6 +101,8 @@ var clientFiles fs.ReadFileFS
 // @scope.users.info.edit Allows for editing a user's info
 // @scope.users.perms.view Allows for viewing a user's global permissions
 // @scope.users.perms.edit Allows for editing a user's global permissions
+// @scope.roles.view Allows for viewing roles and who holds them
+// @scope.roles.edit Allows for creating, editing and deleting roles
 func RegisterRoutes(e *gin.Engine) {
 	e.Use(func(c *gin.Context) {
 		middleware.Recover(c)
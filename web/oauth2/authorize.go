@@ -0,0 +1,261 @@
+package oauth2
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/oauth2"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+)
+
+// authorization is a checked request from /oauth2/authorize
+type authorization struct {
+	client      *models.Client
+	redirectUri string
+	scopes      []*scopes.Scope
+}
+
+// @Summary Authorize a client
+// @Description Starts the authorization code flow for a client. The user is sent to the panel to approve the client, and then back to the redirect uri with a code. PKCE with S256 is required.
+// @Param request query AuthorizeRequest true "Authorization request"
+// @Success 302 {object} nil
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Router /oauth2/authorize [get]
+func handleAuthorize(c *gin.Context) {
+	var request AuthorizeRequest
+	err := c.ShouldBindQuery(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	auth, ok := validateAuthorizeRequest(c, db, &request, true)
+	if !ok {
+		return
+	}
+
+	//the panel asks the user, and has to know where the client wanted to go
+	query := c.Request.URL.Query()
+	query.Set("redirect_uri", auth.redirectUri)
+	c.Redirect(http.StatusFound, "/auth/authorize?"+query.Encode())
+}
+
+// @Summary Get authorization consent
+// @Description Gets what a client is asking for, so the user can decide whether to allow it. Only scopes the user has are listed.
+// @Param request query AuthorizeRequest true "Authorization request"
+// @Success 200 {object} ConsentResponse
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Failure 403 {object} nil
+// @Router /oauth2/authorize/consent [get]
+// @Security OAuth2Application[login]
+func getConsent(c *gin.Context) {
+	var request AuthorizeRequest
+	err := c.ShouldBindQuery(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	auth, ok := validateAuthorizeRequest(c, db, &request, false)
+	if !ok {
+		return
+	}
+
+	user := c.MustGet("user").(*models.User)
+	ps := &services.Permission{DB: db}
+	grantable, err := ps.GetGrantable(user.ID, auth.scopes)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.JSON(http.StatusOK, &ConsentResponse{
+		ClientId:    auth.client.ClientId,
+		Name:        auth.client.Name,
+		Description: auth.client.Description,
+		RedirectUri: auth.redirectUri,
+		Scopes:      grantable,
+	})
+}
+
+// @Summary Answer authorization consent
+// @Description Approves or denies a client. Where to send the user back to is returned, with either a code or an error.
+// @Param request body ConsentRequest true "Authorization request and answer"
+// @Success 200 {object} ConsentResult
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Failure 403 {object} nil
+// @Router /oauth2/authorize/consent [post]
+// @Security OAuth2Application[login]
+func postConsent(c *gin.Context) {
+	var request ConsentRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	auth, ok := validateAuthorizeRequest(c, db, &request.AuthorizeRequest, false)
+	if !ok {
+		return
+	}
+
+	if !request.Approve {
+		c.JSON(http.StatusOK, &ConsentResult{RedirectUri: redirectWithError(auth.redirectUri, request.State, "access_denied")})
+		return
+	}
+
+	//the user may have unticked some of what was asked for, but can't add to it
+	approved := auth.scopes
+	if request.Scopes != nil {
+		approved = make([]*scopes.Scope, 0)
+		for _, v := range request.Scopes {
+			for _, z := range auth.scopes {
+				if z.Is(v) {
+					approved = append(approved, z)
+					break
+				}
+			}
+		}
+	}
+
+	user := c.MustGet("user").(*models.User)
+	ps := &services.Permission{DB: db}
+	approved, err = ps.GetGrantable(user.ID, approved)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	if len(approved) == 0 {
+		c.JSON(http.StatusOK, &ConsentResult{RedirectUri: redirectWithError(auth.redirectUri, request.State, "invalid_scope")})
+		return
+	}
+
+	os := &services.OAuth2{DB: db}
+	code, err := os.CreateAuthorizationCode(auth.client, user, auth.redirectUri, request.CodeChallenge, approved)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	query := url.Values{}
+	query.Set("code", code)
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	c.JSON(http.StatusOK, &ConsentResult{RedirectUri: appendQuery(auth.redirectUri, query)})
+}
+
+// requiresUserSession stops clients from approving other clients, only the user themselves can do that
+func requiresUserSession(c *gin.Context) {
+	if _, exists := c.Get("client"); exists {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Next()
+}
+
+// validateAuthorizeRequest checks the client and redirect uri, and then the rest of the request. Problems with the
+// client or redirect uri are shown to the user, everything else is sent back to the client when redirecting.
+func validateAuthorizeRequest(c *gin.Context, db *gorm.DB, request *AuthorizeRequest, redirect bool) (*authorization, bool) {
+	os := &services.OAuth2{DB: db}
+	client, err := os.Get(request.ClientId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return nil, false
+	}
+
+	redirectUri := request.RedirectUri
+	if redirectUri == "" && len(client.RedirectUris) == 1 {
+		redirectUri = client.RedirectUris[0]
+	}
+	if !client.HasRedirectUri(redirectUri) {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "redirect_uri is not registered for this client"})
+		return nil, false
+	}
+
+	fail := func(code, description string) (*authorization, bool) {
+		query := url.Values{}
+		query.Set("error", code)
+		query.Set("error_description", description)
+		if request.State != "" {
+			query.Set("state", request.State)
+		}
+		if redirect {
+			c.Redirect(http.StatusFound, appendQuery(redirectUri, query))
+		} else {
+			c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: code, ErrorDescription: description})
+		}
+		return nil, false
+	}
+
+	if request.ResponseType != "code" {
+		return fail("unsupported_response_type", "only code is supported")
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		return fail("invalid_request", "a S256 code_challenge is required")
+	}
+
+	requested, ok := scopes.ParseScopes(request.Scope)
+	if !ok || len(requested) == 0 {
+		return fail("invalid_scope", "scope is missing or unknown")
+	}
+
+	return &authorization{client: client, redirectUri: redirectUri, scopes: requested}, true
+}
+
+func redirectWithError(redirectUri, state, code string) string {
+	query := url.Values{}
+	query.Set("error", code)
+	if state != "" {
+		query.Set("state", state)
+	}
+	return appendQuery(redirectUri, query)
+}
+
+// appendQuery adds to the query of a redirect uri, keeping what the client registered
+func appendQuery(redirectUri string, query url.Values) string {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return redirectUri
+	}
+	existing := u.Query()
+	for k, v := range query {
+		existing[k] = v
+	}
+	u.RawQuery = existing.Encode()
+	return u.String()
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientId            string `form:"client_id" json:"client_id"`
+	RedirectUri         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+} //@name OAuth2AuthorizeRequest
+
+type ConsentRequest struct {
+	AuthorizeRequest
+	Approve bool `json:"approve"`
+	//the scopes the user agreed to, leaving this out agrees to all that were asked for
+	Scopes []string `json:"scopes"`
+} //@name OAuth2ConsentRequest
+
+type ConsentResponse struct {
+	ClientId    string          `json:"client_id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	RedirectUri string          `json:"redirect_uri"`
+	Scopes      []*scopes.Scope `json:"scopes"`
+} //@name OAuth2ConsentResponse
+
+type ConsentResult struct {
+	RedirectUri string `json:"redirect_uri"`
+} //@name OAuth2ConsentResult
@@ -6,6 +6,7 @@ import (
 	"github.com/pufferpanel/pufferpanel/v3/middleware"
 	"github.com/pufferpanel/pufferpanel/v3/oauth2"
 	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"github.com/spf13/cast"
 	"net/http"
 )
@@ -13,6 +14,23 @@ import (
 func RegisterRoutes(rg *gin.RouterGroup) {
 	rg.POST("/token", setHeaders, recovery, middleware.NeedsDatabase, handleTokenRequest)
 	rg.OPTIONS("/token", response.CreateOptions("POST"))
+
+	rg.POST("/revoke", setHeaders, recovery, middleware.NeedsDatabase, handleRevoke)
+	rg.OPTIONS("/revoke", response.CreateOptions("POST"))
+
+	rg.POST("/introspect", setHeaders, recovery, middleware.NeedsDatabase, handleIntrospect)
+	rg.OPTIONS("/introspect", response.CreateOptions("POST"))
+
+	rg.POST("/sshkey/used", setHeaders, recovery, middleware.NeedsDatabase, handleSSHKeyUsed)
+	rg.OPTIONS("/sshkey/used", response.CreateOptions("POST"))
+
+	rg.GET("/tunnel", setHeaders, recovery, middleware.NeedsDatabase, handleTunnel)
+
+	rg.GET("/authorize", setHeaders, middleware.NeedsDatabase, handleAuthorize)
+
+	rg.GET("/authorize/consent", setHeaders, middleware.NeedsDatabase, middleware.AuthMiddleware, requiresUserSession, middleware.RequiresPermission(scopes.ScopeLogin), getConsent)
+	rg.POST("/authorize/consent", setHeaders, middleware.NeedsDatabase, middleware.AuthMiddleware, requiresUserSession, middleware.RequiresPermission(scopes.ScopeLogin), postConsent)
+	rg.OPTIONS("/authorize/consent", response.CreateOptions("GET", "POST"))
 }
 
 func setHeaders(c *gin.Context) {
@@ -0,0 +1,126 @@
+package oauth2
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/oauth2"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"net/http"
+)
+
+// @Summary Revoke a token
+// @Description Revokes an access or refresh token given to the client, along with every other token from the same approval. Unknown tokens are not an error, as the token is gone either way.
+// @Param request formData OAuth2TokenActionRequest true "Token to revoke"
+// @Success 200 {object} nil
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Failure 401 {object} oauth2.ErrorResponse
+// @Accept x-www-form-urlencoded
+// @Router /oauth2/revoke [post]
+func handleRevoke(c *gin.Context) {
+	var request OAuth2TokenActionRequest
+	err := c.MustBindWith(&request, binding.FormPost)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	client, ok := authenticateClient(c, db, request.ClientId, request.ClientSecret, true)
+	if !ok {
+		return
+	}
+
+	ss := &services.Session{DB: db}
+	err = ss.ExpireForClient(request.Token, client.ID)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// @Summary Introspect a token
+// @Description Gets whether a token is still active, and what it can do. Clients can only look at their own tokens, while a session with the oauth2.auth scope can look at any.
+// @Param request formData OAuth2TokenActionRequest true "Token to look at"
+// @Success 200 {object} oauth2.TokenInfoResponse
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Failure 401 {object} oauth2.ErrorResponse
+// @Accept x-www-form-urlencoded
+// @Router /oauth2/introspect [post]
+func handleIntrospect(c *gin.Context) {
+	var request OAuth2TokenActionRequest
+	err := c.MustBindWith(&request, binding.FormPost)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ss := &services.Session{DB: db}
+	ps := &services.Permission{DB: db}
+
+	//either the client the token was given to, or something allowed to check every token
+	var client *models.Client
+	if bearer := middleware.GetToken(c); bearer != "" && request.ClientId == "" {
+		caller, err := ss.Validate(bearer)
+		if err != nil || caller.UserId == nil {
+			c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+			return
+		}
+		perms, err := ps.GetForUserAndServer(*caller.UserId, "")
+		if response.HandleError(c, err, http.StatusInternalServerError) {
+			return
+		}
+		if !scopes.ContainsScope(perms.EffectiveScopes(), scopes.ScopeOAuth2Auth) || (len(caller.Scopes) > 0 && !scopes.ContainsScope(caller.Scopes, scopes.ScopeOAuth2Auth)) {
+			c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+			return
+		}
+	} else {
+		var ok bool
+		client, ok = authenticateClient(c, db, request.ClientId, request.ClientSecret, false)
+		if !ok {
+			return
+		}
+	}
+
+	session, err := ss.Validate(request.Token)
+	if err != nil || (client != nil && (session.ClientId == nil || *session.ClientId != client.ID)) {
+		c.JSON(http.StatusOK, &oauth2.TokenInfoResponse{Active: false})
+		return
+	}
+
+	res := &oauth2.TokenInfoResponse{
+		Active:    true,
+		TokenType: "Bearer",
+		Exp:       session.ExpirationTime.Unix(),
+	}
+	if session.ClientId != nil {
+		res.ClientId = session.Client.ClientId
+	}
+
+	granted := session.Scopes
+	if session.UserId != nil {
+		res.Username = session.User.Username
+		if len(granted) == 0 {
+			perms, err := ps.GetForUserAndServer(*session.UserId, "")
+			if response.HandleError(c, err, http.StatusInternalServerError) {
+				return
+			}
+			granted = perms.EffectiveScopes()
+		}
+	}
+	res.Scope = joinScopes(granted)
+
+	c.JSON(http.StatusOK, res)
+}
+
+type OAuth2TokenActionRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientId      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+} //@name OAuth2TokenActionRequest
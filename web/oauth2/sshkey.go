@@ -0,0 +1,81 @@
+package oauth2
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/oauth2"
+	"github.com/pufferpanel/pufferpanel/v3/response"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"net/http"
+	"strings"
+)
+
+// @Summary Record an SSH key login
+// @Description Used by nodes once a client has logged in over SFTP with a key. Only the node sees the client prove it holds the private key, so it tells the panel when that happens.
+// @Param Authorization header string true "Bearer and the node's secret"
+// @Param request formData OAuth2SSHKeyUsedRequest true "Key which was used"
+// @Success 204 {object} nil
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Failure 401 {object} oauth2.ErrorResponse
+// @Accept x-www-form-urlencoded
+// @Router /oauth2/sshkey/used [post]
+func handleSSHKeyUsed(c *gin.Context) {
+	var request OAuth2SSHKeyUsedRequest
+	err := c.MustBindWith(&request, binding.FormPost)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	auth := strings.TrimSpace(c.GetHeader("Authorization"))
+	if !strings.HasPrefix(auth, "Bearer ") {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return
+	}
+
+	db := middleware.GetDatabase(c)
+	ss := &services.Session{DB: db}
+	node, err := ss.ValidateNode(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return
+	}
+
+	ks := &services.SSHKey{DB: db}
+	key, err := ks.GetByFingerprint(request.Fingerprint)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+		return
+	}
+
+	//a node only hears of keys for users who can log in to one of its servers
+	servers, err := services.GetSFTPServers(db, key.UserId)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	onNode := false
+	for _, v := range servers {
+		if v.Server.Node.ID == node.ID {
+			onNode = true
+			break
+		}
+	}
+	if !onNode {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+		return
+	}
+
+	err = ks.MarkUsed(key, request.ClientIP)
+	if response.HandleError(c, err, http.StatusInternalServerError) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type OAuth2SSHKeyUsedRequest struct {
+	Fingerprint string `form:"fingerprint" binding:"required"`
+	//where the SFTP client connected to the node from
+	ClientIP string `form:"client_ip"`
+} //@name OAuth2SSHKeyUsedRequest
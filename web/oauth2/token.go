@@ -1,15 +1,21 @@
 package oauth2
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/logging"
 	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/models"
 	"github.com/pufferpanel/pufferpanel/v3/oauth2"
 	"github.com/pufferpanel/pufferpanel/v3/response"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"github.com/pufferpanel/pufferpanel/v3/services"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -45,14 +51,20 @@ func handleTokenRequest(c *gin.Context) {
 	switch strings.ToLower(request.GrantType) {
 	case "client_credentials":
 		{
+			if !checkTokenLockout(c, db, c.ClientIP(), request.ClientId) {
+				return
+			}
+
 			os := &services.OAuth2{DB: db}
 			client, err := os.Get(request.ClientId)
 			if err != nil {
+				failToken(db, c.ClientIP(), request.ClientId)
 				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
 				return
 			}
 
 			if !client.ValidateSecret(request.ClientSecret) {
+				failToken(db, c.ClientIP(), request.ClientId)
 				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_client"})
 				return
 			}
@@ -76,7 +88,7 @@ func handleTokenRequest(c *gin.Context) {
 				return
 			}
 
-			if !scopes.ContainsScope(perms.Scopes, scopes.ScopeLogin) {
+			if !scopes.ContainsScope(perms.EffectiveScopes(), scopes.ScopeLogin) {
 				//because servers don't have an explicit login scope, we need to check the root user
 				if serverId == "" {
 					c.AbortWithStatus(http.StatusForbidden)
@@ -88,12 +100,12 @@ func handleTokenRequest(c *gin.Context) {
 					return
 				}
 
-				if !scopes.ContainsScope(userPerms.Scopes, scopes.ScopeLogin) {
+				if !scopes.ContainsScope(userPerms.EffectiveScopes(), scopes.ScopeLogin) {
 					c.AbortWithStatus(http.StatusForbidden)
 					return
 				}
 			}
-			for _, v := range perms.Scopes {
+			for _, v := range perms.EffectiveScopes() {
 				allScopes = append(allScopes, v.String())
 			}
 
@@ -105,106 +117,239 @@ func handleTokenRequest(c *gin.Context) {
 			})
 			return
 		}
-	case "password":
+	case "authorization_code":
 		{
-			auth := strings.TrimSpace(c.GetHeader("Authorization"))
-			if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
-				c.Header("WWW-Authenticate", "Bearer")
-				c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+			client, ok := authenticateClient(c, db, request.ClientId, request.ClientSecret, true)
+			if !ok {
 				return
 			}
 
-			//validate this is a bearer token and a good JWT token
-			auth = strings.TrimPrefix(auth, "Bearer ")
-			node, err := session.ValidateNode(auth)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+			os := &services.OAuth2{DB: db}
+			code, err := os.RedeemAuthorizationCode(client, request.Code, request.RedirectUri, request.CodeVerifier)
+			if errors.Is(err, pufferpanel.ErrInvalidAuthorizationCode) {
+				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_grant"})
+				return
+			} else if response.HandleError(c, err, http.StatusInternalServerError) {
 				return
 			}
 
-			us := &services.User{DB: db}
-			ss := &services.Server{DB: db}
-
-			//get user and server information
-			parts := strings.SplitN(request.Username, "#", 2)
-			if len(parts) != 2 {
-				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "bad username"})
+			token, refreshToken, err := session.CreateForAuthorization(code)
+			if response.HandleError(c, err, http.StatusInternalServerError) {
 				return
 			}
-			user, err := us.GetByEmail(parts[0])
-			if err != nil {
-				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+
+			c.JSON(http.StatusOK, &oauth2.TokenResponse{
+				AccessToken:  token,
+				TokenType:    "Bearer",
+				Scope:        joinScopes(code.Scopes),
+				ExpiresIn:    expiresIn,
+				RefreshToken: refreshToken,
+			})
+			return
+		}
+	case "refresh_token":
+		{
+			client, ok := authenticateClient(c, db, request.ClientId, request.ClientSecret, true)
+			if !ok {
 				return
 			}
 
-			server, err := ss.Get(parts[1])
-			if err != nil {
-				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+			token, refreshToken, granted, err := session.Refresh(client, request.RefreshToken)
+			if errors.Is(err, pufferpanel.ErrInvalidRefreshToken) {
+				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_grant"})
+				return
+			} else if response.HandleError(c, err, http.StatusInternalServerError) {
 				return
 			}
 
-			//ensure the node asking for the credential check is where this server is
-			if server.Node.ID != node.ID {
-				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+			c.JSON(http.StatusOK, &oauth2.TokenResponse{
+				AccessToken:  token,
+				TokenType:    "Bearer",
+				Scope:        joinScopes(granted),
+				ExpiresIn:    expiresIn,
+				RefreshToken: refreshToken,
+			})
+			return
+		}
+	case "password":
+		{
+			user, grant, ok := validateSftpRequest(c, session, db, request.Username)
+			if !ok {
 				return
 			}
 
-			//confirm user has access to this server
-			ps := &services.Permission{DB: db}
-			perms, err := ps.GetForUserAndServer(user.ID, server.Identifier)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+			//the request comes from the node, which tells us where the SFTP client is connecting from
+			if !checkTokenLockout(c, db, request.ClientIP, user.Email) {
 				return
 			}
 
-			if perms.ID == 0 || !scopes.ContainsScope(perms.Scopes, scopes.ScopeServerSftp) {
-				//also grab global, in case it's an admin or it's a perm that's granted globally
-				perms, err = ps.GetForUserAndServer(user.ID, "")
-				if err != nil {
-					c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
-					return
+			//validate their credentials
+			us := &services.User{DB: db}
+			email := user.Email
+			user, _, err = us.ValidateLogin(email, request.Password)
+			if err != nil {
+				if errors.Is(err, pufferpanel.ErrInvalidCredentials) {
+					failToken(db, request.ClientIP, email)
 				}
+				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+				return
+			}
 
-				if perms.ID == 0 || !scopes.ContainsScope(perms.Scopes, scopes.ScopeServerSftp) {
-					c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
-					return
-				}
+			ls := &services.LoginLimiter{DB: db}
+			if _, err = ls.Succeed(email); err != nil {
+				logging.Error.Printf("Error clearing failed logins: %s", err.Error())
 			}
 
-			//validate their credentials
+			//at this point, their login credentials were valid, and we need to shortcut because otp
+			respondSftpToken(c, session, user, grant)
+		}
+	case "ssh_key":
+		{
+			user, grant, ok := validateSftpRequest(c, session, db, request.Username)
+			if !ok {
+				return
+			}
 
-			var token string
-			user, _, err = us.ValidateLogin(user.Email, request.Password)
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(request.PublicKey))
 			if err != nil {
 				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
 				return
 			}
 
-			//at this point, their login credentials were valid, and we need to shortcut because otp
-			sessionService := &services.Session{DB: db}
-			token, err = sessionService.CreateForUser(user)
+			user, err = services.ValidateSSHKey(db, user.Email, key)
 			if err != nil {
-				logging.Error.Printf("Error generating token: %s", err.Error())
 				c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
 				return
 			}
 
-			mappedScopes := make([]string, 0)
+			//a public key proves nothing on its own, the node checks the client has the private key, so no session is
+			//made for the user here, and the node tells us when the key was used
+			c.JSON(http.StatusOK, sftpTokenResponse(grant))
+		}
+	default:
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "unsupported_grant_type"})
+	}
+}
+
+// sftpGrant is what an SFTP login can reach, either a single server, or every server the user can access
+type sftpGrant struct {
+	server  *models.Server
+	access  *services.SFTPAccess
+	servers []*services.SFTPServer
+}
 
-			for _, p := range perms.Scopes {
-				mappedScopes = append(mappedScopes, server.Identifier+":"+p.Value)
+// validateSftpRequest confirms a node is asking about a server it hosts, and that the user has SFTP access to it.
+// A username without a server is given every server on the node the user has SFTP access to.
+func validateSftpRequest(c *gin.Context, session *services.Session, db *gorm.DB, username string) (*models.User, *sftpGrant, bool) {
+	auth := strings.TrimSpace(c.GetHeader("Authorization"))
+	if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return nil, nil, false
+	}
+
+	//validate this is a bearer token and a good JWT token
+	auth = strings.TrimPrefix(auth, "Bearer ")
+	node, err := session.ValidateNode(auth)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return nil, nil, false
+	}
+
+	us := &services.User{DB: db}
+	ss := &services.Server{DB: db}
+
+	//get user and server information
+	parts := strings.SplitN(username, "#", 2)
+	user, err := us.GetByEmail(parts[0])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return nil, nil, false
+	}
+
+	if len(parts) == 1 {
+		all, err := services.GetSFTPServers(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+			return nil, nil, false
+		}
+
+		//only the servers on the node asking can be reached through it
+		grant := &sftpGrant{servers: make([]*services.SFTPServer, 0)}
+		for _, v := range all {
+			if v.Server.Node.ID == node.ID {
+				grant.servers = append(grant.servers, v)
 			}
+		}
+		if len(grant.servers) == 0 {
+			c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+			return nil, nil, false
+		}
+		return user, grant, true
+	}
 
-			c.JSON(http.StatusOK, &oauth2.TokenResponse{
-				AccessToken: token,
-				TokenType:   "Bearer",
-				Scope:       scopes.ScopeServerSftp.String(),
-				ExpiresIn:   expiresIn,
-			})
+	server, err := ss.Get(parts[1])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return nil, nil, false
+	}
+
+	//ensure the node asking for the credential check is where this server is
+	if server.Node.ID != node.ID {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+		return nil, nil, false
+	}
+
+	//confirm user has access to this server
+	access, err := services.GetSFTPAccess(db, user.ID, server.Identifier)
+	if errors.Is(err, pufferpanel.ErrNoPermission) {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+		return nil, nil, false
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return nil, nil, false
+	}
+
+	return user, &sftpGrant{server: server, access: access}, true
+}
+
+func respondSftpToken(c *gin.Context, session *services.Session, user *models.User, grant *sftpGrant) {
+	token, err := session.CreateForUser(user)
+	if err != nil {
+		logging.Error.Printf("Error generating token: %s", err.Error())
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "no access"})
+		return
+	}
+
+	res := sftpTokenResponse(grant)
+	res.AccessToken = token
+	res.TokenType = "Bearer"
+	res.ExpiresIn = expiresIn
+	c.JSON(http.StatusOK, res)
+}
+
+// sftpTokenResponse gets what the node needs to know about what the login can reach
+func sftpTokenResponse(grant *sftpGrant) *oauth2.SFTPTokenResponse {
+	res := &oauth2.SFTPTokenResponse{}
+	if grant.servers != nil {
+		granted := make([]string, 0, len(grant.servers))
+		for _, v := range grant.servers {
+			granted = append(granted, v.Server.Identifier+":"+sftpScope(v.Access).String())
 		}
-	default:
-		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "unsupported_grant_type"})
+		res.Scope = strings.Join(granted, " ")
+		res.Servers = services.ToSFTPServerAccess(grant.servers)
+	} else {
+		res.Scope = grant.server.Identifier + ":" + sftpScope(grant.access).String()
+		res.Paths = grant.access.Paths
+	}
+	return res
+}
+
+func sftpScope(access *services.SFTPAccess) *scopes.Scope {
+	if access.ReadOnly {
+		return scopes.ScopeServerSftpReadOnly
 	}
+	return scopes.ScopeServerSftp
 }
 
 type OAuth2TokenRequest struct {
@@ -213,4 +358,63 @@ type OAuth2TokenRequest struct {
 	ClientSecret string `form:"client_secret"`
 	Username     string `form:"username"`
 	Password     string `form:"password"`
+	PublicKey    string `form:"public_key"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	//ClientIP is where an SFTP client is connecting from, given by the node asking about its login
+	ClientIP string `form:"client_ip"`
 } //@name OAuth2TokenRequest
+
+// authenticateClient finds the client, and checks its secret. Public clients have no secret, so are only let through
+// where allowed, which is where they have to have used PKCE to get a token.
+func authenticateClient(c *gin.Context, db *gorm.DB, clientId, clientSecret string, allowPublic bool) (*models.Client, bool) {
+	if !checkTokenLockout(c, db, c.ClientIP(), clientId) {
+		return nil, false
+	}
+
+	os := &services.OAuth2{DB: db}
+	client, err := os.Get(clientId)
+	if err != nil || (client.Public && (!allowPublic || clientSecret != "")) || (!client.Public && !client.ValidateSecret(clientSecret)) {
+		failToken(db, c.ClientIP(), clientId)
+		c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return nil, false
+	}
+	return client, true
+}
+
+// checkTokenLockout refuses the request if the IP or account has failed too many times, saying when they can try again
+func checkTokenLockout(c *gin.Context, db *gorm.DB, ip, account string) bool {
+	ls := &services.LoginLimiter{DB: db}
+	until, err := ls.Check(ip, account)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, &oauth2.ErrorResponse{Error: "server_error", ErrorDescription: err.Error()})
+		return false
+	}
+	if !until.IsZero() {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(until)/time.Second)+1))
+		c.JSON(http.StatusTooManyRequests, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: pufferpanel.ErrLoginLocked.Error()})
+		return false
+	}
+	return true
+}
+
+// failToken counts a failed request against the IP and account
+func failToken(db *gorm.DB, ip, account string) {
+	ls := &services.LoginLimiter{DB: db}
+	until, err := ls.Fail(ip, account)
+	if err != nil {
+		logging.Error.Printf("Error recording failed login: %s", err.Error())
+	} else if !until.IsZero() {
+		logging.Info.Printf("Logins to %s or from %s locked until %s", account, ip, until.Format(time.RFC3339))
+	}
+}
+
+func joinScopes(list []*scopes.Scope) string {
+	result := make([]string, len(list))
+	for k, v := range list {
+		result[k] = v.String()
+	}
+	return strings.Join(result, " ")
+}
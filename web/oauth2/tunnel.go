@@ -0,0 +1,65 @@
+package oauth2
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pufferpanel/pufferpanel/v3/logging"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
+	"github.com/pufferpanel/pufferpanel/v3/oauth2"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/tunnel"
+	"net/http"
+	"strings"
+)
+
+var tunnelUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	//daemons aren't browsers, so there is no origin to check
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// @Summary Open a node tunnel
+// @Description Lets a node the panel can't connect to connect to the panel instead. The websocket carries the requests the panel sends to the node, for as long as it stays open.
+// @Param Authorization header string true "Bearer and the node's secret"
+// @Success 101 {object} nil
+// @Failure 400 {object} oauth2.ErrorResponse
+// @Failure 401 {object} oauth2.ErrorResponse
+// @Failure 429 {object} oauth2.ErrorResponse
+// @Router /oauth2/tunnel [get]
+func handleTunnel(c *gin.Context) {
+	db := middleware.GetDatabase(c)
+	if !checkTokenLockout(c, db, c.ClientIP(), "") {
+		return
+	}
+
+	auth := strings.TrimSpace(c.GetHeader("Authorization"))
+	if !strings.HasPrefix(auth, "Bearer ") {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return
+	}
+
+	ss := &services.Session{DB: db}
+	node, err := ss.ValidateNode(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		failToken(db, c.ClientIP(), "")
+		c.JSON(http.StatusUnauthorized, &oauth2.ErrorResponse{Error: "invalid_client"})
+		return
+	}
+
+	if node.IsLocal() || !node.Tunnel {
+		c.JSON(http.StatusBadRequest, &oauth2.ErrorResponse{Error: "invalid_request", ErrorDescription: "node is not set to use a tunnel"})
+		return
+	}
+
+	conn, err := tunnelUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logging.Error.Printf("Error opening tunnel for node %s: %s", node.Name, err.Error())
+		return
+	}
+
+	services.AddTunnel(node, tunnel.NewSession(conn, false))
+}
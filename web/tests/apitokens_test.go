@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user := &models.User{Username: "tokenuser", Email: "tokens@example.com"}
+	if !assert.NoError(t, user.SetPassword("tokenpassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	defer (&services.User{DB: db}).Delete(user)
+
+	first := &models.Server{Identifier: "tokensrv1", Name: "tokensrv1", Type: "generic"}
+	second := &models.Server{Identifier: "tokensrv2", Name: "tokensrv2", Type: "generic"}
+	for _, v := range []*models.Server{first, second} {
+		if !assert.NoError(t, db.Create(v).Error) {
+			return
+		}
+		defer db.Delete(v)
+	}
+
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfClients, scopes.ScopeSelfEdit}}).Error) {
+		return
+	}
+	for _, v := range []*models.Server{first, second} {
+		if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, ServerIdentifier: &v.Identifier, Scopes: []*scopes.Scope{scopes.ScopeServerView, scopes.ScopeServerUserView}}).Error) {
+			return
+		}
+	}
+
+	session, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	create := func(t *testing.T, request *models.APIToken) *models.APIToken {
+		response := CallAPI("POST", "/api/self/tokens", request, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			t.FailNow()
+		}
+		token := &models.APIToken{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(token)) {
+			t.FailNow()
+		}
+		return token
+	}
+
+	var bound *models.APIToken
+
+	t.Run("Create", func(t *testing.T) {
+		//nodes.view is not something the user has, so is left out
+		bound = create(t, &models.APIToken{
+			Name:      "ci",
+			Scopes:    []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeServerUserView, scopes.ScopeNodesView},
+			Servers:   []string{first.Identifier},
+			ExpiresAt: time.Now().Add(24 * time.Hour),
+		})
+		assert.NotEmpty(t, bound.Secret)
+		assert.Equal(t, []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeServerUserView}, bound.Scopes)
+		assert.Equal(t, []string{first.Identifier}, bound.Servers)
+
+		response := CallAPI("GET", "/api/self/tokens", nil, session)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			var tokens []*models.APIToken
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&tokens))
+			if assert.Len(t, tokens, 1) {
+				//the token itself is never shown again
+				assert.Empty(t, tokens[0].Secret)
+				assert.Nil(t, tokens[0].LastUsed)
+			}
+		}
+	})
+
+	t.Run("CreateInvalid", func(t *testing.T) {
+		response := CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "old", Scopes: []*scopes.Scope{scopes.ScopeLogin}, ExpiresAt: time.Now().Add(-time.Hour)}, session)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "forever", Scopes: []*scopes.Scope{scopes.ScopeLogin}, ExpiresAt: time.Now().Add(2 * 365 * 24 * time.Hour)}, session)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "nothing", Scopes: []*scopes.Scope{scopes.ScopeNodesView}, ExpiresAt: time.Now().Add(time.Hour)}, session)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "missing", Scopes: []*scopes.Scope{scopes.ScopeLogin}, Servers: []string{"notaserver"}, ExpiresAt: time.Now().Add(time.Hour)}, session)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Use", func(t *testing.T) {
+		response := CallAPI("GET", "/api/self", nil, bound.Secret)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = CallAPI("GET", "/api/servers/"+first.Identifier+"/user", nil, bound.Secret)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//the user can, but the token is not for that server
+		response = CallAPI("GET", "/api/servers/"+second.Identifier+"/user", nil, bound.Secret)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("GET", "/api/servers/"+second.Identifier+"/user", nil, session)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//nor was it given self.clients
+		response = CallAPI("GET", "/api/self/tokens", nil, bound.Secret)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		token := &models.APIToken{}
+		if assert.NoError(t, db.First(token, bound.ID).Error) {
+			assert.NotNil(t, token.LastUsed)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token := create(t, &models.APIToken{Name: "short", Scopes: []*scopes.Scope{scopes.ScopeLogin}, ExpiresAt: time.Now().Add(time.Hour)})
+		response := CallAPI("GET", "/api/self", nil, token.Secret)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		assert.NoError(t, db.Model(&models.APIToken{}).Where("id = ?", token.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error)
+		response = CallAPI("GET", "/api/self", nil, token.Secret)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("CreateFromToken", func(t *testing.T) {
+		narrow := create(t, &models.APIToken{
+			Name:      "narrow",
+			Scopes:    []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfClients},
+			Servers:   []string{first.Identifier},
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+
+		//a token can't get past its own limits by making another one
+		response := CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "broad", Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfEdit, scopes.ScopeServerUserView}, ExpiresAt: time.Now().Add(time.Hour)}, narrow.Secret)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "broad", Scopes: []*scopes.Scope{scopes.ScopeLogin}, Servers: []string{second.Identifier}, ExpiresAt: time.Now().Add(time.Hour)}, narrow.Secret)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/api/self/tokens", &models.APIToken{Name: "broad", Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfEdit, scopes.ScopeServerUserView}, Servers: []string{first.Identifier}, ExpiresAt: time.Now().Add(time.Hour)}, narrow.Secret)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			token := &models.APIToken{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(token))
+			assert.Equal(t, []*scopes.Scope{scopes.ScopeLogin}, token.Scopes)
+			assert.Equal(t, []string{first.Identifier}, token.Servers)
+		}
+
+		//nor by making a client, which would act with everything the user can do
+		response = CallAPI("POST", "/api/self/oauth2", &models.Client{Name: "broad"}, narrow.Secret)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		adminToken, err := createSessionAdmin()
+		if !assert.NoError(t, err) {
+			return
+		}
+		response := CallAPI("DELETE", fmt.Sprintf("/api/self/tokens/%d", bound.ID), nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		response = CallAPI("DELETE", fmt.Sprintf("/api/self/tokens/%d", bound.ID), nil, session)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		response = CallAPI("GET", "/api/self", nil, bound.Secret)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+}
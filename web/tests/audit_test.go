@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	adminToken, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user := &models.User{Username: "audituser", Email: "audit@example.com"}
+	if !assert.NoError(t, user.SetPassword("auditpassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	defer (&services.User{DB: db}).Delete(user)
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin}}).Error) {
+		return
+	}
+	userToken, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	search := func(t *testing.T, query string) []*models.AuditLog {
+		response := CallAPI("GET", "/api/audit?"+query, nil, adminToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return nil
+		}
+		result := &models.AuditSearchResponse{}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(result))
+		return result.Entries
+	}
+
+	var roleId uint
+
+	t.Run("RecordsChanges", func(t *testing.T) {
+		response := CallAPI("POST", "/api/roles", &models.Role{Name: "auditrole", Description: "first", Scopes: []*scopes.Scope{scopes.ScopeLogin}}, adminToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		role := &models.Role{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(role)) {
+			return
+		}
+		roleId = role.ID
+
+		response = CallAPI("PUT", fmt.Sprintf("/api/roles/%d", roleId), &models.Role{Name: "auditrole", Description: "second", Scopes: []*scopes.Scope{scopes.ScopeLogin}}, adminToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		response = CallAPI("DELETE", fmt.Sprintf("/api/roles/%d", roleId), nil, adminToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		entries := search(t, fmt.Sprintf("targetType=role&targetId=%d", roleId))
+		if !assert.Len(t, entries, 3) {
+			return
+		}
+
+		assert.Equal(t, "role.delete", entries[0].Action)
+		assert.NotEmpty(t, entries[0].Before)
+		assert.Empty(t, entries[0].After)
+
+		//only what changed is kept
+		assert.Equal(t, "role.edit", entries[1].Action)
+		before := make(map[string]interface{})
+		after := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal([]byte(entries[1].Before), &before))
+		assert.NoError(t, json.Unmarshal([]byte(entries[1].After), &after))
+		assert.Equal(t, "first", before["description"])
+		assert.Equal(t, "second", after["description"])
+		assert.NotContains(t, after, "name")
+
+		assert.Equal(t, "role.create", entries[2].Action)
+		assert.Empty(t, entries[2].Before)
+		assert.Contains(t, entries[2].After, "auditrole")
+		assert.Equal(t, loginAdminUser.Username, entries[2].ActorName)
+	})
+
+	t.Run("RecordsPermissions", func(t *testing.T) {
+		response := CallAPI("PUT", fmt.Sprintf("/api/users/%d/perms", user.ID), &models.PermissionView{Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeServerCreate}}, adminToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		entries := search(t, fmt.Sprintf("action=user.perms.*&targetId=%d", user.ID))
+		if assert.Len(t, entries, 1) {
+			assert.Contains(t, entries[0].After, scopes.ScopeServerCreate.Value)
+			assert.NotContains(t, entries[0].Before, scopes.ScopeServerCreate.Value)
+		}
+	})
+
+	t.Run("SkipsFailures", func(t *testing.T) {
+		response := CallAPI("PUT", "/api/roles/999999", &models.Role{Name: "missing"}, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		assert.Empty(t, search(t, "targetType=role&targetId=999999"))
+	})
+
+	t.Run("RequiresScope", func(t *testing.T) {
+		response := CallAPI("GET", "/api/audit", nil, userToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		old := &models.AuditLog{Action: "test.old", CreatedAt: time.Now().AddDate(0, 0, -100)}
+		if !assert.NoError(t, db.Create(old).Error) {
+			return
+		}
+
+		count, err := (&services.Audit{DB: db}).Prune(time.Now().AddDate(0, 0, -90))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		assert.Empty(t, search(t, "action=test.old"))
+		assert.NotEmpty(t, search(t, fmt.Sprintf("targetType=role&targetId=%d", roleId)))
+	})
+}
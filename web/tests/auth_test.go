@@ -359,6 +359,93 @@ func TestReauth(t *testing.T) {
 	})
 }
 
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user := &models.User{Username: "passwordResetUser", Email: "reset@example.com"}
+	if !assert.NoError(t, user.SetPassword("forgotten")) || !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+
+	ss := &services.Session{DB: db}
+	session, err := ss.CreateForUser(user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("RequestReset", func(t *testing.T) {
+		response := CallAPI("POST", "/auth/reset/request", auth.ResetRequestData{Email: user.Email}, "")
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		//unknown emails look the same, so they can't be used to find accounts
+		response = CallAPI("POST", "/auth/reset/request", auth.ResetRequestData{Email: "nobody@example.com"}, "")
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		var count int64
+		db.Model(&models.PasswordReset{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		ps := &services.PasswordReset{DB: db}
+		var err error
+		for err == nil {
+			_, err = ps.Create(user)
+		}
+		assert.ErrorIs(t, err, pufferpanel.ErrPasswordResetLimit)
+		db.Where("user_id = ?", user.ID).Delete(&models.PasswordReset{})
+	})
+
+	t.Run("ConfirmReset", func(t *testing.T) {
+		ps := &services.PasswordReset{DB: db}
+		token, err := ps.Create(user)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response := CallAPI("POST", "/auth/reset/confirm", auth.ResetConfirmData{Token: token, Password: "short"}, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/auth/reset/confirm", auth.ResetConfirmData{Token: "not a token", Password: "remembered"}, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/auth/reset/confirm", auth.ResetConfirmData{Token: token, Password: "remembered"}, "")
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		us := &services.User{DB: db}
+		updated, err := us.GetById(user.ID)
+		if assert.NoError(t, err) {
+			assert.True(t, us.IsValidCredentials(updated, "remembered"))
+		}
+
+		_, err = ss.Validate(session)
+		assert.Error(t, err, "sessions should be ended by a reset")
+
+		//links only work once
+		response = CallAPI("POST", "/auth/reset/confirm", auth.ResetConfirmData{Token: token, Password: "rememberedagain"}, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("ExpiredToken", func(t *testing.T) {
+		ps := &services.PasswordReset{DB: db}
+		token, err := ps.Create(user)
+		if !assert.NoError(t, err) {
+			return
+		}
+		hashed, _ := services.HashToken(token)
+		db.Model(&models.PasswordReset{}).Where("token = ?", hashed).Update("expiration_time", time.Now().Add(-time.Minute))
+
+		response := CallAPI("POST", "/auth/reset/confirm", auth.ResetConfirmData{Token: token, Password: "tooslowtoreset"}, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
 func TestMisc(t *testing.T) {
 	t.Parallel()
 	t.Run("GetJWKS", func(t *testing.T) {
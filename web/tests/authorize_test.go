@@ -0,0 +1,451 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/oauth2"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	web "github.com/pufferpanel/pufferpanel/v3/web/oauth2"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func postForm(path string, form url.Values, token string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		request.Header.Add("Authorization", "Bearer "+token)
+	}
+	response := httptest.NewRecorder()
+	pufferpanel.Engine.ServeHTTP(response, request)
+	return response
+}
+
+func TestAuthorizationCode(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user := &models.User{Username: "authorizeuser", Email: "authorize@example.com"}
+	if !assert.NoError(t, user.SetPassword("authorizepassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfClients}}).Error) {
+		return
+	}
+	userToken, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	//a third party app, owned by someone else
+	const redirectUri = "https://app.example.com/callback"
+	const secret = "appsecret"
+	client := &models.Client{ClientId: "thirdpartyapp", Name: "Third party", UserId: loginAdminUser.ID, RedirectUris: []string{redirectUri}}
+	if !assert.NoError(t, client.SetClientSecret(secret)) {
+		return
+	}
+	if !assert.NoError(t, db.Create(client).Error) {
+		return
+	}
+
+	verifier := strings.Repeat("v", 50)
+	sum := sha256.Sum256([]byte(verifier))
+	request := web.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientId:            client.ClientId,
+		RedirectUri:         redirectUri,
+		Scope:               "login self.clients nodes.view",
+		State:               "xyz",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+	query := func(r web.AuthorizeRequest) string {
+		values := url.Values{}
+		values.Set("response_type", r.ResponseType)
+		values.Set("client_id", r.ClientId)
+		values.Set("redirect_uri", r.RedirectUri)
+		values.Set("scope", r.Scope)
+		values.Set("state", r.State)
+		values.Set("code_challenge", r.CodeChallenge)
+		values.Set("code_challenge_method", r.CodeChallengeMethod)
+		return values.Encode()
+	}
+
+	approve := func(t *testing.T, answer web.ConsentRequest) *url.URL {
+		response := CallAPI("POST", "/oauth2/authorize/consent", answer, userToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			t.FailNow()
+		}
+		res := &web.ConsentResult{}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(res))
+		u, err := url.Parse(res.RedirectUri)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return u
+	}
+
+	exchange := func(code, codeVerifier string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("grant_type", "authorization_code")
+		form.Set("client_id", client.ClientId)
+		form.Set("client_secret", secret)
+		form.Set("code", code)
+		form.Set("redirect_uri", redirectUri)
+		form.Set("code_verifier", codeVerifier)
+		return postForm("/oauth2/token", form, "")
+	}
+
+	var accessToken string
+
+	t.Run("Authorize", func(t *testing.T) {
+		response := CallAPI("GET", "/oauth2/authorize?"+query(request), nil, "")
+		if assert.Equal(t, http.StatusFound, response.Code) {
+			assert.True(t, strings.HasPrefix(response.Header().Get("Location"), "/auth/authorize?"))
+		}
+
+		//an unregistered redirect is never followed
+		bad := request
+		bad.RedirectUri = "https://evil.example.com/callback"
+		response = CallAPI("GET", "/oauth2/authorize?"+query(bad), nil, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		//anything else is sent back to the client
+		bad = request
+		bad.CodeChallengeMethod = "plain"
+		response = CallAPI("GET", "/oauth2/authorize?"+query(bad), nil, "")
+		if assert.Equal(t, http.StatusFound, response.Code) {
+			location, err := url.Parse(response.Header().Get("Location"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, "app.example.com", location.Host)
+				assert.Equal(t, "invalid_request", location.Query().Get("error"))
+				assert.Equal(t, "xyz", location.Query().Get("state"))
+			}
+		}
+	})
+
+	t.Run("Consent", func(t *testing.T) {
+		response := CallAPI("GET", "/oauth2/authorize/consent?"+query(request), nil, userToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		res := &web.ConsentResponse{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(res)) {
+			return
+		}
+		assert.Equal(t, "Third party", res.Name)
+		//the user doesn't have nodes.view, so can't give it
+		assert.Equal(t, []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfClients}, res.Scopes)
+
+		response = CallAPI("GET", "/oauth2/authorize/consent?"+query(request), nil, "")
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		location := approve(t, web.ConsentRequest{AuthorizeRequest: request, Approve: false})
+		assert.Equal(t, "access_denied", location.Query().Get("error"))
+		assert.Empty(t, location.Query().Get("code"))
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		location := approve(t, web.ConsentRequest{AuthorizeRequest: request, Approve: true})
+		code := location.Query().Get("code")
+		if !assert.NotEmpty(t, code) {
+			return
+		}
+
+		response := exchange(code, strings.Repeat("w", 50))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		//and the code is gone after being tried
+		response = exchange(code, verifier)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Exchange", func(t *testing.T) {
+		location := approve(t, web.ConsentRequest{AuthorizeRequest: request, Approve: true, Scopes: []string{scopes.ScopeLogin.Value}})
+		assert.Equal(t, "xyz", location.Query().Get("state"))
+		code := location.Query().Get("code")
+
+		response := exchange(code, verifier)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		res := &oauth2.TokenResponse{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(res)) {
+			return
+		}
+		assert.Equal(t, "login", res.Scope)
+		accessToken = res.AccessToken
+
+		response = exchange(code, verifier)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("ConcurrentExchange", func(t *testing.T) {
+		location := approve(t, web.ConsentRequest{AuthorizeRequest: request, Approve: true, Scopes: []string{scopes.ScopeLogin.Value}})
+		code := location.Query().Get("code")
+
+		//hold both requests right before they use up the code, so both have already found it
+		var waiting atomic.Int32
+		bothFound := make(chan struct{})
+		err := db.Callback().Delete().Before("gorm:begin_transaction").Register("test:hold_codes", func(tx *gorm.DB) {
+			if tx.Statement.Schema == nil || tx.Statement.Schema.Table != "authorization_codes" {
+				return
+			}
+			if waiting.Add(1) == 2 {
+				close(bothFound)
+			}
+			select {
+			case <-bothFound:
+			case <-time.After(5 * time.Second):
+			}
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer db.Callback().Delete().Remove("test:hold_codes")
+
+		results := make(chan int, 2)
+		for i := 0; i < cap(results); i++ {
+			go func() {
+				results <- exchange(code, verifier).Code
+			}()
+		}
+		var codes []int
+		for i := 0; i < cap(results); i++ {
+			codes = append(codes, <-results)
+		}
+		assert.ElementsMatch(t, []int{http.StatusOK, http.StatusBadRequest}, codes)
+	})
+
+	t.Run("DownSelected", func(t *testing.T) {
+		response := CallAPI("GET", "/api/self", nil, accessToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//the user can, but didn't allow the app to
+		response = CallAPI("GET", "/api/self/oauth2", nil, accessToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("GET", "/api/self/oauth2", nil, userToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//nor can the app approve other apps
+		response = CallAPI("GET", "/oauth2/authorize/consent?"+query(request), nil, accessToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Introspect", func(t *testing.T) {
+		form := url.Values{}
+		form.Set("token", accessToken)
+		form.Set("client_id", client.ClientId)
+		form.Set("client_secret", secret)
+		response := postForm("/oauth2/introspect", form, "")
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		res := &oauth2.TokenInfoResponse{}
+		if assert.NoError(t, json.NewDecoder(response.Body).Decode(res)) {
+			assert.True(t, res.Active)
+			assert.Equal(t, "login", res.Scope)
+			assert.Equal(t, client.ClientId, res.ClientId)
+			assert.Equal(t, user.Username, res.Username)
+		}
+
+		//other clients can't see it
+		form.Set("client_id", loginOAuth2Admin.ClientId)
+		form.Set("client_secret", loginOAuth2AdminSecret)
+		response = postForm("/oauth2/introspect", form, "")
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			res = &oauth2.TokenInfoResponse{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(res))
+			assert.False(t, res.Active)
+		}
+
+		//a secret is needed to introspect
+		form.Set("client_id", client.ClientId)
+		form.Del("client_secret")
+		response = postForm("/oauth2/introspect", form, "")
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		//but an admin session can look at anything
+		adminToken, err := createSessionAdmin()
+		if !assert.NoError(t, err) {
+			return
+		}
+		form.Del("client_id")
+		response = postForm("/oauth2/introspect", form, adminToken)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			res = &oauth2.TokenInfoResponse{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(res))
+			assert.True(t, res.Active)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		form := url.Values{}
+		form.Set("token", accessToken)
+		form.Set("client_id", client.ClientId)
+		form.Set("client_secret", "wrong")
+		response := postForm("/oauth2/revoke", form, "")
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		form.Set("client_secret", secret)
+		response = postForm("/oauth2/revoke", form, "")
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = CallAPI("GET", "/api/self", nil, accessToken)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		//revoking again is fine
+		response = postForm("/oauth2/revoke", form, "")
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		location := approve(t, web.ConsentRequest{AuthorizeRequest: request, Approve: true, Scopes: []string{scopes.ScopeLogin.Value}})
+		response := exchange(location.Query().Get("code"), verifier)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		first := &oauth2.TokenResponse{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(first)) || !assert.NotEmpty(t, first.RefreshToken) {
+			return
+		}
+
+		refresh := func(token, clientSecret string) *httptest.ResponseRecorder {
+			form := url.Values{}
+			form.Set("grant_type", "refresh_token")
+			form.Set("client_id", client.ClientId)
+			form.Set("client_secret", clientSecret)
+			form.Set("refresh_token", token)
+			return postForm("/oauth2/token", form, "")
+		}
+
+		//the client has a secret, so the refresh token alone isn't enough
+		response = refresh(first.RefreshToken, "")
+		if assert.Equal(t, http.StatusUnauthorized, response.Code) {
+			res := &oauth2.ErrorResponse{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(res))
+			assert.Equal(t, "invalid_client", res.Error)
+		}
+
+		response = refresh(first.RefreshToken, secret)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		second := &oauth2.TokenResponse{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(second)) {
+			return
+		}
+		assert.Equal(t, "login", second.Scope)
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+		response = CallAPI("GET", "/api/self", nil, second.AccessToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//using the first one again means it was stolen, so everything from it goes
+		response = refresh(first.RefreshToken, secret)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		response = refresh(second.RefreshToken, secret)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		response = CallAPI("GET", "/api/self", nil, first.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+		response = CallAPI("GET", "/api/self", nil, second.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("PublicClient", func(t *testing.T) {
+		const appRedirect = "http://127.0.0.1:8000/callback"
+		response := CallAPI("POST", "/api/self/oauth2", &models.Client{Name: "desktop app", RedirectUris: []string{appRedirect}, Public: true}, userToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		public := &models.Client{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(public)) {
+			return
+		}
+		defer (&services.OAuth2{DB: db}).Delete(public.ClientId)
+		assert.True(t, public.Public)
+		assert.Empty(t, public.ClientSecret)
+
+		publicRequest := request
+		publicRequest.ClientId = public.ClientId
+		publicRequest.RedirectUri = appRedirect
+		location := approve(t, web.ConsentRequest{AuthorizeRequest: publicRequest, Approve: true, Scopes: []string{scopes.ScopeLogin.Value}})
+
+		//the verifier stands in for the secret
+		form := url.Values{}
+		form.Set("grant_type", "authorization_code")
+		form.Set("client_id", public.ClientId)
+		form.Set("code", location.Query().Get("code"))
+		form.Set("redirect_uri", appRedirect)
+		form.Set("code_verifier", verifier)
+		response = postForm("/oauth2/token", form, "")
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		res := &oauth2.TokenResponse{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(res)) {
+			return
+		}
+
+		form = url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", public.ClientId)
+		form.Set("refresh_token", res.RefreshToken)
+		response = postForm("/oauth2/token", form, "")
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//but it can't get tokens on its own, or look at them
+		form = url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", public.ClientId)
+		response = postForm("/oauth2/token", form, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		form = url.Values{}
+		form.Set("token", res.AccessToken)
+		form.Set("client_id", public.ClientId)
+		response = postForm("/oauth2/introspect", form, "")
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("RedirectUris", func(t *testing.T) {
+		response := CallAPI("POST", "/api/self/oauth2", &models.Client{Name: "app", RedirectUris: []string{"http://example.com/callback"}}, userToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", "/api/self/oauth2", &models.Client{Name: "app", RedirectUris: []string{"http://127.0.0.1:8000/callback", "myapp:/callback"}}, userToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		created := &models.Client{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(created)) {
+			return
+		}
+		assert.Len(t, created.RedirectUris, 2)
+
+		response = CallAPI("PUT", "/api/self/oauth2/"+created.ClientId, &models.Client{Name: "app", RedirectUris: []string{"https://app.example.com/#fragment"}}, userToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		os := &services.OAuth2{DB: db}
+		assert.NoError(t, os.Delete(created.ClientId))
+		assert.NoError(t, os.Delete(client.ClientId))
+	})
+}
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/braintree/manners"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
 	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/config"
 	"github.com/pufferpanel/pufferpanel/v3/database"
@@ -64,6 +67,7 @@ func TestMain(m *testing.M) {
 		router := gin.New()
 		router.Use(gin.Recovery())
 		//router.Use(gin.Logger())
+		router.Use(sessions.Sessions("session", cookie.NewStore(securecookie.GenerateRandomKey(32))))
 		gin.SetMode(gin.ReleaseMode)
 		web.RegisterRoutes(router)
 
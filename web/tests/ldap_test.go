@@ -0,0 +1,467 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/web/auth"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ldapOpBindRequest       = 0
+	ldapOpBindResponse      = 1
+	ldapOpUnbindRequest     = 2
+	ldapOpSearchRequest     = 3
+	ldapOpSearchEntry       = 4
+	ldapOpSearchDone        = 5
+	ldapOpExtendedRequest   = 23
+	ldapOpExtendedResponse  = 24
+	ldapStartTLSOID         = "1.3.6.1.4.1.1466.20037"
+	ldapResultSuccess       = 0
+	ldapResultNoSuchObject  = 32
+	ldapResultInvalidCreds  = 49
+	ldapResultUnwilling     = 53
+	ldapServiceDN           = "cn=admin,dc=example,dc=com"
+	ldapServicePassword     = "adminpass"
+	ldapUserDN              = "uid=johndoe,ou=people,dc=example,dc=com"
+	ldapUserPassword        = "ldappassword"
+	ldapPanelUsersGroup     = "cn=panel-users,ou=groups,dc=example,dc=com"
+	ldapServerOperatorGroup = "cn=operators,ou=groups,dc=example,dc=com"
+)
+
+// fakeLDAP is a small in-process LDAP server, which understands just enough to bind, search and StartTLS
+type fakeLDAP struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+
+	lock      sync.Mutex
+	entries   map[string]map[string][]string
+	passwords map[string]string
+}
+
+func newFakeLDAP(t *testing.T) *fakeLDAP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeLDAP{
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: key}}},
+		entries: map[string]map[string][]string{
+			ldapUserDN: {
+				"objectClass": {"person"},
+				"uid":         {"johndoe"},
+				"mail":        {"johndoe@example.com"},
+				"memberOf":    {ldapPanelUsersGroup, ldapServerOperatorGroup},
+			},
+		},
+		passwords: map[string]string{
+			ldapServiceDN: ldapServicePassword,
+			ldapUserDN:    ldapUserPassword,
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return server
+}
+
+func (f *fakeLDAP) url() string {
+	return "ldap://" + f.listener.Addr().String()
+}
+
+func (f *fakeLDAP) setAttribute(dn, attribute string, values ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.entries[dn][attribute] = values
+}
+
+func (f *fakeLDAP) addEntry(dn, password string, attributes map[string][]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.entries[dn] = attributes
+	f.passwords[dn] = password
+}
+
+func (f *fakeLDAP) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldapOpBindRequest:
+			dn, _ := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := ldapResultSuccess
+			f.lock.Lock()
+			if dn != "" && (password == "" || f.passwords[dn] != password) {
+				code = ldapResultInvalidCreds
+			}
+			f.lock.Unlock()
+			_, err = conn.Write(ldapResponse(id, ldapOpBindResponse, code).Bytes())
+		case ldapOpSearchRequest:
+			err = f.search(conn, id, op)
+		case ldapOpExtendedRequest:
+			if op.Children[0].Data.String() != ldapStartTLSOID {
+				_, err = conn.Write(ldapResponse(id, ldapOpExtendedResponse, ldapResultUnwilling).Bytes())
+				break
+			}
+			if _, err = conn.Write(ldapResponse(id, ldapOpExtendedResponse, ldapResultSuccess).Bytes()); err == nil {
+				tlsConn := tls.Server(conn, f.tlsConfig)
+				if err = tlsConn.Handshake(); err == nil {
+					conn = tlsConn
+				}
+			}
+		case ldapOpUnbindRequest:
+			return
+		default:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeLDAP) search(conn net.Conn, id int64, op *ber.Packet) error {
+	base, _ := op.Children[0].Value.(string)
+	scope, _ := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if scope == 0 {
+		if _, exists := f.entries[base]; !exists {
+			_, err := conn.Write(ldapResponse(id, ldapOpSearchDone, ldapResultNoSuchObject).Bytes())
+			return err
+		}
+	}
+
+	for dn, attributes := range f.entries {
+		if scope == 0 && dn != base {
+			continue
+		}
+		if scope != 0 && !strings.HasSuffix(strings.ToLower(dn), strings.ToLower(base)) {
+			continue
+		}
+		if !ldapFilterMatches(filter, attributes) {
+			continue
+		}
+
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapOpSearchEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+		list := ber.NewSequence("")
+		for name, values := range attributes {
+			attribute := ber.NewSequence("")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attribute.AppendChild(set)
+			list.AppendChild(attribute)
+		}
+		entry.AppendChild(list)
+
+		envelope := ber.NewSequence("")
+		envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+		envelope.AppendChild(entry)
+		if _, err := conn.Write(envelope.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write(ldapResponse(id, ldapOpSearchDone, ldapResultSuccess).Bytes())
+	return err
+}
+
+// ldapFilterMatches understands and, or, not, equality and presence filters
+func ldapFilterMatches(filter *ber.Packet, attributes map[string][]string) bool {
+	switch filter.Tag {
+	case 0:
+		for _, v := range filter.Children {
+			if !ldapFilterMatches(v, attributes) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, v := range filter.Children {
+			if ldapFilterMatches(v, attributes) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return !ldapFilterMatches(filter.Children[0], attributes)
+	case 3:
+		name, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for k, values := range attributes {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+			for _, v := range values {
+				if strings.EqualFold(v, value) {
+					return true
+				}
+			}
+		}
+		return false
+	case 7:
+		name := filter.Data.String()
+		for k, values := range attributes {
+			if strings.EqualFold(k, name) && len(values) > 0 {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func ldapResponse(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	return envelope
+}
+
+func TestLDAP(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	directory := newFakeLDAP(t)
+	defer func() {
+		_ = directory.listener.Close()
+	}()
+
+	server := &models.Server{Identifier: "ldapserver", Name: "ldapserver", Type: "generic"}
+	if !assert.NoError(t, db.Create(server).Error) {
+		return
+	}
+	defer db.Delete(server)
+
+	_ = config.LdapEnabled.Set(true, false)
+	_ = config.LdapUrl.Set(directory.url(), false)
+	_ = config.LdapBindDn.Set(ldapServiceDN, false)
+	_ = config.LdapBindPassword.Set(ldapServicePassword, false)
+	_ = config.LdapBaseDn.Set("dc=example,dc=com", false)
+	_ = config.LdapDisabledFilter.Set("(employeeType=disabled)", false)
+	_ = config.LdapGroups.Set([]config.LDAPGroup{
+		{Group: ldapPanelUsersGroup, Scopes: []string{scopes.ScopeLogin.Value, scopes.ScopeSelfEdit.Value}},
+		{Group: ldapServerOperatorGroup, Servers: map[string][]string{server.Identifier: {scopes.ScopeServerView.Value, scopes.ScopeServerSftp.Value}}},
+	}, false)
+	defer func() {
+		_ = config.LdapEnabled.Set(false, false)
+		_ = config.LdapGroups.Set(nil, false)
+	}()
+
+	ps := &services.Permission{DB: db}
+	us := &services.User{DB: db}
+
+	t.Run("SearchThenBind", func(t *testing.T) {
+		response := CallAPI("POST", "/auth/login", auth.LoginRequestData{Email: "johndoe@example.com", Password: ldapUserPassword}, "")
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+
+		user, err := us.GetByEmail("johndoe@example.com")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "johndoe", user.Username)
+
+		global, err := ps.GetForUserAndServer(user.ID, "")
+		if assert.NoError(t, err) {
+			assert.True(t, scopes.ContainsScope(global.Scopes, scopes.ScopeLogin))
+			assert.True(t, scopes.ContainsScope(global.Scopes, scopes.ScopeSelfEdit))
+		}
+		perms, err := ps.GetForUserAndServer(user.ID, server.Identifier)
+		if assert.NoError(t, err) {
+			assert.True(t, scopes.ContainsScope(perms.Scopes, scopes.ScopeServerSftp))
+		}
+	})
+
+	t.Run("InvalidPassword", func(t *testing.T) {
+		response := CallAPI("POST", "/auth/login", auth.LoginRequestData{Email: "johndoe@example.com", Password: "wrong"}, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		//the password the panel made for the user must never work
+		_, _, err := us.ValidateLogin("johndoe@example.com", "")
+		assert.ErrorIs(t, err, pufferpanel.ErrInvalidCredentials)
+	})
+
+	t.Run("LocalUsersStillWork", func(t *testing.T) {
+		response := CallAPI("POST", "/auth/login", auth.LoginRequestData{Email: loginAdminUser.Email, Password: loginAdminUserPassword}, "")
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("LocalAdminNotTakenOver", func(t *testing.T) {
+		//an LDAP entry with the same email as a local admin, which isn't in any group that would make it an admin
+		directory.addEntry("uid=localadmin,ou=people,dc=example,dc=com", "otherpassword", map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"localadmin"},
+			"mail":        {loginAdminUser.Email},
+			"memberOf":    {ldapPanelUsersGroup},
+		})
+
+		_, _, err := us.ValidateLogin(loginAdminUser.Email, "otherpassword")
+		assert.ErrorIs(t, err, pufferpanel.ErrInvalidCredentials)
+
+		global, err := ps.GetForUserAndServer(loginAdminUser.ID, "")
+		if assert.NoError(t, err) {
+			assert.True(t, scopes.ContainsScope(global.Scopes, scopes.ScopeAdmin))
+		}
+		managed, err := services.IsLDAPUser(db, loginAdminUser.ID)
+		if assert.NoError(t, err) {
+			assert.False(t, managed)
+		}
+
+		//and the local password still works
+		_, _, err = us.ValidateLogin(loginAdminUser.Email, loginAdminUserPassword)
+		assert.NoError(t, err)
+	})
+
+	t.Run("SFTP", func(t *testing.T) {
+		sftpAuth := &services.DatabaseSFTPAuthorization{}
+		perms, err := sftpAuth.Validate("johndoe@example.com#"+server.Identifier, ldapUserPassword)
+		if assert.NoError(t, err) {
+			assert.Equal(t, server.Identifier, perms.Extensions["server_id"])
+		}
+
+		_, err = sftpAuth.Validate("johndoe@example.com#"+server.Identifier, "wrong")
+		assert.Error(t, err)
+	})
+
+	t.Run("DirectBindWithStartTLS", func(t *testing.T) {
+		_ = config.LdapUserDn.Set("uid={username},ou=people,dc=example,dc=com", false)
+		_ = config.LdapStartTls.Set(true, false)
+		_ = config.LdapInsecureSkipVerify.Set(true, false)
+		defer func() {
+			_ = config.LdapUserDn.Set("", false)
+			_ = config.LdapStartTls.Set(false, false)
+			_ = config.LdapInsecureSkipVerify.Set(false, false)
+		}()
+
+		user, _, err := us.ValidateLogin("johndoe@example.com", ldapUserPassword)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "johndoe@example.com", user.Email)
+		}
+	})
+
+	t.Run("SyncGroups", func(t *testing.T) {
+		directory.setAttribute(ldapUserDN, "memberOf", ldapPanelUsersGroup)
+
+		l := &services.LDAP{DB: db}
+		if !assert.NoError(t, l.Sync()) {
+			return
+		}
+
+		user, err := us.GetByEmail("johndoe@example.com")
+		if !assert.NoError(t, err) {
+			return
+		}
+		perms, err := ps.GetForUserAndServer(user.ID, server.Identifier)
+		if assert.NoError(t, err) {
+			assert.Empty(t, perms.Scopes)
+		}
+		global, err := ps.GetForUserAndServer(user.ID, "")
+		if assert.NoError(t, err) {
+			assert.True(t, scopes.ContainsScope(global.Scopes, scopes.ScopeLogin))
+		}
+	})
+
+	t.Run("Deprovision", func(t *testing.T) {
+		user, err := us.GetByEmail("johndoe@example.com")
+		if !assert.NoError(t, err) {
+			return
+		}
+		ss := &services.Session{DB: db}
+		session, err := ss.CreateForUser(user)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		directory.setAttribute(ldapUserDN, "employeeType", "disabled")
+
+		l := &services.LDAP{DB: db}
+		if !assert.NoError(t, l.Sync()) {
+			return
+		}
+
+		all, err := ps.GetForUser(user.ID)
+		if assert.NoError(t, err) {
+			assert.Empty(t, all)
+		}
+		_, err = ss.Validate(session)
+		assert.Error(t, err)
+
+		identity := &models.LDAPIdentity{}
+		if assert.NoError(t, db.Where("user_id = ?", user.ID).First(identity).Error) {
+			assert.True(t, identity.Disabled)
+		}
+
+		response := CallAPI("POST", "/auth/login", auth.LoginRequestData{Email: "johndoe@example.com", Password: ldapUserPassword}, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/web/auth"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLockouts(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user := &models.User{Username: "lockoutuser", Email: "lockout@example.com"}
+	if !assert.NoError(t, user.SetPassword("lockoutpassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	defer (&services.User{DB: db}).Delete(user)
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin}}).Error) {
+		return
+	}
+
+	login := func(password string) int {
+		return CallAPI("POST", "/auth/login", auth.LoginRequestData{Email: user.Email, Password: password}, "").Code
+	}
+
+	t.Run("LocksAccount", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusBadRequest, login("wrongpassword"))
+		}
+
+		//even the right password is refused until the lockout ends
+		response := CallAPI("POST", "/auth/login", auth.LoginRequestData{Email: user.Email, Password: "lockoutpassword"}, "")
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+
+	t.Run("ListAndClear", func(t *testing.T) {
+		userToken, err := createSession(db, user)
+		if !assert.NoError(t, err) {
+			return
+		}
+		response := CallAPI("GET", "/api/lockouts", nil, userToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		adminToken, err := createSessionAdmin()
+		if !assert.NoError(t, err) {
+			return
+		}
+		response = CallAPI("GET", "/api/lockouts", nil, adminToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var records []*models.LoginLockout
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&records))
+
+		var found *models.LoginLockout
+		for _, v := range records {
+			if v.Kind == services.LockoutKindAccount && v.Value == user.Email {
+				found = v
+			}
+		}
+		if !assert.NotNil(t, found) {
+			return
+		}
+		assert.True(t, found.IsLocked())
+		assert.Equal(t, 1, found.Lockouts)
+
+		response = CallAPI("DELETE", fmt.Sprintf("/api/lockouts/%d", found.ID), nil, adminToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		response = CallAPI("DELETE", fmt.Sprintf("/api/lockouts/%d", found.ID), nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		assert.Equal(t, http.StatusOK, login("lockoutpassword"))
+	})
+
+	t.Run("SuccessResets", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, http.StatusBadRequest, login("wrongpassword"))
+		}
+		assert.Equal(t, http.StatusOK, login("lockoutpassword"))
+		assert.Equal(t, http.StatusBadRequest, login("wrongpassword"))
+		assert.Equal(t, http.StatusOK, login("lockoutpassword"))
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		ls := &services.LoginLimiter{DB: db}
+		account := "backoff@example.com"
+
+		var first, second time.Time
+		for i := 0; i < 5; i++ {
+			first, err = ls.Fail("", account)
+			assert.NoError(t, err)
+		}
+		if !assert.False(t, first.IsZero()) {
+			return
+		}
+
+		//the lockout ending doesn't forget it happened, so the next one is longer
+		assert.NoError(t, db.Model(&models.LoginLockout{}).Where("value = ?", account).UpdateColumn("locked_until", time.Now().Add(-time.Second)).Error)
+		for i := 0; i < 5; i++ {
+			second, err = ls.Fail("", account)
+			assert.NoError(t, err)
+		}
+		assert.InDelta(t, 2*time.Until(first).Seconds(), time.Until(second).Seconds(), 5)
+	})
+
+	t.Run("LocksIP", func(t *testing.T) {
+		ls := &services.LoginLimiter{DB: db}
+		ip := "192.0.2.45"
+
+		//spread over many accounts, so only the IP is locked out
+		for i := 0; i < 20; i++ {
+			_, err := ls.Fail(ip, fmt.Sprintf("spray%d@example.com", i))
+			assert.NoError(t, err)
+		}
+
+		until, err := ls.Check(ip, user.Email)
+		assert.NoError(t, err)
+		assert.False(t, until.IsZero())
+
+		until, err = ls.Check("192.0.2.46", user.Email)
+		assert.NoError(t, err)
+		assert.True(t, until.IsZero())
+	})
+}
@@ -0,0 +1,271 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	pkgsftp "github.com/pkg/sftp"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/servers"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/sftp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// transferRoutes are what a node moving a server is allowed to download from the old one
+var transferRoutes = []string{"GET /daemon/server/:serverId/transfer", "GET /daemon/server/:serverId/backup/download"}
+
+func TestMigration(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	adminToken, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ns := &services.Node{DB: db}
+
+	createServer := func(id string, port int) bool {
+		data := map[string]interface{}{}
+		if !assert.NoError(t, json.Unmarshal(CreateServerData, &data)) {
+			return false
+		}
+		data["data"].(map[string]interface{})["port"].(map[string]interface{})["value"] = port
+
+		response := CallAPI("PUT", "/api/servers/"+id, data, adminToken)
+		return assert.Equal(t, http.StatusOK, response.Code)
+	}
+
+	if !createServer("migratefrom", 25600) {
+		return
+	}
+	defer CallAPI("DELETE", "/api/servers/migratefrom", nil, adminToken)
+	if !createServer("migrateto", 25601) {
+		return
+	}
+	defer CallAPI("DELETE", "/api/servers/migrateto", nil, adminToken)
+
+	err = os.WriteFile(filepath.Join(config.ServersFolder.Value(), "migratefrom", "moved.txt"), []byte("moved"), 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	createNode := func(name string, port uint16) *models.Node {
+		node := &models.Node{
+			Name:        name,
+			PublicHost:  models.LocalNode.PrivateHost,
+			PrivateHost: models.LocalNode.PrivateHost,
+			PublicPort:  port,
+			PrivatePort: port,
+			SFTPPort:    port + 1,
+			Secret:      name + "secret",
+		}
+		if !assert.NoError(t, ns.Create(node)) {
+			return nil
+		}
+		return node
+	}
+
+	errorCode := func(response *http.Response) string {
+		result := &pufferpanel.ErrorResponse{}
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil || result.Error == nil {
+			return ""
+		}
+		return result.Error.Code
+	}
+
+	t.Run("NoMigration", func(t *testing.T) {
+		response := CallAPI("GET", "/api/servers/migratefrom/migration", nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("SameNode", func(t *testing.T) {
+		response := CallAPI("POST", "/api/servers/migratefrom/migration", &models.ServerMigrationRequest{NodeId: models.LocalNode.ID}, adminToken)
+		if assert.Equal(t, http.StatusBadRequest, response.Code) {
+			assert.Equal(t, "ErrMigrationSameNode", errorCode(response.Result()))
+		}
+	})
+
+	t.Run("OfflineNode", func(t *testing.T) {
+		//nothing listens on this node, so it can't be checked
+		node := createNode("migrateoffline", 40007)
+		if node == nil {
+			return
+		}
+		defer ns.Delete(node.ID)
+
+		response := CallAPI("POST", "/api/servers/migratefrom/migration", &models.ServerMigrationRequest{NodeId: node.ID}, adminToken)
+		if assert.Equal(t, http.StatusBadRequest, response.Code) {
+			assert.Equal(t, "ErrNodeOffline", errorCode(response.Result()))
+		}
+	})
+
+	t.Run("Transfer", func(t *testing.T) {
+		ts, err := services.NewTokenService()
+		if !assert.NoError(t, err) {
+			return
+		}
+		token, err := ts.GenerateRequest()
+		if !assert.NoError(t, err) {
+			return
+		}
+		limited, err := ts.GenerateServerRequest("migratefrom", transferRoutes, time.Hour)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		transfer := &pufferpanel.ServerTransfer{
+			Source: fmt.Sprintf("http://%s:%d/daemon/server/migratefrom", models.LocalNode.PrivateHost, models.LocalNode.PrivatePort),
+			Token:  limited,
+		}
+		response := CallAPI("POST", "/daemon/server/migrateto/transfer", transfer, token)
+		if !assert.Equal(t, http.StatusAccepted, response.Code) {
+			return
+		}
+
+		//the files come in the background, which is followed through the status
+		status := &pufferpanel.ServerTransferStatus{}
+		for i := 0; i < 100; i++ {
+			response = CallAPI("GET", "/daemon/server/migrateto/transfer/status", nil, token)
+			if !assert.Equal(t, http.StatusOK, response.Code) || !assert.NoError(t, json.NewDecoder(response.Body).Decode(status)) {
+				return
+			}
+			if !status.Running {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !assert.True(t, status.Done, status.Error) {
+			return
+		}
+		assert.Greater(t, status.Total, int64(0))
+		assert.Equal(t, status.Total, status.Copied)
+
+		data, err := os.ReadFile(filepath.Join(config.ServersFolder.Value(), "migrateto", "moved.txt"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "moved", string(data))
+		}
+	})
+
+	t.Run("TransferToken", func(t *testing.T) {
+		ts, err := services.NewTokenService()
+		if !assert.NoError(t, err) {
+			return
+		}
+		limited, err := ts.GenerateServerRequest("migratefrom", transferRoutes, time.Hour)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response := CallAPI("GET", "/daemon/server/migratefrom/transfer", nil, limited)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//the node given the token can only download that server
+		response = CallAPI("GET", "/daemon/server/migratefrom/data", nil, limited)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("POST", "/daemon/server/migratefrom/transfer", &pufferpanel.ServerTransfer{Source: "http://localhost"}, limited)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("GET", "/daemon/server/migrateto/transfer", nil, limited)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("GET", "/daemon/stats", nil, limited)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		expired, err := ts.GenerateServerRequest("migratefrom", transferRoutes, -time.Minute)
+		if !assert.NoError(t, err) {
+			return
+		}
+		response = CallAPI("GET", "/daemon/server/migratefrom/transfer", nil, expired)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		ts, err := services.NewTokenService()
+		if !assert.NoError(t, err) {
+			return
+		}
+		token, err := ts.GenerateRequest()
+		if !assert.NoError(t, err) {
+			return
+		}
+		prg := servers.GetFromCache("migratefrom")
+		if !assert.NotNil(t, prg) {
+			return
+		}
+		handlers := sftp.CreateRequestPrefix(prg, false, nil, nil)
+
+		response := CallAPI("PUT", "/daemon/server/migratefrom/migrating", nil, token)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		//while it is being copied, nothing can be changed which would be left behind
+		response = CallAPI("POST", "/daemon/server/migratefrom/start?wait", nil, token)
+		assert.Equal(t, "ErrServerMigrating", errorCode(response.Result()))
+		_, err = handlers.FilePut.Filewrite(pkgsftp.NewRequest("Put", "/locked.txt"))
+		assert.Error(t, err)
+		assert.Error(t, handlers.FileCmd.Filecmd(pkgsftp.NewRequest("Mkdir", "/locked")))
+
+		response = CallAPI("DELETE", "/daemon/server/migratefrom/migrating", nil, token)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		if assert.NoError(t, handlers.FileCmd.Filecmd(pkgsftp.NewRequest("Mkdir", "/locked"))) {
+			assert.NoError(t, handlers.FileCmd.Filecmd(pkgsftp.NewRequest("Rmdir", "/locked")))
+		}
+	})
+
+	t.Run("RollsBack", func(t *testing.T) {
+		//this node is the local daemon again, which already has the server, so making it there fails
+		node := createNode("migrateloop", models.LocalNode.PrivatePort)
+		if node == nil {
+			return
+		}
+		defer ns.Delete(node.ID)
+		status := &models.NodeStatus{NodeId: node.ID, Online: true, OS: runtime.GOOS, Arch: runtime.GOARCH, LastChecked: time.Now()}
+		if !assert.NoError(t, db.Create(status).Error) {
+			return
+		}
+
+		response := CallAPI("POST", "/api/servers/migratefrom/migration", &models.ServerMigrationRequest{NodeId: node.ID}, adminToken)
+		if !assert.Equal(t, http.StatusAccepted, response.Code) {
+			return
+		}
+
+		migration := &models.ServerMigration{}
+		for i := 0; i < 100; i++ {
+			response = CallAPI("GET", "/api/servers/migratefrom/migration", nil, adminToken)
+			if !assert.Equal(t, http.StatusOK, response.Code) || !assert.NoError(t, json.NewDecoder(response.Body).Decode(migration)) {
+				return
+			}
+			if migration.Status != models.MigrationRunning {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		assert.Equal(t, models.MigrationFailed, migration.Status)
+		assert.Equal(t, models.MigrationStepCreating, migration.Step)
+		assert.True(t, migration.RolledBack)
+		assert.NotEmpty(t, migration.Error)
+
+		server, err := (&services.Server{DB: db}).Get("migratefrom")
+		if assert.NoError(t, err) {
+			assert.Nil(t, server.RawNodeID)
+		}
+		assert.FileExists(t, filepath.Join(config.ServersFolder.Value(), "migratefrom", "moved.txt"))
+		if prg := servers.GetFromCache("migratefrom"); assert.NotNil(t, prg) {
+			assert.False(t, prg.IsMigrating())
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestNodeStatus(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	adminToken, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("LocalNode", func(t *testing.T) {
+		response := CallAPI("GET", fmt.Sprintf("/api/nodes/%d/status?refresh=true", models.LocalNode.ID), nil, adminToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		status := &models.NodeStatus{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(status)) {
+			return
+		}
+
+		assert.True(t, status.Online)
+		assert.Empty(t, status.Error)
+		assert.Equal(t, pufferpanel.Version, status.Version)
+		assert.False(t, status.VersionSkew)
+		assert.Equal(t, runtime.GOOS, status.OS)
+		assert.Equal(t, runtime.GOARCH, status.Arch)
+		assert.Equal(t, runtime.NumCPU(), status.CPUs)
+		assert.NotZero(t, status.MemoryTotal)
+		assert.NotNil(t, status.LastOnline)
+	})
+
+	t.Run("StatsNeedAuth", func(t *testing.T) {
+		//anyone can see the daemon is up, but not what it is running
+		response := CallAPI("GET", "/daemon", nil, "")
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			assert.NotContains(t, response.Body.String(), "host")
+		}
+
+		response = CallAPI("GET", "/daemon/stats", nil, "")
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		ts, err := services.NewTokenService()
+		if !assert.NoError(t, err) {
+			return
+		}
+		token, err := ts.GenerateRequest()
+		if !assert.NoError(t, err) {
+			return
+		}
+		response = CallAPI("GET", "/daemon/stats", nil, token)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			stats := &pufferpanel.DaemonStats{}
+			if assert.NoError(t, json.NewDecoder(response.Body).Decode(stats)) && assert.NotNil(t, stats.Host) {
+				assert.Equal(t, runtime.NumCPU(), stats.Host.CPUs)
+			}
+		}
+	})
+
+	t.Run("OfflineNode", func(t *testing.T) {
+		//grab a port nothing is listening on
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+		port := uint16(l.Addr().(*net.TCPAddr).Port)
+		_ = l.Close()
+
+		node := &models.Node{
+			Name:        "offlinenode",
+			PublicHost:  "127.0.0.1",
+			PrivateHost: "127.0.0.1",
+			PublicPort:  port,
+			PrivatePort: port,
+			SFTPPort:    port + 1,
+			Secret:      "offlinenodesecret",
+		}
+		ns := &services.Node{DB: db}
+		if !assert.NoError(t, ns.Create(node)) {
+			return
+		}
+		defer ns.Delete(node.ID)
+
+		nss := &services.NodeStatus{DB: db}
+
+		//a single failed check isn't enough to be called offline
+		status, err := nss.Check(node)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotEmpty(t, status.Error)
+		assert.Equal(t, 1, status.Failures)
+
+		status, err = nss.Check(node)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, status.Online)
+		assert.Equal(t, 2, status.Failures)
+
+		entries, _, err := (&services.Audit{DB: db}).Search(&models.AuditSearch{Action: "node.offline", TargetType: "node", TargetId: fmt.Sprintf("%d", node.ID), PageLimit: 10, Page: 1})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+
+		response := CallAPI("GET", "/api/nodes/status", nil, adminToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var statuses []*models.NodeStatus
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&statuses)) {
+			return
+		}
+
+		var found *models.NodeStatus
+		for _, v := range statuses {
+			if v.NodeId == node.ID {
+				found = v
+			}
+		}
+		if assert.NotNil(t, found) {
+			assert.Equal(t, node.Name, found.Name)
+			assert.False(t, found.Online)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/web/auth"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is a minimal OpenID Connect provider, which hands out codes for whatever claims the test wants
+type fakeIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	lock  sync.Mutex
+	codes map[string]fakeIssuerCode
+}
+
+type fakeIssuerCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &fakeIssuer{key: key, codes: make(map[string]fakeIssuerCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, _ := r.BasicAuth()
+		if clientId != "panel" || clientSecret != "secret" || r.PostFormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		issuer.lock.Lock()
+		code, exists := issuer.codes[r.PostFormValue("code")]
+		delete(issuer.codes, r.PostFormValue("code"))
+		issuer.lock.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !exists || base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, code.claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": signed})
+	})
+	issuer.server = httptest.NewServer(mux)
+	return issuer
+}
+
+// login goes through the whole login, returning the response from the panel's callback
+func (f *fakeIssuer) login(t *testing.T, provider string, claims jwt.MapClaims, state func(string) string) *httptest.ResponseRecorder {
+	response := CallAPI("GET", "/auth/oidc/"+provider+"/login", nil, "")
+	if !assert.Equal(t, http.StatusFound, response.Code) {
+		return response
+	}
+
+	location, err := url.Parse(response.Header().Get("Location"))
+	if !assert.NoError(t, err) || !assert.True(t, strings.HasPrefix(location.String(), f.server.URL+"/authorize")) {
+		return response
+	}
+	query := location.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	var cookie *http.Cookie
+	for _, v := range response.Result().Cookies() {
+		if v.Name == "puffer_oidc" {
+			cookie = v
+		}
+	}
+	if !assert.NotNil(t, cookie) {
+		return response
+	}
+
+	claims["iss"] = f.server.URL
+	claims["aud"] = "panel"
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	claims["nonce"] = query.Get("nonce")
+
+	f.lock.Lock()
+	f.codes["code-"+query.Get("state")] = fakeIssuerCode{challenge: query.Get("code_challenge"), claims: claims}
+	f.lock.Unlock()
+
+	returnedState := query.Get("state")
+	if state != nil {
+		returnedState = state(returnedState)
+	}
+
+	request, _ := http.NewRequest("GET", "/auth/oidc/"+provider+"/callback?code=code-"+url.QueryEscape(query.Get("state"))+"&state="+url.QueryEscape(returnedState), nil)
+	request.AddCookie(cookie)
+	writer := httptest.NewRecorder()
+	pufferpanel.Engine.ServeHTTP(writer, request)
+	return writer
+}
+
+func hasAuthCookie(response *httptest.ResponseRecorder) bool {
+	for _, v := range response.Result().Cookies() {
+		if v.Name == "puffer_auth" && v.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOIDC(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+
+	err = config.OidcProviders.Set([]config.OIDCProvider{
+		{Name: "fake", DisplayName: "Fake Provider", Issuer: issuer.server.URL, ClientId: "panel", ClientSecret: "secret"},
+		{Name: "open", Issuer: issuer.server.URL, ClientId: "panel", ClientSecret: "secret", AllowRegistration: true, DefaultScopes: []string{scopes.ScopeLogin.Value, scopes.ScopeSelfEdit.Value}},
+	}, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = config.OidcProviders.Set(nil, false)
+	}()
+
+	t.Run("ListProviders", func(t *testing.T) {
+		response := CallAPI("GET", "/auth/oidc", nil, "")
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var providers []auth.OIDCProviderInfo
+		if assert.NoError(t, json.NewDecoder(response.Body).Decode(&providers)) && assert.Len(t, providers, 2) {
+			assert.Equal(t, "Fake Provider", providers[0].DisplayName)
+			assert.Equal(t, "open", providers[1].DisplayName)
+		}
+	})
+
+	t.Run("UnknownProvider", func(t *testing.T) {
+		response := CallAPI("GET", "/auth/oidc/missing/login", nil, "")
+		assert.Equal(t, http.StatusFound, response.Code)
+		assert.Equal(t, "/auth/login?error=ErrOIDCProviderNotFound", response.Header().Get("Location"))
+	})
+
+	t.Run("UnverifiedEmail", func(t *testing.T) {
+		response := issuer.login(t, "fake", jwt.MapClaims{"sub": "admin-subject", "email": loginAdminUser.Email, "email_verified": false}, nil)
+		assert.Equal(t, "/auth/login?error=ErrOIDCNoAccount", response.Header().Get("Location"))
+		assert.False(t, hasAuthCookie(response))
+	})
+
+	t.Run("LinkByEmail", func(t *testing.T) {
+		response := issuer.login(t, "fake", jwt.MapClaims{"sub": "admin-subject", "email": loginAdminUser.Email, "email_verified": true}, nil)
+		if !assert.Equal(t, "/auth/login?oidc=success", response.Header().Get("Location")) {
+			return
+		}
+		assert.True(t, hasAuthCookie(response))
+
+		identity := &models.OIDCIdentity{}
+		if assert.NoError(t, db.Where(&models.OIDCIdentity{Provider: "fake", Subject: "admin-subject"}).First(identity).Error) {
+			assert.Equal(t, loginAdminUser.ID, identity.UserId)
+		}
+	})
+
+	t.Run("LinkBySubject", func(t *testing.T) {
+		//once linked, the subject is enough even if the email at the provider changes
+		response := issuer.login(t, "fake", jwt.MapClaims{"sub": "admin-subject", "email": "changed@example.com"}, nil)
+		assert.Equal(t, "/auth/login?oidc=success", response.Header().Get("Location"))
+		assert.True(t, hasAuthCookie(response))
+	})
+
+	t.Run("StateMismatch", func(t *testing.T) {
+		response := issuer.login(t, "fake", jwt.MapClaims{"sub": "admin-subject"}, func(string) string {
+			return "forged"
+		})
+		assert.Equal(t, "/auth/login?error=ErrInvalidSession", response.Header().Get("Location"))
+		assert.False(t, hasAuthCookie(response))
+	})
+
+	t.Run("NoRegistration", func(t *testing.T) {
+		response := issuer.login(t, "fake", jwt.MapClaims{"sub": "new-subject", "email": "oidc-new@example.com", "email_verified": true}, nil)
+		assert.Equal(t, "/auth/login?error=ErrOIDCNoAccount", response.Header().Get("Location"))
+	})
+
+	t.Run("Registration", func(t *testing.T) {
+		response := issuer.login(t, "open", jwt.MapClaims{"sub": "new-subject", "email": "oidc-new@example.com", "email_verified": true, "preferred_username": "oidcuser"}, nil)
+		if !assert.Equal(t, "/auth/login?oidc=success", response.Header().Get("Location")) {
+			return
+		}
+		assert.True(t, hasAuthCookie(response))
+
+		us := &services.User{DB: db}
+		user, err := us.GetByEmail("oidc-new@example.com")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "oidcuser", user.Username)
+
+		ps := &services.Permission{DB: db}
+		perms, err := ps.GetForUserAndServer(user.ID, "")
+		if assert.NoError(t, err) {
+			assert.True(t, scopes.ContainsScope(perms.Scopes, scopes.ScopeLogin))
+			assert.True(t, scopes.ContainsScope(perms.Scopes, scopes.ScopeSelfEdit))
+		}
+	})
+}
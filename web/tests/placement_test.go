@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPlacement(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	adminToken, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ns := &services.Node{DB: db}
+	ss := &services.Server{DB: db}
+	ps := &services.Placement{DB: db}
+
+	//neither node has a daemon, so they are marked online by hand
+	createNode := func(name string, port uint16, arch string) *models.Node {
+		node := &models.Node{
+			Name:        name,
+			PublicHost:  "127.0.0.1",
+			PrivateHost: "127.0.0.1",
+			PublicPort:  port,
+			PrivatePort: port,
+			SFTPPort:    port + 1,
+			Secret:      name + "secret",
+			MaxMemory:   1000,
+		}
+		if !assert.NoError(t, ns.Create(node)) {
+			return nil
+		}
+		status := &models.NodeStatus{NodeId: node.ID, Online: true, OS: runtime.GOOS, Arch: arch, LastChecked: time.Now()}
+		assert.NoError(t, db.Create(status).Error)
+		return node
+	}
+
+	fullNode := createNode("placementfull", 40001, runtime.GOARCH)
+	if fullNode == nil {
+		return
+	}
+	defer ns.Delete(fullNode.ID)
+	emptyNode := createNode("placementempty", 40003, "riscv64")
+	if emptyNode == nil {
+		return
+	}
+	defer ns.Delete(emptyNode.ID)
+
+	existing := &models.Server{Name: "placementexisting", Identifier: "placeexist", NodeID: fullNode.ID, Type: "generic", Memory: 500}
+	if !assert.NoError(t, ss.Create(existing)) {
+		return
+	}
+	defer ss.Delete(existing.Identifier)
+
+	errorCode := func(err error) string {
+		if e, ok := err.(*pufferpanel.Error); ok {
+			return e.Code
+		}
+		return ""
+	}
+
+	t.Run("Usage", func(t *testing.T) {
+		response := CallAPI("GET", fmt.Sprintf("/api/nodes/%d/usage", fullNode.ID), nil, adminToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		usage := &models.NodeUsage{}
+		if assert.NoError(t, json.NewDecoder(response.Body).Decode(usage)) {
+			assert.Equal(t, int64(1), usage.Servers)
+			assert.Equal(t, int64(500), usage.Memory)
+			assert.Equal(t, int64(1000), usage.MaxMemory)
+		}
+	})
+
+	t.Run("RejectsOverCommit", func(t *testing.T) {
+		err := ps.Check(fullNode, &models.Server{Identifier: "placenew", Memory: 600})
+		assert.Equal(t, "ErrNodeOverCommitted", errorCode(err))
+
+		assert.NoError(t, ps.Check(fullNode, &models.Server{Identifier: "placenew", Memory: 500}))
+
+		//a server being changed doesn't count against itself
+		assert.NoError(t, ps.Check(fullNode, &models.Server{Identifier: existing.Identifier, Memory: 1000}))
+	})
+
+	t.Run("RejectsPort", func(t *testing.T) {
+		//nodes from before port pools had a single range of ports, which becomes their pool
+		for _, v := range []string{"port_min", "port_max"} {
+			if !assert.NoError(t, db.Exec("ALTER TABLE nodes ADD COLUMN `"+v+"` integer NOT NULL DEFAULT 0").Error) {
+				return
+			}
+		}
+		assert.NoError(t, db.Exec("UPDATE nodes SET port_min = 30000, port_max = 30010 WHERE id = ?", fullNode.ID).Error)
+		assert.NoError(t, db.Exec("DELETE FROM migrations WHERE id = ?", "node-port-ranges").Error)
+		if !assert.NoError(t, database.Migrate(db)) {
+			return
+		}
+		assert.False(t, db.Migrator().HasColumn(&models.Node{}, "port_min"))
+
+		definition := func(port int) pufferpanel.Server {
+			return pufferpanel.Server{Variables: map[string]pufferpanel.Variable{
+				"port": {Type: pufferpanel.Type{Type: "integer"}, Value: port},
+			}}
+		}
+		pp := &services.PortPool{DB: db}
+		err := pp.Check(fullNode, &models.Server{Identifier: "placenew"}, definition(25565))
+		assert.Equal(t, "ErrPortNotInPool", errorCode(err))
+
+		assert.NoError(t, pp.Check(fullNode, &models.Server{Identifier: "placenew"}, definition(30005)))
+	})
+
+	t.Run("BinPack", func(t *testing.T) {
+		node, err := ps.Pick(services.PlacementBinPack, &models.Server{Identifier: "placenew", Memory: 200}, pufferpanel.Server{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, fullNode.ID, node.ID)
+		}
+
+		//too big for the fuller node, so it goes on the next fullest
+		node, err = ps.Pick(services.PlacementBinPack, &models.Server{Identifier: "placenew", Memory: 900}, pufferpanel.Server{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, emptyNode.ID, node.ID)
+		}
+	})
+
+	t.Run("Spread", func(t *testing.T) {
+		node, err := ps.Pick(services.PlacementSpread, &models.Server{Identifier: "placenew", Memory: 200}, pufferpanel.Server{})
+		if assert.NoError(t, err) {
+			assert.NotEqual(t, fullNode.ID, node.ID)
+		}
+	})
+
+	t.Run("Requirements", func(t *testing.T) {
+		definition := pufferpanel.Server{Requirements: pufferpanel.Requirements{Arch: "riscv64"}}
+		node, err := ps.Pick(services.PlacementBinPack, &models.Server{Identifier: "placenew"}, definition)
+		if assert.NoError(t, err) {
+			assert.Equal(t, emptyNode.ID, node.ID)
+		}
+
+		definition = pufferpanel.Server{Requirements: pufferpanel.Requirements{OS: "plan9"}}
+		_, err = ps.Pick(services.PlacementBinPack, &models.Server{Identifier: "placenew"}, definition)
+		assert.Equal(t, "ErrNoNodeAvailable", errorCode(err))
+	})
+
+	t.Run("InvalidPlacement", func(t *testing.T) {
+		response := CallAPI("PUT", "/api/servers/placebad", &models.ServerCreation{Placement: "random"}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestPortPool(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	adminToken, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ns := &services.Node{DB: db}
+	ss := &services.Server{DB: db}
+	pp := &services.PortPool{DB: db}
+
+	node := &models.Node{
+		Name:        "portpoolnode",
+		PublicHost:  "127.0.0.1",
+		PrivateHost: "127.0.0.1",
+		PublicPort:  40005,
+		PrivatePort: 40005,
+		SFTPPort:    40006,
+		Secret:      "portpoolsecret",
+	}
+	if !assert.NoError(t, ns.Create(node)) {
+		return
+	}
+	defer ns.Delete(node.ID)
+
+	errorCode := func(err error) string {
+		if e, ok := err.(*pufferpanel.Error); ok {
+			return e.Code
+		}
+		return ""
+	}
+
+	definition := func(port interface{}, queryPort interface{}) *pufferpanel.Server {
+		result := &pufferpanel.Server{Variables: map[string]pufferpanel.Variable{
+			"ip":   {Type: pufferpanel.Type{Type: "string"}, Value: "0.0.0.0"},
+			"port": {Type: pufferpanel.Type{Type: "integer"}, Value: port},
+		}}
+		if queryPort != nil {
+			result.Variables["queryPort"] = pufferpanel.Variable{Type: pufferpanel.Type{Type: "port"}, Value: queryPort}
+		}
+		return result
+	}
+
+	createServer := func(id string) *models.Server {
+		server := &models.Server{Name: id, Identifier: id, NodeID: node.ID, Type: "generic", IP: "0.0.0.0"}
+		if !assert.NoError(t, ss.Create(server)) {
+			return nil
+		}
+		return server
+	}
+
+	first := createServer("portfirst")
+	if first == nil {
+		return
+	}
+	defer ss.Delete(first.Identifier)
+
+	t.Run("AddRanges", func(t *testing.T) {
+		path := fmt.Sprintf("/api/nodes/%d/ports", node.ID)
+		response := CallAPI("POST", path, &models.PortRange{IP: "0.0.0.0", PortMin: 30000, PortMax: 30002}, adminToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//every IP overlaps a single IP
+		response = CallAPI("POST", path, &models.PortRange{IP: "10.0.0.1", PortMin: 30001, PortMax: 30005}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("POST", path, &models.PortRange{IP: "10.0.0.1", PortMin: 31000, PortMax: 31001}, adminToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = CallAPI("POST", path, &models.PortRange{IP: "10.0.0.2", PortMin: 31005, PortMax: 31000}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("AssignsFreePorts", func(t *testing.T) {
+		def := definition(0, "")
+		if !assert.NoError(t, pp.Assign(node, first, def)) {
+			return
+		}
+		assert.Equal(t, uint16(30000), first.Port)
+		assert.Equal(t, 30000, def.Variables["port"].Value)
+		assert.Equal(t, 30001, def.Variables["queryPort"].Value)
+	})
+
+	t.Run("RefusesConflicts", func(t *testing.T) {
+		server := &models.Server{Identifier: "portsecond", IP: "0.0.0.0"}
+
+		err := pp.Assign(node, server, definition(30001, nil))
+		assert.Equal(t, "ErrPortInUse", errorCode(err))
+
+		err = pp.Assign(node, server, definition(25565, nil))
+		assert.Equal(t, "ErrPortNotInPool", errorCode(err))
+
+		//giving the server the same ports again is fine
+		assert.NoError(t, pp.Assign(node, first, definition(30000, 30001)))
+	})
+
+	t.Run("PicksIP", func(t *testing.T) {
+		second := &models.Server{Identifier: "portsecond", IP: "0.0.0.0"}
+		if !assert.NoError(t, pp.Assign(node, second, definition(0, nil))) {
+			return
+		}
+		assert.Equal(t, uint16(30002), second.Port)
+
+		//the range for every IP is used up, so the next server is bound to the other range's IP
+		third := &models.Server{Identifier: "portthird", IP: "0.0.0.0"}
+		def := definition(0, nil)
+		if !assert.NoError(t, pp.Assign(node, third, def)) {
+			return
+		}
+		assert.Equal(t, uint16(31000), third.Port)
+		assert.Equal(t, "10.0.0.1", third.IP)
+		assert.Equal(t, "10.0.0.1", def.Variables["ip"].Value)
+
+		fourth := &models.Server{Identifier: "portfourth", IP: "0.0.0.0"}
+		assert.NoError(t, pp.Assign(node, fourth, definition(0, nil)))
+
+		fifth := &models.Server{Identifier: "portfifth", IP: "0.0.0.0"}
+		err := pp.Assign(node, fifth, definition(0, nil))
+		assert.Equal(t, "ErrNoFreePort", errorCode(err))
+
+		for _, v := range []string{"portsecond", "portthird", "portfourth"} {
+			assert.NoError(t, pp.Release(v))
+		}
+	})
+
+	t.Run("ReleasedOnDelete", func(t *testing.T) {
+		getPorts := func() *models.NodePorts {
+			response := CallAPI("GET", fmt.Sprintf("/api/nodes/%d/ports", node.ID), nil, adminToken)
+			if !assert.Equal(t, http.StatusOK, response.Code) {
+				return nil
+			}
+			result := &models.NodePorts{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(result))
+			return result
+		}
+
+		ports := getPorts()
+		if !assert.NotNil(t, ports) {
+			return
+		}
+		assert.Len(t, ports.Ranges, 2)
+		if assert.Len(t, ports.Allocations, 2) {
+			assert.Equal(t, first.Identifier, ports.Allocations[0].ServerIdentifier)
+		}
+
+		assert.NoError(t, ss.Delete(first.Identifier))
+		ports = getPorts()
+		if assert.NotNil(t, ports) {
+			assert.Empty(t, ports.Allocations)
+		}
+	})
+
+	t.Run("RemoveRange", func(t *testing.T) {
+		ports, err := pp.Get(node.ID)
+		if !assert.NoError(t, err) || !assert.NotEmpty(t, ports.Ranges) {
+			return
+		}
+
+		path := fmt.Sprintf("/api/nodes/%d/ports/%d", node.ID, ports.Ranges[0].ID)
+		response := CallAPI("DELETE", path, nil, adminToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		response = CallAPI("DELETE", path, nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
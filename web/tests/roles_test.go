@@ -0,0 +1,285 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	adminToken, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	server := &models.Server{Identifier: "roleserver", Name: "roleserver", Type: "generic"}
+	if !assert.NoError(t, db.Create(server).Error) {
+		return
+	}
+	defer db.Delete(server)
+
+	user := &models.User{Username: "roleuser", Email: "roleuser@example.com"}
+	if !assert.NoError(t, user.SetPassword("rolepassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfClients}}).Error) {
+		return
+	}
+	userToken, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	editor := &models.User{Username: "roleeditor", Email: "roleeditor@example.com"}
+	if !assert.NoError(t, editor.SetPassword("rolepassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(editor).Error) {
+		return
+	}
+	editorScopes := []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeRolesView, scopes.ScopeRolesEdit, scopes.ScopeUserPermsEdit}
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &editor.ID, Scopes: editorScopes}).Error) {
+		return
+	}
+	editorToken, err := createSession(db, editor)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var moderator, operator *models.Role
+	serverUsers := "/api/servers/" + server.Identifier + "/user"
+
+	createRole := func(t *testing.T, token string, role *models.Role) *models.Role {
+		response := CallAPI("POST", "/api/roles", role, token)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			t.FailNow()
+		}
+		created := &models.Role{}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(created))
+		return created
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		moderator = createRole(t, adminToken, &models.Role{
+			Name:      "moderator",
+			ForServer: true,
+			Scopes:    []*scopes.Scope{scopes.ScopeServerView, scopes.ScopeServerConsole},
+		})
+		assert.NotZero(t, moderator.ID)
+		assert.True(t, moderator.ForServer)
+
+		operator = createRole(t, adminToken, &models.Role{
+			Name:   "operator",
+			Scopes: []*scopes.Scope{scopes.ScopeNodesView},
+		})
+
+		response := CallAPI("POST", "/api/roles", &models.Role{Name: "moderator", ForServer: true}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		//server roles can only contain server scopes
+		response = CallAPI("POST", "/api/roles", &models.Role{Name: "broken", ForServer: true, Scopes: []*scopes.Scope{scopes.ScopeNodesView}}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("GET", "/api/roles", nil, adminToken)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			var roles []*models.Role
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&roles))
+			assert.Len(t, roles, 2)
+		}
+	})
+
+	t.Run("AssignOnServer", func(t *testing.T) {
+		response := CallAPI("GET", serverUsers, nil, userToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		response = CallAPI("PUT", serverUsers+"/"+user.Email, &models.PermissionView{Roles: []uint{moderator.ID}}, adminToken)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		response = CallAPI("GET", serverUsers, nil, adminToken)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			var users []*models.UserPermissionsView
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&users))
+			if assert.Len(t, users, 1) {
+				assert.Equal(t, []uint{moderator.ID}, users[0].Roles)
+			}
+		}
+
+		//a global role can't be given on a server
+		response = CallAPI("PUT", serverUsers+"/"+user.Email, &models.PermissionView{Roles: []uint{operator.ID}}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("PUT", "/api/users/"+strconv.Itoa(int(user.ID))+"/perms", &models.PermissionView{Scopes: []*scopes.Scope{scopes.ScopeLogin}, Roles: []uint{moderator.ID}}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("UpdatePropagates", func(t *testing.T) {
+		response := CallAPI("GET", serverUsers, nil, userToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		moderator.Scopes = append(moderator.Scopes, scopes.ScopeServerUserView)
+		response = CallAPI("PUT", "/api/roles/"+strconv.Itoa(int(moderator.ID)), moderator, adminToken)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		response = CallAPI("GET", serverUsers, nil, userToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Effective", func(t *testing.T) {
+		response := CallAPI("GET", "/api/self/perms/effective?server="+server.Identifier, nil, userToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		effective := &models.EffectivePermissionsView{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(effective)) {
+			return
+		}
+		assert.Equal(t, server.Identifier, effective.ServerIdentifier)
+		assert.True(t, scopes.ContainsScope(effective.Scopes, scopes.ScopeServerUserView))
+		assert.True(t, scopes.ContainsScope(effective.Scopes, scopes.ScopeLogin))
+
+		var fromRole *models.PermissionSourceView
+		for _, v := range effective.Sources {
+			if v.Role == moderator.Name {
+				fromRole = v
+			}
+		}
+		if assert.NotNil(t, fromRole) {
+			assert.Equal(t, server.Identifier, fromRole.ServerIdentifier)
+			assert.Len(t, fromRole.Scopes, 3)
+		}
+
+		response = CallAPI("GET", "/api/users/"+strconv.Itoa(int(user.ID))+"/perms/effective", nil, adminToken)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			global := &models.EffectivePermissionsView{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(global))
+			assert.False(t, scopes.ContainsScope(global.Scopes, scopes.ScopeServerUserView))
+		}
+	})
+
+	t.Run("Holders", func(t *testing.T) {
+		response := CallAPI("GET", "/api/roles/"+strconv.Itoa(int(moderator.ID))+"/holders", nil, editorToken)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var holders []*models.RoleHolderView
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&holders))
+		if assert.Len(t, holders, 1) {
+			assert.Equal(t, user.Email, holders[0].Email)
+			assert.Equal(t, server.Identifier, holders[0].ServerIdentifier)
+		}
+	})
+
+	t.Run("NoEscalation", func(t *testing.T) {
+		//the editor doesn't have nodes.view, so can't hand it out
+		response := CallAPI("PUT", "/api/users/"+strconv.Itoa(int(editor.ID))+"/perms", &models.PermissionView{Scopes: editorScopes, Roles: []uint{operator.ID}}, editorToken)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		ps := &services.Permission{DB: db}
+		perms, err := ps.GetForUserAndServer(editor.ID, "")
+		if assert.NoError(t, err) {
+			assert.Empty(t, perms.Roles)
+		}
+
+		//nor put it into a role, or change a role holding it
+		created := createRole(t, editorToken, &models.Role{Name: "sneaky", Scopes: []*scopes.Scope{scopes.ScopeNodesView, scopes.ScopeLogin}})
+		assert.Equal(t, []*scopes.Scope{scopes.ScopeLogin}, created.Scopes)
+
+		response = CallAPI("PUT", "/api/roles/"+strconv.Itoa(int(operator.ID)), &models.Role{Name: "operator"}, editorToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		rs := &services.Role{DB: db}
+		role, err := rs.Get(operator.ID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []*scopes.Scope{scopes.ScopeNodesView}, role.Scopes)
+		}
+
+		response = CallAPI("DELETE", "/api/roles/"+strconv.Itoa(int(operator.ID)), nil, editorToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("DELETE", "/api/roles/"+strconv.Itoa(int(created.ID)), nil, editorToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		client := &models.Client{ClientId: "roleclient", UserId: user.ID, Name: "roleclient"}
+		if !assert.NoError(t, client.SetClientSecret("secret")) {
+			return
+		}
+		if !assert.NoError(t, db.Create(client).Error) {
+			return
+		}
+		ss := &services.Session{DB: db}
+		clientToken, err := ss.CreateForClient(client)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		//without roles, a client can do what its user can
+		response := CallAPI("GET", serverUsers, nil, clientToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		viewer := createRole(t, adminToken, &models.Role{Name: "viewer", ForServer: true, Scopes: []*scopes.Scope{scopes.ScopeServerView}})
+		response = CallAPI("PUT", "/api/self/oauth2/"+client.ClientId+"/perms?server="+server.Identifier, &models.PermissionView{Roles: []uint{viewer.ID}}, userToken)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		response = CallAPI("GET", "/api/self/oauth2/"+client.ClientId+"/perms?server="+server.Identifier, nil, userToken)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			view := &models.PermissionView{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(view))
+			assert.Equal(t, []uint{viewer.ID}, view.Roles)
+		}
+
+		response = CallAPI("GET", serverUsers, nil, clientToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		response = CallAPI("PUT", "/api/self/oauth2/"+client.ClientId+"/perms?server="+server.Identifier, &models.PermissionView{Roles: []uint{viewer.ID, moderator.ID}}, userToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		response = CallAPI("GET", serverUsers, nil, clientToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//roles with scopes the user doesn't have can't be given to their client
+		response = CallAPI("PUT", "/api/self/oauth2/"+client.ClientId+"/perms", &models.PermissionView{Roles: []uint{operator.ID}}, userToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		response = CallAPI("GET", "/api/self/oauth2/"+client.ClientId+"/perms", nil, userToken)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			view := &models.PermissionView{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(view))
+			assert.Empty(t, view.Roles)
+		}
+
+		os := &services.OAuth2{DB: db}
+		assert.NoError(t, os.Delete(client.ClientId))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		response := CallAPI("DELETE", "/api/roles/"+strconv.Itoa(int(moderator.ID)), nil, adminToken)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		response = CallAPI("GET", serverUsers, nil, userToken)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		response = CallAPI("GET", "/api/roles/"+strconv.Itoa(int(moderator.ID)), nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
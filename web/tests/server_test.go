@@ -1,24 +1,37 @@
 package tests
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
+	pkgsftp "github.com/pkg/sftp"
 	"github.com/pufferpanel/pufferpanel/v3"
 	"github.com/pufferpanel/pufferpanel/v3/config"
 	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/files"
+	"github.com/pufferpanel/pufferpanel/v3/middleware"
 	"github.com/pufferpanel/pufferpanel/v3/models"
 	"github.com/pufferpanel/pufferpanel/v3/scopes"
 	"github.com/pufferpanel/pufferpanel/v3/servers"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/sftp"
 	"github.com/pufferpanel/pufferpanel/v3/utils"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -303,6 +316,626 @@ func TestServers(t *testing.T) {
 		assert.Equal(t, http.StatusOK, response.Code)
 	})
 
+	t.Run("ResumableUpload", func(t *testing.T) {
+		contents := []byte("this is a file that was uploaded in pieces")
+		hash := sha256.Sum256(contents)
+
+		response := CallAPI("POST", "/api/servers/"+serverId+"/upload", &pufferpanel.UploadRequest{Path: "uploads/chunked.txt", Size: int64(len(contents))}, session)
+		if !assert.Equal(t, http.StatusCreated, response.Code) {
+			return
+		}
+		var upload files.Upload
+		err := json.NewDecoder(response.Body).Decode(&upload)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response = CallAPIRaw("PUT", fmt.Sprintf("/api/servers/%s/upload/%s?offset=%d", serverId, upload.Id, 10), contents[10:], session)
+		if !assert.Equal(t, http.StatusConflict, response.Code) {
+			return
+		}
+
+		response = CallAPIRaw("PUT", fmt.Sprintf("/api/servers/%s/upload/%s?offset=%d", serverId, upload.Id, 0), contents[:10], session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/upload/"+upload.Id, nil, session)
+		if !assert.Equal(t, http.StatusBadRequest, response.Code) {
+			return
+		}
+
+		response = CallAPIRaw("PUT", fmt.Sprintf("/api/servers/%s/upload/%s?offset=%d", serverId, upload.Id, 10), contents[10:], session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/upload/"+upload.Id, &pufferpanel.UploadFinishRequest{Checksum: "sha256:" + hex.EncodeToString(hash[:])}, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		data, err := os.ReadFile(filepath.Join(config.ServersFolder.Value(), serverId, "uploads", "chunked.txt"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, contents, data)
+	})
+
+	t.Run("ResumableUploadRewind", func(t *testing.T) {
+		folder := filepath.Join(config.ServersFolder.Value(), serverId, "rewind")
+		defer os.RemoveAll(folder)
+
+		//without a size, a rewound chunk which is shorter than the first attempt decides where the file ends
+		response := CallAPI("POST", "/api/servers/"+serverId+"/upload", &pufferpanel.UploadRequest{Path: "rewind/unknown.txt"}, session)
+		if !assert.Equal(t, http.StatusCreated, response.Code) {
+			return
+		}
+		var upload files.Upload
+		err := json.NewDecoder(response.Body).Decode(&upload)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response = CallAPIRaw("PUT", fmt.Sprintf("/api/servers/%s/upload/%s?offset=%d", serverId, upload.Id, 0), []byte("first attempt which is long"), session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+
+		response = CallAPIRaw("PUT", fmt.Sprintf("/api/servers/%s/upload/%s?offset=%d", serverId, upload.Id, 6), []byte("retry"), session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/upload/"+upload.Id, nil, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		data, err := os.ReadFile(filepath.Join(folder, "unknown.txt"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "first retry", string(data))
+		}
+	})
+
+	t.Run("RangeDownload", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/servers/"+serverId+"/file/uploads/chunked.txt", nil)
+		request.Header.Set("Authorization", "Bearer "+session)
+		request.Header.Set("Range", "bytes=5-8")
+		response := httptest.NewRecorder()
+		pufferpanel.Engine.ServeHTTP(response, request)
+
+		if !assert.Equal(t, http.StatusPartialContent, response.Code) {
+			return
+		}
+		assert.Equal(t, "is a", response.Body.String())
+		assert.Equal(t, "bytes 5-8/42", response.Header().Get("Content-Range"))
+	})
+
+	t.Run("SearchFiles", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(config.ServersFolder.Value(), serverId, "uploads", "server.properties"), []byte("motd=hello\nmax-players=20\n"), 0644)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response := CallAPI("GET", "/api/servers/"+serverId+"/search?name=*.properties&content=max-players", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var res pufferpanel.FileSearchResponse
+		err = json.NewDecoder(response.Body).Decode(&res)
+		if !assert.NoError(t, err) || !assert.Len(t, res.Results, 1) {
+			return
+		}
+		assert.Equal(t, "/uploads/server.properties", res.Results[0].Path)
+		assert.Equal(t, []files.SearchMatch{{Line: 2, Text: "max-players=20"}}, res.Results[0].Matches)
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/search?name=*&depth=1", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		err = json.NewDecoder(response.Body).Decode(&res)
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, v := range res.Results {
+			assert.NotEqual(t, "/uploads/server.properties", v.Path)
+		}
+	})
+
+	t.Run("ListFiles", func(t *testing.T) {
+		folder := filepath.Join(config.ServersFolder.Value(), serverId, "uploads")
+		if !assert.NoError(t, os.Symlink("chunked.txt", filepath.Join(folder, "inside.txt"))) {
+			return
+		}
+		if !assert.NoError(t, os.Symlink("../../../outside", filepath.Join(folder, "outside"))) {
+			return
+		}
+
+		response := CallAPI("GET", "/api/servers/"+serverId+"/file/uploads?sort=name&limit=2&page=1", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		assert.Equal(t, "4", response.Header().Get("X-Total-Count"))
+
+		var list []pufferpanel.FileDesc
+		err := json.NewDecoder(response.Body).Decode(&list)
+		if !assert.NoError(t, err) || !assert.Len(t, list, 3) {
+			return
+		}
+		assert.Equal(t, "..", list[0].Name)
+		assert.Equal(t, "chunked.txt", list[1].Name)
+		assert.Equal(t, "0644", list[1].Mode)
+		assert.Equal(t, "text/plain; charset=utf-8", list[1].Mime)
+		assert.NotNil(t, list[1].Uid)
+		assert.Equal(t, "inside.txt", list[2].Name)
+		assert.True(t, list[2].Symlink)
+		assert.False(t, list[2].SymlinkEscapes)
+		if utils.UseOpenat2() {
+			assert.Equal(t, int64(42), list[2].Size)
+		}
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/file/uploads?filter=out*", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		err = json.NewDecoder(response.Body).Decode(&list)
+		if !assert.NoError(t, err) || !assert.Len(t, list, 2) {
+			return
+		}
+		assert.True(t, list[1].SymlinkEscapes)
+		assert.Equal(t, "../../../outside", list[1].SymlinkTarget)
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/file/", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		err = json.NewDecoder(response.Body).Decode(&list)
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, v := range list {
+			if v.Name == "uploads" {
+				assert.Equal(t, 4, *v.Children)
+			}
+		}
+	})
+
+	t.Run("ListFifo", func(t *testing.T) {
+		folder := filepath.Join(config.ServersFolder.Value(), serverId, "fifo")
+		if !assert.NoError(t, os.MkdirAll(folder, 0755)) {
+			return
+		}
+		defer os.RemoveAll(folder)
+		if err := exec.Command("mkfifo", filepath.Join(folder, "pipe")).Run(); err != nil {
+			t.Skip("mkfifo is not available")
+		}
+
+		//nothing writes to the pipe, so the listing only returns if it isn't opened
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			done <- CallAPI("GET", "/api/servers/"+serverId+"/file/fifo", nil, session)
+		}()
+		select {
+		case response := <-done:
+			if !assert.Equal(t, http.StatusOK, response.Code) {
+				return
+			}
+			var list []pufferpanel.FileDesc
+			if assert.NoError(t, json.NewDecoder(response.Body).Decode(&list)) && assert.Len(t, list, 2) {
+				assert.Equal(t, "pipe", list[1].Name)
+				assert.Empty(t, list[1].Mime)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("listing a folder with a FIFO in it hung")
+		}
+	})
+
+	t.Run("FileOperations", func(t *testing.T) {
+		root := filepath.Join(config.ServersFolder.Value(), serverId)
+
+		response := CallAPI("POST", "/api/servers/"+serverId+"/file/uploads", &pufferpanel.FileOperation{Action: "copy", Target: "/uploads/nested"}, session)
+		if !assert.Equal(t, http.StatusConflict, response.Code) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/file/uploads", &pufferpanel.FileOperation{Action: "copy", Target: "/copied"}, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) || !assert.FileExists(t, filepath.Join(root, "copied", "chunked.txt")) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/file/copied/chunked.txt", &pufferpanel.FileOperation{Action: "move", Target: "/copied/renamed.txt"}, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) || !assert.FileExists(t, filepath.Join(root, "copied", "renamed.txt")) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/file/copied/renamed.txt", &pufferpanel.FileOperation{Action: "chmod", Mode: "4600"}, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		fi, err := os.Stat(filepath.Join(root, "copied", "renamed.txt"))
+		if !assert.NoError(t, err) || !assert.Equal(t, os.FileMode(0600), fi.Mode()) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/servers/"+serverId+"/file/", &pufferpanel.FileOperation{Action: "delete", Paths: []string{"copied", "uploads"}}, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		assert.NoDirExists(t, filepath.Join(root, "copied"))
+		assert.NoDirExists(t, filepath.Join(root, "uploads"))
+	})
+
+	t.Run("DiskQuota", func(t *testing.T) {
+		prg := servers.GetFromCache(serverId)
+		if !assert.NotNil(t, prg) {
+			return
+		}
+		prg.RecalculateUsage()
+		used := prg.GetFileServer().GetQuota().Used()
+
+		prg.Quota = pufferpanel.Quota{Limit: used + 1024}
+		prg.ApplyQuota()
+		defer func() {
+			prg.Quota = pufferpanel.Quota{}
+			prg.ApplyQuota()
+		}()
+
+		response := CallAPIRaw("PUT", "/api/servers/"+serverId+"/file/toolarge.txt", make([]byte, 2048), session)
+		if !assert.Equal(t, http.StatusInsufficientStorage, response.Code) {
+			return
+		}
+		assert.NoFileExists(t, filepath.Join(config.ServersFolder.Value(), serverId, "toolarge.txt"))
+		assert.Equal(t, used, prg.GetFileServer().GetQuota().Used())
+
+		response = CallAPIRaw("PUT", "/api/servers/"+serverId+"/file/fits.txt", make([]byte, 512), session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/stats", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		stats := &pufferpanel.ServerStats{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(stats)) {
+			return
+		}
+		assert.Equal(t, used+512, stats.DiskUsed)
+		assert.Equal(t, used+1024, stats.DiskLimit)
+
+		response = CallAPI("DELETE", "/api/servers/"+serverId+"/file/fits.txt", nil, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		assert.Equal(t, used, prg.GetFileServer().GetQuota().Used())
+	})
+
+	t.Run("PathRestrictions", func(t *testing.T) {
+		restricted := &models.PermissionView{
+			Scopes: []*scopes.Scope{scopes.ScopeServerView, scopes.ScopeServerFileView, scopes.ScopeServerFileEdit, scopes.ScopeServerSftpReadOnly},
+			Paths:  []string{"/allowed/"},
+		}
+		response := CallAPI("PUT", "/api/servers/"+serverId+"/user/"+loginNoLoginUser.Email, restricted, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		defer CallAPIRaw("PUT", "/api/servers/"+serverId+"/user/"+loginNoLoginUser.Email, []byte(`{"scopes": ["server.view", "server.data.view"]}`), session)
+
+		response = CallAPIRaw("PUT", "/api/servers/"+serverId+"/file/allowed?folder", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		for _, v := range []string{"allowed/inside.txt", "secret.txt"} {
+			response = CallAPIRaw("PUT", "/api/servers/"+serverId+"/file/"+v, []byte("data"), session)
+			if !assert.Equal(t, http.StatusNoContent, response.Code) {
+				return
+			}
+		}
+		defer CallAPI("POST", "/api/servers/"+serverId+"/file/", &pufferpanel.FileOperation{Action: "delete", Paths: []string{"allowed", "secret.txt"}}, session)
+
+		userSession, err := createSession(db, loginNoLoginUser)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/file/", nil, userSession)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var list []pufferpanel.FileDesc
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&list)) {
+			return
+		}
+		if assert.Len(t, list, 1) {
+			assert.Equal(t, "allowed", list[0].Name)
+		}
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/file/secret.txt", nil, userSession)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPIRaw("PUT", "/api/servers/"+serverId+"/file/secret.txt", []byte("changed"), userSession)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("POST", "/api/servers/"+serverId+"/file/allowed/inside.txt", &pufferpanel.FileOperation{Action: "move", Target: "/outside.txt"}, userSession)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = CallAPI("GET", "/api/servers/"+serverId+"/file/allowed/inside.txt", nil, userSession)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		//uploads are checked against the path they go to, even when only their id is given
+		response = CallAPI("POST", "/api/servers/"+serverId+"/upload", &pufferpanel.UploadRequest{Path: "secret-upload.txt"}, session)
+		if assert.Equal(t, http.StatusCreated, response.Code) {
+			var upload files.Upload
+			if assert.NoError(t, json.NewDecoder(response.Body).Decode(&upload)) {
+				path := "/api/servers/" + serverId + "/upload/" + upload.Id
+				response = CallAPI("GET", path, nil, userSession)
+				assert.Equal(t, http.StatusForbidden, response.Code)
+				response = CallAPIRaw("PUT", path+"?offset=0", []byte("changed"), userSession)
+				assert.Equal(t, http.StatusForbidden, response.Code)
+				response = CallAPI("POST", path, nil, userSession)
+				assert.Equal(t, http.StatusForbidden, response.Code)
+				response = CallAPI("DELETE", path, nil, userSession)
+				assert.Equal(t, http.StatusForbidden, response.Code)
+				response = CallAPI("DELETE", path, nil, session)
+				assert.Equal(t, http.StatusNoContent, response.Code)
+			}
+		}
+
+		//the header is set by the panel, so the user cannot lift their own restrictions
+		request, _ := http.NewRequest("GET", "/api/servers/"+serverId+"/file/secret.txt", nil)
+		request.Header.Set("Authorization", "Bearer "+userSession)
+		request.Header.Set(middleware.PathRestrictionHeader, "/")
+		writer := httptest.NewRecorder()
+		pufferpanel.Engine.ServeHTTP(writer, request)
+		assert.Equal(t, http.StatusForbidden, writer.Code)
+
+		response = CallAPI("GET", "/api/servers/"+serverId+"/search?name=*.txt", nil, userSession)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var search pufferpanel.FileSearchResponse
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&search)) {
+			return
+		}
+		if assert.Len(t, search.Results, 1) {
+			assert.Equal(t, "/allowed/inside.txt", search.Results[0].Path)
+		}
+
+		auth := &services.DatabaseSFTPAuthorization{}
+		perms, err := auth.Validate(loginNoLoginUser.Email+"#"+serverId, loginNoLoginUserPassword)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "true", perms.Extensions["read_only"])
+		assert.Equal(t, "allowed", perms.Extensions["paths"])
+	})
+
+	t.Run("SFTPLog", func(t *testing.T) {
+		prg := servers.GetFromCache(serverId)
+		if !assert.NotNil(t, prg) {
+			return
+		}
+
+		record := func(entry pufferpanel.SFTPLogEntry) {
+			entry.Session = "test"
+			entry.User = loginAdminUser.Email
+			prg.RecordSFTP(entry)
+		}
+		handlers := sftp.CreateRequestPrefix(prg, false, nil, record)
+
+		writer, err := handlers.FilePut.Filewrite(pkgsftp.NewRequest("Put", "/sftp.txt"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = writer.WriteAt([]byte("uploaded"), 0)
+		if !assert.NoError(t, err) || !assert.NoError(t, writer.(io.Closer).Close()) {
+			return
+		}
+
+		rename := pkgsftp.NewRequest("Rename", "/sftp.txt")
+		rename.Target = "/sftp-renamed.txt"
+		if !assert.NoError(t, handlers.FileCmd.Filecmd(rename)) {
+			return
+		}
+		if !assert.NoError(t, handlers.FileCmd.Filecmd(pkgsftp.NewRequest("Remove", "/sftp-renamed.txt"))) {
+			return
+		}
+
+		readOnly := sftp.CreateRequestPrefix(prg, true, nil, record)
+		assert.Error(t, readOnly.FileCmd.Filecmd(pkgsftp.NewRequest("Mkdir", "/sftp-folder")))
+
+		response := CallAPI("GET", "/api/servers/"+serverId+"/sftp/log?limit=3", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var log pufferpanel.SFTPLogResponse
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&log)) {
+			return
+		}
+		if !assert.Len(t, log.Entries, 3) {
+			return
+		}
+		assert.Equal(t, "delete", log.Entries[0].Action)
+		assert.Equal(t, "rename", log.Entries[1].Action)
+		assert.Equal(t, "/sftp-renamed.txt", log.Entries[1].Target)
+		assert.Equal(t, "write", log.Entries[2].Action)
+		assert.Equal(t, int64(8), log.Entries[2].Bytes)
+		assert.Equal(t, loginAdminUser.Email, log.Entries[2].User)
+	})
+
+	t.Run("SFTPAllServers", func(t *testing.T) {
+		auth := &services.DatabaseSFTPAuthorization{}
+		perms, err := auth.Validate(loginAdminUser.Email, loginAdminUserPassword)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var access []pufferpanel.SFTPServerAccess
+		if !assert.NoError(t, json.Unmarshal([]byte(perms.Extensions["servers"]), &access)) || !assert.NotEmpty(t, access) {
+			return
+		}
+
+		handlers := sftp.CreateServerRoot(access, nil)
+		lister, err := handlers.FileList.Filelist(pkgsftp.NewRequest("List", "/"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		entries := make([]os.FileInfo, 10)
+		n, _ := lister.ListAt(entries, 0)
+		var folder string
+		for _, v := range entries[:n] {
+			if strings.HasSuffix(v.Name(), "("+serverId+")") {
+				folder = v.Name()
+			}
+		}
+		if !assert.NotEmpty(t, folder) {
+			return
+		}
+
+		writer, err := handlers.FilePut.Filewrite(pkgsftp.NewRequest("Put", "/"+folder+"/all.txt"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = writer.WriteAt([]byte("all"), 0)
+		if !assert.NoError(t, err) || !assert.NoError(t, writer.(io.Closer).Close()) {
+			return
+		}
+		assert.FileExists(t, filepath.Join(config.ServersFolder.Value(), serverId, "all.txt"))
+
+		rename := pkgsftp.NewRequest("Rename", "/"+folder+"/all.txt")
+		rename.Target = "/all.txt"
+		assert.Error(t, handlers.FileCmd.Filecmd(rename))
+		assert.Error(t, handlers.FileCmd.Filecmd(pkgsftp.NewRequest("Rmdir", "/"+folder)))
+		assert.NoError(t, handlers.FileCmd.Filecmd(pkgsftp.NewRequest("Remove", "/"+folder+"/all.txt")))
+	})
+
+	t.Run("SCP", func(t *testing.T) {
+		_, hostKey, err := ed25519.GenerateKey(nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		signer, err := ssh.NewSignerFromKey(hostKey)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		auth := &services.DatabaseSFTPAuthorization{}
+		serverConfig := &ssh.ServerConfig{
+			PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+				return auth.Validate(c.User(), string(pass))
+			},
+		}
+		serverConfig.AddHostKey(signer)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer utils.Close(listener)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go sftp.HandleConn(conn, serverConfig)
+			}
+		}()
+
+		client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            loginAdminUser.Email + "#" + serverId,
+			Auth:            []ssh.AuthMethod{ssh.Password(loginAdminUserPassword)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer utils.Close(client)
+
+		//upload, as "scp file host:/scp.txt" would
+		err = runSCPCommand(client, "scp -t /scp.txt", func(in io.Writer, out io.Reader) error {
+			if err := readSCPAck(out); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprint(in, "C0644 5 scp.txt\n"); err != nil {
+				return err
+			}
+			if err := readSCPAck(out); err != nil {
+				return err
+			}
+			if _, err := in.Write([]byte("hello\x00")); err != nil {
+				return err
+			}
+			return readSCPAck(out)
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(config.ServersFolder.Value(), serverId, "scp.txt"))
+		if !assert.NoError(t, err) || !assert.Equal(t, "hello", string(data)) {
+			return
+		}
+
+		//download, as "scp host:/scp.txt file" would
+		var header string
+		var contents []byte
+		err = runSCPCommand(client, "scp -f /scp.txt", func(in io.Writer, out io.Reader) error {
+			reader := bufio.NewReader(out)
+			if _, err := in.Write([]byte{0}); err != nil {
+				return err
+			}
+			var err error
+			if header, err = reader.ReadString('\n'); err != nil {
+				return err
+			}
+			if _, err = in.Write([]byte{0}); err != nil {
+				return err
+			}
+			contents = make([]byte, 6)
+			if _, err = io.ReadFull(reader, contents); err != nil {
+				return err
+			}
+			_, err = in.Write([]byte{0})
+			return err
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "C0644 5 scp.txt\n", header)
+		assert.Equal(t, "hello\x00", string(contents))
+
+		//anything other than scp and rsync is refused, as are rsync options which could leave the server
+		assert.Error(t, runSCPCommand(client, "ls /", nil))
+		assert.Error(t, runSCPCommand(client, "rsync --server --sender -L . /", nil))
+
+		//read only logins can download, but not have rsync remove what it sent
+		readOnly := &models.PermissionView{Scopes: []*scopes.Scope{scopes.ScopeServerView, scopes.ScopeServerSftpReadOnly}}
+		response := CallAPI("PUT", "/api/servers/"+serverId+"/user/"+loginNoLoginUser.Email, readOnly, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		defer CallAPIRaw("PUT", "/api/servers/"+serverId+"/user/"+loginNoLoginUser.Email, []byte(`{"scopes": ["server.view", "server.data.view"]}`), session)
+
+		readOnlyClient, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            loginNoLoginUser.Email + "#" + serverId,
+			Auth:            []ssh.AuthMethod{ssh.Password(loginNoLoginUserPassword)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer utils.Close(readOnlyClient)
+
+		sess, err := readOnlyClient.NewSession()
+		if !assert.NoError(t, err) {
+			return
+		}
+		stderr := &bytes.Buffer{}
+		sess.Stderr = stderr
+		assert.Error(t, sess.Run("rsync --server --sender --remove-source-files . /scp.txt"))
+		utils.Close(sess)
+		assert.Contains(t, stderr.String(), "read only")
+
+		assert.NoError(t, os.Remove(filepath.Join(config.ServersFolder.Value(), serverId, "scp.txt")))
+	})
+
 	t.Run("InstallServer", func(t *testing.T) {
 		response := CallAPI("POST", "/api/servers/"+serverId+"/install", nil, session)
 		if !assert.Equal(t, http.StatusAccepted, response.Code) {
@@ -470,3 +1103,44 @@ func TestServers(t *testing.T) {
 		assert.True(t, messageReceived, "Console messages were not received")
 	})
 }
+
+// runSCPCommand runs a command over SSH, talking to it with speak, and fails if it does not exit cleanly
+func runSCPCommand(client *ssh.Client, command string, speak func(in io.Writer, out io.Reader) error) error {
+	sess, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer utils.Close(sess)
+
+	in, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = sess.Start(command); err != nil {
+		return err
+	}
+
+	if speak != nil {
+		if err = speak(in, out); err != nil {
+			return err
+		}
+	}
+	_ = in.Close()
+	_, _ = io.Copy(io.Discard, out)
+	return sess.Wait()
+}
+
+func readSCPAck(out io.Reader) error {
+	code := make([]byte, 1)
+	if _, err := io.ReadFull(out, code); err != nil {
+		return err
+	}
+	if code[0] != 0 {
+		return fmt.Errorf("scp replied with %d", code[0])
+	}
+	return nil
+}
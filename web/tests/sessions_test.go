@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestSessions(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	user := &models.User{Username: "sessionuser", Email: "sessions@example.com"}
+	if !assert.NoError(t, user.SetPassword("sessionpassword")) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	defer (&services.User{DB: db}).Delete(user)
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfEdit}}).Error) {
+		return
+	}
+
+	first, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+	second, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+	third, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	getSessions := func(t *testing.T, token string) []*models.SessionView {
+		response := CallAPI("GET", "/api/self/sessions", nil, token)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return nil
+		}
+		var sessions []*models.SessionView
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&sessions))
+		return sessions
+	}
+
+	t.Run("List", func(t *testing.T) {
+		//the second has been used, the third not yet
+		response := CallAPI("GET", "/api/self", nil, second)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		sessions := getSessions(t, first)
+		if !assert.Len(t, sessions, 3) {
+			return
+		}
+		assert.True(t, sessions[0].Current)
+		assert.NotNil(t, sessions[0].LastSeen)
+		assert.False(t, sessions[1].Current)
+		assert.NotNil(t, sessions[1].LastSeen)
+		assert.Nil(t, sessions[2].LastSeen)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		sessions := getSessions(t, first)
+		if !assert.Len(t, sessions, 3) {
+			return
+		}
+
+		response := CallAPI("DELETE", fmt.Sprintf("/api/self/sessions/%d", sessions[1].Id), nil, first)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		response = CallAPI("GET", "/api/self", nil, second)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		//someone else's sessions can't be seen or revoked
+		adminToken, err := createSessionAdmin()
+		if !assert.NoError(t, err) {
+			return
+		}
+		response = CallAPI("DELETE", fmt.Sprintf("/api/self/sessions/%d", sessions[2].Id), nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		response = CallAPI("GET", "/api/self", nil, third)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("RevokeOthers", func(t *testing.T) {
+		response := CallAPI("DELETE", "/api/self/sessions", nil, first)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		response = CallAPI("GET", "/api/self", nil, third)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		sessions := getSessions(t, first)
+		if assert.Len(t, sessions, 1) {
+			assert.True(t, sessions[0].Current)
+		}
+	})
+
+	t.Run("ForceLogout", func(t *testing.T) {
+		response := CallAPI("DELETE", fmt.Sprintf("/api/users/%d/sessions", user.ID), nil, first)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		adminToken, err := createSessionAdmin()
+		if !assert.NoError(t, err) {
+			return
+		}
+		response = CallAPI("DELETE", fmt.Sprintf("/api/users/%d/sessions", user.ID), nil, adminToken)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		response = CallAPI("GET", "/api/self", nil, first)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		var count int64
+		db.Model(&models.AuditLog{}).Where("action = ? AND target_id = ?", "user.sessions.revoked", fmt.Sprint(user.ID)).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSSHKeys(t *testing.T) {
+	session, err := createSessionAdmin()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	public, _, err := ed25519.GenerateKey(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if !assert.NoError(t, err) {
+		return
+	}
+	authorizedKey := string(ssh.MarshalAuthorizedKey(publicKey))
+
+	var key models.SSHKey
+
+	t.Run("AddKey", func(t *testing.T) {
+		response := CallAPI("POST", "/api/self/sshkeys", &models.SSHKey{PublicKey: "not a key"}, session)
+		if !assert.Equal(t, http.StatusBadRequest, response.Code) {
+			return
+		}
+
+		response = CallAPI("POST", "/api/self/sshkeys", &models.SSHKey{Name: "laptop", PublicKey: authorizedKey}, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&key)) {
+			return
+		}
+		assert.Equal(t, ssh.FingerprintSHA256(publicKey), key.Fingerprint)
+		assert.Nil(t, key.LastUsed)
+
+		response = CallAPI("POST", "/api/self/sshkeys", &models.SSHKey{Name: "again", PublicKey: authorizedKey}, session)
+		assert.Equal(t, http.StatusConflict, response.Code)
+	})
+
+	t.Run("ListKeys", func(t *testing.T) {
+		response := CallAPI("GET", "/api/self/sshkeys", nil, session)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		var keys []models.SSHKey
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&keys)) {
+			return
+		}
+		if assert.Len(t, keys, 1) {
+			assert.Equal(t, "laptop", keys[0].Name)
+		}
+	})
+
+	t.Run("ValidateKey", func(t *testing.T) {
+		user, err := services.ValidateSSHKey(db, loginAdminUser.Email, publicKey)
+		if !assert.NoError(t, err) || !assert.Equal(t, loginAdminUser.ID, user.ID) {
+			return
+		}
+
+		_, err = services.ValidateSSHKey(db, loginNoLoginUser.Email, publicKey)
+		assert.Error(t, err)
+
+		auth := &services.DatabaseSFTPAuthorization{}
+		_, err = auth.ValidateKey(loginNoLoginUser.Email+"#testserver", publicKey)
+		assert.Error(t, err)
+
+		auth.KeyUsed(key.Fingerprint, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000})
+		ks := &services.SSHKey{DB: db}
+		used, err := ks.Get(loginAdminUser.ID, key.ID)
+		if assert.NoError(t, err) && assert.NotNil(t, used.LastUsed) {
+			assert.Equal(t, "127.0.0.1", used.LastUsedIP)
+		}
+	})
+
+	t.Run("KeyGrantHasNoSession", func(t *testing.T) {
+		ss := &services.Server{DB: db}
+		server := &models.Server{Name: "sshkeyserver", Identifier: "sshkeyserver", Type: "generic", IP: "0.0.0.0"}
+		if !assert.NoError(t, ss.Create(server)) {
+			return
+		}
+		defer ss.Delete(server.Identifier)
+
+		form := url.Values{}
+		form.Set("grant_type", "ssh_key")
+		form.Set("username", loginAdminUser.Email+"#"+server.Identifier)
+		form.Set("public_key", authorizedKey)
+
+		request, _ := http.NewRequest("POST", "/oauth2/token", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "Bearer "+models.LocalNode.Secret)
+		response := httptest.NewRecorder()
+		pufferpanel.Engine.ServeHTTP(response, request)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+
+		//only what the login can reach is given back, as anyone can know a public key
+		var res map[string]interface{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(&res)) {
+			return
+		}
+		assert.Equal(t, server.Identifier+":server.sftp", res["scope"])
+		assert.NotContains(t, res, "access_token")
+
+		//nor is the key used until the node says the client proved it has the private key
+		ks := &services.SSHKey{DB: db}
+		used, err := ks.Get(loginAdminUser.ID, key.ID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "127.0.0.1", used.LastUsedIP)
+		}
+
+		form = url.Values{}
+		form.Set("fingerprint", key.Fingerprint)
+		form.Set("client_ip", "10.0.0.5")
+		request, _ = http.NewRequest("POST", "/oauth2/sshkey/used", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "Bearer "+models.LocalNode.Secret)
+		response = httptest.NewRecorder()
+		pufferpanel.Engine.ServeHTTP(response, request)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+		used, err = ks.Get(loginAdminUser.ID, key.ID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "10.0.0.5", used.LastUsedIP)
+		}
+	})
+
+	t.Run("RevokeKey", func(t *testing.T) {
+		response := CallAPI("DELETE", fmt.Sprintf("/api/self/sshkeys/%d", key.ID), nil, session)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		_, err := services.ValidateSSHKey(db, loginAdminUser.Email, publicKey)
+		assert.Error(t, err)
+
+		response = CallAPI("DELETE", fmt.Sprintf("/api/self/sshkeys/%d", key.ID), nil, session)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/services"
+	"github.com/pufferpanel/pufferpanel/v3/tunnel"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTunnel(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ns := &services.Node{DB: db}
+
+	//nothing listens on this address, so the node can only be reached through the tunnel
+	node := &models.Node{
+		Name:        "tunnelnode",
+		PublicHost:  "127.0.0.1",
+		PrivateHost: "127.0.0.1",
+		PublicPort:  40009,
+		PrivatePort: 40009,
+		SFTPPort:    40010,
+		Secret:      "tunnelsecret",
+		Tunnel:      true,
+	}
+	if !assert.NoError(t, ns.Create(node)) {
+		return
+	}
+	defer ns.Delete(node.ID)
+
+	tunnelUrl := fmt.Sprintf("ws://%s:%d/oauth2/tunnel", models.LocalNode.PrivateHost, models.LocalNode.PrivatePort)
+	dial := func(secret string) (*websocket.Conn, *http.Response, error) {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+secret)
+		return websocket.DefaultDialer.Dial(tunnelUrl, header)
+	}
+
+	callFeatures := func() (int, error) {
+		response, err := ns.CallNode(node, http.MethodGet, "/daemon/features", nil, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer response.Body.Close()
+		return response.StatusCode, nil
+	}
+
+	t.Run("NotConnected", func(t *testing.T) {
+		_, err := callFeatures()
+		assert.Equal(t, pufferpanel.ErrNodeNotConnected, err)
+	})
+
+	t.Run("RefusesNodeWithoutTunnel", func(t *testing.T) {
+		_, response, err := dial(models.LocalNode.Secret)
+		if assert.Error(t, err) && assert.NotNil(t, response) {
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		}
+	})
+
+	t.Run("Connected", func(t *testing.T) {
+		conn, _, err := dial(node.Secret)
+		if !assert.NoError(t, err) {
+			return
+		}
+		session := tunnel.NewSession(conn, true)
+		defer session.Close()
+		go (&http.Server{Handler: pufferpanel.Engine}).Serve(session)
+
+		for i := 0; i < 50 && !services.IsTunnelConnected(node.ID); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		code, err := callFeatures()
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, code)
+		}
+
+		//the node shows as online, as the monitor reaches it the same way
+		status, err := (&services.NodeStatus{DB: db}).Check(node)
+		if assert.NoError(t, err) {
+			assert.True(t, status.Online)
+		}
+
+		_ = session.Close()
+		for i := 0; i < 50 && services.IsTunnelConnected(node.ID); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_, err = callFeatures()
+		assert.Equal(t, pufferpanel.ErrNodeNotConnected, err)
+	})
+}
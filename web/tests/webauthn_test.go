@@ -0,0 +1,358 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/pquerna/otp/totp"
+	"github.com/pufferpanel/pufferpanel/v3"
+	"github.com/pufferpanel/pufferpanel/v3/config"
+	"github.com/pufferpanel/pufferpanel/v3/database"
+	"github.com/pufferpanel/pufferpanel/v3/models"
+	"github.com/pufferpanel/pufferpanel/v3/scopes"
+	"github.com/pufferpanel/pufferpanel/v3/web/api"
+	"github.com/pufferpanel/pufferpanel/v3/web/auth"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// softAuthenticator acts as a security key, with "none" attestation
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 32)
+	_, _ = rand.Read(id)
+	return &softAuthenticator{key: key, id: id}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	u, _ := url.Parse(config.MasterUrl.Value())
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    u.Scheme + "://" + u.Host,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte) []byte {
+	u, _ := url.Parse(config.MasterUrl.Value())
+	rpIdHash := sha256.Sum256([]byte(u.Hostname()))
+	a.signCount++
+
+	data := append([]byte{}, rpIdHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) json.RawMessage {
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  -7,
+		-1: 1,
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//user present, user verified, attested credential data included
+	authData := a.authData(0x45)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, coseKey...)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData(t, "webauthn.create", options.Response.Challenge)),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+	})
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) json.RawMessage {
+	clientData := a.clientData(t, "webauthn.get", options.Response.Challenge)
+	authData := a.authData(0x05)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+func (a *softAuthenticator) response(t *testing.T, response map[string]string) json.RawMessage {
+	id := base64.RawURLEncoding.EncodeToString(a.id)
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// callWithCookies makes a request as a browser would, keeping the cookies the panel sets
+func callWithCookies(method, path string, body []byte, cookies []*http.Cookie) (*httptest.ResponseRecorder, []*http.Cookie) {
+	request := httptest.NewRequest(method, path, bytes.NewReader(body))
+	for _, v := range cookies {
+		request.AddCookie(v)
+	}
+	writer := httptest.NewRecorder()
+	pufferpanel.Engine.ServeHTTP(writer, request)
+
+	result := (&http.Response{Header: writer.Header()}).Cookies()
+	if len(result) == 0 {
+		result = cookies
+	}
+	return writer, result
+}
+
+func TestWebAuthn(t *testing.T) {
+	db, err := database.GetConnection()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	password := "webauthnpassword"
+	user := &models.User{Username: "webauthnuser", Email: "webauthn@example.com"}
+	if !assert.NoError(t, user.SetPassword(password)) {
+		return
+	}
+	if !assert.NoError(t, db.Create(user).Error) {
+		return
+	}
+	if !assert.NoError(t, db.Create(&models.Permissions{UserId: &user.ID, Scopes: []*scopes.Scope{scopes.ScopeLogin, scopes.ScopeSelfEdit}}).Error) {
+		return
+	}
+
+	token, err := createSession(db, user)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	key := newSoftAuthenticator(t)
+	var recoveryCodes []string
+
+	login := func(t *testing.T) ([]*http.Cookie, *auth.LoginResponse) {
+		body, _ := json.Marshal(auth.LoginRequestData{Email: user.Email, Password: password})
+		response, cookies := callWithCookies("POST", "/auth/login", body, nil)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			t.FailNow()
+		}
+		res := &auth.LoginResponse{}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(res))
+		return cookies, res
+	}
+
+	t.Run("Register", func(t *testing.T) {
+		response := CallAPI("POST", "/api/self/webauthn", nil, token)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		options := &protocol.CredentialCreation{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(options)) {
+			return
+		}
+
+		response = CallAPI("PUT", "/api/self/webauthn", api.WebAuthnRegistrationRequest{Name: "Test key", Credential: key.create(t, options)}, token)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		res := &api.WebAuthnRegistrationResponse{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(res)) {
+			return
+		}
+		assert.Equal(t, "Test key", res.Credential.Name)
+		assert.Len(t, res.RecoveryCodes, 10)
+		recoveryCodes = res.RecoveryCodes
+
+		//finishing without starting is refused
+		response = CallAPI("PUT", "/api/self/webauthn", api.WebAuthnRegistrationRequest{Name: "Again", Credential: key.create(t, options)}, token)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		//the same key can't be registered twice
+		response = CallAPI("POST", "/api/self/webauthn", nil, token)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(options)) {
+			return
+		}
+		assert.Len(t, options.Response.CredentialExcludeList, 1)
+		response = CallAPI("PUT", "/api/self/webauthn", api.WebAuthnRegistrationRequest{Name: "Again", Credential: key.create(t, options)}, token)
+		assert.Equal(t, http.StatusConflict, response.Code)
+	})
+
+	t.Run("LoginWithKey", func(t *testing.T) {
+		cookies, res := login(t)
+		assert.True(t, res.OtpNeeded)
+		assert.Equal(t, []string{"webauthn", "recovery"}, res.SecondFactors)
+		assert.Empty(t, res.Scopes)
+
+		response, cookies := callWithCookies("POST", "/auth/webauthn/begin", nil, cookies)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		options := &protocol.CredentialAssertion{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(options)) {
+			return
+		}
+
+		signed := key.get(t, options)
+		response, _ = callWithCookies("POST", "/auth/webauthn/finish", signed, cookies)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		assert.True(t, hasAuthCookie(response))
+
+		//the pending login is gone once it has been used
+		response, _ = callWithCookies("POST", "/auth/webauthn/finish", signed, cookies)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		credential := &models.WebAuthnCredential{}
+		if assert.NoError(t, db.Where(&models.WebAuthnCredential{UserId: user.ID}).First(credential).Error) {
+			assert.NotNil(t, credential.LastUsed)
+		}
+	})
+
+	t.Run("LoginWithWrongKey", func(t *testing.T) {
+		cookies, _ := login(t)
+
+		response, cookies := callWithCookies("POST", "/auth/webauthn/begin", nil, cookies)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		options := &protocol.CredentialAssertion{}
+		if !assert.NoError(t, json.NewDecoder(response.Body).Decode(options)) {
+			return
+		}
+
+		other := newSoftAuthenticator(t)
+		other.id = key.id
+		response, _ = callWithCookies("POST", "/auth/webauthn/finish", other.get(t, options), cookies)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.False(t, hasAuthCookie(response))
+	})
+
+	t.Run("OtpNotEnabled", func(t *testing.T) {
+		cookies, _ := login(t)
+
+		code, err := totp.GenerateCode("", time.Now())
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, _ := json.Marshal(auth.OtpRequestData{Token: code})
+		response, _ := callWithCookies("POST", "/auth/otp", body, cookies)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("LoginWithRecoveryCode", func(t *testing.T) {
+		if !assert.NotEmpty(t, recoveryCodes) {
+			return
+		}
+
+		cookies, _ := login(t)
+		body, _ := json.Marshal(auth.RecoveryRequestData{Code: recoveryCodes[0]})
+		response, _ := callWithCookies("POST", "/auth/recovery", body, cookies)
+		if !assert.Equal(t, http.StatusOK, response.Code) {
+			return
+		}
+		assert.True(t, hasAuthCookie(response))
+
+		//codes only work once
+		cookies, _ = login(t)
+		response, _ = callWithCookies("POST", "/auth/recovery", body, cookies)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = CallAPI("GET", "/api/self/recovery", nil, token)
+		if assert.Equal(t, http.StatusOK, response.Code) {
+			status := &api.RecoveryCodeStatus{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(status))
+			assert.Equal(t, int64(9), status.Remaining)
+		}
+	})
+
+	t.Run("RecoveryWithoutPassword", func(t *testing.T) {
+		body, _ := json.Marshal(auth.RecoveryRequestData{Code: recoveryCodes[1]})
+		response, _ := callWithCookies("POST", "/auth/recovery", body, nil)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("ResetNotAllowed", func(t *testing.T) {
+		response := CallAPI("DELETE", "/api/users/"+strconv.Itoa(int(user.ID))+"/secondfactors", nil, token)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("AdminReset", func(t *testing.T) {
+		adminToken, err := createSessionAdmin()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		response := CallAPI("DELETE", "/api/users/"+strconv.Itoa(int(user.ID))+"/secondfactors", nil, adminToken)
+		if !assert.Equal(t, http.StatusNoContent, response.Code) {
+			return
+		}
+
+		var count int64
+		db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		entry := &models.AuditLog{}
+		if assert.NoError(t, db.Where(&models.AuditLog{Action: "user.secondfactors.reset", TargetId: strconv.Itoa(int(user.ID))}).First(entry).Error) {
+			if assert.NotNil(t, entry.ActorId) {
+				assert.Equal(t, loginAdminUser.ID, *entry.ActorId)
+			}
+			assert.Contains(t, entry.Details, "webauthn")
+		}
+
+		_, res := login(t)
+		assert.False(t, res.OtpNeeded)
+		assert.NotEmpty(t, res.Scopes)
+	})
+}